@@ -2,18 +2,40 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-
 	"net/http"
-
+	"os"
+	"path/filepath"
 	"time"
+	_ "time/tzdata" // Time zones of schedules, also in images without a zoneinfo database
 
+	goredis "github.com/go-redis/redis/v8"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"email-queue-service/internal/core/domain"
 	"email-queue-service/internal/core/ports"
 	"email-queue-service/internal/core/service"
+	digestmemory "email-queue-service/internal/infrastructure/digest/memory"
+	digestredis "email-queue-service/internal/infrastructure/digest/redis"
+	idempotencymemory "email-queue-service/internal/infrastructure/idempotency/memory"
+	idempotencyredis "email-queue-service/internal/infrastructure/idempotency/redis"
+	jobstatememory "email-queue-service/internal/infrastructure/jobstate/memory"
+	jobstateredis "email-queue-service/internal/infrastructure/jobstate/redis"
+	leadermemory "email-queue-service/internal/infrastructure/leader/memory"
+	leaderredis "email-queue-service/internal/infrastructure/leader/redis"
+	"email-queue-service/internal/infrastructure/queue/disk"
+	"email-queue-service/internal/infrastructure/queue/hybrid"
 	"email-queue-service/internal/infrastructure/queue/memory"
+	"email-queue-service/internal/infrastructure/queue/nats"
+	"email-queue-service/internal/infrastructure/queue/postgres"
 	"email-queue-service/internal/infrastructure/queue/redis"
+	schedulememory "email-queue-service/internal/infrastructure/schedule/memory"
+	scheduleredis "email-queue-service/internal/infrastructure/schedule/redis"
+	sequencememory "email-queue-service/internal/infrastructure/sequence/memory"
+	sequenceredis "email-queue-service/internal/infrastructure/sequence/redis"
 	"email-queue-service/internal/infrastructure/worker"
 	"email-queue-service/internal/interfaces/http/v1"
 	"email-queue-service/internal/interfaces/http/v1/handlers"
@@ -21,6 +43,7 @@ import (
 	"email-queue-service/internal/pkg/dlq"
 	"email-queue-service/internal/pkg/logger"
 	"email-queue-service/internal/pkg/metrics"
+	"email-queue-service/internal/pkg/render"
 	"email-queue-service/internal/pkg/shutdown"
 )
 
@@ -39,40 +62,332 @@ func main() {
 	deadLetterQueue := dlq.NewInMemoryDLQ(appLogger)
 	appLogger.Println("In-memory Dead Letter Queue initialized.")
 
-	var emailQueue ports.Queue
-	if cfg.UseRedisQueue {
-		redisClient := redis.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
-		emailQueue = redis.NewRedisQueue(redisClient, appLogger, metrics.EmailQueueLength)
-		appLogger.Printf("Initialized Redis queue at %s", cfg.RedisAddr)
-	} else {
-		emailQueue = memory.NewMemoryQueue(cfg.QueueCapacity, metrics.EmailQueueLength)
-		appLogger.Printf("Initialized in-memory queue with capacity: %d", cfg.QueueCapacity)
+	var db *sql.DB
+	var redisClient goredis.UniversalClient
+	var natsConn *natsgo.Conn
+	// Initialize the store behind the job status API
+	var jobStates ports.JobStateStore
+	switch cfg.JobStateStore {
+	case config.StoreRedis:
+		if redisClient == nil {
+			redisClient = connectRedis(cfg, appLogger)
+		}
+		jobStates = jobstateredis.NewStore(redisClient, cfg.RedisKeyPrefix, cfg.JobStateTTL)
+		appLogger.Printf("Job statuses are stored in Redis at %s (TTL: %s)", cfg.RedisAddr, cfg.JobStateTTL)
+	default:
+		jobStates = jobstatememory.NewStore(cfg.JobStateTTL)
+		appLogger.Printf("Job statuses are stored in memory (TTL: %s)", cfg.JobStateTTL)
+	}
+
+	// Queues dead-letter jobs through the service, which counts them and
+	// updates their status
+	deadLetters := service.NewDeadLetterQueue(deadLetterQueue, jobStates, appLogger, metrics.EmailJobsDLQTotal)
+
+	// Every named queue gets its own queue and scheduler on the backend
+	queues := make([]service.NamedQueue, 0, len(cfg.Queues))
+	for _, queueCfg := range cfg.Queues {
+		name := queueCfg.Name
+		// Queue depth is exported per priority lane and in total
+		queueDepth := metrics.NewQueueDepth(metrics.EmailQueueLength.WithLabelValues(name), metrics.EmailQueueLaneLength.MustCurryWith(prometheus.Labels{"queue": name}))
+		// Scheduled jobs are exported in total and, for pending retries, on their own
+		scheduledJobs := metrics.NewScheduledJobs(metrics.EmailScheduledJobs.WithLabelValues(name), metrics.EmailRetriesPending.WithLabelValues(name))
+		// Named queues keep their files next to those of the default queue
+		queueDir := cfg.DiskQueueDir
+		if name != domain.DefaultQueue {
+			queueDir = filepath.Join(cfg.DiskQueueDir, name)
+		}
+
+		var emailQueue ports.Queue
+		var scheduler ports.Scheduler
+		switch cfg.QueueBackend {
+		case config.QueueBackendRedis:
+			if redisClient == nil {
+				redisClient = connectRedis(cfg, appLogger)
+			}
+			keyBase := redis.KeyBase(cfg.RedisKeyPrefix, name)
+			if cfg.RedisReliable {
+				emailQueue = redis.NewReliableRedisQueue(redisClient, keyBase, appLogger, queueDepth, cfg.ConsumerName, cfg.VisibilityTimeout)
+				appLogger.Printf("Initialized reliable Redis queue %s at %s (consumer: %s, visibility timeout: %s)", name, cfg.RedisAddr, cfg.ConsumerName, cfg.VisibilityTimeout)
+			} else {
+				emailQueue = redis.NewRedisQueue(redisClient, keyBase, appLogger, queueDepth)
+				appLogger.Printf("Initialized Redis queue %s at %s", name, cfg.RedisAddr)
+			}
+			scheduler = redis.NewScheduler(redisClient, keyBase, emailQueue, appLogger, scheduledJobs)
+		case config.QueueBackendRedisStreams:
+			if redisClient == nil {
+				redisClient = connectRedis(cfg, appLogger)
+			}
+			keyBase := redis.KeyBase(cfg.RedisKeyPrefix, name)
+			streamsQueue, err := redis.NewStreamsQueue(redisClient, keyBase, deadLetters, appLogger, queueDepth, cfg.ConsumerName, cfg.VisibilityTimeout, cfg.StreamMaxLen, cfg.StreamMaxDeliver)
+			if err != nil {
+				appLogger.Fatalf("Failed to initialize Redis Streams queue %s: %v", name, err)
+			}
+			emailQueue = streamsQueue
+			scheduler = redis.NewScheduler(redisClient, keyBase, emailQueue, appLogger, scheduledJobs)
+			appLogger.Printf("Initialized Redis Streams queue %s at %s (consumer: %s, claim idle: %s, max deliver: %d)", name, cfg.RedisAddr, cfg.ConsumerName, cfg.VisibilityTimeout, cfg.StreamMaxDeliver)
+		case config.QueueBackendDisk:
+			diskQueue, err := disk.NewDiskQueue(disk.Options{
+				Dir:          queueDir,
+				Fsync:        disk.FsyncPolicy(cfg.DiskFsyncPolicy),
+				SyncInterval: cfg.DiskSyncInterval,
+				SegmentBytes: cfg.DiskSegmentBytes,
+			}, appLogger, queueDepth)
+			if err != nil {
+				appLogger.Fatalf("Failed to initialize on-disk queue %s: %v", name, err)
+			}
+			emailQueue = diskQueue
+			journal, pending, err := disk.OpenScheduleJournal(queueDir)
+			if err != nil {
+				appLogger.Fatalf("Failed to open schedule journal of queue %s: %v", name, err)
+			}
+			scheduler = memory.NewJournaledScheduler(emailQueue, journal, pending, appLogger, scheduledJobs)
+			appLogger.Printf("Recovered %d scheduled jobs from %s", len(pending), queueDir)
+			appLogger.Printf("Initialized on-disk queue %s in %s (fsync: %s)", name, queueDir, cfg.DiskFsyncPolicy)
+		case config.QueueBackendHybrid:
+			hybridQueue, err := hybrid.NewHybridQueue(cfg.QueueCapacity, queueDir, appLogger, queueDepth)
+			if err != nil {
+				appLogger.Fatalf("Failed to initialize hybrid queue %s: %v", name, err)
+			}
+			emailQueue = hybridQueue
+			journal, pending, err := disk.OpenScheduleJournal(queueDir)
+			if err != nil {
+				appLogger.Fatalf("Failed to open schedule journal of queue %s: %v", name, err)
+			}
+			scheduler = memory.NewJournaledScheduler(emailQueue, journal, pending, appLogger, scheduledJobs)
+			appLogger.Printf("Recovered %d scheduled jobs from %s", len(pending), queueDir)
+			appLogger.Printf("Initialized hybrid queue %s with capacity: %d (spilling to %s)", name, cfg.QueueCapacity, queueDir)
+		case config.QueueBackendPostgres:
+			// The configuration only allows the default queue on Postgres.
+			var err error
+			db, err = sql.Open("postgres", cfg.DatabaseURL)
+			if err != nil {
+				appLogger.Fatalf("Failed to open database: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := db.PingContext(ctx); err != nil {
+				appLogger.Fatalf("Could not connect to Postgres: %v", err)
+			}
+			if cfg.SQLAutoMigrate {
+				if err := postgres.Migrate(ctx, db); err != nil {
+					appLogger.Fatalf("Failed to migrate database: %v", err)
+				}
+			}
+			cancel()
+			postgresQueue, err := postgres.NewPostgresQueue(db, cfg.DatabaseURL, deadLetters, appLogger, queueDepth, scheduledJobs, cfg.ConsumerName, cfg.VisibilityTimeout, cfg.SQLPollInterval, cfg.SQLMaxDeliver)
+			if err != nil {
+				appLogger.Fatalf("Failed to initialize Postgres queue: %v", err)
+			}
+			emailQueue = postgresQueue
+			scheduler = postgresQueue
+			appLogger.Printf("Initialized Postgres queue (consumer: %s, lease: %s, max deliver: %d)", cfg.ConsumerName, cfg.VisibilityTimeout, cfg.SQLMaxDeliver)
+		case config.QueueBackendNATS:
+			// The configuration only allows the default queue on NATS.
+			var err error
+			natsConn, err = nats.Connect(cfg.NATSURL, cfg.ConsumerName, appLogger)
+			if err != nil {
+				appLogger.Fatalf("Could not connect to NATS: %v", err)
+			}
+			jetStreamQueue, err := nats.NewJetStreamQueue(natsConn, deadLetters, appLogger, queueDepth, cfg.VisibilityTimeout, cfg.NATSMaxDeliver)
+			if err != nil {
+				appLogger.Fatalf("Failed to initialize JetStream queue: %v", err)
+			}
+			emailQueue = jetStreamQueue
+			// JetStream cannot hold messages back, so scheduled jobs are kept in memory.
+			scheduler = memory.NewScheduler(emailQueue, appLogger, scheduledJobs)
+			appLogger.Printf("Initialized JetStream queue at %s (ack wait: %s, max deliver: %d)", cfg.NATSURL, cfg.VisibilityTimeout, cfg.NATSMaxDeliver)
+		default:
+			emailQueue = memory.NewMemoryQueue(cfg.QueueCapacity, memory.Overflow{
+				Policy:  memory.OverflowPolicy(cfg.QueueOverflow),
+				Timeout: cfg.EnqueueTimeout,
+				DLQ:     deadLetters,
+			}, appLogger, queueDepth)
+			appLogger.Printf("Initialized in-memory queue %s with capacity: %d (overflow: %s)", name, cfg.QueueCapacity, cfg.QueueOverflow)
+			scheduler = memory.NewScheduler(emailQueue, appLogger, scheduledJobs)
+		}
+		queues = append(queues, service.NamedQueue{
+			Name:          name,
+			Queue:         emailQueue,
+			Scheduler:     scheduler,
+			MaxRetries:    queueCfg.MaxRetries,
+			RetryPolicies: queueCfg.RetryPolicies,
+		})
+	}
+
+	// Initialize the store remembering Idempotency-Key headers
+	var idempotencyStore ports.IdempotencyStore
+	switch cfg.IdempotencyStore {
+	case config.StoreRedis:
+		if redisClient == nil {
+			redisClient = connectRedis(cfg, appLogger)
+		}
+		idempotencyStore = idempotencyredis.NewStore(redisClient, cfg.RedisKeyPrefix, cfg.IdempotencyLease, cfg.IdempotencyTTL)
+		appLogger.Printf("Idempotency keys are stored in Redis at %s (TTL: %s)", cfg.RedisAddr, cfg.IdempotencyTTL)
+	default:
+		idempotencyStore = idempotencymemory.NewStore(cfg.IdempotencyLease, cfg.IdempotencyTTL)
+		appLogger.Printf("Idempotency keys are stored in memory (TTL: %s)", cfg.IdempotencyTTL)
+	}
+
+	// Initialize the store of drip sequences and their enrollments
+	var sequenceStore ports.SequenceStore
+	switch cfg.SequenceStore {
+	case config.StoreRedis:
+		if redisClient == nil {
+			redisClient = connectRedis(cfg, appLogger)
+		}
+		sequenceStore = sequenceredis.NewStore(redisClient, cfg.RedisKeyPrefix, cfg.JobStateTTL)
+		appLogger.Printf("Sequences are stored in Redis at %s", cfg.RedisAddr)
+	default:
+		sequenceStore = sequencememory.NewStore(cfg.JobStateTTL)
+		appLogger.Printf("Sequences are stored in memory")
+	}
+
+	// Initialize the store buffering the jobs of digests
+	var digestStore ports.DigestStore
+	switch cfg.DigestStore {
+	case config.StoreRedis:
+		if redisClient == nil {
+			redisClient = connectRedis(cfg, appLogger)
+		}
+		digestStore = digestredis.NewStore(redisClient, cfg.RedisKeyPrefix, deadLetters, appLogger)
+		appLogger.Printf("Digest buffers are stored in Redis at %s (window: %s)", cfg.RedisAddr, cfg.DigestWindow)
+	default:
+		digestStore = digestmemory.NewStore()
+		appLogger.Printf("Digest buffers are stored in memory (window: %s)", cfg.DigestWindow)
+	}
+
+	// Initialize the store of recurring schedules and the election of the
+	// instance firing them. Without a store, schedules are disabled.
+	var scheduleStore ports.ScheduleStore
+	var scheduleLeader ports.LeaderElector
+	switch cfg.ScheduleStore {
+	case config.StoreRedis:
+		if redisClient == nil {
+			redisClient = connectRedis(cfg, appLogger)
+		}
+		scheduleStore = scheduleredis.NewStore(redisClient, cfg.RedisKeyPrefix)
+		scheduleLeader = leaderredis.NewElector(redisClient, cfg.RedisKeyPrefix, "email_schedule_leader", cfg.ConsumerName, cfg.ScheduleLease)
+		appLogger.Printf("Schedules are stored in Redis at %s (leader lease: %s)", cfg.RedisAddr, cfg.ScheduleLease)
+	case config.StoreMemory:
+		scheduleStore = schedulememory.NewStore()
+		scheduleLeader = leadermemory.NewElector()
+		appLogger.Warnf("Schedules are stored in memory: they are lost on restart, and every instance fires its own")
+	default:
+		appLogger.Printf("Recurring schedules are disabled: set SCHEDULE_STORE to enable them")
+	}
+
+	// Initialize renderer for HTML post-processing
+	renderer := render.NewRenderer(cfg.InlineCSS, cfg.GenerateTextBody)
+
+	// Initialize renderer merging buffered jobs into digests
+	digestSubject := render.DefaultDigestSubject
+	if cfg.DigestSubject != "" {
+		digestSubject = cfg.DigestSubject
+	}
+	digestTemplate := render.DefaultDigestTemplate
+	if cfg.DigestTemplate != "" {
+		content, err := os.ReadFile(cfg.DigestTemplate)
+		if err != nil {
+			appLogger.Fatalf("Failed to read digest template: %v", err)
+		}
+		digestTemplate = string(content)
+	}
+	digestRenderer, err := render.NewDigestRenderer(digestSubject, digestTemplate)
+	if err != nil {
+		appLogger.Fatalf("Failed to initialize digest renderer: %v", err)
 	}
 
 	// Initialize email service
 	emailService := service.NewEmailService(
-		emailQueue,
+		queues,
+		jobStates,
 		deadLetterQueue,
+		renderer,
 		appLogger,
 		metrics.EmailJobsEnqueuedTotal,
 		metrics.EmailJobsProcessedTotal,
 		metrics.EmailJobsFailedTotal,
 		metrics.EmailJobsRetriedTotal,
 		metrics.EmailJobsDLQTotal,
+		metrics.EmailJobsCancelledTotal,
+		metrics.EmailJobsExpiredTotal,
 		metrics.EmailProcessingDuration,
-		cfg.MaxRetries,
-		cfg.RetryDelaySeconds,
 	)
 
-	// Initialize worker pool
-	workerPool := worker.NewWorkerPool(cfg.WorkerCount, emailQueue, appLogger)
-	appLogger.Printf("Starting %d email workers...", cfg.WorkerCount)
-	workerPool.Start(emailService.ProcessEmailJob) // Pass the processing function
+	// Initialize digest service, which merges jobs with a digest key into digests
+	digestService := service.NewDigestService(
+		emailService,
+		digestStore,
+		jobStates,
+		deadLetters,
+		renderer,
+		digestRenderer,
+		cfg.DigestWindow,
+		appLogger,
+		metrics.EmailDigestJobsBufferedTotal,
+		metrics.EmailDigestsTotal,
+	)
+
+	// Initialize sequence service, which drives the steps of drip sequences
+	sequenceService := service.NewSequenceService(
+		sequenceStore,
+		digestService,
+		appLogger,
+		metrics.EmailSequenceEnrollmentsTotal,
+		metrics.EmailSequenceEnrollmentsFinishedTotal,
+	)
+
+	// Initialize schedule service, which enqueues the runs of recurring schedules
+	var scheduleService ports.ScheduleService
+	var scheduleHandler *handlers.ScheduleHandler
+	if scheduleStore != nil {
+		scheduleService = service.NewScheduleService(
+			scheduleStore,
+			digestService,
+			scheduleLeader,
+			cfg.ScheduleInterval,
+			cfg.ScheduleGrace,
+			appLogger,
+			metrics.EmailScheduleRunsTotal,
+			metrics.EmailScheduleRunsMissedTotal,
+			metrics.EmailScheduleRecipientsFailedTotal,
+			metrics.EmailScheduleLeader,
+		)
+		scheduleHandler = handlers.NewScheduleHandler(scheduleService, appLogger)
+	}
 
-	// Initialize HTTP handlers and routes
-	emailHandler := handlers.NewEmailHandler(emailService, appLogger)
+	// Initialize a worker pool per named queue
+	workerPools := make([]*worker.WorkerPool, len(queues))
+	for i, queue := range queues {
+		workers := cfg.Queues[i].Workers
+		workerPools[i] = worker.NewWorkerPool(workers, queue.Queue, cfg.PriorityWeights, cfg.StarvationLimit, appLogger)
+		appLogger.Printf("Starting %d email workers for queue %s...", workers, queue.Name)
+		workerPools[i].Start(sequenceService.ProcessEmailJob) // Pass the processing function, which continues sequences and merges digests
+	}
+
+	// Start firing recurring schedules once this instance leads
+	if scheduleService != nil {
+		appLogger.Printf("Starting schedule loop (interval: %s, consumer: %s)...", cfg.ScheduleInterval, cfg.ConsumerName)
+		scheduleService.Start()
+	}
+
+	// Watch Redis, which the service may have started without
+	var redisMonitor *redis.Monitor
+	var healthChecks []ports.HealthChecker
+	if redisClient != nil {
+		redisMonitor = redis.NewMonitor(redisClient, appLogger)
+		healthChecks = append(healthChecks, redisMonitor)
+	}
+
+	// Initialize HTTP handlers and routes. API requests are answered with
+	// 503 while Redis is unavailable.
+	emailHandler := handlers.NewEmailHandler(digestService, idempotencyStore, cfg.BatchMaxSize, appLogger)
+	sequenceHandler := handlers.NewSequenceHandler(sequenceService, appLogger)
+	healthHandler := handlers.NewHealthHandler(healthChecks...)
+	api := http.NewServeMux()
+	v1.SetupRoutes(api, emailHandler, sequenceHandler, scheduleHandler)
 	mux := http.NewServeMux()
-	v1.SetupRoutes(mux, emailHandler)
+	mux.Handle("/", healthHandler.RequireReady(api))
+	mux.HandleFunc("GET /readyz", healthHandler.Ready)
 
 	// Add Prometheus metrics handler
 	mux.Handle("/metrics", promhttp.Handler())
@@ -104,14 +419,68 @@ func main() {
 			appLogger.Println("HTTP server gracefully stopped.")
 		}
 
-		// 2. Close the queue (no new jobs can be enqueued)
-		emailQueue.Close()
-		appLogger.Println("Email queue closed for new jobs.")
+		// 2. Stop firing schedules and let another instance take over
+		if scheduleService != nil {
+			scheduleService.Stop()
+		}
 
-		// 3. Signal workers to stop and wait for them to finish current jobs
-		workerPool.Stop()
+		// 3. Close the queues (no new jobs can be enqueued)
+		for _, queue := range queues {
+			queue.Queue.Close()
+		}
+		appLogger.Println("Email queues closed for new jobs.")
+
+		// 4. Let workers drain the queues, then wait for them to finish current jobs
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer drainCancel()
+		for _, workerPool := range workerPools {
+			workerPool.Stop(drainCtx)
+		}
 		appLogger.Println("All workers stopped.")
 
+		// 5. Stop the schedulers once no worker can schedule a retry anymore
+		for _, queue := range queues {
+			queue.Scheduler.Close()
+		}
+
+		// 6. Close the backend connections once no worker needs them to acknowledge jobs
+		if redisMonitor != nil {
+			redisMonitor.Close()
+		}
+		if redisClient != nil {
+			if err := redisClient.Close(); err != nil {
+				appLogger.Errorf("Redis client close error: %v", err)
+			}
+		}
+		if db != nil {
+			if err := db.Close(); err != nil {
+				appLogger.Errorf("Database close error: %v", err)
+			}
+		}
+		if natsConn != nil {
+			natsConn.Close()
+		}
+
 		appLogger.Println("Application shutdown complete.")
 	})
 }
+
+// connectRedis creates the Redis client shared by the queue and the stores,
+// waiting up to REDIS_CONNECT_TIMEOUT_SECONDS for Redis to come up. If it
+// does not, the service starts degraded and the client keeps reconnecting.
+func connectRedis(cfg *config.Config, l *logger.Logger) goredis.UniversalClient {
+	client, err := redis.NewRedisClient(redis.ClientOptions{
+		Mode:             redis.Mode(cfg.RedisMode),
+		Addrs:            cfg.RedisAddrs,
+		Password:         cfg.RedisPassword,
+		DB:               cfg.RedisDB,
+		MasterName:       cfg.RedisMasterName,
+		SentinelPassword: cfg.SentinelPassword,
+		ConnectWait:      cfg.RedisConnectWait,
+	}, l)
+	if err != nil {
+		l.Fatalf("Invalid Redis configuration: %v", err)
+	}
+	l.Printf("Using Redis at %s (mode: %s)", cfg.RedisAddr, cfg.RedisMode)
+	return client
+}
@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Time zones of the tests, also without a zoneinfo database
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "*/15 9-17 * * mon-fri"},
+		{spec: "0 0 1,15 jan,JUL *"},
+		{spec: "5/20 * * * 7"},
+		{spec: "@weekly"},
+		{spec: " @Daily "},
+		{spec: "0 0 ? * sun"},
+		{spec: "", wantErr: true},
+		{spec: "* * * *", wantErr: true},
+		{spec: "* * * * * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "* 24 * * *", wantErr: true},
+		{spec: "* * 0 * *", wantErr: true},
+		{spec: "* * * 13 *", wantErr: true},
+		{spec: "* * * * 8", wantErr: true},
+		{spec: "* * * * fri-mon", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "* * * foo *", wantErr: true},
+		{spec: "@often", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseCron(tt.spec); (err != nil) != tt.wantErr {
+			t.Errorf("ParseCron(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{"0 9 * * mon", "2026-10-18T12:00:00Z", "2026-10-19T09:00:00Z"},   // Sunday to Monday
+		{"0 9 * * mon", "2026-10-19T09:00:00Z", "2026-10-26T09:00:00Z"},   // Strictly after
+		{"*/15 * * * *", "2026-10-18T12:07:30Z", "2026-10-18T12:15:00Z"},  // Seconds are dropped
+		{"0 0 29 2 *", "2026-10-18T00:00:00Z", "2028-02-29T00:00:00Z"},    // Next leap year
+		{"0 0 1 * *", "2026-12-15T00:00:00Z", "2027-01-01T00:00:00Z"},     // Year wrap
+		{"0 12 13 * fri", "2026-10-18T00:00:00Z", "2026-10-23T12:00:00Z"}, // Either day matches
+		{"0 0 * * 7", "2026-10-18T00:00:00Z", "2026-10-25T00:00:00Z"},     // 7 is Sunday
+		{"@hourly", "2026-10-18T23:30:00Z", "2026-10-19T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		expr, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) error = %v", tt.spec, err)
+		}
+		after, _ := time.Parse(time.RFC3339, tt.after)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if got := expr.Next(after); !got.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.after, got, tt.want)
+		}
+	}
+
+	// An expression that never matches ends the search.
+	expr, _ := ParseCron("0 0 30 2 *")
+	if got := expr.Next(time.Now()); !got.IsZero() {
+		t.Errorf("Next() of February 30 = %s, want the zero time", got)
+	}
+}
+
+func TestCronNextAcrossDaylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  string
+	}{
+		{
+			// 2:30 does not exist on 2026-03-08, when clocks skip from 2:00 to 3:00.
+			name:  "skipped time",
+			spec:  "30 2 * * *",
+			after: time.Date(2026, 3, 8, 0, 0, 0, 0, ny),
+			want:  "2026-03-09T02:30:00-04:00",
+		},
+		{
+			// 1:30 happens twice on 2026-11-01, first in EDT, then in EST.
+			name:  "repeated time",
+			spec:  "30 1 * * *",
+			after: time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC).In(ny), // 1:30 EDT
+			want:  "2026-11-02T01:30:00-05:00",
+		},
+		{
+			name:  "hourly through the repeated hour",
+			spec:  "0 * * * *",
+			after: time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC).In(ny), // 1:30 EDT
+			want:  "2026-11-01T02:00:00-05:00",
+		},
+	}
+	for _, tt := range tests {
+		expr, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) error = %v", tt.spec, err)
+		}
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		got := expr.Next(tt.after)
+		if !got.Equal(want) {
+			t.Errorf("%s: %q.Next(%s) = %s, want %s", tt.name, tt.spec, tt.after, got, tt.want)
+		}
+		if got.Location() != ny {
+			t.Errorf("%s: Next() location = %s, want %s", tt.name, got.Location(), ny)
+		}
+	}
+}
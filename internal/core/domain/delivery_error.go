@@ -0,0 +1,139 @@
+package domain
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// FailurePermanent marks failures that no retry can fix, such as an unknown
+// recipient. It has no retry policy: permanently failed jobs go straight to
+// the DLQ.
+const FailurePermanent FailureClass = "permanent"
+
+// DeliveryError is a failed delivery attempt as reported by the receiving
+// SMTP server or the email provider's API.
+type DeliveryError struct {
+	Class        FailureClass `json:"class"`
+	Code         int          `json:"code,omitempty"`          // SMTP reply code, e.g. 550, or the provider's HTTP status
+	EnhancedCode string       `json:"enhanced_code,omitempty"` // RFC 3463 enhanced status code, e.g. "5.1.1"
+	Provider     string       `json:"provider,omitempty"`      // Provider that reported the error, empty for SMTP
+	ProviderCode string       `json:"provider_code,omitempty"` // Provider-specific error code
+	Message      string       `json:"message"`                 // Diagnostic text as returned
+}
+
+// Error returns the diagnostic in the form it was reported, e.g.
+// "550 5.1.1 no such user".
+func (e *DeliveryError) Error() string {
+	var parts []string
+	if e.Provider != "" {
+		parts = append(parts, e.Provider+":")
+	}
+	if e.Code != 0 {
+		parts = append(parts, strconv.Itoa(e.Code))
+	}
+	if e.EnhancedCode != "" {
+		parts = append(parts, e.EnhancedCode)
+	}
+	if e.ProviderCode != "" {
+		parts = append(parts, e.ProviderCode)
+	}
+	parts = append(parts, e.Message)
+	return strings.Join(parts, " ")
+}
+
+// FailureClass returns the class the error was classified as.
+func (e *DeliveryError) FailureClass() FailureClass { return e.Class }
+
+// Permanent reports whether retrying the delivery cannot succeed.
+func (e *DeliveryError) Permanent() bool { return e.Class == FailurePermanent }
+
+// rateLimitedStatus lists enhanced status codes that servers use for
+// throttling, with either class digit.
+var rateLimitedStatus = map[string]bool{
+	"4.7.28": true, // Sending rate too high (Gmail and others)
+	"5.7.28": true, // Sending volume too high
+	"4.4.5":  true, // System congestion
+	"5.4.5":  true, // Daily sending quota exceeded
+	"4.5.3":  true, // Too many recipients
+}
+
+// NewSMTPError classifies an SMTP reply. The enhanced status code decides
+// when present, the reply code otherwise: 4xx replies are transient, 5xx
+// permanent. Greylisting and throttling replies get their own classes, as
+// they need longer delays rather than quick retries.
+func NewSMTPError(code int, enhancedCode, message string) *DeliveryError {
+	e := &DeliveryError{Code: code, EnhancedCode: enhancedCode, Message: message}
+
+	text := strings.ToLower(message)
+	switch {
+	case rateLimitedStatus[enhancedCode], strings.Contains(text, "rate limit"), strings.Contains(text, "too many"):
+		e.Class = FailureRateLimited
+	case strings.Contains(text, "greylist"), strings.Contains(text, "graylist"):
+		e.Class = FailureGreylisted
+	case strings.HasPrefix(enhancedCode, "5."):
+		e.Class = FailurePermanent
+	case strings.HasPrefix(enhancedCode, "4."):
+		e.Class = FailureTransient
+	case code >= 500 && code < 600:
+		e.Class = FailurePermanent
+	default:
+		e.Class = FailureTransient
+	}
+	return e
+}
+
+// providerErrorCodes maps provider-specific error codes to failure classes,
+// for codes where the HTTP status alone is misleading.
+var providerErrorCodes = map[string]map[string]FailureClass{
+	"ses": {
+		"MessageRejected":           FailurePermanent,
+		"MailFromDomainNotVerified": FailurePermanent,
+		"AccountSendingPaused":      FailureTransient,
+		"Throttling":                FailureRateLimited,
+		"LimitExceeded":             FailureRateLimited,
+	},
+	"sendgrid": {
+		"invalid_email": FailurePermanent,
+		"bounced":       FailurePermanent,
+		"blocked":       FailurePermanent,
+	},
+	"mailgun": {
+		"suppressed": FailurePermanent,
+	},
+}
+
+// NewProviderError classifies an error returned by an email provider's HTTP
+// API. Known provider codes decide first. Otherwise 429 is rate limiting,
+// 408 and 5xx are transient and other 4xx are permanent, except 401 and 403:
+// they point at the account rather than the message, so the job is kept for
+// when the account is fixed.
+func NewProviderError(provider string, status int, providerCode, message string) *DeliveryError {
+	e := &DeliveryError{Code: status, Provider: provider, ProviderCode: providerCode, Message: message}
+
+	if class, ok := providerErrorCodes[provider][providerCode]; ok {
+		e.Class = class
+		return e
+	}
+	switch {
+	case status == 429:
+		e.Class = FailureRateLimited
+	case status == 401, status == 403, status == 408, status >= 500:
+		e.Class = FailureTransient
+	case status >= 400:
+		e.Class = FailurePermanent
+	default:
+		e.Class = FailureTransient
+	}
+	return e
+}
+
+// AsDeliveryError returns err as a DeliveryError, wrapping errors that are
+// not one (e.g. network errors) as transient failures.
+func AsDeliveryError(err error) *DeliveryError {
+	var de *DeliveryError
+	if errors.As(err, &de) {
+		return de
+	}
+	return &DeliveryError{Class: ClassOf(err), Message: err.Error()}
+}
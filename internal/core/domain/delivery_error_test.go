@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewSMTPErrorClassifies(t *testing.T) {
+	tests := []struct {
+		code     int
+		enhanced string
+		message  string
+		want     FailureClass
+	}{
+		{550, "5.1.1", "no such user", FailurePermanent},
+		{451, "4.3.0", "temporary server error", FailureTransient},
+		{421, "4.7.28", "sending rate too high", FailureRateLimited},
+		{550, "5.7.28", "daily volume exceeded", FailureRateLimited},
+		{452, "", "Too many connections", FailureRateLimited},
+		{450, "4.2.0", "Greylisted, try again later", FailureGreylisted},
+		{451, "", "graylisting in action", FailureGreylisted},
+		{554, "", "transaction failed", FailurePermanent},
+		{421, "", "service not available", FailureTransient},
+		// The enhanced code decides over the reply code.
+		{550, "4.4.1", "no answer from host", FailureTransient},
+	}
+	for _, tt := range tests {
+		if got := NewSMTPError(tt.code, tt.enhanced, tt.message).Class; got != tt.want {
+			t.Errorf("NewSMTPError(%d, %q, %q) class = %s, want %s", tt.code, tt.enhanced, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestNewProviderErrorClassifies(t *testing.T) {
+	tests := []struct {
+		provider string
+		status   int
+		code     string
+		want     FailureClass
+	}{
+		{"ses", 400, "MessageRejected", FailurePermanent},
+		{"ses", 400, "Throttling", FailureRateLimited},
+		{"ses", 400, "AccountSendingPaused", FailureTransient},
+		{"sendgrid", 400, "bounced", FailurePermanent},
+		{"mailgun", 200, "suppressed", FailurePermanent},
+		{"sendgrid", 429, "", FailureRateLimited},
+		{"sendgrid", 401, "", FailureTransient},
+		{"sendgrid", 403, "", FailureTransient},
+		{"sendgrid", 408, "", FailureTransient},
+		{"sendgrid", 503, "", FailureTransient},
+		{"sendgrid", 422, "", FailurePermanent},
+		{"mailgun", 400, "Throttling", FailurePermanent}, // Codes are per provider
+	}
+	for _, tt := range tests {
+		if got := NewProviderError(tt.provider, tt.status, tt.code, "failed").Class; got != tt.want {
+			t.Errorf("NewProviderError(%s, %d, %q) class = %s, want %s", tt.provider, tt.status, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestDeliveryErrorString(t *testing.T) {
+	if got := NewSMTPError(550, "5.1.1", "no such user").Error(); got != "550 5.1.1 no such user" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := NewProviderError("ses", 400, "MessageRejected", "Email address is not verified").Error(); got != "ses: 400 MessageRejected Email address is not verified" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestAsDeliveryError(t *testing.T) {
+	smtp := NewSMTPError(550, "5.1.1", "no such user")
+	if got := AsDeliveryError(fmt.Errorf("send: %w", smtp)); got != smtp {
+		t.Errorf("AsDeliveryError(wrapped) = %v, want the wrapped error", got)
+	}
+	got := AsDeliveryError(errors.New("connection reset by peer"))
+	if got.Class != FailureTransient || got.Message != "connection reset by peer" || got.Permanent() {
+		t.Errorf("AsDeliveryError(plain) = %+v, want a transient failure with its message", got)
+	}
+}
@@ -0,0 +1,22 @@
+package domain
+
+// DigestRef marks a job that sends the digest of a buffer. Items is zero
+// until the buffered jobs have been merged into the job.
+type DigestRef struct {
+	Buffer string `json:"buffer"`
+	Items  int    `json:"items,omitempty"`
+}
+
+// DigestBuffer returns the buffer the job is collected in until its digest
+// is sent: one per recipient and digest key.
+func (j *EmailJob) DigestBuffer() string {
+	return j.DigestKey + ":" + j.To
+}
+
+// DigestItem is a job merged into a digest, rendered for the digest
+// template.
+type DigestItem struct {
+	ID      string
+	Subject string
+	Message Message
+}
@@ -1,16 +1,136 @@
 package domain
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/mail"
+	"strconv"
+	"time"
 )
 
 // EmailJob represents an email sending task.
 type EmailJob struct {
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
-	Retries int    `json:"retries"` // Added for retry logic
+	ID         string         `json:"id,omitempty"` // Assigned when the job is accepted
+	To         string         `json:"to"`
+	Subject    string         `json:"subject"`
+	Body       string         `json:"body"`                  // Body in BodyFormat (plain text unless stated otherwise)
+	BodyFormat BodyFormat     `json:"body_format,omitempty"` // Format of Body: text (default), html or markdown
+	HTMLBody   string         `json:"html_body,omitempty"`   // Optional HTML body
+	TextBody   string         `json:"text_body,omitempty"`   // Optional plain-text alternative for HTML bodies
+	Render     *RenderOptions `json:"render,omitempty"`      // Per-job overrides for HTML post-processing
+	SendAt     *time.Time     `json:"send_at,omitempty"`     // Hold the job until this time (RFC 3339)
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`  // Do not send the job after this time (RFC 3339)
+	TTL        string         `json:"ttl,omitempty"`         // Alternative to ExpiresAt: how long after acceptance the job expires, e.g. 15m
+	Priority   Priority       `json:"priority,omitempty"`    // Priority lane: high, normal (default) or bulk
+	Queue      string         `json:"queue,omitempty"`       // Named queue the job is delivered through (default: default)
+	Type       string         `json:"type,omitempty"`        // Job type, selects the retry policy (optional)
+	DigestKey  string         `json:"digest_key,omitempty"`  // Merge into a digest with the recipient's other jobs of this key
+	Retries    int            `json:"retries"`               // Added for retry logic
+
+	// Sequence is set on the jobs of sequence steps.
+	Sequence *SequenceRef `json:"sequence,omitempty"`
+	// Digest is set on the jobs that send a digest.
+	Digest *DigestRef `json:"digest,omitempty"`
+
+	// Retry state, set by the service when a delivery attempt fails.
+	FirstAttemptAt *time.Time     `json:"first_attempt_at,omitempty"` // Start of the first delivery attempt
+	NextAttemptAt  *time.Time     `json:"next_attempt_at,omitempty"`  // When the pending retry is due
+	RetryDelayMs   int64          `json:"retry_delay_ms,omitempty"`   // Backoff delay before the pending retry
+	LastError      *DeliveryError `json:"last_error,omitempty"`       // Why the latest delivery attempt failed
+
+	// Receipt is set by the queue on Dequeue and identifies this delivery
+	// when the job is acknowledged. It is never serialized.
+	Receipt string `json:"-"`
+}
+
+// BodyFormat describes how the Body field of a job is written.
+type BodyFormat string
+
+const (
+	BodyFormatText     BodyFormat = "text"
+	BodyFormatHTML     BodyFormat = "html"
+	BodyFormatMarkdown BodyFormat = "markdown"
+)
+
+// Priority names the queue lane a job is delivered through.
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityBulk   Priority = "bulk"
+)
+
+// Priorities lists every priority lane, from highest to lowest.
+var Priorities = []Priority{PriorityHigh, PriorityNormal, PriorityBulk}
+
+// Lane returns the priority lane of the job, defaulting to normal.
+func (j *EmailJob) Lane() Priority {
+	if j.Priority == "" {
+		return PriorityNormal
+	}
+	return j.Priority
+}
+
+// DefaultQueue is the queue jobs without a queue name go to.
+const DefaultQueue = "default"
+
+// QueueName returns the named queue of the job, defaulting to DefaultQueue.
+func (j *EmailJob) QueueName() string {
+	if j.Queue == "" {
+		return DefaultQueue
+	}
+	return j.Queue
+}
+
+// RenderOptions switches the HTML post-processing steps on or off for a job.
+// A nil field falls back to the service-wide default.
+type RenderOptions struct {
+	InlineCSS    *bool `json:"inline_css,omitempty"`
+	GenerateText *bool `json:"generate_text,omitempty"`
+}
+
+// Message is the fully rendered email handed to the sender.
+type Message struct {
+	To       string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+// NewJobID returns a new random job ID.
+func NewJobID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Not expected to happen; fall back to an ID that is unique per process.
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// IsScheduled reports whether the job should be held until its send_at time.
+func (j *EmailJob) IsScheduled(now time.Time) bool {
+	return j.SendAt != nil && j.SendAt.After(now)
+}
+
+// ResolveExpiry turns the ttl of a job accepted at now into its expires_at
+// time.
+func (j *EmailJob) ResolveExpiry(now time.Time) {
+	if j.ExpiresAt != nil || j.TTL == "" {
+		return
+	}
+	ttl, err := time.ParseDuration(j.TTL)
+	if err != nil {
+		return // Rejected by Validate
+	}
+	expiresAt := now.Add(ttl)
+	j.ExpiresAt = &expiresAt
+}
+
+// Expired reports whether the job must no longer be sent.
+func (j *EmailJob) Expired(now time.Time) bool {
+	return j.ExpiresAt != nil && !now.Before(*j.ExpiresAt)
 }
 
 // Validate checks if the EmailJob fields are valid.
@@ -21,10 +141,53 @@ func (j *EmailJob) Validate() error {
 	if j.Subject == "" {
 		return fmt.Errorf("subject field is required")
 	}
-	if j.Body == "" {
+	if j.Body == "" && j.HTMLBody == "" && j.TextBody == "" {
 		return fmt.Errorf("body field is required")
 	}
 
+	switch j.BodyFormat {
+	case "", BodyFormatText, BodyFormatHTML, BodyFormatMarkdown:
+	default:
+		return fmt.Errorf("unsupported body_format %q: must be text, html or markdown", j.BodyFormat)
+	}
+	if j.BodyFormat != "" && j.BodyFormat != BodyFormatText && j.Body == "" {
+		return fmt.Errorf("body field is required when body_format is %s", j.BodyFormat)
+	}
+	if j.BodyFormat != "" && j.BodyFormat != BodyFormatText && j.HTMLBody != "" {
+		return fmt.Errorf("html_body cannot be combined with body_format %s", j.BodyFormat)
+	}
+
+	switch j.Priority {
+	case "", PriorityHigh, PriorityNormal, PriorityBulk:
+	default:
+		return fmt.Errorf("unsupported priority %q: must be high, normal or bulk", j.Priority)
+	}
+
+	if j.ExpiresAt != nil && j.TTL != "" {
+		return fmt.Errorf("expires_at and ttl cannot be combined")
+	}
+	if j.TTL != "" {
+		ttl, err := time.ParseDuration(j.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl %q: must be a positive duration such as 90s or 15m", j.TTL)
+		}
+		if j.SendAt != nil && !time.Now().Add(ttl).After(*j.SendAt) {
+			return fmt.Errorf("ttl must end after send_at")
+		}
+	}
+	if j.ExpiresAt != nil {
+		if !j.ExpiresAt.After(time.Now()) {
+			return fmt.Errorf("expires_at is in the past")
+		}
+		if j.SendAt != nil && !j.ExpiresAt.After(*j.SendAt) {
+			return fmt.Errorf("expires_at must be after send_at")
+		}
+	}
+
+	if j.DigestKey != "" && j.SendAt != nil {
+		return fmt.Errorf("digest_key cannot be combined with send_at")
+	}
+
 	// Simple email format validation
 	if _, err := mail.ParseAddress(j.To); err != nil {
 		return fmt.Errorf("invalid email format for 'to' field: %w", err)
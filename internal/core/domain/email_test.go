@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailJobLane(t *testing.T) {
+	tests := []struct {
+		priority Priority
+		want     Priority
+	}{
+		{"", PriorityNormal},
+		{PriorityHigh, PriorityHigh},
+		{PriorityNormal, PriorityNormal},
+		{PriorityBulk, PriorityBulk},
+	}
+	for _, tt := range tests {
+		job := EmailJob{Priority: tt.priority}
+		if got := job.Lane(); got != tt.want {
+			t.Errorf("Lane() of priority %q = %s, want %s", tt.priority, got, tt.want)
+		}
+	}
+}
+
+func TestEmailJobValidatePriority(t *testing.T) {
+	for _, priority := range []Priority{"", PriorityHigh, PriorityNormal, PriorityBulk, "urgent"} {
+		job := EmailJob{To: "a@example.com", Subject: "Hi", Body: "Hello", Priority: priority}
+		err := job.Validate()
+		if priority == "urgent" && err == nil {
+			t.Errorf("Validate() accepted priority %q", priority)
+		}
+		if priority != "urgent" && err != nil {
+			t.Errorf("Validate() of priority %q error = %v", priority, err)
+		}
+	}
+}
+
+func TestEmailJobResolveExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		job  EmailJob
+		want *time.Time
+	}{
+		{"no expiry", EmailJob{}, nil},
+		{"ttl", EmailJob{TTL: "15m"}, ptr(now.Add(15 * time.Minute))},
+		{"expires_at", EmailJob{ExpiresAt: &expiresAt}, &expiresAt},
+		{"invalid ttl", EmailJob{TTL: "soon"}, nil},
+	}
+	for _, tt := range tests {
+		tt.job.ResolveExpiry(now)
+		if got := tt.job.ExpiresAt; (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+			t.Errorf("%s: ExpiresAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEmailJobExpired(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	job := EmailJob{ExpiresAt: &now}
+
+	if job.Expired(now.Add(-time.Nanosecond)) {
+		t.Errorf("Expired() just before expires_at = true, want false")
+	}
+	if !job.Expired(now) {
+		t.Errorf("Expired() at expires_at = false, want true")
+	}
+	if (&EmailJob{}).Expired(now) {
+		t.Errorf("Expired() of a job without expiry = true, want false")
+	}
+}
+
+func TestEmailJobValidateExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	soon := time.Now().Add(time.Minute)
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		job     EmailJob
+		wantErr bool
+	}{
+		{"ttl", EmailJob{TTL: "15m"}, false},
+		{"expires_at", EmailJob{ExpiresAt: &later}, false},
+		{"expires_at after send_at", EmailJob{SendAt: &soon, ExpiresAt: &later}, false},
+		{"both", EmailJob{TTL: "15m", ExpiresAt: &later}, true},
+		{"invalid ttl", EmailJob{TTL: "soon"}, true},
+		{"negative ttl", EmailJob{TTL: "-1m"}, true},
+		{"ttl ends before send_at", EmailJob{TTL: "30s", SendAt: &soon}, true},
+		{"expires_at in the past", EmailJob{ExpiresAt: &past}, true},
+		{"expires_at before send_at", EmailJob{SendAt: &later, ExpiresAt: &soon}, true},
+	}
+	for _, tt := range tests {
+		tt.job.To, tt.job.Subject, tt.job.Body = "a@example.com", "Hi", "Hello"
+		if err := tt.job.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
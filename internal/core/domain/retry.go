@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// FailureClass groups delivery failures that call for the same retry policy.
+type FailureClass string
+
+const (
+	FailureTransient   FailureClass = "transient"    // Network errors, timeouts, temporary server errors
+	FailureRateLimited FailureClass = "rate_limited" // The provider or receiving server throttles us
+	FailureGreylisted  FailureClass = "greylisted"   // The receiving server asks to try again later
+)
+
+// FailureClasses lists every failure class a retry policy can be set for.
+var FailureClasses = []FailureClass{FailureTransient, FailureRateLimited, FailureGreylisted}
+
+// Jitter selects how a retry delay is randomized.
+type Jitter string
+
+const (
+	JitterNone         Jitter = "none"         // Use the exponential delay as is
+	JitterFull         Jitter = "full"         // Pick a delay between zero and the exponential delay
+	JitterDecorrelated Jitter = "decorrelated" // Pick a delay between base and three times the previous one
+)
+
+// RetryPolicy decides when a failed job is attempted again.
+type RetryPolicy struct {
+	Base   time.Duration // Delay before the first retry
+	Factor float64       // Growth of the delay with every further retry
+	Cap    time.Duration // Upper bound of a single delay (0: none)
+	Jitter Jitter
+	MaxAge time.Duration // Give up once a retry would be due later than this after the first attempt (0: never)
+}
+
+// Delay returns how long to wait before the given retry, counting from 1.
+// prev is the delay before the previous retry, which decorrelated jitter
+// builds on.
+func (p RetryPolicy) Delay(retry int, prev time.Duration) time.Duration {
+	var d time.Duration
+	switch p.Jitter {
+	case JitterDecorrelated:
+		if prev < p.Base {
+			prev = p.Base
+		}
+		d = p.Base + randDuration(3*prev-p.Base)
+	default:
+		d = p.exponential(retry)
+		if p.Jitter == JitterFull {
+			d = randDuration(d)
+		}
+	}
+	if p.Cap > 0 && d > p.Cap {
+		d = p.Cap
+	}
+	return d
+}
+
+func (p RetryPolicy) exponential(retry int) time.Duration {
+	d := float64(p.Base)
+	for i := 1; i < retry; i++ {
+		d *= p.Factor
+		if p.Cap > 0 && d > float64(p.Cap) {
+			return p.Cap
+		}
+	}
+	return time.Duration(d)
+}
+
+// randDuration returns a random duration in [0, max].
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// RetryPolicies holds the retry policy of every failure class, and of every
+// failure class of the job types that have policies of their own.
+type RetryPolicies struct {
+	Default RetryPolicy
+	Classes map[FailureClass]RetryPolicy
+	Types   map[string]map[FailureClass]RetryPolicy
+}
+
+// For returns the retry policy for a job of jobType that failed with class.
+func (p *RetryPolicies) For(jobType string, class FailureClass) RetryPolicy {
+	if policy, ok := p.Types[jobType][class]; ok {
+		return policy
+	}
+	if policy, ok := p.Classes[class]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// ClassOf returns the failure class carried by err, or transient for errors
+// that do not carry one.
+func ClassOf(err error) FailureClass {
+	var classified interface{ FailureClass() FailureClass }
+	if errors.As(err, &classified) {
+		return classified.FailureClass()
+	}
+	return FailureTransient
+}
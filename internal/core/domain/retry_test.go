@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyExponentialDelay(t *testing.T) {
+	p := RetryPolicy{Base: time.Second, Factor: 2, Cap: 10 * time.Second, Jitter: JitterNone}
+	for retry, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second, // Capped
+		60: 10 * time.Second,
+	} {
+		if got := p.Delay(retry, 0); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", retry, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyJitterBounds(t *testing.T) {
+	full := RetryPolicy{Base: time.Second, Factor: 2, Jitter: JitterFull}
+	decorrelated := RetryPolicy{Base: time.Second, Cap: 20 * time.Second, Jitter: JitterDecorrelated}
+	for i := 0; i < 200; i++ {
+		if d := full.Delay(3, 0); d < 0 || d > 4*time.Second {
+			t.Fatalf("full jitter Delay(3) = %s, want within [0, 4s]", d)
+		}
+		if d := decorrelated.Delay(2, 3*time.Second); d < time.Second || d > 9*time.Second {
+			t.Fatalf("decorrelated Delay after 3s = %s, want within [1s, 9s]", d)
+		}
+		if d := decorrelated.Delay(2, time.Minute); d > 20*time.Second {
+			t.Fatalf("decorrelated Delay after 1m = %s, want at most the 20s cap", d)
+		}
+	}
+}
+
+func TestRetryPoliciesFor(t *testing.T) {
+	def := RetryPolicy{Base: time.Second}
+	rateLimited := RetryPolicy{Base: 30 * time.Second}
+	marketing := RetryPolicy{Base: time.Minute}
+	p := &RetryPolicies{
+		Default: def,
+		Classes: map[FailureClass]RetryPolicy{FailureRateLimited: rateLimited},
+		Types:   map[string]map[FailureClass]RetryPolicy{"marketing": {FailureRateLimited: marketing}},
+	}
+
+	tests := []struct {
+		jobType string
+		class   FailureClass
+		want    RetryPolicy
+	}{
+		{"", FailureTransient, def},
+		{"", FailureRateLimited, rateLimited},
+		{"receipt", FailureRateLimited, rateLimited},
+		{"marketing", FailureRateLimited, marketing},
+		{"marketing", FailureGreylisted, def},
+	}
+	for _, tt := range tests {
+		if got := p.For(tt.jobType, tt.class); got != tt.want {
+			t.Errorf("For(%q, %s) = %+v, want %+v", tt.jobType, tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestClassOf(t *testing.T) {
+	greylisted := NewSMTPError(450, "4.2.0", "greylisted, try again later")
+	if got := ClassOf(fmt.Errorf("send failed: %w", greylisted)); got != FailureGreylisted {
+		t.Errorf("ClassOf(wrapped greylisting) = %s, want greylisted", got)
+	}
+	if got := ClassOf(errors.New("connection reset")); got != FailureTransient {
+		t.Errorf("ClassOf(plain error) = %s, want transient", got)
+	}
+}
@@ -0,0 +1,167 @@
+package domain
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// CatchUpPolicy decides what happens to the runs of a schedule that were
+// missed, e.g. while no instance was running.
+type CatchUpPolicy string
+
+const (
+	CatchUpLatest CatchUpPolicy = "latest" // Fire the latest missed run once (default)
+	CatchUpSkip   CatchUpPolicy = "skip"   // Drop runs that are late by more than the grace period
+	CatchUpAll    CatchUpPolicy = "all"    // Fire every missed run
+)
+
+// Schedule is a recurring email: every time its cron expression matches in
+// its time zone, one job is enqueued for each of its recipients. Subject
+// and Body are Go templates executed with the data of the recipient, e.g.
+// {{.first_name}}.
+type Schedule struct {
+	Name       string              `json:"name"`
+	Cron       string              `json:"cron"`               // e.g. 0 9 * * mon
+	Timezone   string              `json:"timezone,omitempty"` // IANA time zone of the cron expression (default: UTC)
+	Subject    string              `json:"subject"`
+	Body       string              `json:"body"`
+	BodyFormat BodyFormat          `json:"body_format,omitempty"` // Format of Body: text (default), html or markdown
+	Render     *RenderOptions      `json:"render,omitempty"`      // Overrides for HTML post-processing of the jobs
+	Recipients []ScheduleRecipient `json:"recipients"`
+	CatchUp    CatchUpPolicy       `json:"catch_up,omitempty"` // What happens to missed runs (default: latest)
+	Paused     bool                `json:"paused,omitempty"`   // Paused schedules do not fire; missed runs are not caught up
+	Queue      string              `json:"queue,omitempty"`    // Named queue the jobs are delivered through
+	Priority   Priority            `json:"priority,omitempty"` // Priority lane of the jobs (default: normal)
+	Type       string              `json:"type,omitempty"`     // Job type, selects the retry policy
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+	LastRunAt  *time.Time          `json:"last_run_at,omitempty"` // Time of the last run that fired
+	NextRunAt  time.Time           `json:"next_run_at"`           // Time of the next run; zero if there is none
+	RetryRuns  []time.Time         `json:"retry_runs,omitempty"`  // Runs whose jobs did not all enqueue, retried on the next ticks
+}
+
+// ScheduleRecipient is a recipient of a schedule with the data filling in
+// its templates.
+type ScheduleRecipient struct {
+	To   string            `json:"to"`
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Location returns the time zone of the schedule.
+func (s *Schedule) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC // Checked by Validate
+	}
+	return loc
+}
+
+// NextAfter returns the first run of the schedule after t, or the zero time
+// if there is none.
+func (s *Schedule) NextAfter(t time.Time) time.Time {
+	expr, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{} // Checked by Validate
+	}
+	return expr.Next(t.In(s.Location()))
+}
+
+// Due returns the runs from NextRunAt up to now that fire under the
+// catch-up policy, how many are missed instead, and the run to wait for
+// next. With CatchUpAll, at most limit runs are returned; the others stay
+// due. Otherwise at most the latest run fires, and with CatchUpSkip only if
+// it is no more than grace late.
+func (s *Schedule) Due(now time.Time, grace time.Duration, limit int) (due []time.Time, missed int, next time.Time) {
+	expr, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil, 0, time.Time{} // Checked by Validate
+	}
+	loc := s.Location()
+
+	var count int
+	var latest time.Time
+	at := s.NextRunAt
+	for !at.IsZero() && !at.After(now) {
+		if s.CatchUp == CatchUpAll {
+			if len(due) == limit {
+				break
+			}
+			due = append(due, at)
+		}
+		latest = at
+		count++
+		at = expr.Next(at.In(loc))
+	}
+	switch {
+	case s.CatchUp == CatchUpAll:
+		return due, 0, at
+	case count == 0:
+		return nil, 0, at
+	case s.CatchUp == CatchUpSkip && now.Sub(latest) > grace:
+		return nil, count, at
+	}
+	return []time.Time{latest}, count - 1, at
+}
+
+// RenderTemplates executes the templates of the schedule for a recipient. HTML
+// bodies are rendered with html/template, so that data cannot inject
+// markup.
+func (s *Schedule) RenderTemplates(r ScheduleRecipient) (subject, body string, err error) {
+	return renderTemplates(s.Subject, s.Body, s.BodyFormat, r.Data)
+}
+
+// Validate checks the schedule, its cron expression and time zone, and
+// parses its templates.
+func (s *Schedule) Validate() error {
+	if !namePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid schedule name %q: use up to 64 lowercase letters, digits, '_' or '-'", s.Name)
+	}
+	expr, err := ParseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+	loc := time.UTC
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q: use an IANA name such as Europe/Berlin", s.Timezone)
+		}
+	}
+	if expr.Next(time.Now().In(loc)).IsZero() {
+		return fmt.Errorf("cron expression %q never matches", s.Cron)
+	}
+	if s.Subject == "" {
+		return fmt.Errorf("subject field is required")
+	}
+	if s.Body == "" {
+		return fmt.Errorf("body field is required")
+	}
+	switch s.BodyFormat {
+	case "", BodyFormatText, BodyFormatHTML, BodyFormatMarkdown:
+	default:
+		return fmt.Errorf("unsupported body_format %q: must be text, html or markdown", s.BodyFormat)
+	}
+	if len(s.Recipients) == 0 {
+		return fmt.Errorf("a schedule needs at least one recipient")
+	}
+	switch s.CatchUp {
+	case "", CatchUpLatest, CatchUpSkip, CatchUpAll:
+	default:
+		return fmt.Errorf("unsupported catch_up %q: must be latest, skip or all", s.CatchUp)
+	}
+	switch s.Priority {
+	case "", PriorityHigh, PriorityNormal, PriorityBulk:
+	default:
+		return fmt.Errorf("unsupported priority %q: must be high, normal or bulk", s.Priority)
+	}
+	if _, err := template.New("subject").Parse(s.Subject); err != nil {
+		return fmt.Errorf("invalid subject template: %w", err)
+	}
+	if _, err := template.New("body").Parse(s.Body); err != nil {
+		return fmt.Errorf("invalid body template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleDue(t *testing.T) {
+	start := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	now := start.Add(5*time.Hour + 10*time.Minute) // Runs at 0:00 to 5:00 are due
+	at := func(hour int) time.Time { return start.Add(time.Duration(hour) * time.Hour) }
+
+	tests := []struct {
+		name       string
+		catchUp    CatchUpPolicy
+		grace      time.Duration
+		wantDue    []time.Time
+		wantMissed int
+		wantNext   time.Time
+	}{
+		{name: "default fires the latest run", wantDue: []time.Time{at(5)}, wantMissed: 5, wantNext: at(6)},
+		{name: "latest", catchUp: CatchUpLatest, wantDue: []time.Time{at(5)}, wantMissed: 5, wantNext: at(6)},
+		{name: "skip a late run", catchUp: CatchUpSkip, grace: time.Minute, wantMissed: 6, wantNext: at(6)},
+		{name: "skip within grace", catchUp: CatchUpSkip, grace: 15 * time.Minute, wantDue: []time.Time{at(5)}, wantMissed: 5, wantNext: at(6)},
+		{name: "all up to the limit", catchUp: CatchUpAll, wantDue: []time.Time{at(0), at(1), at(2), at(3)}, wantNext: at(4)},
+	}
+	for _, tt := range tests {
+		s := Schedule{Cron: "@hourly", CatchUp: tt.catchUp, NextRunAt: start}
+		due, missed, next := s.Due(now, tt.grace, 4)
+		if !equalTimes(due, tt.wantDue) || missed != tt.wantMissed || !next.Equal(tt.wantNext) {
+			t.Errorf("%s: Due() = %v, %d, %s; want %v, %d, %s", tt.name, due, missed, next, tt.wantDue, tt.wantMissed, tt.wantNext)
+		}
+	}
+
+	// Nothing is due before NextRunAt.
+	s := Schedule{Cron: "@hourly", NextRunAt: at(6)}
+	if due, missed, next := s.Due(now, time.Minute, 4); len(due) != 0 || missed != 0 || !next.Equal(at(6)) {
+		t.Errorf("Due() before the next run = %v, %d, %s; want nothing due, next %s", due, missed, next, at(6))
+	}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScheduleNextAfterInTimezone(t *testing.T) {
+	s := Schedule{Cron: "0 9 * * mon", Timezone: "Europe/Berlin"}
+	got := s.NextAfter(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC); !got.Equal(want) { // 9:00 CET
+		t.Errorf("NextAfter() = %s, want %s", got, want)
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	valid := func() Schedule {
+		return Schedule{
+			Name:       "weekly-report",
+			Cron:       "0 9 * * mon",
+			Timezone:   "Europe/Berlin",
+			Subject:    "Report for {{.team}}",
+			Body:       "Hi {{.first_name}}",
+			Recipients: []ScheduleRecipient{{To: "a@example.com"}},
+		}
+	}
+	tests := []struct {
+		name    string
+		modify  func(*Schedule)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(s *Schedule) {}},
+		{name: "invalid name", modify: func(s *Schedule) { s.Name = "Weekly Report" }, wantErr: true},
+		{name: "invalid cron", modify: func(s *Schedule) { s.Cron = "0 9 * *" }, wantErr: true},
+		{name: "never matches", modify: func(s *Schedule) { s.Cron = "0 0 31 2 *" }, wantErr: true},
+		{name: "unknown timezone", modify: func(s *Schedule) { s.Timezone = "Mars/Olympus" }, wantErr: true},
+		{name: "no recipients", modify: func(s *Schedule) { s.Recipients = nil }, wantErr: true},
+		{name: "invalid catch_up", modify: func(s *Schedule) { s.CatchUp = "some" }, wantErr: true},
+		{name: "invalid template", modify: func(s *Schedule) { s.Subject = "{{.team" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		s := valid()
+		tt.modify(&s)
+		if err := s.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package domain
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// namePattern restricts sequence and schedule names to what is safe in
+// URLs, Redis keys and metric labels.
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Sequence is a drip campaign: emails sent to an enrolled recipient one
+// after the other, each a fixed time after the enrollment, until the last
+// one is sent or an exit event is triggered for the enrollment.
+type Sequence struct {
+	Name      string         `json:"name"`
+	Steps     []SequenceStep `json:"steps"`
+	ExitOn    []string       `json:"exit_on,omitempty"`  // Events that end an enrollment, e.g. converted
+	Queue     string         `json:"queue,omitempty"`    // Named queue the steps are delivered through
+	Priority  Priority       `json:"priority,omitempty"` // Priority lane of the steps (default: normal)
+	Type      string         `json:"type,omitempty"`     // Job type of the steps, selects the retry policy
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// SequenceStep is one email of a sequence. Subject and Body are Go
+// templates executed with the data of the enrollment, e.g. {{.first_name}}.
+type SequenceStep struct {
+	Delay      string         `json:"delay,omitempty"` // Time after the enrollment the step is sent, e.g. 48h
+	Subject    string         `json:"subject"`
+	Body       string         `json:"body"`
+	BodyFormat BodyFormat     `json:"body_format,omitempty"` // Format of Body: text (default), html or markdown
+	Render     *RenderOptions `json:"render,omitempty"`      // Overrides for HTML post-processing of the step
+}
+
+// Offset returns how long after the enrollment the step is sent.
+func (s *SequenceStep) Offset() time.Duration {
+	d, _ := time.ParseDuration(s.Delay) // Checked by Validate
+	return d
+}
+
+// RenderTemplates executes the templates of the step with data. HTML bodies are
+// rendered with html/template, so that data cannot inject markup.
+func (s *SequenceStep) RenderTemplates(data map[string]string) (subject, body string, err error) {
+	return renderTemplates(s.Subject, s.Body, s.BodyFormat, data)
+}
+
+// renderTemplates executes a subject and a body template with data. HTML
+// bodies are rendered with html/template.
+func renderTemplates(subjectText, bodyText string, format BodyFormat, data map[string]string) (subject, body string, err error) {
+	subject, err = executeText("subject", subjectText, data)
+	if err != nil {
+		return "", "", err
+	}
+	if format == BodyFormatHTML {
+		body, err = executeHTML("body", bodyText, data)
+	} else {
+		body, err = executeText("body", bodyText, data)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return subject, body, nil
+}
+
+func executeText(name, text string, data map[string]string) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+func executeHTML(name, text string, data map[string]string) (string, error) {
+	t, err := htmltemplate.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// ExitsOn reports whether event ends enrollments in the sequence.
+func (s *Sequence) ExitsOn(event string) bool {
+	for _, e := range s.ExitOn {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the sequence and parses the templates of its steps.
+func (s *Sequence) Validate() error {
+	if !namePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid sequence name %q: use up to 64 lowercase letters, digits, '_' or '-'", s.Name)
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("a sequence needs at least one step")
+	}
+	switch s.Priority {
+	case "", PriorityHigh, PriorityNormal, PriorityBulk:
+	default:
+		return fmt.Errorf("unsupported priority %q: must be high, normal or bulk", s.Priority)
+	}
+	for _, event := range s.ExitOn {
+		if strings.TrimSpace(event) == "" {
+			return fmt.Errorf("exit_on events must not be empty")
+		}
+	}
+
+	var prev time.Duration
+	for i, step := range s.Steps {
+		if step.Subject == "" {
+			return fmt.Errorf("step %d: subject field is required", i+1)
+		}
+		if step.Body == "" {
+			return fmt.Errorf("step %d: body field is required", i+1)
+		}
+		switch step.BodyFormat {
+		case "", BodyFormatText, BodyFormatHTML, BodyFormatMarkdown:
+		default:
+			return fmt.Errorf("step %d: unsupported body_format %q: must be text, html or markdown", i+1, step.BodyFormat)
+		}
+		var delay time.Duration
+		if step.Delay != "" {
+			var err error
+			delay, err = time.ParseDuration(step.Delay)
+			if err != nil || delay < 0 {
+				return fmt.Errorf("step %d: invalid delay %q: must be a duration such as 90m or 48h", i+1, step.Delay)
+			}
+		}
+		if delay < prev {
+			return fmt.Errorf("step %d: delay %s is shorter than the delay of the step before", i+1, step.Delay)
+		}
+		prev = delay
+		if _, err := template.New("subject").Parse(step.Subject); err != nil {
+			return fmt.Errorf("step %d: invalid subject template: %w", i+1, err)
+		}
+		if _, err := template.New("body").Parse(step.Body); err != nil {
+			return fmt.Errorf("step %d: invalid body template: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// EnrollmentState is where an enrollment is in its sequence.
+type EnrollmentState string
+
+const (
+	EnrollmentActive    EnrollmentState = "active"    // Waiting for or sending its current step
+	EnrollmentCompleted EnrollmentState = "completed" // Every step was sent
+	EnrollmentExited    EnrollmentState = "exited"    // Ended by an exit event
+	EnrollmentFailed    EnrollmentState = "failed"    // A step could not be delivered
+)
+
+// Enrollment is a recipient going through a sequence.
+type Enrollment struct {
+	ID         string            `json:"id"`
+	Sequence   string            `json:"sequence"`
+	To         string            `json:"to"`
+	Data       map[string]string `json:"data,omitempty"` // Template data of the steps
+	State      EnrollmentState   `json:"state"`
+	Step       int               `json:"step"`             // Index of the current step
+	JobID      string            `json:"job_id,omitempty"` // Job of the current step
+	Reason     string            `json:"reason,omitempty"` // Exit event, or why a step failed
+	EnrolledAt time.Time         `json:"enrolled_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// SequenceRef links a job to the enrollment and step it sends.
+type SequenceRef struct {
+	Enrollment string `json:"enrollment"`
+	Step       int    `json:"step"`
+}
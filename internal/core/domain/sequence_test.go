@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSequenceValidate(t *testing.T) {
+	step := SequenceStep{Subject: "Hi", Body: "Hello"}
+	withDelay := func(delay string) SequenceStep {
+		s := step
+		s.Delay = delay
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		seq     Sequence
+		wantErr bool
+	}{
+		{"valid", Sequence{Name: "welcome", Steps: []SequenceStep{step, withDelay("48h")}, ExitOn: []string{"converted"}}, false},
+		{"equal delays", Sequence{Name: "welcome", Steps: []SequenceStep{withDelay("1h"), withDelay("1h")}}, false},
+		{"invalid name", Sequence{Name: "Welcome!", Steps: []SequenceStep{step}}, true},
+		{"no steps", Sequence{Name: "welcome"}, true},
+		{"unknown priority", Sequence{Name: "welcome", Steps: []SequenceStep{step}, Priority: "urgent"}, true},
+		{"empty exit event", Sequence{Name: "welcome", Steps: []SequenceStep{step}, ExitOn: []string{" "}}, true},
+		{"missing subject", Sequence{Name: "welcome", Steps: []SequenceStep{{Body: "Hello"}}}, true},
+		{"invalid delay", Sequence{Name: "welcome", Steps: []SequenceStep{withDelay("2 days")}}, true},
+		{"decreasing delays", Sequence{Name: "welcome", Steps: []SequenceStep{withDelay("48h"), withDelay("1h")}}, true},
+		{"invalid template", Sequence{Name: "welcome", Steps: []SequenceStep{{Subject: "Hi {{.name", Body: "Hello"}}}, true},
+		{"unknown body format", Sequence{Name: "welcome", Steps: []SequenceStep{{Subject: "Hi", Body: "Hello", BodyFormat: "rtf"}}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.seq.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSequenceStepRenderTemplates(t *testing.T) {
+	step := SequenceStep{Subject: "Hi {{.name}}", Body: "<p>Hello {{.name}}</p>", BodyFormat: BodyFormatHTML, Delay: "90m"}
+
+	subject, body, err := step.RenderTemplates(map[string]string{"name": "<Ada>"})
+	if err != nil {
+		t.Fatalf("RenderTemplates() error = %v", err)
+	}
+	if subject != "Hi <Ada>" {
+		t.Errorf("subject = %q, want the data as is", subject)
+	}
+	if !strings.Contains(body, "&lt;Ada&gt;") {
+		t.Errorf("body = %q, want the data escaped in HTML", body)
+	}
+	if _, _, err := step.RenderTemplates(nil); err == nil {
+		t.Errorf("RenderTemplates() without data succeeded, want a missing key error")
+	}
+	if got := step.Offset(); got != 90*time.Minute {
+		t.Errorf("Offset() = %s, want 90m", got)
+	}
+}
+
+func TestSequenceExitsOn(t *testing.T) {
+	seq := Sequence{ExitOn: []string{"converted", "unsubscribed"}}
+	if !seq.ExitsOn("unsubscribed") || seq.ExitsOn("clicked") {
+		t.Errorf("ExitsOn() does not match the exit events %v", seq.ExitOn)
+	}
+}
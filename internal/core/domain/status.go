@@ -0,0 +1,54 @@
+package domain
+
+import "time"
+
+// JobState is where a job is in its lifecycle.
+type JobState string
+
+const (
+	JobQueued       JobState = "queued"        // Waiting in the queue for a worker
+	JobScheduled    JobState = "scheduled"     // Held until its send_at time
+	JobProcessing   JobState = "processing"    // A worker is delivering it
+	JobRetrying     JobState = "retrying"      // The last attempt failed; waiting for the next one
+	JobSent         JobState = "sent"          // Delivered
+	JobFailed       JobState = "failed"        // The last attempt failed; about to be retried or dead-lettered
+	JobDeadLettered JobState = "dead_lettered" // Given up on and stored in the DLQ
+	JobCancelled    JobState = "cancelled"     // Cancelled before it was sent
+	JobBuffered     JobState = "buffered"      // Waiting in a digest buffer
+	JobDigested     JobState = "digested"      // Merged into the digest email digest_id
+)
+
+// Cancellable reports whether a job in this state is still waiting and can
+// be cancelled.
+func (s JobState) Cancellable() bool {
+	return s == JobQueued || s == JobScheduled || s == JobRetrying
+}
+
+// Attempt is one delivery attempt of a job.
+type Attempt struct {
+	Number     int            `json:"number"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Provider   string         `json:"provider"`
+	Error      *DeliveryError `json:"error,omitempty"`
+}
+
+// JobStatus is the externally visible state of a job and its attempts.
+type JobStatus struct {
+	ID            string     `json:"id"`
+	State         JobState   `json:"state"`
+	To            string     `json:"to,omitempty"`
+	Subject       string     `json:"subject,omitempty"`
+	Type          string     `json:"type,omitempty"`
+	Priority      Priority   `json:"priority,omitempty"`
+	Queue         string     `json:"queue,omitempty"`
+	Provider      string     `json:"provider,omitempty"` // Provider of the latest attempt
+	SendAt        *time.Time `json:"send_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	Reason        string     `json:"reason,omitempty"`    // Why the job was dead-lettered
+	DigestID      string     `json:"digest_id,omitempty"` // Job of the digest the job was merged into
+	Attempts      []Attempt  `json:"attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// DigestStore buffers the jobs that are merged into a digest, one buffer per
+// recipient and digest key. Each buffer is owned by the digest job that
+// sends it, and only that job can take it.
+type DigestStore interface {
+	// Add appends a job to a buffer. The digest job digestID becomes the
+	// owner of the buffer if the job starts the buffer, or if the owner
+	// claimed the buffer before staleBefore, so its digest job is taken to be
+	// lost. Add returns whether digestID owns the buffer and when the first
+	// job of the buffer was added, which is now if the buffer was empty.
+	Add(ctx context.Context, buffer string, job domain.EmailJob, digestID string, now, staleBefore time.Time) (bool, time.Time, error)
+	// Take sets a buffer owned by the digest job digestID aside for it and
+	// returns its jobs in the order they were added, or none if the buffer
+	// is empty or owned by another digest job. The next job added starts a
+	// new buffer. Until they are deleted, taking the buffer again returns
+	// the same jobs, so a digest job delivered again after a crash still
+	// finds them.
+	Take(ctx context.Context, buffer, digestID string) ([]domain.EmailJob, error)
+	// Delete removes the jobs the digest job digestID took from a buffer,
+	// once the digest was sent or given up on.
+	Delete(ctx context.Context, buffer, digestID string) error
+}
@@ -0,0 +1,21 @@
+package ports
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnavailable is returned while a backend the service depends on cannot
+// be reached.
+var ErrUnavailable = errors.New("backend is unavailable")
+
+// HealthChecker reports whether a backend the service depends on can be
+// reached, e.g. to answer readiness probes.
+type HealthChecker interface {
+	// Name identifies the backend in readiness responses.
+	Name() string
+	// Check returns an error wrapping ErrUnavailable while the backend
+	// cannot be reached. It must not block for long, as it runs on every
+	// request while the service is degraded.
+	Check(ctx context.Context) error
+}
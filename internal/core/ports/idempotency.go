@@ -0,0 +1,28 @@
+package ports
+
+import "context"
+
+// IdempotencyRecord is what an idempotency key maps to.
+type IdempotencyRecord struct {
+	JobID       string `json:"job_id"`
+	Fingerprint string `json:"fingerprint"` // Hash of the request payload the key was first used with
+	Completed   bool   `json:"completed"`   // False while the first request is still being handled
+}
+
+// IdempotencyStore remembers the idempotency keys of recent requests, so
+// that a repeated request is answered instead of handled again. A key is
+// reserved for a short lease, so that it can be used again if its request
+// never completes, e.g. because the instance handling it crashed; the record
+// of a completed request expires after a longer TTL. Both are set by the
+// store.
+type IdempotencyStore interface {
+	// Reserve claims key for a new request. If the key is taken already, the
+	// existing record is returned with false and nothing is changed.
+	Reserve(ctx context.Context, key string, record IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// Complete marks the request that reserved key as handled. It does
+	// nothing if the reservation of record has expired.
+	Complete(ctx context.Context, key string, record IdempotencyRecord) error
+	// Release forgets key, e.g. after its request failed, so that it can be
+	// retried with the same key.
+	Release(ctx context.Context, key string) error
+}
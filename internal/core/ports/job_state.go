@@ -0,0 +1,30 @@
+package ports
+
+import (
+	"context"
+	"errors"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// ErrJobNotFound is returned for job IDs that have no stored status.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobNotCancellable is returned when cancelling a job that is no longer
+// waiting, e.g. because it is being sent or was sent already.
+var ErrJobNotCancellable = errors.New("job can no longer be cancelled")
+
+// JobStateStore keeps the status of every job for the status API. Statuses
+// expire after a TTL set by the store, counted from their last update.
+type JobStateStore interface {
+	// Update applies update to the status of job id and stores the result.
+	// A job without a stored status starts from one with only the ID set.
+	// If update returns an error, nothing is stored and the error is
+	// returned. Concurrent updates of the same job are applied one after
+	// the other.
+	Update(ctx context.Context, id string, update func(*domain.JobStatus) error) (domain.JobStatus, error)
+	// Get returns the status of job id, or ErrJobNotFound.
+	Get(ctx context.Context, id string) (domain.JobStatus, error)
+	// Delete removes the status of job id.
+	Delete(ctx context.Context, id string) error
+}
@@ -0,0 +1,15 @@
+package ports
+
+import "context"
+
+// LeaderElector decides which of the running instances is the leader, e.g.
+// the one that fires recurring schedules. Leadership is a lease that the
+// leader has to renew before it runs out.
+type LeaderElector interface {
+	// Campaign acquires leadership, or renews it if this instance leads
+	// already, and reports whether this instance is the leader.
+	Campaign(ctx context.Context) (bool, error)
+	// Resign gives up leadership if this instance has it, so that another
+	// instance can take over without waiting for the lease to run out.
+	Resign(ctx context.Context) error
+}
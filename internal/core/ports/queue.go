@@ -1,16 +1,81 @@
 package ports
 
-import "email-queue-service/internal/core/domain"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// ErrQueueClosed is returned by Dequeue once the queue is closed and has no
+// more jobs to hand out, and by Enqueue after the queue was closed.
+var ErrQueueClosed = errors.New("queue is closed")
+
+// ErrQueueFull is matched by the errors Enqueue returns when a bounded queue
+// has no room for a job.
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrUnknownQueue is returned when a job names a queue that is not configured.
+var ErrUnknownQueue = errors.New("unknown queue")
+
+// ErrLeaseLost is returned by ExtendLease when the job was handed back to
+// the queue already, e.g. because its lease ran out before it was extended.
+var ErrLeaseLost = errors.New("job lease lost")
+
+// QueueFullError is returned by bounded queues that have no room for a job.
+type QueueFullError struct {
+	// RetryAfter is how long the queue expects to need to drain its
+	// backlog at the rate it has been observed to drain.
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string { return "queue is full, cannot enqueue job" }
+
+// Is makes errors.Is(err, ErrQueueFull) match every QueueFullError.
+func (e *QueueFullError) Is(target error) bool { return target == ErrQueueFull }
 
 // Queue defines the interface for a job queue.
 type Queue interface {
 	// Enqueue adds a job to the queue. Returns an error if the queue is full or closed.
-	Enqueue(job domain.EmailJob) error
-	// Dequeue retrieves a job from the queue. Returns the job and true if successful,
-	// or an empty job and false if the queue is closed and empty.
-	Dequeue() (domain.EmailJob, bool)
-	// Close closes the job channel, signaling that no more jobs will be enqueued.
+	Enqueue(ctx context.Context, job domain.EmailJob) error
+	// Dequeue retrieves a job from the queue, blocking until one is ready.
+	// It returns ErrQueueClosed once the queue is closed and drained, and
+	// the context's error if ctx is cancelled or its deadline passes first.
+	// lanes lists the priority lanes in the order they are tried: a job from
+	// an earlier lane is preferred whenever one is ready. Lanes left out are
+	// not served.
+	// The job stays owned by the caller until it is passed to Ack or Nack.
+	Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error)
+	// Ack marks a dequeued job as done so it is never delivered again.
+	Ack(job domain.EmailJob) error
+	// Nack hands a dequeued job back to the queue so it can be delivered again.
+	Nack(job domain.EmailJob) error
+	// Close stops the queue from accepting new jobs and wakes up blocked
+	// Dequeue calls. Jobs already dequeued can still be acknowledged.
 	Close()
 	// IsClosed returns true if the queue is closed.
 	IsClosed() bool
 }
+
+// LeaseExtender is implemented by queues that hand out jobs for a limited
+// time only and deliver them again once it runs out. Workers extend the lease
+// of a job while they are still processing it, so that a slow send is not
+// delivered to a second worker.
+type LeaseExtender interface {
+	// LeaseDuration returns how long a lease lasts, or 0 if the queue does
+	// not lease jobs.
+	LeaseDuration() time.Duration
+	// ExtendLease renews the lease of a dequeued job for another
+	// LeaseDuration. It returns ErrLeaseLost if the job is no longer leased.
+	ExtendLease(job domain.EmailJob) error
+}
+
+// BatchQueue is implemented by queues that can add many jobs in one round
+// trip. Queues that do not implement it are filled one Enqueue at a time.
+type BatchQueue interface {
+	Queue
+	// EnqueueBatch adds jobs to the queue and returns one error per job, nil
+	// for the jobs that were enqueued.
+	EnqueueBatch(ctx context.Context, jobs []domain.EmailJob) []error
+}
@@ -0,0 +1,16 @@
+package ports
+
+import "email-queue-service/internal/core/domain"
+
+// Renderer defines the interface for turning an email job into a sendable message.
+type Renderer interface {
+	// Render builds the final message for a job, applying any HTML post-processing.
+	Render(job domain.EmailJob) (domain.Message, error)
+}
+
+// DigestRenderer defines the interface for merging jobs into a digest email.
+type DigestRenderer interface {
+	// RenderDigest builds the subject and HTML body of the digest of items
+	// sent to a recipient under a digest key.
+	RenderDigest(key, to string, items []domain.DigestItem) (subject, htmlBody string, err error)
+}
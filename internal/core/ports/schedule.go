@@ -0,0 +1,53 @@
+package ports
+
+import (
+	"context"
+	"errors"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// ErrScheduleNotFound is returned for schedule names that are not defined.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// ErrInvalidSchedule is returned when a schedule cannot be sent to one of
+// its recipients, e.g. because template data is missing.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ScheduleStore keeps the definitions of recurring schedules together with
+// the time of their next run.
+type ScheduleStore interface {
+	// UpdateSchedule applies update to schedule name and stores the result.
+	// A schedule that is not stored starts from one with only the name set.
+	// If update returns an error, nothing is stored and the error is
+	// returned. Concurrent updates of the same schedule are applied one
+	// after the other, so only one of them can claim a run.
+	UpdateSchedule(ctx context.Context, name string, update func(*domain.Schedule) error) (domain.Schedule, error)
+	// GetSchedule returns schedule name, or ErrScheduleNotFound.
+	GetSchedule(ctx context.Context, name string) (domain.Schedule, error)
+	// ListSchedules returns every schedule, ordered by name.
+	ListSchedules(ctx context.Context) ([]domain.Schedule, error)
+	// DeleteSchedule removes schedule name, or returns ErrScheduleNotFound.
+	DeleteSchedule(ctx context.Context, name string) error
+}
+
+// ScheduleService defines the interface for managing recurring schedules
+// and firing their runs.
+type ScheduleService interface {
+	// PutSchedule validates and stores a schedule definition. Its next run
+	// is kept if the cron expression and time zone did not change and it
+	// was not paused; otherwise it is the first match after now.
+	PutSchedule(ctx context.Context, schedule domain.Schedule) (domain.Schedule, error)
+	// GetSchedule returns a schedule, or ErrScheduleNotFound.
+	GetSchedule(ctx context.Context, name string) (domain.Schedule, error)
+	// ListSchedules returns every schedule, ordered by name.
+	ListSchedules(ctx context.Context) ([]domain.Schedule, error)
+	// DeleteSchedule removes a schedule, or returns ErrScheduleNotFound.
+	// Jobs of runs that fired already are not cancelled.
+	DeleteSchedule(ctx context.Context, name string) error
+	// Start starts the loop firing due runs while this instance is the
+	// leader.
+	Start()
+	// Stop stops the loop and gives up leadership.
+	Stop()
+}
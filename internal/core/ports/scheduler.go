@@ -0,0 +1,18 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// Scheduler defines the interface for holding jobs until they are due.
+// Due jobs are promoted into a Queue.
+type Scheduler interface {
+	// Schedule holds a job until at and then enqueues it.
+	Schedule(ctx context.Context, job domain.EmailJob, at time.Time) error
+	// Close stops promoting jobs. Durable schedulers keep the jobs they hold
+	// and promote them after the next start.
+	Close()
+}
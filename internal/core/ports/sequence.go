@@ -0,0 +1,71 @@
+package ports
+
+import (
+	"context"
+	"errors"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// ErrSequenceNotFound is returned for sequence names that are not defined.
+var ErrSequenceNotFound = errors.New("sequence not found")
+
+// ErrEnrollmentNotFound is returned for enrollment IDs that are not known.
+var ErrEnrollmentNotFound = errors.New("enrollment not found")
+
+// ErrInvalidEnrollment is returned when the steps of a sequence cannot be
+// sent to a recipient, e.g. because template data is missing.
+var ErrInvalidEnrollment = errors.New("invalid enrollment")
+
+// ErrUnknownEvent is returned for events that are not an exit condition of
+// the sequence.
+var ErrUnknownEvent = errors.New("event is not an exit condition of the sequence")
+
+// ErrEnrollmentFinished is returned when triggering an event for an
+// enrollment that has completed, exited or failed already.
+var ErrEnrollmentFinished = errors.New("enrollment has finished")
+
+// SequenceStore keeps sequence definitions and the enrollments going through
+// them. Enrollments are kept while they are active; finished ones expire
+// after a TTL set by the store.
+type SequenceStore interface {
+	// PutSequence creates or replaces a sequence.
+	PutSequence(ctx context.Context, seq domain.Sequence) error
+	// GetSequence returns the sequence name, or ErrSequenceNotFound.
+	GetSequence(ctx context.Context, name string) (domain.Sequence, error)
+	// UpdateEnrollment applies update to enrollment id and stores the
+	// result. An enrollment that is not stored starts from one with only
+	// the ID set. If update returns an error, nothing is stored and the
+	// error is returned. Concurrent updates of the same enrollment are
+	// applied one after the other.
+	UpdateEnrollment(ctx context.Context, id string, update func(*domain.Enrollment) error) (domain.Enrollment, error)
+	// GetEnrollment returns enrollment id, or ErrEnrollmentNotFound.
+	GetEnrollment(ctx context.Context, id string) (domain.Enrollment, error)
+	// DeleteEnrollment removes enrollment id.
+	DeleteEnrollment(ctx context.Context, id string) error
+}
+
+// SequenceService defines the interface for managing drip sequences.
+type SequenceService interface {
+	// PutSequence validates and stores a sequence definition. Enrollments
+	// already going through the sequence use the new steps from their next
+	// step on.
+	PutSequence(ctx context.Context, seq domain.Sequence) (domain.Sequence, error)
+	// GetSequence returns a sequence, or ErrSequenceNotFound.
+	GetSequence(ctx context.Context, name string) (domain.Sequence, error)
+	// Enroll starts the sequence for a recipient and schedules its first
+	// step. data fills in the templates of the steps.
+	Enroll(ctx context.Context, sequence, to string, data map[string]string) (domain.Enrollment, error)
+	// GetEnrollment returns an enrollment of a sequence, or
+	// ErrEnrollmentNotFound.
+	GetEnrollment(ctx context.Context, sequence, id string) (domain.Enrollment, error)
+	// TriggerEvent ends an enrollment if event is one of the exit conditions
+	// of its sequence, and cancels its pending step. It returns
+	// ErrUnknownEvent, or ErrEnrollmentFinished with the enrollment as it
+	// was if it had ended already.
+	TriggerEvent(ctx context.Context, sequence, id, event string) (domain.Enrollment, error)
+	// ProcessEmailJob processes a job through the EmailService and, for
+	// jobs of sequence steps, skips those of ended enrollments and schedules
+	// the next step once a step is sent.
+	ProcessEmailJob(job domain.EmailJob)
+}
@@ -1,16 +1,31 @@
 package ports
 
-import "email-queue-service/internal/core/domain"
+import (
+	"context"
+
+	"email-queue-service/internal/core/domain"
+)
 
 // EmailService defines the interface for managing email jobs.
 type EmailService interface {
 	// EnqueueEmail adds an email job to the queue.
-	EnqueueEmail(job domain.EmailJob) error
+	EnqueueEmail(ctx context.Context, job domain.EmailJob) error
+	// EnqueueEmails adds many email jobs and returns one error per job, nil
+	// for the jobs that were accepted.
+	EnqueueEmails(ctx context.Context, jobs []domain.EmailJob) []error
 	// ProcessEmailJob simulates sending an email and handles retry/DLQ logic.
 	ProcessEmailJob(job domain.EmailJob)
+	// GetEmailStatus returns the status of a job, or ErrJobNotFound.
+	GetEmailStatus(ctx context.Context, id string) (domain.JobStatus, error)
+	// CancelEmail cancels a job that is still waiting to be sent and returns
+	// its status. It returns ErrJobNotFound, or ErrJobNotCancellable with the
+	// status the job had when the cancel lost the race against a worker.
+	CancelEmail(ctx context.Context, id string) (domain.JobStatus, error)
 }
 
 // DeadLetterQueue defines the interface for storing failed jobs.
 type DeadLetterQueue interface {
-	Store(job domain.EmailJob, reason string)
+	// Store keeps a failed job. deliveryErr is the failure that ended its
+	// delivery, or nil if the job failed for another reason (e.g. rendering).
+	Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError)
 }
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func TestCancelEmailStopsQueuedJob(t *testing.T) {
+	ts := newTestService(t, 3)
+	ctx := context.Background()
+	if err := ts.EnqueueEmail(ctx, testJob("queued")); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+
+	status, err := ts.CancelEmail(ctx, "queued")
+	if err != nil || status.State != domain.JobCancelled {
+		t.Fatalf("CancelEmail() = %s, %v; want cancelled", status.State, err)
+	}
+	// Cancelling again succeeds without counting twice.
+	if status, err := ts.CancelEmail(ctx, "queued"); err != nil || status.State != domain.JobCancelled {
+		t.Errorf("second CancelEmail() = %s, %v; want cancelled", status.State, err)
+	}
+	if n := ts.count(ts.counters.cancelled); n != 1 {
+		t.Errorf("cancelled counter = %v, want 1", n)
+	}
+
+	// The worker that picks the job up drops it.
+	ts.ProcessEmailJob(ts.queue.enqueued()[0])
+	if len(ts.sent) != 0 {
+		t.Errorf("sent %d messages of a cancelled job, want 0", len(ts.sent))
+	}
+	if status := ts.status(t, "queued"); status.State != domain.JobCancelled || len(status.Attempts) != 0 {
+		t.Errorf("status = %s with %d attempts, want cancelled without attempts", status.State, len(status.Attempts))
+	}
+}
+
+func TestCancelEmailStopsRetry(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+	ts.ProcessEmailJob(testJob("retrying"))
+
+	status, err := ts.CancelEmail(context.Background(), "retrying")
+	if err != nil || status.State != domain.JobCancelled || status.NextAttemptAt != nil {
+		t.Fatalf("CancelEmail() = %+v, %v; want cancelled without a next attempt", status, err)
+	}
+	ts.ProcessEmailJob(ts.scheduler.scheduled()[0].job)
+	if len(ts.sent) != 1 {
+		t.Errorf("sent %d messages, want only the first attempt", len(ts.sent))
+	}
+}
+
+func TestCancelEmailOfCancelledJobThatExpired(t *testing.T) {
+	ts := newTestService(t, 3)
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(-time.Second)
+	job := testJob("expired")
+	job.ExpiresAt = &expiresAt
+	if err := ts.EnqueueEmail(ctx, job); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+	ts.CancelEmail(ctx, "expired")
+
+	// A cancelled job is not dead-lettered for expiring in the queue.
+	ts.ProcessEmailJob(ts.queue.enqueued()[0])
+	if n := len(ts.dlq.stored()); n != 0 {
+		t.Errorf("%d jobs dead-lettered, want the cancelled job dropped", n)
+	}
+}
+
+func TestCancelEmailLosesToWorker(t *testing.T) {
+	ts := newTestService(t, 3)
+	ctx := context.Background()
+	ts.ProcessEmailJob(testJob("sent"))
+
+	status, err := ts.CancelEmail(ctx, "sent")
+	if !errors.Is(err, ports.ErrJobNotCancellable) || status.State != domain.JobSent {
+		t.Errorf("CancelEmail() of a sent job = %s, %v; want ErrJobNotCancellable with the sent state", status.State, err)
+	}
+	if _, err := ts.CancelEmail(ctx, "unknown"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("CancelEmail() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+	if n := ts.count(ts.counters.cancelled); n != 0 {
+		t.Errorf("cancelled counter = %v, want 0", n)
+	}
+}
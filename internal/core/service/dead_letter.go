@@ -0,0 +1,45 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// deadLetterQueue moves jobs to the DLQ the way the service does: it counts
+// them and marks them as dead-lettered in the status API.
+type deadLetterQueue struct {
+	dlq        ports.DeadLetterQueue
+	jobStates  ports.JobStateStore
+	logger     *logger.Logger
+	dlqCounter *prometheus.CounterVec
+}
+
+// NewDeadLetterQueue wraps dlq for the queues and services that give up on
+// jobs outside the worker, such as a queue dropping jobs when it is full or
+// a broker giving up on redeliveries. The counter is labelled with the name
+// of the queue.
+func NewDeadLetterQueue(dlq ports.DeadLetterQueue, jobStates ports.JobStateStore, l *logger.Logger, dlqCount *prometheus.CounterVec) ports.DeadLetterQueue {
+	return &deadLetterQueue{
+		dlq:        dlq,
+		jobStates:  jobStates,
+		logger:     l,
+		dlqCounter: dlqCount,
+	}
+}
+
+// Store stores a job in the DLQ and records that it was given up on.
+func (d *deadLetterQueue) Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
+	d.dlq.Store(job, reason, deliveryErr)
+	d.dlqCounter.WithLabelValues(job.QueueName()).Inc()
+	trackStatus(d.jobStates, d.logger, job, func(status *domain.JobStatus) {
+		status.State = domain.JobDeadLettered
+		status.Reason = reason
+		status.NextAttemptAt = nil
+	})
+}
+
+// Ensure deadLetterQueue implements the ports.DeadLetterQueue interface
+var _ ports.DeadLetterQueue = (*deadLetterQueue)(nil)
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	jobstatememory "email-queue-service/internal/infrastructure/jobstate/memory"
+	"email-queue-service/internal/pkg/logger"
+)
+
+func TestDeadLetterQueueCountsAndTracksJobs(t *testing.T) {
+	dlq := &recordingDLQ{}
+	jobStates := jobstatememory.NewStore(time.Hour)
+	counter := newTestCounter("test_dlq_total")
+	deadLetters := NewDeadLetterQueue(dlq, jobStates, logger.NewLogger(), counter)
+
+	// A job the service never saw, e.g. one queued with EnqueueTx, gets a
+	// status of its own.
+	job := testJob("dropped")
+	job.Queue = "transactional"
+	deliveryErr := domain.NewSMTPError(451, "4.3.0", "temporary server error")
+	deadLetters.Store(job, "Dropped from the full queue", deliveryErr)
+
+	stored := dlq.stored()
+	if len(stored) != 1 || stored[0].job.ID != "dropped" || stored[0].deliveryErr != deliveryErr {
+		t.Fatalf("DLQ holds %v, want the job with its delivery error", stored)
+	}
+	if got := testutil.ToFloat64(counter.WithLabelValues("transactional")); got != 1 {
+		t.Errorf("DLQ counter of the job's queue = %v, want 1", got)
+	}
+	status, err := jobStates.Get(context.Background(), "dropped")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if status.State != domain.JobDeadLettered || status.Reason != "Dropped from the full queue" || status.Queue != "transactional" {
+		t.Errorf("status = %+v, want dead_lettered with the reason on the job's queue", status)
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// digestRetryDelay is how long a digest waits when its buffer cannot be read.
+const digestRetryDelay = time.Minute
+
+// digestService implements the ports.EmailService interface on top of
+// another EmailService, merging the jobs with a digest key into digests.
+// The first job of a buffer schedules a digest job for the end of the
+// window through the wrapped service; when a worker picks the digest job
+// up, it takes the buffer and becomes the digest email. Only the digest job
+// that owns a buffer can take it, so a job naming another recipient's buffer
+// sends nothing. The buffers live in
+// the DigestStore and the digest jobs in the scheduler, so both survive
+// restarts with durable backends.
+type digestService struct {
+	ports.EmailService
+	store           ports.DigestStore
+	jobStates       ports.JobStateStore
+	dlq             ports.DeadLetterQueue
+	renderer        ports.Renderer
+	digests         ports.DigestRenderer
+	window          time.Duration
+	logger          *logger.Logger
+	bufferedCounter *prometheus.CounterVec
+	digestCounter   *prometheus.CounterVec
+}
+
+// NewDigestService creates an EmailService that buffers jobs with a digest
+// key for window and sends the others through emails. Digests that cannot be
+// rendered go to dlq, which is expected to count them and update their
+// status like the DLQ of NewDeadLetterQueue. The metrics are labelled with
+// the name of the queue.
+func NewDigestService(
+	emails ports.EmailService,
+	store ports.DigestStore,
+	jobStates ports.JobStateStore,
+	dlq ports.DeadLetterQueue,
+	renderer ports.Renderer,
+	digests ports.DigestRenderer,
+	window time.Duration,
+	l *logger.Logger,
+	buffered *prometheus.CounterVec,
+	digestCount *prometheus.CounterVec,
+) ports.EmailService {
+	return &digestService{
+		EmailService:    emails,
+		store:           store,
+		jobStates:       jobStates,
+		dlq:             dlq,
+		renderer:        renderer,
+		digests:         digests,
+		window:          window,
+		logger:          l,
+		bufferedCounter: buffered,
+		digestCounter:   digestCount,
+	}
+}
+
+// EnqueueEmail buffers a job with a digest key and enqueues any other job.
+func (s *digestService) EnqueueEmail(ctx context.Context, job domain.EmailJob) error {
+	if job.DigestKey == "" || job.Digest != nil {
+		return s.EmailService.EnqueueEmail(ctx, job)
+	}
+	return s.buffer(ctx, job)
+}
+
+// EnqueueEmails buffers the jobs with a digest key one by one and enqueues
+// the others as a batch.
+func (s *digestService) EnqueueEmails(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	var rest []domain.EmailJob
+	var restIndex []int
+	for i, job := range jobs {
+		if job.DigestKey == "" || job.Digest != nil {
+			rest = append(rest, job)
+			restIndex = append(restIndex, i)
+			continue
+		}
+		errs[i] = s.buffer(ctx, job)
+	}
+	if len(rest) > 0 {
+		for k, err := range s.EmailService.EnqueueEmails(ctx, rest) {
+			errs[restIndex[k]] = err
+		}
+	}
+	return errs
+}
+
+// buffer adds a job to the buffer of its recipient and digest key. The job
+// that starts a buffer schedules its digest; so does a job finding a buffer
+// that is long overdue, whose digest job was lost, e.g. with an in-memory
+// scheduler that was restarted. Either way the new digest job owns the
+// buffer.
+func (s *digestService) buffer(ctx context.Context, job domain.EmailJob) error {
+	if job.ID == "" {
+		job.ID = domain.NewJobID()
+	}
+	now := time.Now()
+	staleBefore := now.Add(-2 * s.window)
+	if _, err := s.jobStates.Update(ctx, job.ID, func(status *domain.JobStatus) error {
+		*status = newJobStatus(job, domain.JobBuffered)
+		return nil
+	}); err != nil {
+		s.logger.Errorf("Failed to store status of job %s: %v", job.ID, err)
+	}
+
+	digestID := domain.NewJobID()
+	owned, startedAt, err := s.store.Add(ctx, job.DigestBuffer(), job, digestID, now, staleBefore)
+	if err != nil {
+		s.logger.Errorf("Failed to buffer email job %s: %v", job.ID, err)
+		s.forget(job)
+		return fmt.Errorf("failed to buffer email: %w", err)
+	}
+	if owned {
+		at := now.Add(s.window)
+		if startedAt.Before(staleBefore) {
+			s.logger.Warnf("Digest buffer %s is overdue since %s, sending it now", job.DigestBuffer(), startedAt.Add(s.window).Format(time.RFC3339))
+			at = now
+		}
+		if err := s.scheduleDigest(ctx, job, digestID, at); err != nil {
+			return err
+		}
+	}
+	s.logger.Printf("Buffered email job %s for the %s digest of %s", job.ID, job.DigestKey, job.To)
+	s.bufferedCounter.WithLabelValues(job.QueueName()).Inc()
+	return nil
+}
+
+// scheduleDigest schedules the digest job digestID sending the buffer of job
+// at at. If that fails, the buffer is dropped, so that the next job starts
+// a new one rather than joining a buffer that is never sent.
+func (s *digestService) scheduleDigest(ctx context.Context, job domain.EmailJob, digestID string, at time.Time) error {
+	digest := domain.EmailJob{
+		ID:        digestID,
+		To:        job.To,
+		DigestKey: job.DigestKey,
+		Priority:  job.Priority,
+		Queue:     job.Queue,
+		Type:      job.Type,
+		SendAt:    &at,
+		Digest:    &domain.DigestRef{Buffer: job.DigestBuffer()},
+	}
+	err := s.EmailService.EnqueueEmail(ctx, digest)
+	if err == nil {
+		return nil
+	}
+	s.logger.Errorf("Failed to schedule digest %s: %v", job.DigestBuffer(), err)
+	dropped, takeErr := s.store.Take(context.Background(), job.DigestBuffer(), digestID)
+	if takeErr != nil {
+		s.logger.Errorf("Failed to drop digest buffer %s: %v", job.DigestBuffer(), takeErr)
+	}
+	for _, d := range dropped {
+		s.forget(d)
+	}
+	s.release(job.DigestBuffer(), digestID)
+	return err
+}
+
+// ProcessEmailJob merges the buffer of a digest job into it before it is
+// processed. Retries of the digest are processed as they are. The buffer is
+// deleted only once the digest was processed, so a digest job delivered
+// again after a crash merges it again.
+func (s *digestService) ProcessEmailJob(job domain.EmailJob) {
+	if job.Digest == nil || job.Digest.Items > 0 {
+		s.EmailService.ProcessEmailJob(job)
+		return
+	}
+
+	if job.Digest.Buffer != job.DigestBuffer() {
+		s.logger.Errorf("Digest job %s names buffer %s, not that of %s, dropping it", job.ID, job.Digest.Buffer, job.To)
+		s.forget(job)
+		return
+	}
+
+	ctx := context.Background()
+	buffered, err := s.store.Take(ctx, job.Digest.Buffer, job.ID)
+	if err != nil {
+		s.logger.Errorf("Failed to take digest buffer %s: %v. Retrying in %s.", job.Digest.Buffer, err, digestRetryDelay)
+		retryAt := time.Now().Add(digestRetryDelay)
+		job.SendAt = &retryAt
+		if err := s.EmailService.EnqueueEmail(ctx, job); err != nil {
+			s.logger.Errorf("Failed to reschedule digest %s: %v", job.Digest.Buffer, err)
+		}
+		return
+	}
+	if len(buffered) == 0 {
+		// Only possible if the buffers were lost, e.g. in a restarted
+		// in-memory store, or if a later digest job claimed the buffer
+		// because this one was overdue.
+		s.logger.Warnf("Digest buffer %s is empty or owned by another digest job, dropping digest job %s", job.Digest.Buffer, job.ID)
+		s.forget(job)
+		return
+	}
+
+	items := make([]domain.DigestItem, 0, len(buffered))
+	digested := make([]domain.EmailJob, 0, len(buffered))
+	for _, b := range buffered {
+		msg, err := s.renderer.Render(b)
+		if err != nil {
+			// The job fails on its own, so the others are still sent.
+			s.logger.Errorf("Failed to render email job %s for digest %s: %v. Moving to DLQ.", b.ID, job.Digest.Buffer, err)
+			s.dlq.Store(b, fmt.Sprintf("Failed to render for digest %s: %v", job.ID, err), nil)
+			continue
+		}
+		items = append(items, domain.DigestItem{ID: b.ID, Subject: b.Subject, Message: msg})
+		digested = append(digested, b)
+	}
+	if len(items) == 0 {
+		s.logger.Warnf("No job of digest buffer %s could be rendered, dropping digest job %s", job.Digest.Buffer, job.ID)
+		s.forget(job)
+		s.release(job.Digest.Buffer, job.ID)
+		return
+	}
+	subject, body, err := s.digests.RenderDigest(job.DigestKey, job.To, items)
+	job.Digest = &domain.DigestRef{Buffer: job.Digest.Buffer, Items: len(items)}
+	for _, b := range digested {
+		s.track(b, func(status *domain.JobStatus) {
+			status.State = domain.JobDigested
+			status.DigestID = job.ID
+		})
+	}
+	if err != nil {
+		// A template that fails for these items fails on a retry too.
+		s.logger.Errorf("Failed to render digest %s: %v. Moving to DLQ.", job.Digest.Buffer, err)
+		s.dlq.Store(job, fmt.Sprintf("Failed to render digest: %v", err), nil)
+		s.release(job.Digest.Buffer, job.ID)
+		return
+	}
+
+	job.Subject = subject
+	job.HTMLBody = body
+	s.track(job, func(status *domain.JobStatus) {
+		status.Subject = subject
+	})
+	s.logger.Printf("Merged %d email jobs into digest %s for %s", len(items), job.ID, job.To)
+	s.digestCounter.WithLabelValues(job.QueueName()).Inc()
+	s.EmailService.ProcessEmailJob(job)
+	s.release(job.Digest.Buffer, job.ID)
+}
+
+// release deletes the buffer the digest job digestID took. A failure leaves
+// the jobs in the store, which is only logged.
+func (s *digestService) release(buffer, digestID string) {
+	if err := s.store.Delete(context.Background(), buffer, digestID); err != nil {
+		s.logger.Errorf("Failed to delete digest buffer %s taken by digest job %s: %v", buffer, digestID, err)
+	}
+}
+
+// track applies update to the stored status of a job. Failures are only
+// logged.
+func (s *digestService) track(job domain.EmailJob, update func(*domain.JobStatus)) {
+	if _, err := s.jobStates.Update(context.Background(), job.ID, func(status *domain.JobStatus) error {
+		if status.CreatedAt.IsZero() {
+			*status = newJobStatus(job, domain.JobBuffered)
+		}
+		update(status)
+		status.UpdatedAt = time.Now()
+		return nil
+	}); err != nil {
+		s.logger.Errorf("Failed to update status of job %s: %v", job.ID, err)
+	}
+}
+
+// forget removes the status of a job that was not accepted or is dropped.
+func (s *digestService) forget(job domain.EmailJob) {
+	if err := s.jobStates.Delete(context.Background(), job.ID); err != nil {
+		s.logger.Errorf("Failed to remove status of job %s: %v", job.ID, err)
+	}
+}
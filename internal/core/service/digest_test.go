@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	digestmemory "email-queue-service/internal/infrastructure/digest/memory"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/render"
+)
+
+type testDigestService struct {
+	*digestService
+	*testService
+	store *digestmemory.Store
+}
+
+// newTestDigestService returns a digest service with a window of an hour,
+// sending through the test email service.
+func newTestDigestService(t *testing.T) *testDigestService {
+	t.Helper()
+	ts := &testDigestService{
+		testService: newTestService(t, 3),
+		store:       digestmemory.NewStore(),
+	}
+	digests, err := render.NewDigestRenderer(render.DefaultDigestSubject, render.DefaultDigestTemplate)
+	if err != nil {
+		t.Fatalf("NewDigestRenderer() error = %v", err)
+	}
+	deadLetters := NewDeadLetterQueue(ts.testService.dlq, ts.testService.jobStates, logger.NewLogger(), ts.counters.dlq)
+	ts.digestService = NewDigestService(ts.emailService, ts.store, ts.testService.jobStates, deadLetters, render.NewRenderer(false, false), digests, time.Hour, logger.NewLogger(),
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_buffered_total"}, []string{"queue"}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_digests_total"}, []string{"queue"}),
+	).(*digestService)
+	return ts
+}
+
+// The methods are promoted from both services; the tests go through the
+// digest service.
+func (ts *testDigestService) EnqueueEmail(ctx context.Context, job domain.EmailJob) error {
+	return ts.digestService.EnqueueEmail(ctx, job)
+}
+
+func (ts *testDigestService) ProcessEmailJob(job domain.EmailJob) {
+	ts.digestService.ProcessEmailJob(job)
+}
+
+func (ts *testDigestService) buffer(t *testing.T, id, to string) {
+	t.Helper()
+	job := domain.EmailJob{ID: id, To: to, Subject: "Activity " + id, Body: "Something happened", DigestKey: "activity"}
+	if err := ts.EnqueueEmail(context.Background(), job); err != nil {
+		t.Fatalf("EnqueueEmail(%s) error = %v", id, err)
+	}
+}
+
+func TestDigestMergesBufferedJobs(t *testing.T) {
+	ts := newTestDigestService(t)
+	start := time.Now()
+	ts.buffer(t, "job-1", "a@example.com")
+	ts.buffer(t, "job-2", "a@example.com")
+	ts.buffer(t, "job-3", "b@example.com")
+
+	scheduled := ts.scheduler.scheduled()
+	if len(scheduled) != 2 {
+		t.Fatalf("scheduled %d digest jobs, want one per recipient", len(scheduled))
+	}
+	if at := scheduled[0].at; at.Before(start.Add(time.Hour)) || at.After(time.Now().Add(time.Hour)) {
+		t.Errorf("digest scheduled at %s, want the end of the window", at)
+	}
+	if got := ts.status(t, "job-1").State; got != domain.JobBuffered {
+		t.Errorf("buffered job state = %s, want %s", got, domain.JobBuffered)
+	}
+
+	digest := scheduled[0].job
+	ts.ProcessEmailJob(digest)
+	if len(ts.sent) != 1 || ts.sent[0].To != "a@example.com" || ts.sent[0].Subject != "2 new notifications" {
+		t.Fatalf("sent %+v, want one digest of 2 jobs to a@example.com", ts.sent)
+	}
+	for _, id := range []string{"job-1", "job-2"} {
+		if status := ts.status(t, id); status.State != domain.JobDigested || status.DigestID != digest.ID {
+			t.Errorf("job %s status = %s (digest %q), want %s into %s", id, status.State, status.DigestID, domain.JobDigested, digest.ID)
+		}
+	}
+
+	// The next job starts a new buffer.
+	ts.buffer(t, "job-4", "a@example.com")
+	if len(ts.scheduler.scheduled()) != 3 {
+		t.Errorf("scheduled %d digest jobs, want a new one for the new buffer", len(ts.scheduler.scheduled()))
+	}
+}
+
+func TestDigestMergesTakenBufferAgain(t *testing.T) {
+	ts := newTestDigestService(t)
+	ts.buffer(t, "job-1", "a@example.com")
+	ts.buffer(t, "job-2", "a@example.com")
+	digest := ts.scheduler.scheduled()[0].job
+
+	// An instance took the buffer and crashed before sending the digest.
+	if _, err := ts.store.Take(context.Background(), digest.Digest.Buffer, digest.ID); err != nil {
+		t.Fatalf("Take() error = %v", err)
+	}
+
+	ts.ProcessEmailJob(digest)
+	if len(ts.sent) != 1 || ts.sent[0].Subject != "2 new notifications" {
+		t.Fatalf("sent %+v, want the digest of 2 jobs", ts.sent)
+	}
+	if jobs, _ := ts.store.Take(context.Background(), digest.Digest.Buffer, digest.ID); len(jobs) != 0 {
+		t.Errorf("buffer holds %d jobs after the digest was sent, want it deleted", len(jobs))
+	}
+}
+
+func TestDigestIgnoresForgedDigestJobs(t *testing.T) {
+	ts := newTestDigestService(t)
+	ts.buffer(t, "job-1", "victim@example.com")
+
+	forged := []domain.EmailJob{
+		// A digest job of the attacker naming the victim's buffer.
+		{ID: "forged-1", To: "attacker@example.com", DigestKey: "activity", Digest: &domain.DigestRef{Buffer: "activity:victim@example.com"}},
+		// A digest job to the victim that does not own the buffer.
+		{ID: "forged-2", To: "victim@example.com", DigestKey: "activity", Digest: &domain.DigestRef{Buffer: "activity:victim@example.com"}},
+	}
+	for _, job := range forged {
+		ts.ProcessEmailJob(job)
+	}
+	if len(ts.sent) != 0 {
+		t.Fatalf("sent %+v for forged digest jobs, want nothing", ts.sent)
+	}
+
+	ts.ProcessEmailJob(ts.scheduler.scheduled()[0].job)
+	if len(ts.sent) != 1 || ts.sent[0].To != "victim@example.com" || ts.sent[0].Subject != "1 new notifications" {
+		t.Errorf("sent %+v, want the victim's digest of 1 job", ts.sent)
+	}
+}
+
+func TestDigestClaimsOverdueBuffer(t *testing.T) {
+	ts := newTestDigestService(t)
+	// A buffer whose digest job was lost three hours ago.
+	started := time.Now().Add(-3 * time.Hour)
+	if _, _, err := ts.store.Add(context.Background(), "activity:a@example.com", testJob("job-0"), "lost", started, started); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	ts.buffer(t, "job-1", "a@example.com")
+	if scheduled := ts.scheduler.scheduled(); len(scheduled) != 0 {
+		t.Errorf("scheduled %+v, want the overdue digest sent now", scheduled)
+	}
+	enqueued := ts.queue.enqueued()
+	if len(enqueued) != 1 || enqueued[0].Digest == nil {
+		t.Fatalf("enqueued %+v, want the digest job of the overdue buffer", enqueued)
+	}
+
+	// The lost digest job turning up late sends nothing.
+	ts.ProcessEmailJob(domain.EmailJob{ID: "lost", To: "a@example.com", DigestKey: "activity", Digest: &domain.DigestRef{Buffer: "activity:a@example.com"}})
+	if len(ts.sent) != 0 {
+		t.Fatalf("sent %+v for the lost digest job, want nothing", ts.sent)
+	}
+	ts.ProcessEmailJob(enqueued[0])
+	if len(ts.sent) != 1 || ts.sent[0].Subject != "2 new notifications" {
+		t.Errorf("sent %+v, want one digest of 2 jobs", ts.sent)
+	}
+}
+
+func TestDigestDeadLettersDigestThatFailsToRender(t *testing.T) {
+	ts := newTestDigestService(t)
+	digests, err := render.NewDigestRenderer(`{{index .Items 5}}`, render.DefaultDigestTemplate)
+	if err != nil {
+		t.Fatalf("NewDigestRenderer() error = %v", err)
+	}
+	ts.digests = digests
+	ts.buffer(t, "job-1", "a@example.com")
+
+	digest := ts.scheduler.scheduled()[0].job
+	ts.ProcessEmailJob(digest)
+	if len(ts.sent) != 0 {
+		t.Fatalf("sent %+v, want nothing", ts.sent)
+	}
+	stored := ts.testService.dlq.stored()
+	if len(stored) != 1 || stored[0].job.ID != digest.ID {
+		t.Fatalf("DLQ holds %v, want the digest job", stored)
+	}
+	if n := ts.count(ts.counters.dlq); n != 1 {
+		t.Errorf("DLQ counter = %v, want 1", n)
+	}
+	if status := ts.status(t, digest.ID); status.State != domain.JobDeadLettered {
+		t.Errorf("digest status = %s, want %s", status.State, domain.JobDeadLettered)
+	}
+}
+
+// failingRenderer fails to render the jobs in fail and renders the others
+// with the wrapped renderer.
+type failingRenderer struct {
+	ports.Renderer
+	fail map[string]bool
+}
+
+func (r failingRenderer) Render(job domain.EmailJob) (domain.Message, error) {
+	if r.fail[job.ID] {
+		return domain.Message{}, errors.New("broken markdown")
+	}
+	return r.Renderer.Render(job)
+}
+
+func TestDigestDeadLettersItemsThatFailToRender(t *testing.T) {
+	ts := newTestDigestService(t)
+	ts.digestService.renderer = failingRenderer{Renderer: ts.digestService.renderer, fail: map[string]bool{"job-2": true}}
+	ts.buffer(t, "job-1", "a@example.com")
+	ts.buffer(t, "job-2", "a@example.com")
+
+	digest := ts.scheduler.scheduled()[0].job
+	ts.ProcessEmailJob(digest)
+	if len(ts.sent) != 1 || ts.sent[0].Subject != "1 new notifications" {
+		t.Fatalf("sent %+v, want a digest of the job that rendered", ts.sent)
+	}
+	status := ts.status(t, digest.ID)
+	if status.State != domain.JobSent {
+		t.Errorf("digest status = %s, want %s", status.State, domain.JobSent)
+	}
+	if got := ts.status(t, "job-1"); got.State != domain.JobDigested {
+		t.Errorf("job-1 status = %s, want %s", got.State, domain.JobDigested)
+	}
+	if got := ts.status(t, "job-2"); got.State != domain.JobDeadLettered || got.DigestID != "" {
+		t.Errorf("job-2 status = %s (digest %q), want %s outside the digest", got.State, got.DigestID, domain.JobDeadLettered)
+	}
+	if stored := ts.testService.dlq.stored(); len(stored) != 1 || stored[0].job.ID != "job-2" {
+		t.Errorf("DLQ holds %v, want job-2", stored)
+	}
+}
+
+func TestDigestDropsDigestWithoutRenderedItems(t *testing.T) {
+	ts := newTestDigestService(t)
+	ts.digestService.renderer = failingRenderer{Renderer: ts.digestService.renderer, fail: map[string]bool{"job-1": true}}
+	ts.buffer(t, "job-1", "a@example.com")
+
+	digest := ts.scheduler.scheduled()[0].job
+	ts.ProcessEmailJob(digest)
+	if len(ts.sent) != 0 {
+		t.Fatalf("sent %+v, want nothing", ts.sent)
+	}
+	if stored := ts.testService.dlq.stored(); len(stored) != 1 || stored[0].job.ID != "job-1" {
+		t.Errorf("DLQ holds %v, want job-1 only", stored)
+	}
+	if _, err := ts.testService.GetEmailStatus(context.Background(), digest.ID); err == nil {
+		t.Errorf("GetEmailStatus() of the empty digest succeeded, want it forgotten")
+	}
+}
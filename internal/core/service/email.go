@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -12,97 +14,369 @@ import (
 	"email-queue-service/internal/pkg/logger"
 )
 
+// NamedQueue is a queue jobs can be sent through by name, together with the
+// scheduler holding its scheduled jobs and retries, and its retry settings.
+type NamedQueue struct {
+	Name          string
+	Queue         ports.Queue
+	Scheduler     ports.Scheduler
+	MaxRetries    int
+	RetryPolicies *domain.RetryPolicies
+}
+
 // emailService implements the ports.EmailService interface.
 type emailService struct {
-	queue                   ports.Queue
+	queues                  map[string]NamedQueue
+	jobStates               ports.JobStateStore
 	dlq                     ports.DeadLetterQueue
+	renderer                ports.Renderer
+	sender                  func(domain.Message) error // The simulated send unless replaced in tests
 	logger                  *logger.Logger
-	enqueuedCounter         prometheus.Counter
-	processedCounter        prometheus.Counter
-	failedCounter           prometheus.Counter
-	retriedCounter          prometheus.Counter
-	dlqCounter              prometheus.Counter
-	processingDurationGauge prometheus.Histogram
-	maxRetries              int
-	retryDelaySeconds       int
+	enqueuedCounter         *prometheus.CounterVec
+	processedCounter        *prometheus.CounterVec
+	failedCounter           *prometheus.CounterVec
+	retriedCounter          *prometheus.CounterVec
+	cancelledCounter        *prometheus.CounterVec
+	expiredCounter          *prometheus.CounterVec
+	processingDurationGauge *prometheus.HistogramVec
 }
 
-// NewEmailService creates a new EmailService instance.
+// NewEmailService creates a new EmailService instance delivering through
+// queues. The metrics are labelled with the name of the queue.
 func NewEmailService(
-	q ports.Queue,
+	queues []NamedQueue,
+	jobStates ports.JobStateStore,
 	dlq ports.DeadLetterQueue,
+	renderer ports.Renderer,
 	l *logger.Logger,
-	enqueued prometheus.Counter,
-	processed prometheus.Counter,
-	failed prometheus.Counter,
-	retried prometheus.Counter,
-	dlqCount prometheus.Counter,
-	processingDuration prometheus.Histogram,
-	maxRetries int,
-	retryDelaySeconds int,
+	enqueued *prometheus.CounterVec,
+	processed *prometheus.CounterVec,
+	failed *prometheus.CounterVec,
+	retried *prometheus.CounterVec,
+	dlqCount *prometheus.CounterVec,
+	cancelled *prometheus.CounterVec,
+	expired *prometheus.CounterVec,
+	processingDuration *prometheus.HistogramVec,
 ) ports.EmailService {
-	return &emailService{
-		queue:                   q,
-		dlq:                     dlq,
+	s := &emailService{
+		queues:                  make(map[string]NamedQueue, len(queues)),
+		jobStates:               jobStates,
+		dlq:                     NewDeadLetterQueue(dlq, jobStates, l, dlqCount),
+		renderer:                renderer,
 		logger:                  l,
 		enqueuedCounter:         enqueued,
 		processedCounter:        processed,
 		failedCounter:           failed,
 		retriedCounter:          retried,
-		dlqCounter:              dlqCount,
+		cancelledCounter:        cancelled,
+		expiredCounter:          expired,
 		processingDurationGauge: processingDuration,
-		maxRetries:              maxRetries,
-		retryDelaySeconds:       retryDelaySeconds,
 	}
+	s.sender = s.send
+	for _, q := range queues {
+		s.queues[q.Name] = q
+	}
+	return s
 }
 
-// EnqueueEmail adds an email job to the queue.
-func (s *emailService) EnqueueEmail(job domain.EmailJob) error {
-	err := s.queue.Enqueue(job)
+// queueFor returns the named queue of a job.
+func (s *emailService) queueFor(job domain.EmailJob) (NamedQueue, error) {
+	q, ok := s.queues[job.QueueName()]
+	if !ok {
+		return NamedQueue{}, fmt.Errorf("%w %q", ports.ErrUnknownQueue, job.QueueName())
+	}
+	return q, nil
+}
+
+// EnqueueEmail adds an email job to its queue, or to the queue's scheduler
+// if its send_at time is still in the future.
+func (s *emailService) EnqueueEmail(ctx context.Context, job domain.EmailJob) error {
+	q, err := s.queueFor(job)
+	if err != nil {
+		return err
+	}
+	if job.ID == "" {
+		job.ID = domain.NewJobID()
+	}
+	now := time.Now()
+	job.ResolveExpiry(now)
+
+	if job.IsScheduled(now) {
+		return s.schedule(ctx, q, job)
+	}
+
+	s.storeStatus(ctx, job, domain.JobQueued)
+	err = q.Queue.Enqueue(ctx, job)
 	if err != nil {
 		s.logger.Errorf("Failed to enqueue email job: %v", err)
-		s.failedCounter.Inc() // Increment failed counter if enqueue fails
+		s.failedCounter.WithLabelValues(q.Name).Inc() // Increment failed counter if enqueue fails
+		s.forgetStatus(job)
 		return fmt.Errorf("failed to enqueue email: %w", err)
 	}
-	s.logger.Printf("Enqueued email job for %s (retries: %d)", job.To, job.Retries)
-	s.enqueuedCounter.Inc()
+	s.logger.Printf("Enqueued email job %s for %s on queue %s (retries: %d)", job.ID, job.To, q.Name, job.Retries)
+	s.enqueuedCounter.WithLabelValues(q.Name).Inc()
+	return nil
+}
+
+// EnqueueEmails adds many email jobs at once and returns one error per job.
+// Jobs that are due go to their queue in a single batch per queue if the
+// queue supports it; scheduled jobs go to the scheduler one by one.
+func (s *emailService) EnqueueEmails(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	now := time.Now()
+	due := make(map[string][]domain.EmailJob)
+	dueIndex := make(map[string][]int)
+	for i, job := range jobs {
+		q, err := s.queueFor(job)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if job.ID == "" {
+			job.ID = domain.NewJobID()
+		}
+		job.ResolveExpiry(now)
+		if job.IsScheduled(now) {
+			errs[i] = s.schedule(ctx, q, job)
+			continue
+		}
+		s.storeStatus(ctx, job, domain.JobQueued)
+		due[q.Name] = append(due[q.Name], job)
+		dueIndex[q.Name] = append(dueIndex[q.Name], i)
+	}
+
+	for name, queued := range due {
+		for k, err := range s.enqueueBatch(ctx, s.queues[name].Queue, queued) {
+			if err != nil {
+				s.logger.Errorf("Failed to enqueue email job %s: %v", queued[k].ID, err)
+				s.failedCounter.WithLabelValues(name).Inc()
+				s.forgetStatus(queued[k])
+				errs[dueIndex[name][k]] = fmt.Errorf("failed to enqueue email: %w", err)
+				continue
+			}
+			s.enqueuedCounter.WithLabelValues(name).Inc()
+		}
+	}
+
+	accepted := 0
+	for _, err := range errs {
+		if err == nil {
+			accepted++
+		}
+	}
+	s.logger.Printf("Accepted %d of a batch of %d email jobs", accepted, len(jobs))
+	return errs
+}
+
+// enqueueBatch adds jobs to queue, in a single batch if the queue supports
+// it.
+func (s *emailService) enqueueBatch(ctx context.Context, queue ports.Queue, jobs []domain.EmailJob) []error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	if bq, ok := queue.(ports.BatchQueue); ok {
+		return bq.EnqueueBatch(ctx, jobs)
+	}
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		errs[i] = queue.Enqueue(ctx, job)
+	}
+	return errs
+}
+
+// schedule hands a job whose send_at time is in the future to the scheduler
+// of its queue.
+func (s *emailService) schedule(ctx context.Context, q NamedQueue, job domain.EmailJob) error {
+	s.storeStatus(ctx, job, domain.JobScheduled)
+	if err := q.Scheduler.Schedule(ctx, job, *job.SendAt); err != nil {
+		s.logger.Errorf("Failed to schedule email job: %v", err)
+		s.failedCounter.WithLabelValues(q.Name).Inc()
+		s.forgetStatus(job)
+		return fmt.Errorf("failed to schedule email: %w", err)
+	}
+	s.logger.Printf("Scheduled email job %s for %s at %s", job.ID, job.To, job.SendAt.Format(time.RFC3339))
+	s.enqueuedCounter.WithLabelValues(q.Name).Inc()
 	return nil
 }
 
 // ProcessEmailJob simulates sending an email and handles retry/DLQ logic.
 func (s *emailService) ProcessEmailJob(job domain.EmailJob) {
 	s.logger.Printf("Processing email to: %s, Subject: %s (Attempt: %d)", job.To, job.Subject, job.Retries+1)
+	q, err := s.queueFor(job)
+	if err != nil {
+		// Only possible if the queue was removed from the configuration
+		// while it still held jobs.
+		s.logger.Errorf("Cannot process email to %s: %v. Moving to DLQ.", job.To, err)
+		s.deadLetter(job, err.Error(), nil)
+		return
+	}
 	start := time.Now()
+	if job.FirstAttemptAt == nil {
+		job.FirstAttemptAt = &start
+	}
+	attempt := domain.Attempt{Number: job.Retries + 1, StartedAt: start, Provider: simulatedProvider}
+	switch err := s.claim(job, attempt); {
+	case errors.Is(err, errJobCancelled):
+		s.logger.Printf("Skipping cancelled email job %s to %s", job.ID, job.To)
+		return
+	case errors.Is(err, errJobExpired):
+		s.logger.Warnf("Email job %s to %s expired at %s before it was sent. Moving to DLQ.", job.ID, job.To, job.ExpiresAt.Format(time.RFC3339))
+		s.expire(q, job)
+		return
+	}
 
-	// Simulate external email sending service call with a chance of failure
-	time.Sleep(1 * time.Second) // Simulate work
-	success := rand.Intn(100) < 80 // 80% success rate for demonstration
+	msg, err := s.renderer.Render(job)
+	if err != nil {
+		// A job that cannot be rendered will not render on a retry either.
+		s.logger.Errorf("Failed to render email to %s: %v. Moving to DLQ.", job.To, err)
+		s.failedCounter.WithLabelValues(q.Name).Inc()
+		s.deadLetter(job, fmt.Sprintf("Failed to render email: %v", err), nil)
+		return
+	}
+	s.logger.Printf("Rendered email to %s (html: %d bytes, text: %d bytes)", msg.To, len(msg.HTMLBody), len(msg.TextBody))
 
-	if success {
+	if err := s.sender(msg); err == nil {
 		s.logger.Printf("Successfully sent email to: %s", job.To)
-		s.processedCounter.Inc()
-		s.processingDurationGauge.Observe(time.Since(start).Seconds())
+		s.processedCounter.WithLabelValues(q.Name).Inc()
+		s.processingDurationGauge.WithLabelValues(q.Name).Observe(time.Since(start).Seconds())
+		s.track(job, func(status *domain.JobStatus) {
+			finishAttempt(status, attempt.Number, nil)
+			status.State = domain.JobSent
+		})
 	} else {
-		s.logger.Warnf("Failed to send email to: %s (Attempt: %d)", job.To, job.Retries+1)
-		s.failedCounter.Inc()
-		s.processingDurationGauge.Observe(time.Since(start).Seconds())
-
-		if job.Retries < s.maxRetries {
-			job.Retries++
-			s.retriedCounter.Inc()
-			s.logger.Printf("Retrying email to: %s in %d seconds (Attempt: %d/%d)", job.To, s.retryDelaySeconds, job.Retries+1, s.maxRetries+1)
-			// Simulate delay before re-enqueuing for retry
-			time.AfterFunc(time.Duration(s.retryDelaySeconds)*time.Second, func() {
-				if err := s.queue.Enqueue(job); err != nil {
-					s.logger.Errorf("Failed to re-enqueue email for retry to %s: %v", job.To, err)
-					s.dlq.Store(job, fmt.Sprintf("Failed to re-enqueue after %d retries: %v", job.Retries, err))
-					s.dlqCounter.Inc()
-				}
-			})
-		} else {
-			s.logger.Errorf("Email to %s permanently failed after %d retries. Moving to DLQ.", job.To, job.Retries)
-			s.dlq.Store(job, fmt.Sprintf("Permanently failed after %d retries", job.Retries))
-			s.dlqCounter.Inc()
+		deliveryErr := domain.AsDeliveryError(err)
+		job.LastError = deliveryErr
+		s.logger.Warnf("Failed to send email to: %s (Attempt: %d, %s): %v", job.To, job.Retries+1, deliveryErr.Class, deliveryErr)
+		s.failedCounter.WithLabelValues(q.Name).Inc()
+		s.processingDurationGauge.WithLabelValues(q.Name).Observe(time.Since(start).Seconds())
+		s.track(job, func(status *domain.JobStatus) {
+			finishAttempt(status, attempt.Number, deliveryErr)
+			status.State = domain.JobFailed
+		})
+
+		if deliveryErr.Permanent() {
+			// Retrying cannot change the outcome, e.g. for an unknown recipient.
+			s.logger.Errorf("Email to %s failed permanently: %v. Moving to DLQ.", job.To, deliveryErr)
+			s.deadLetter(job, fmt.Sprintf("Permanent failure: %v", deliveryErr), deliveryErr)
+			return
 		}
+		s.retry(q, job, deliveryErr.Class)
+	}
+}
+
+// GetEmailStatus returns the stored status of a job.
+func (s *emailService) GetEmailStatus(ctx context.Context, id string) (domain.JobStatus, error) {
+	return s.jobStates.Get(ctx, id)
+}
+
+// CancelEmail cancels a job that is queued, scheduled or waiting for a
+// retry. The job stays where it is and is dropped by the worker that picks it
+// up; cancelling a job that was cancelled already succeeds again.
+func (s *emailService) CancelEmail(ctx context.Context, id string) (domain.JobStatus, error) {
+	var current domain.JobStatus
+	status, err := s.jobStates.Update(ctx, id, func(status *domain.JobStatus) error {
+		current = *status
+		switch {
+		case status.CreatedAt.IsZero():
+			return ports.ErrJobNotFound
+		case status.State == domain.JobCancelled:
+			return errJobCancelled
+		case !status.State.Cancellable():
+			return ports.ErrJobNotCancellable
+		}
+		status.State = domain.JobCancelled
+		status.NextAttemptAt = nil
+		status.UpdatedAt = time.Now()
+		return nil
+	})
+	switch {
+	case errors.Is(err, errJobCancelled):
+		return current, nil
+	case errors.Is(err, ports.ErrJobNotCancellable):
+		return current, err
+	case err != nil:
+		return domain.JobStatus{}, err
+	}
+	s.logger.Printf("Cancelled email job %s to %s", id, status.To)
+	s.cancelledCounter.WithLabelValues(statusQueue(status)).Inc()
+	return status, nil
+}
+
+// retry schedules the next attempt of a failed job according to the retry
+// policy of its queue, type and failure class, or moves it to the DLQ once
+// the policy gives up on it.
+func (s *emailService) retry(q NamedQueue, job domain.EmailJob, class domain.FailureClass) {
+	if job.Retries >= q.MaxRetries {
+		s.logger.Errorf("Email to %s permanently failed after %d retries. Moving to DLQ.", job.To, job.Retries)
+		s.deadLetter(job, fmt.Sprintf("Permanently failed after %d retries: %v", job.Retries, job.LastError), job.LastError)
+		return
+	}
+
+	policy := q.RetryPolicies.For(job.Type, class)
+	delay := policy.Delay(job.Retries+1, time.Duration(job.RetryDelayMs)*time.Millisecond)
+	retryAt := time.Now().Add(delay)
+	if job.Expired(retryAt) {
+		s.logger.Errorf("Email to %s expires at %s, before its next retry. Moving to DLQ.", job.To, job.ExpiresAt.Format(time.RFC3339))
+		s.expire(q, job)
+		return
+	}
+	if policy.MaxAge > 0 && retryAt.After(job.FirstAttemptAt.Add(policy.MaxAge)) {
+		s.logger.Errorf("Email to %s failed for longer than %s after %d retries. Moving to DLQ.", job.To, policy.MaxAge, job.Retries)
+		s.deadLetter(job, fmt.Sprintf("Retry window of %s exceeded after %d retries: %v", policy.MaxAge, job.Retries, job.LastError), job.LastError)
+		return
+	}
+
+	job.Retries++
+	job.NextAttemptAt = &retryAt
+	job.RetryDelayMs = delay.Milliseconds()
+	s.retriedCounter.WithLabelValues(q.Name).Inc()
+	s.logger.Printf("Retrying email to: %s in %s (Attempt: %d/%d)", job.To, delay.Round(time.Millisecond), job.Retries+1, q.MaxRetries+1)
+	// Stored before scheduling, so that it cannot overwrite the status of
+	// an attempt that starts right away.
+	s.track(job, func(status *domain.JobStatus) {
+		status.State = domain.JobRetrying
+		status.NextAttemptAt = &retryAt
+	})
+	// The retry is held by the scheduler, which keeps it across restarts
+	// on durable backends and accepts it while the queue is shutting down.
+	if err := q.Scheduler.Schedule(context.Background(), job, retryAt); err != nil {
+		s.logger.Errorf("Failed to schedule retry of email to %s: %v", job.To, err)
+		s.deadLetter(job, fmt.Sprintf("Failed to schedule retry %d: %v", job.Retries, err), job.LastError)
+	}
+}
+
+// reasonExpired is the DLQ reason of jobs that expired before they were sent.
+const reasonExpired = "expired"
+
+// expire moves a job that must no longer be sent to the DLQ.
+func (s *emailService) expire(q NamedQueue, job domain.EmailJob) {
+	s.expiredCounter.WithLabelValues(q.Name).Inc()
+	s.deadLetter(job, reasonExpired, job.LastError)
+}
+
+// deadLetter stores a job that is given up on in the DLQ.
+func (s *emailService) deadLetter(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
+	s.dlq.Store(job, reason, deliveryErr)
+}
+
+// simulatedProvider names the simulated sender in attempt histories.
+const simulatedProvider = "simulated"
+
+// simulatedFailures are the failures send picks from, weighted by how often
+// they occur.
+var simulatedFailures = []*domain.DeliveryError{
+	domain.NewSMTPError(421, "4.4.2", "connection dropped"),
+	domain.NewSMTPError(451, "4.3.0", "temporary server error"),
+	domain.NewSMTPError(421, "4.7.28", "sending rate too high, slow down"),
+	domain.NewSMTPError(450, "4.2.0", "greylisted, try again later"),
+	domain.NewSMTPError(550, "5.1.1", "no such user"),
+}
+
+// send simulates an external email sending service call with a chance of failure.
+func (s *emailService) send(msg domain.Message) error {
+	time.Sleep(1 * time.Second) // Simulate work
+	if rand.Intn(100) < 80 {    // 80% success rate for demonstration
+		return nil
 	}
+	return simulatedFailures[rand.Intn(len(simulatedFailures))]
 }
@@ -0,0 +1,475 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	jobstatememory "email-queue-service/internal/infrastructure/jobstate/memory"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/render"
+)
+
+// recordingQueue records the jobs enqueued into it, or fails with err.
+type recordingQueue struct {
+	mu   sync.Mutex
+	jobs []domain.EmailJob
+	err  error
+}
+
+func (q *recordingQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.jobs = append(q.jobs, job)
+	return nil
+}
+
+func (q *recordingQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	return domain.EmailJob{}, ports.ErrQueueClosed
+}
+
+func (q *recordingQueue) Ack(job domain.EmailJob) error  { return nil }
+func (q *recordingQueue) Nack(job domain.EmailJob) error { return nil }
+func (q *recordingQueue) Close()                         {}
+func (q *recordingQueue) IsClosed() bool                 { return false }
+
+func (q *recordingQueue) enqueued() []domain.EmailJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]domain.EmailJob(nil), q.jobs...)
+}
+
+type scheduledJob struct {
+	job domain.EmailJob
+	at  time.Time
+}
+
+// recordingScheduler records the jobs scheduled with it, or fails with err.
+type recordingScheduler struct {
+	mu   sync.Mutex
+	jobs []scheduledJob
+	err  error
+}
+
+func (s *recordingScheduler) Schedule(ctx context.Context, job domain.EmailJob, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.jobs = append(s.jobs, scheduledJob{job: job, at: at})
+	return nil
+}
+
+func (s *recordingScheduler) Close() {}
+
+func (s *recordingScheduler) scheduled() []scheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]scheduledJob(nil), s.jobs...)
+}
+
+type dlqEntry struct {
+	job         domain.EmailJob
+	reason      string
+	deliveryErr *domain.DeliveryError
+}
+
+// recordingDLQ keeps the jobs moved to the DLQ.
+type recordingDLQ struct {
+	mu      sync.Mutex
+	entries []dlqEntry
+}
+
+func (d *recordingDLQ) Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, dlqEntry{job: job, reason: reason, deliveryErr: deliveryErr})
+}
+
+func (d *recordingDLQ) stored() []dlqEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]dlqEntry(nil), d.entries...)
+}
+
+// testCounters are the metrics of a test email service.
+type testCounters struct {
+	enqueued, processed, failed, retried, dlq, cancelled, expired *prometheus.CounterVec
+}
+
+func newTestCounter(name string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name}, []string{"queue"})
+}
+
+// testPolicies retries every failure class after 5s, 10s, 20s, ...
+var testPolicies = &domain.RetryPolicies{
+	Default: domain.RetryPolicy{Base: 5 * time.Second, Factor: 2, Jitter: domain.JitterNone},
+}
+
+// testService is an email service with a single default queue whose sends
+// fail with sendErr.
+type testService struct {
+	*emailService
+	queue     *recordingQueue
+	scheduler *recordingScheduler
+	dlq       *recordingDLQ
+	jobStates *jobstatememory.Store
+	counters  testCounters
+	sent      []domain.Message
+	sendErr   error
+}
+
+func newTestService(t *testing.T, maxRetries int) *testService {
+	t.Helper()
+	ts := &testService{
+		queue:     &recordingQueue{},
+		scheduler: &recordingScheduler{},
+		dlq:       &recordingDLQ{},
+		jobStates: jobstatememory.NewStore(time.Hour),
+		counters: testCounters{
+			enqueued:  newTestCounter("test_enqueued_total"),
+			processed: newTestCounter("test_processed_total"),
+			failed:    newTestCounter("test_failed_total"),
+			retried:   newTestCounter("test_retried_total"),
+			dlq:       newTestCounter("test_dlq_total"),
+			cancelled: newTestCounter("test_cancelled_total"),
+			expired:   newTestCounter("test_expired_total"),
+		},
+	}
+	queues := []NamedQueue{{
+		Name:          domain.DefaultQueue,
+		Queue:         ts.queue,
+		Scheduler:     ts.scheduler,
+		MaxRetries:    maxRetries,
+		RetryPolicies: testPolicies,
+	}}
+	c := ts.counters
+	ts.emailService = NewEmailService(queues, ts.jobStates, ts.dlq, render.NewRenderer(false, false), logger.NewLogger(),
+		c.enqueued, c.processed, c.failed, c.retried, c.dlq, c.cancelled, c.expired,
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_duration_seconds"}, []string{"queue"}),
+	).(*emailService)
+	ts.sender = func(msg domain.Message) error {
+		ts.sent = append(ts.sent, msg)
+		return ts.sendErr
+	}
+	return ts
+}
+
+func (ts *testService) count(c *prometheus.CounterVec) float64 {
+	return testutil.ToFloat64(c.WithLabelValues(domain.DefaultQueue))
+}
+
+func (ts *testService) status(t *testing.T, id string) domain.JobStatus {
+	t.Helper()
+	status, err := ts.GetEmailStatus(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetEmailStatus(%s) error = %v", id, err)
+	}
+	return status
+}
+
+func testJob(id string) domain.EmailJob {
+	return domain.EmailJob{ID: id, To: "a@example.com", Subject: "Hi", Body: "Hello"}
+}
+
+func TestProcessEmailJobSchedulesRetry(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+
+	for retries, delay := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		job := testJob("retry")
+		job.Retries = retries
+		before := time.Now()
+		ts.ProcessEmailJob(job)
+
+		scheduled := ts.scheduler.scheduled()
+		if len(scheduled) != retries+1 {
+			t.Fatalf("scheduled %d retries, want %d", len(scheduled), retries+1)
+		}
+		got := scheduled[retries]
+		if got.job.Retries != retries+1 {
+			t.Errorf("retry scheduled with Retries = %d, want %d", got.job.Retries, retries+1)
+		}
+		if got.at.Before(before.Add(delay)) || got.at.After(time.Now().Add(delay)) {
+			t.Errorf("retry %d scheduled in %s, want %s", retries+1, got.at.Sub(before), delay)
+		}
+		if got.job.LastError == nil || got.job.LastError.Class != domain.FailureTransient {
+			t.Errorf("retry carries LastError %v, want the transient failure", got.job.LastError)
+		}
+
+		status := ts.status(t, "retry")
+		if status.State != domain.JobRetrying || status.NextAttemptAt == nil || !status.NextAttemptAt.Equal(got.at) {
+			t.Errorf("status = %s, next attempt %v; want retrying at %s", status.State, status.NextAttemptAt, got.at)
+		}
+	}
+	if n := ts.count(ts.counters.retried); n != 2 {
+		t.Errorf("retried counter = %v, want 2", n)
+	}
+	if n := len(ts.dlq.stored()); n != 0 {
+		t.Errorf("%d jobs dead-lettered, want 0", n)
+	}
+}
+
+func TestProcessEmailJobDeadLettersAfterMaxRetries(t *testing.T) {
+	ts := newTestService(t, 2)
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+
+	job := testJob("exhausted")
+	job.Retries = 2
+	ts.ProcessEmailJob(job)
+
+	if n := len(ts.scheduler.scheduled()); n != 0 {
+		t.Errorf("scheduled %d retries past the maximum, want 0", n)
+	}
+	stored := ts.dlq.stored()
+	if len(stored) != 1 || !strings.Contains(stored[0].reason, "after 2 retries") {
+		t.Fatalf("DLQ holds %v, want the exhausted job", stored)
+	}
+	if status := ts.status(t, "exhausted"); status.State != domain.JobDeadLettered {
+		t.Errorf("status = %s, want dead_lettered", status.State)
+	}
+}
+
+func TestProcessEmailJobDeadLettersWhenRetryCannotBeScheduled(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+	ts.scheduler.err = errors.New("scheduler is closed")
+
+	ts.ProcessEmailJob(testJob("unscheduled"))
+
+	stored := ts.dlq.stored()
+	if len(stored) != 1 || !strings.Contains(stored[0].reason, "Failed to schedule retry 1") {
+		t.Fatalf("DLQ holds %v, want the job whose retry could not be scheduled", stored)
+	}
+	if stored[0].deliveryErr == nil {
+		t.Errorf("DLQ entry has no delivery error, want the failure of the attempt")
+	}
+}
+
+func TestEnqueueEmailSchedulesFutureJobs(t *testing.T) {
+	ts := newTestService(t, 3)
+	ctx := context.Background()
+
+	sendAt := time.Now().Add(time.Hour)
+	scheduled := testJob("later")
+	scheduled.SendAt = &sendAt
+	if err := ts.EnqueueEmail(ctx, scheduled); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+	if err := ts.EnqueueEmail(ctx, testJob("now")); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+
+	if got := ts.scheduler.scheduled(); len(got) != 1 || got[0].job.ID != "later" || !got[0].at.Equal(sendAt) {
+		t.Errorf("scheduled %v, want the later job at its send_at time", got)
+	}
+	if got := ts.queue.enqueued(); len(got) != 1 || got[0].ID != "now" {
+		t.Errorf("enqueued %v, want the job that is due", got)
+	}
+	if status := ts.status(t, "later"); status.State != domain.JobScheduled {
+		t.Errorf("status of the later job = %s, want scheduled", status.State)
+	}
+	if n := ts.count(ts.counters.enqueued); n != 2 {
+		t.Errorf("enqueued counter = %v, want 2", n)
+	}
+}
+
+func TestProcessEmailJobRetriesByFailureClass(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.queues[domain.DefaultQueue] = NamedQueue{
+		Name:       domain.DefaultQueue,
+		Queue:      ts.queue,
+		Scheduler:  ts.scheduler,
+		MaxRetries: 3,
+		RetryPolicies: &domain.RetryPolicies{
+			Default: domain.RetryPolicy{Base: 5 * time.Second, Factor: 2, Jitter: domain.JitterNone},
+			Classes: map[domain.FailureClass]domain.RetryPolicy{
+				domain.FailureRateLimited: {Base: time.Minute, Factor: 1, Jitter: domain.JitterNone},
+			},
+		},
+	}
+	ts.sendErr = domain.NewSMTPError(421, "4.7.28", "sending rate too high, slow down")
+
+	before := time.Now()
+	ts.ProcessEmailJob(testJob("throttled"))
+	scheduled := ts.scheduler.scheduled()
+	if len(scheduled) != 1 {
+		t.Fatalf("scheduled %d retries, want 1", len(scheduled))
+	}
+	if delay := scheduled[0].at.Sub(before); delay < time.Minute || delay > time.Minute+time.Second {
+		t.Errorf("rate limited job retried in %s, want the 1m of its class", delay)
+	}
+}
+
+func TestProcessEmailJobGivesUpAfterMaxAge(t *testing.T) {
+	ts := newTestService(t, 10)
+	ts.queues[domain.DefaultQueue] = NamedQueue{
+		Name:          domain.DefaultQueue,
+		Queue:         ts.queue,
+		Scheduler:     ts.scheduler,
+		MaxRetries:    10,
+		RetryPolicies: &domain.RetryPolicies{Default: domain.RetryPolicy{Base: time.Hour, Factor: 1, Jitter: domain.JitterNone, MaxAge: 2 * time.Hour}},
+	}
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+
+	firstAttempt := time.Now().Add(-90 * time.Minute)
+	job := testJob("old")
+	job.Retries = 1
+	job.FirstAttemptAt = &firstAttempt
+	ts.ProcessEmailJob(job)
+
+	if n := len(ts.scheduler.scheduled()); n != 0 {
+		t.Errorf("scheduled %d retries past the retry window, want 0", n)
+	}
+	stored := ts.dlq.stored()
+	if len(stored) != 1 || !strings.Contains(stored[0].reason, "Retry window of 2h0m0s exceeded") {
+		t.Errorf("DLQ holds %v, want the job whose retry window ran out", stored)
+	}
+}
+
+func TestProcessEmailJobDeadLettersPermanentFailures(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.sendErr = domain.NewSMTPError(550, "5.1.1", "no such user")
+
+	ts.ProcessEmailJob(testJob("bounced"))
+
+	if n := len(ts.scheduler.scheduled()); n != 0 {
+		t.Errorf("scheduled %d retries of a permanent failure, want 0", n)
+	}
+	stored := ts.dlq.stored()
+	if len(stored) != 1 || stored[0].deliveryErr == nil || stored[0].deliveryErr.EnhancedCode != "5.1.1" {
+		t.Fatalf("DLQ holds %v, want the job with its delivery error", stored)
+	}
+	if !strings.HasPrefix(stored[0].reason, "Permanent failure") {
+		t.Errorf("DLQ reason = %q, want a permanent failure", stored[0].reason)
+	}
+
+	status := ts.status(t, "bounced")
+	if status.State != domain.JobDeadLettered || len(status.Attempts) != 1 {
+		t.Fatalf("status = %s with %d attempts, want dead_lettered after 1", status.State, len(status.Attempts))
+	}
+	if attempt := status.Attempts[0]; attempt.Error == nil || attempt.Error.Class != domain.FailurePermanent || attempt.FinishedAt == nil {
+		t.Errorf("attempt = %+v, want it finished with the permanent failure", attempt)
+	}
+}
+
+func TestEnqueueEmailsSplitsBatch(t *testing.T) {
+	ts := newTestService(t, 3)
+	sendAt := time.Now().Add(time.Hour)
+
+	jobs := []domain.EmailJob{testJob("due-1"), testJob("later"), testJob("elsewhere"), testJob("due-2")}
+	jobs[1].SendAt = &sendAt
+	jobs[2].Queue = "unknown"
+	errs := ts.EnqueueEmails(context.Background(), jobs)
+
+	if errs[0] != nil || errs[1] != nil || errs[3] != nil {
+		t.Errorf("EnqueueEmails() errors = %v, want the due and scheduled jobs accepted", errs)
+	}
+	if !errors.Is(errs[2], ports.ErrUnknownQueue) {
+		t.Errorf("error of the job for an unknown queue = %v, want ErrUnknownQueue", errs[2])
+	}
+	if got := ts.queue.enqueued(); len(got) != 2 || got[0].ID != "due-1" || got[1].ID != "due-2" {
+		t.Errorf("enqueued %v, want the two due jobs in order", got)
+	}
+	if got := ts.scheduler.scheduled(); len(got) != 1 || got[0].job.ID != "later" {
+		t.Errorf("scheduled %v, want the later job", got)
+	}
+}
+
+func TestEnqueueEmailsReportsRejectedJobs(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.queue.err = &ports.QueueFullError{}
+
+	errs := ts.EnqueueEmails(context.Background(), []domain.EmailJob{testJob("1"), testJob("2")})
+	for i, err := range errs {
+		if !errors.Is(err, ports.ErrQueueFull) {
+			t.Errorf("error of job %d = %v, want ErrQueueFull", i, err)
+		}
+	}
+	if _, err := ts.GetEmailStatus(context.Background(), "1"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("GetEmailStatus() of a rejected job error = %v, want ErrJobNotFound", err)
+	}
+	if n := ts.count(ts.counters.failed); n != 2 {
+		t.Errorf("failed counter = %v, want 2", n)
+	}
+}
+
+func TestProcessEmailJobDeadLettersExpiredJobs(t *testing.T) {
+	ts := newTestService(t, 3)
+	ctx := context.Background()
+
+	job := testJob("otp")
+	job.TTL = "15m"
+	if err := ts.EnqueueEmail(ctx, job); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+	queued := ts.queue.enqueued()[0]
+	if queued.ExpiresAt == nil || queued.ExpiresAt.Before(time.Now().Add(14*time.Minute)) {
+		t.Fatalf("queued job expires at %v, want the ttl resolved to a time", queued.ExpiresAt)
+	}
+	if status := ts.status(t, "otp"); status.ExpiresAt == nil || !status.ExpiresAt.Equal(*queued.ExpiresAt) {
+		t.Errorf("status expires at %v, want %v", status.ExpiresAt, queued.ExpiresAt)
+	}
+
+	// The job sat in the queue for longer than its ttl.
+	expired := time.Now().Add(-time.Second)
+	queued.ExpiresAt = &expired
+	ts.ProcessEmailJob(queued)
+
+	if n := len(ts.sent); n != 0 {
+		t.Errorf("sent %d messages, want the expired job dropped", n)
+	}
+	stored := ts.dlq.stored()
+	if len(stored) != 1 || stored[0].reason != reasonExpired {
+		t.Fatalf("DLQ holds %v, want the job with reason %q", stored, reasonExpired)
+	}
+	if n := ts.count(ts.counters.expired); n != 1 {
+		t.Errorf("expired counter = %v, want 1", n)
+	}
+	if status := ts.status(t, "otp"); status.State != domain.JobDeadLettered || len(status.Attempts) != 0 {
+		t.Errorf("status = %s with %d attempts, want dead_lettered without an attempt", status.State, len(status.Attempts))
+	}
+}
+
+func TestProcessEmailJobDoesNotRetryPastExpiry(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+
+	// The first retry would run 5s from now, after the job expired.
+	job := testJob("otp")
+	expiresAt := time.Now().Add(2 * time.Second)
+	job.ExpiresAt = &expiresAt
+	ts.ProcessEmailJob(job)
+
+	if n := len(ts.scheduler.scheduled()); n != 0 {
+		t.Errorf("scheduled %d retries past the expiry, want 0", n)
+	}
+	stored := ts.dlq.stored()
+	if len(stored) != 1 || stored[0].reason != reasonExpired || stored[0].deliveryErr == nil {
+		t.Fatalf("DLQ holds %v, want the job expired with its last failure", stored)
+	}
+	if n := ts.count(ts.counters.expired); n != 1 {
+		t.Errorf("expired counter = %v, want 1", n)
+	}
+
+	// A job that still has time left is retried.
+	job = testJob("later")
+	expiresAt = time.Now().Add(time.Hour)
+	job.ExpiresAt = &expiresAt
+	ts.ProcessEmailJob(job)
+	if n := len(ts.scheduler.scheduled()); n != 1 {
+		t.Errorf("scheduled %d retries, want 1 before the job expires", n)
+	}
+}
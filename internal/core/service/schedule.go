@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// maxCatchUpRuns bounds how many missed runs of a schedule with catch_up all
+// fire per tick; the others fire on the following ticks.
+const maxCatchUpRuns = 100
+
+// errScheduleClaimed stops a tick from firing a run that another instance
+// fired already, or of a schedule that was changed or deleted meanwhile.
+var errScheduleClaimed = errors.New("schedule run claimed already")
+
+// scheduleService implements the ports.ScheduleService interface on top of
+// an EmailService. Every instance runs the loop, but only the leader fires
+// runs. A run is claimed by moving the next run time of its schedule in the
+// store before its jobs are enqueued, so an instance that still believes it
+// leads after its lease ran out cannot fire the same run again. A run whose
+// jobs do not all enqueue is recorded in the schedule and fired again on the
+// next ticks while it is within the grace period. The job IDs of a run are
+// derived from the run, so a retry skips the recipients whose job was
+// enqueued before.
+type scheduleService struct {
+	store         ports.ScheduleStore
+	emails        ports.EmailService
+	leader        ports.LeaderElector
+	interval      time.Duration
+	grace         time.Duration
+	logger        *logger.Logger
+	runsCounter   *prometheus.CounterVec
+	missedCounter *prometheus.CounterVec
+	failedCounter *prometheus.CounterVec
+	leaderGauge   prometheus.Gauge
+
+	mu      sync.Mutex
+	leading bool
+	started bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduleService creates a new ScheduleService that checks for due runs
+// every interval and enqueues their jobs through emails. Runs that are late
+// by more than grace count as missed under catch_up skip, and runs whose
+// jobs fail to enqueue are retried for up to grace. The counters of fired
+// runs, missed runs and failed recipients are labelled with the schedule;
+// the gauge is 1 while this instance leads.
+func NewScheduleService(
+	store ports.ScheduleStore,
+	emails ports.EmailService,
+	leader ports.LeaderElector,
+	interval time.Duration,
+	grace time.Duration,
+	l *logger.Logger,
+	runs *prometheus.CounterVec,
+	missed *prometheus.CounterVec,
+	failed *prometheus.CounterVec,
+	leading prometheus.Gauge,
+) ports.ScheduleService {
+	return &scheduleService{
+		store:         store,
+		emails:        emails,
+		leader:        leader,
+		interval:      interval,
+		grace:         grace,
+		logger:        l,
+		runsCounter:   runs,
+		missedCounter: missed,
+		failedCounter: failed,
+		leaderGauge:   leading,
+		done:          make(chan struct{}),
+	}
+}
+
+// PutSchedule validates and stores a schedule definition, keeping the
+// creation time, last run, runs to retry and, unless its timing changed,
+// the next run of the one it replaces. The templates are rendered for every recipient up
+// front, so that missing template data is reported now rather than at the
+// first run.
+func (s *scheduleService) PutSchedule(ctx context.Context, schedule domain.Schedule) (domain.Schedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return domain.Schedule{}, err
+	}
+	for i, r := range schedule.Recipients {
+		job, err := scheduleJob(schedule, r)
+		if err != nil {
+			return domain.Schedule{}, fmt.Errorf("%w: recipient %d: %v", ports.ErrInvalidSchedule, i+1, err)
+		}
+		if err := job.Validate(); err != nil {
+			return domain.Schedule{}, fmt.Errorf("%w: recipient %d: %v", ports.ErrInvalidSchedule, i+1, err)
+		}
+	}
+
+	stored, err := s.store.UpdateSchedule(ctx, schedule.Name, func(current *domain.Schedule) error {
+		now := time.Now()
+		schedule.CreatedAt = now
+		schedule.UpdatedAt = now
+		schedule.LastRunAt = nil
+		schedule.RetryRuns = nil
+		schedule.NextRunAt = schedule.NextAfter(now)
+		if !current.CreatedAt.IsZero() {
+			schedule.CreatedAt = current.CreatedAt
+			schedule.LastRunAt = current.LastRunAt
+			schedule.RetryRuns = current.RetryRuns
+			if !current.Paused && current.Cron == schedule.Cron && current.Timezone == schedule.Timezone {
+				schedule.NextRunAt = current.NextRunAt
+			}
+		}
+		*current = schedule
+		return nil
+	})
+	if err != nil {
+		return domain.Schedule{}, err
+	}
+	s.logger.Printf("Stored schedule %s (%s in %s) for %d recipients, next run at %s", stored.Name, stored.Cron, stored.Location(), len(stored.Recipients), stored.NextRunAt.Format(time.RFC3339))
+	return stored, nil
+}
+
+// GetSchedule returns a schedule definition.
+func (s *scheduleService) GetSchedule(ctx context.Context, name string) (domain.Schedule, error) {
+	return s.store.GetSchedule(ctx, name)
+}
+
+// ListSchedules returns every schedule definition.
+func (s *scheduleService) ListSchedules(ctx context.Context) ([]domain.Schedule, error) {
+	return s.store.ListSchedules(ctx)
+}
+
+// DeleteSchedule removes a schedule definition.
+func (s *scheduleService) DeleteSchedule(ctx context.Context, name string) error {
+	if err := s.store.DeleteSchedule(ctx, name); err != nil {
+		return err
+	}
+	s.logger.Printf("Deleted schedule %s", name)
+	return nil
+}
+
+// Start starts the loop firing due runs.
+func (s *scheduleService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop stops the loop, waiting for a tick in progress, and resigns, so that
+// another instance takes over right away.
+func (s *scheduleService) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = false
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if err := s.leader.Resign(context.Background()); err != nil {
+		s.logger.Errorf("Failed to resign schedule leadership: %v", err)
+	}
+	s.setLeading(false)
+	s.logger.Println("Schedule loop stopped.")
+}
+
+func (s *scheduleService) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.tick()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+// tick renews the leadership of this instance and, if it leads, fires the
+// runs that are due.
+func (s *scheduleService) tick() {
+	ctx := context.Background()
+	leading, err := s.leader.Campaign(ctx)
+	if err != nil {
+		s.logger.Errorf("Failed to campaign for schedule leadership: %v", err)
+		leading = false
+	}
+	s.setLeading(leading)
+	if !leading {
+		return
+	}
+
+	schedules, err := s.store.ListSchedules(ctx)
+	if err != nil {
+		s.logger.Errorf("Failed to list schedules: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, schedule := range schedules {
+		if schedule.Paused || (len(schedule.RetryRuns) == 0 && !isDue(schedule, now)) {
+			continue
+		}
+		s.fire(ctx, schedule, now)
+	}
+}
+
+// setLeading records whether this instance leads, logging changes.
+func (s *scheduleService) setLeading(leading bool) {
+	s.mu.Lock()
+	changed := s.leading != leading
+	s.leading = leading
+	s.mu.Unlock()
+
+	if leading {
+		s.leaderGauge.Set(1)
+	} else {
+		s.leaderGauge.Set(0)
+	}
+	if changed && leading {
+		s.logger.Println("This instance now fires the recurring schedules.")
+	} else if changed {
+		s.logger.Println("This instance no longer fires the recurring schedules.")
+	}
+}
+
+// fire claims the due runs of a schedule and the runs to retry, enqueues
+// their jobs and records the runs to retry again.
+func (s *scheduleService) fire(ctx context.Context, schedule domain.Schedule, now time.Time) {
+	var due, retries []time.Time
+	var missed int
+	claimed, err := s.store.UpdateSchedule(ctx, schedule.Name, func(current *domain.Schedule) error {
+		if current.CreatedAt.IsZero() || current.Paused || !current.NextRunAt.Equal(schedule.NextRunAt) || !sameRuns(current.RetryRuns, schedule.RetryRuns) {
+			return errScheduleClaimed
+		}
+		retries, current.RetryRuns = current.RetryRuns, nil
+		due, missed = nil, 0
+		if isDue(*current, now) {
+			due, missed, current.NextRunAt = current.Due(now, s.grace, maxCatchUpRuns)
+		}
+		if len(due) > 0 {
+			lastRunAt := due[len(due)-1]
+			current.LastRunAt = &lastRunAt
+		}
+		return nil
+	})
+	if errors.Is(err, errScheduleClaimed) {
+		return
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to claim the run of schedule %s at %s: %v", schedule.Name, schedule.NextRunAt.Format(time.RFC3339), err)
+		return
+	}
+
+	if missed > 0 {
+		s.logger.Warnf("Schedule %s missed %d runs (catch_up: %s)", claimed.Name, missed, catchUpPolicy(claimed))
+		s.missedCounter.WithLabelValues(claimed.Name).Add(float64(missed))
+	}
+	var retryAgain []time.Time
+	for _, at := range retries {
+		if s.enqueueRun(ctx, claimed, at, now, true) {
+			retryAgain = append(retryAgain, at)
+		}
+	}
+	for _, at := range due {
+		if s.enqueueRun(ctx, claimed, at, now, false) {
+			retryAgain = append(retryAgain, at)
+		}
+	}
+	if len(retryAgain) > 0 {
+		s.retryLater(ctx, claimed.Name, retryAgain)
+	}
+}
+
+// enqueueRun enqueues the jobs of the run of a schedule at at and reports
+// whether the run is to be retried: some of its jobs failed to enqueue and
+// it is within the grace period. A retry skips the recipients whose job was
+// enqueued before. Once the run is not retried anymore, the recipients whose
+// job failed are counted, and the run counts as fired if any job was
+// enqueued.
+func (s *scheduleService) enqueueRun(ctx context.Context, schedule domain.Schedule, at, now time.Time, retry bool) bool {
+	runAt := at.In(schedule.Location()).Format(time.RFC3339)
+	jobs := make([]domain.EmailJob, 0, len(schedule.Recipients))
+	enqueued, rejected := 0, 0
+	for i, r := range schedule.Recipients {
+		job, err := scheduleJob(schedule, r)
+		if err != nil {
+			s.logger.Errorf("Failed to render schedule %s for %s: %v", schedule.Name, r.To, err)
+			rejected++
+			continue
+		}
+		job.ID = runJobID(schedule, at, i, r)
+		if retry {
+			if _, err := s.emails.GetEmailStatus(ctx, job.ID); err == nil {
+				enqueued++
+				continue
+			}
+		}
+		jobs = append(jobs, job)
+	}
+
+	failed := 0
+	for i, err := range s.emails.EnqueueEmails(ctx, jobs) {
+		if err != nil {
+			s.logger.Errorf("Failed to enqueue the run of schedule %s at %s for %s: %v", schedule.Name, runAt, jobs[i].To, err)
+			failed++
+			continue
+		}
+		enqueued++
+	}
+	if failed > 0 && now.Sub(at) < s.grace {
+		s.logger.Warnf("Schedule %s enqueued %d of %d jobs of its run at %s, retrying the other %d on the next tick", schedule.Name, enqueued, len(schedule.Recipients), runAt, failed)
+		return true
+	}
+
+	if rejected+failed > 0 {
+		s.failedCounter.WithLabelValues(schedule.Name).Add(float64(rejected + failed))
+	}
+	if enqueued == 0 {
+		s.logger.Errorf("Schedule %s enqueued none of the %d jobs of its run at %s, giving up on it", schedule.Name, len(schedule.Recipients), runAt)
+		return false
+	}
+	s.logger.Printf("Schedule %s fired its run at %s: %d of %d jobs enqueued", schedule.Name, runAt, enqueued, len(schedule.Recipients))
+	s.runsCounter.WithLabelValues(schedule.Name).Inc()
+	return false
+}
+
+// retryLater records runs of a schedule whose jobs are to be enqueued again
+// on the next ticks.
+func (s *scheduleService) retryLater(ctx context.Context, name string, runs []time.Time) {
+	_, err := s.store.UpdateSchedule(ctx, name, func(current *domain.Schedule) error {
+		if current.CreatedAt.IsZero() {
+			return ports.ErrScheduleNotFound // Deleted meanwhile
+		}
+		current.RetryRuns = append(current.RetryRuns, runs...)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ports.ErrScheduleNotFound) {
+		s.logger.Errorf("Failed to record %d runs of schedule %s to retry: %v", len(runs), name, err)
+	}
+}
+
+// isDue reports whether the next run of a schedule is due at now.
+func isDue(schedule domain.Schedule, now time.Time) bool {
+	return !schedule.NextRunAt.IsZero() && !schedule.NextRunAt.After(now)
+}
+
+// sameRuns reports whether a and b hold the same run times.
+func sameRuns(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// runJobID returns the ID of the job of a run of a schedule for its i-th
+// recipient, which is the same every time the run is enqueued.
+func runJobID(schedule domain.Schedule, at time.Time, i int, r domain.ScheduleRecipient) string {
+	sum := sha256.Sum256([]byte(schedule.Name + "\n" + strconv.FormatInt(schedule.CreatedAt.UnixNano(), 10) + "\n" +
+		strconv.FormatInt(at.UnixNano(), 10) + "\n" + strconv.Itoa(i) + "\n" + r.To))
+	return hex.EncodeToString(sum[:16])
+}
+
+// catchUpPolicy returns the catch-up policy of a schedule, or the default.
+func catchUpPolicy(schedule domain.Schedule) domain.CatchUpPolicy {
+	if schedule.CatchUp == "" {
+		return domain.CatchUpLatest
+	}
+	return schedule.CatchUp
+}
+
+// scheduleJob returns the job sending a run of a schedule to a recipient.
+func scheduleJob(schedule domain.Schedule, r domain.ScheduleRecipient) (domain.EmailJob, error) {
+	subject, body, err := schedule.RenderTemplates(r)
+	if err != nil {
+		return domain.EmailJob{}, err
+	}
+	return domain.EmailJob{
+		ID:         domain.NewJobID(),
+		To:         r.To,
+		Subject:    subject,
+		Body:       body,
+		BodyFormat: schedule.BodyFormat,
+		Render:     schedule.Render,
+		Priority:   schedule.Priority,
+		Queue:      schedule.Queue,
+		Type:       schedule.Type,
+	}, nil
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	schedulememory "email-queue-service/internal/infrastructure/schedule/memory"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// fakeLeader leads while leading is set.
+type fakeLeader struct {
+	leading bool
+}
+
+func (l *fakeLeader) Campaign(ctx context.Context) (bool, error) { return l.leading, nil }
+func (l *fakeLeader) Resign(ctx context.Context) error           { l.leading = false; return nil }
+
+type testScheduleService struct {
+	*scheduleService
+	emails       *testService
+	store        *schedulememory.Store
+	leader       *fakeLeader
+	runs, missed *prometheus.CounterVec
+	failed       *prometheus.CounterVec
+}
+
+// newTestScheduleService returns a leading schedule service enqueueing
+// through the test email service, with the hourly schedule defined.
+func newTestScheduleService(t *testing.T) *testScheduleService {
+	t.Helper()
+	ts := &testScheduleService{
+		emails: newTestService(t, 3),
+		store:  schedulememory.NewStore(),
+		leader: &fakeLeader{leading: true},
+		runs:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_runs_total"}, []string{"schedule"}),
+		missed: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_missed_total"}, []string{"schedule"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_failed_total"}, []string{"schedule"}),
+	}
+	ts.scheduleService = NewScheduleService(ts.store, ts.emails, ts.leader, time.Minute, 5*time.Minute, logger.NewLogger(),
+		ts.runs, ts.missed, ts.failed, prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_leader"}),
+	).(*scheduleService)
+
+	inlineCSS := false
+	hourly := domain.Schedule{
+		Name:    "hourly",
+		Cron:    "@hourly",
+		Subject: "Report for {{.team}}",
+		Body:    "Hi {{.name}}",
+		Render:  &domain.RenderOptions{InlineCSS: &inlineCSS},
+		Recipients: []domain.ScheduleRecipient{
+			{To: "a@example.com", Data: map[string]string{"team": "Sales", "name": "Ada"}},
+			{To: "b@example.com", Data: map[string]string{"team": "Support", "name": "Bob"}},
+		},
+	}
+	if _, err := ts.PutSchedule(context.Background(), hourly); err != nil {
+		t.Fatalf("PutSchedule() error = %v", err)
+	}
+	return ts
+}
+
+// setNextRun moves the next run of the hourly schedule.
+func (ts *testScheduleService) setNextRun(t *testing.T, at time.Time) {
+	t.Helper()
+	if _, err := ts.store.UpdateSchedule(context.Background(), "hourly", func(s *domain.Schedule) error {
+		s.NextRunAt = at
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateSchedule() error = %v", err)
+	}
+}
+
+func TestScheduleTickFiresDueRuns(t *testing.T) {
+	ts := newTestScheduleService(t)
+	ts.tick()
+	if n := len(ts.emails.queue.enqueued()); n != 0 {
+		t.Fatalf("enqueued %d jobs before the first run, want none", n)
+	}
+
+	// Six runs are due; catch_up latest fires the latest of them.
+	latest := time.Now().Truncate(time.Hour)
+	ts.setNextRun(t, latest.Add(-5*time.Hour))
+	ts.tick()
+	jobs := ts.emails.queue.enqueued()
+	if len(jobs) != 2 || jobs[0].Subject != "Report for Sales" || jobs[1].To != "b@example.com" {
+		t.Fatalf("enqueued %+v, want one job per recipient", jobs)
+	}
+	if r := jobs[0].Render; r == nil || r.InlineCSS == nil || *r.InlineCSS {
+		t.Errorf("job render options = %+v, want those of the schedule", r)
+	}
+	if got := testutil.ToFloat64(ts.missed.WithLabelValues("hourly")); got != 5 {
+		t.Errorf("missed runs = %v, want 5", got)
+	}
+	schedule, err := ts.GetSchedule(context.Background(), "hourly")
+	if err != nil {
+		t.Fatalf("GetSchedule() error = %v", err)
+	}
+	if schedule.LastRunAt == nil || !schedule.LastRunAt.Equal(latest) || !schedule.NextRunAt.Equal(latest.Add(time.Hour)) {
+		t.Errorf("schedule ran at %v, next at %s; want %s and %s", schedule.LastRunAt, schedule.NextRunAt, latest, latest.Add(time.Hour))
+	}
+
+	// The run is claimed, so it does not fire again.
+	ts.tick()
+	if n := len(ts.emails.queue.enqueued()); n != 2 {
+		t.Errorf("enqueued %d jobs after another tick, want the run to fire once", n)
+	}
+}
+
+func TestScheduleTickRetriesRunsThatFailedToEnqueue(t *testing.T) {
+	ts := newTestScheduleService(t)
+	ts.emails.queue.err = errors.New("queue is full")
+	at := time.Now().Add(-time.Minute)
+	ts.setNextRun(t, at)
+	ts.tick()
+	if n := len(ts.emails.queue.enqueued()); n != 0 {
+		t.Fatalf("enqueued %d jobs into a failing queue, want none", n)
+	}
+	if got := testutil.ToFloat64(ts.runs.WithLabelValues("hourly")); got != 0 {
+		t.Errorf("runs = %v with no job enqueued, want 0", got)
+	}
+	schedule, err := ts.GetSchedule(context.Background(), "hourly")
+	if err != nil {
+		t.Fatalf("GetSchedule() error = %v", err)
+	}
+	if len(schedule.RetryRuns) != 1 || !schedule.RetryRuns[0].Equal(at) {
+		t.Fatalf("retry runs = %v, want [%s]", schedule.RetryRuns, at)
+	}
+
+	// The job of the first recipient got through before; the retry only
+	// enqueues the other one.
+	ts.emails.queue.err = nil
+	first, err := scheduleJob(schedule, schedule.Recipients[0])
+	if err != nil {
+		t.Fatalf("scheduleJob() error = %v", err)
+	}
+	first.ID = runJobID(schedule, at, 0, schedule.Recipients[0])
+	if err := ts.emails.EnqueueEmail(context.Background(), first); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+	ts.tick()
+	jobs := ts.emails.queue.enqueued()
+	if len(jobs) != 2 || jobs[1].To != "b@example.com" {
+		t.Fatalf("enqueued %+v, want the retry to enqueue the second recipient only", jobs)
+	}
+	if got := testutil.ToFloat64(ts.runs.WithLabelValues("hourly")); got != 1 {
+		t.Errorf("runs = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(ts.failed.WithLabelValues("hourly")); got != 0 {
+		t.Errorf("failed recipients = %v, want 0", got)
+	}
+	if schedule, _ = ts.GetSchedule(context.Background(), "hourly"); len(schedule.RetryRuns) != 0 {
+		t.Errorf("retry runs = %v after the retry, want none", schedule.RetryRuns)
+	}
+}
+
+func TestScheduleTickGivesUpOnRunsPastGrace(t *testing.T) {
+	ts := newTestScheduleService(t)
+	ts.emails.queue.err = errors.New("queue is full")
+	if _, err := ts.store.UpdateSchedule(context.Background(), "hourly", func(s *domain.Schedule) error {
+		s.RetryRuns = []time.Time{time.Now().Add(-10 * time.Minute)}
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateSchedule() error = %v", err)
+	}
+	ts.tick()
+	if got := testutil.ToFloat64(ts.failed.WithLabelValues("hourly")); got != 2 {
+		t.Errorf("failed recipients = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(ts.runs.WithLabelValues("hourly")); got != 0 {
+		t.Errorf("runs = %v with no job enqueued, want 0", got)
+	}
+	schedule, err := ts.GetSchedule(context.Background(), "hourly")
+	if err != nil {
+		t.Fatalf("GetSchedule() error = %v", err)
+	}
+	if len(schedule.RetryRuns) != 0 {
+		t.Errorf("retry runs = %v past the grace period, want none", schedule.RetryRuns)
+	}
+}
+
+func TestScheduleTickOnlyFiresOnLeader(t *testing.T) {
+	ts := newTestScheduleService(t)
+	ts.leader.leading = false
+	ts.setNextRun(t, time.Now().Add(-time.Minute))
+	ts.tick()
+	if n := len(ts.emails.queue.enqueued()); n != 0 {
+		t.Fatalf("enqueued %d jobs without leading, want none", n)
+	}
+
+	ts.leader.leading = true
+	ts.tick()
+	if n := len(ts.emails.queue.enqueued()); n != 2 {
+		t.Errorf("enqueued %d jobs once leading, want 2", n)
+	}
+}
+
+func TestScheduleTickSkipsPausedSchedules(t *testing.T) {
+	ts := newTestScheduleService(t)
+	if _, err := ts.store.UpdateSchedule(context.Background(), "hourly", func(s *domain.Schedule) error {
+		s.Paused = true
+		s.NextRunAt = time.Now().Add(-time.Minute)
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateSchedule() error = %v", err)
+	}
+	ts.tick()
+	if n := len(ts.emails.queue.enqueued()); n != 0 {
+		t.Errorf("enqueued %d jobs of a paused schedule, want none", n)
+	}
+}
+
+func TestPutScheduleRejectsMissingTemplateData(t *testing.T) {
+	ts := newTestScheduleService(t)
+	schedule := domain.Schedule{
+		Name:       "weekly",
+		Cron:       "0 9 * * mon",
+		Subject:    "Report for {{.team}}",
+		Body:       "Hi",
+		Recipients: []domain.ScheduleRecipient{{To: "a@example.com"}},
+	}
+	if _, err := ts.PutSchedule(context.Background(), schedule); !errors.Is(err, ports.ErrInvalidSchedule) {
+		t.Errorf("PutSchedule() without template data error = %v, want ErrInvalidSchedule", err)
+	}
+}
+
+func TestPutScheduleKeepsNextRunUnlessTimingChanged(t *testing.T) {
+	ts := newTestScheduleService(t)
+	ctx := context.Background()
+	stored, err := ts.GetSchedule(ctx, "hourly")
+	if err != nil {
+		t.Fatalf("GetSchedule() error = %v", err)
+	}
+	pending := time.Now().Add(-time.Minute)
+	ts.setNextRun(t, pending)
+
+	stored.Subject = "Hourly report for {{.team}}"
+	updated, err := ts.PutSchedule(ctx, stored)
+	if err != nil {
+		t.Fatalf("PutSchedule() error = %v", err)
+	}
+	if !updated.NextRunAt.Equal(pending) || !updated.CreatedAt.Equal(stored.CreatedAt) {
+		t.Errorf("updated schedule = next %s, created %s; want the pending run %s and creation time %s", updated.NextRunAt, updated.CreatedAt, pending, stored.CreatedAt)
+	}
+
+	stored.Cron = "0 9 * * mon"
+	updated, err = ts.PutSchedule(ctx, stored)
+	if err != nil {
+		t.Fatalf("PutSchedule() error = %v", err)
+	}
+	if !updated.NextRunAt.After(time.Now()) {
+		t.Errorf("next run after changing the cron = %s, want the next match from now on", updated.NextRunAt)
+	}
+}
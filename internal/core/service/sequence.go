@@ -0,0 +1,363 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// sequenceService implements the ports.SequenceService interface on top of
+// an EmailService. Every step is a job of its own, scheduled for its send
+// time through the scheduler of its queue; the next step is only scheduled
+// once the current one is sent, so an enrollment has at most one pending
+// job and an exit event only needs to cancel that one.
+type sequenceService struct {
+	store           ports.SequenceStore
+	emails          ports.EmailService
+	logger          *logger.Logger
+	enrolledCounter *prometheus.CounterVec
+	finishedCounter *prometheus.CounterVec
+}
+
+// NewSequenceService creates a new SequenceService sending the steps through
+// emails. The enrolled counter is labelled with the sequence, the finished
+// counter with the sequence and the final state.
+func NewSequenceService(
+	store ports.SequenceStore,
+	emails ports.EmailService,
+	l *logger.Logger,
+	enrolled *prometheus.CounterVec,
+	finished *prometheus.CounterVec,
+) ports.SequenceService {
+	return &sequenceService{
+		store:           store,
+		emails:          emails,
+		logger:          l,
+		enrolledCounter: enrolled,
+		finishedCounter: finished,
+	}
+}
+
+// PutSequence validates and stores a sequence definition, keeping the
+// creation time of the one it replaces.
+func (s *sequenceService) PutSequence(ctx context.Context, seq domain.Sequence) (domain.Sequence, error) {
+	if err := seq.Validate(); err != nil {
+		return domain.Sequence{}, err
+	}
+	now := time.Now()
+	seq.CreatedAt = now
+	seq.UpdatedAt = now
+	existing, err := s.store.GetSequence(ctx, seq.Name)
+	switch {
+	case err == nil:
+		seq.CreatedAt = existing.CreatedAt
+	case !errors.Is(err, ports.ErrSequenceNotFound):
+		return domain.Sequence{}, err
+	}
+	if err := s.store.PutSequence(ctx, seq); err != nil {
+		return domain.Sequence{}, err
+	}
+	s.logger.Printf("Stored sequence %s with %d steps", seq.Name, len(seq.Steps))
+	return seq, nil
+}
+
+// GetSequence returns a sequence definition.
+func (s *sequenceService) GetSequence(ctx context.Context, name string) (domain.Sequence, error) {
+	return s.store.GetSequence(ctx, name)
+}
+
+// Enroll starts a sequence for a recipient. Every step is rendered with
+// data up front, so that missing template data is reported now rather than
+// days later.
+func (s *sequenceService) Enroll(ctx context.Context, sequence, to string, data map[string]string) (domain.Enrollment, error) {
+	seq, err := s.store.GetSequence(ctx, sequence)
+	if err != nil {
+		return domain.Enrollment{}, err
+	}
+	now := time.Now()
+	enrollment := domain.Enrollment{
+		ID:         domain.NewJobID(),
+		Sequence:   seq.Name,
+		To:         to,
+		Data:       data,
+		State:      domain.EnrollmentActive,
+		EnrolledAt: now,
+		UpdatedAt:  now,
+	}
+	var first domain.EmailJob
+	for i := range seq.Steps {
+		job, err := stepJob(seq, enrollment, i, now)
+		if err != nil {
+			return domain.Enrollment{}, fmt.Errorf("%w: step %d: %v", ports.ErrInvalidEnrollment, i+1, err)
+		}
+		if err := job.Validate(); err != nil {
+			return domain.Enrollment{}, fmt.Errorf("%w: step %d: %v", ports.ErrInvalidEnrollment, i+1, err)
+		}
+		if i == 0 {
+			first = job
+		}
+	}
+	enrollment.JobID = first.ID
+
+	// Stored before the first step is queued, so that the worker picking it
+	// up finds the enrollment.
+	if _, err := s.store.UpdateEnrollment(ctx, enrollment.ID, func(e *domain.Enrollment) error {
+		*e = enrollment
+		return nil
+	}); err != nil {
+		return domain.Enrollment{}, fmt.Errorf("failed to store enrollment: %w", err)
+	}
+	if err := s.emails.EnqueueEmail(ctx, first); err != nil {
+		if err := s.store.DeleteEnrollment(context.Background(), enrollment.ID); err != nil {
+			s.logger.Errorf("Failed to remove enrollment %s: %v", enrollment.ID, err)
+		}
+		return domain.Enrollment{}, err
+	}
+	s.logger.Printf("Enrolled %s in sequence %s (enrollment %s)", to, seq.Name, enrollment.ID)
+	s.enrolledCounter.WithLabelValues(seq.Name).Inc()
+	return enrollment, nil
+}
+
+// GetEnrollment returns an enrollment of a sequence.
+func (s *sequenceService) GetEnrollment(ctx context.Context, sequence, id string) (domain.Enrollment, error) {
+	enrollment, err := s.store.GetEnrollment(ctx, id)
+	if err != nil {
+		return domain.Enrollment{}, err
+	}
+	if enrollment.Sequence != sequence {
+		return domain.Enrollment{}, ports.ErrEnrollmentNotFound
+	}
+	return enrollment, nil
+}
+
+// errEnrollmentFinished stops TriggerEvent from updating an enrollment that
+// has ended already.
+var errEnrollmentFinished = errors.New("enrollment has finished")
+
+// TriggerEvent ends an enrollment on one of the exit events of its sequence
+// and cancels its pending step. A step that a worker is sending already is
+// still sent, but the next one is not scheduled.
+func (s *sequenceService) TriggerEvent(ctx context.Context, sequence, id, event string) (domain.Enrollment, error) {
+	seq, err := s.store.GetSequence(ctx, sequence)
+	if err != nil {
+		return domain.Enrollment{}, err
+	}
+	if !seq.ExitsOn(event) {
+		return domain.Enrollment{}, fmt.Errorf("%w: %q", ports.ErrUnknownEvent, event)
+	}
+
+	var current domain.Enrollment
+	enrollment, err := s.store.UpdateEnrollment(ctx, id, func(e *domain.Enrollment) error {
+		current = *e
+		switch {
+		case e.EnrolledAt.IsZero(), e.Sequence != sequence:
+			return ports.ErrEnrollmentNotFound
+		case e.State != domain.EnrollmentActive:
+			return errEnrollmentFinished
+		}
+		finish(e, domain.EnrollmentExited, event)
+		return nil
+	})
+	switch {
+	case errors.Is(err, errEnrollmentFinished):
+		return current, ports.ErrEnrollmentFinished
+	case err != nil:
+		return domain.Enrollment{}, err
+	}
+	s.logger.Printf("Enrollment %s in sequence %s exited on %s", id, sequence, event)
+	s.finishedCounter.WithLabelValues(sequence, string(domain.EnrollmentExited)).Inc()
+
+	if _, err := s.emails.CancelEmail(ctx, enrollment.JobID); err != nil && !errors.Is(err, ports.ErrJobNotCancellable) {
+		// The worker skips the step of an exited enrollment anyway.
+		s.logger.Errorf("Failed to cancel step %d of enrollment %s: %v", enrollment.Step+1, id, err)
+	}
+	return enrollment, nil
+}
+
+// ProcessEmailJob processes a job through the EmailService. Steps of
+// enrollments that have ended are skipped, and once a step is sent, the next
+// one is scheduled. If the store fails, the step is sent rather than held
+// up, but the sequence cannot continue.
+func (s *sequenceService) ProcessEmailJob(job domain.EmailJob) {
+	ref := job.Sequence
+	if ref == nil {
+		s.emails.ProcessEmailJob(job)
+		return
+	}
+
+	ctx := context.Background()
+	enrollment, err := s.store.GetEnrollment(ctx, ref.Enrollment)
+	if err == nil && enrollment.State == domain.EnrollmentActive && enrollment.Step == ref.Step-1 {
+		enrollment, err = s.catchUp(ctx, enrollment, job)
+	}
+	switch {
+	case err == nil && (enrollment.State != domain.EnrollmentActive || enrollment.JobID != job.ID):
+		// Ended, or a redelivered step that the enrollment is past already
+		s.logger.Printf("Skipping step %d of enrollment %s (%s at step %d)", ref.Step+1, ref.Enrollment, enrollment.State, enrollment.Step+1)
+		if _, err := s.emails.CancelEmail(ctx, job.ID); err != nil && !errors.Is(err, ports.ErrJobNotCancellable) {
+			s.logger.Errorf("Failed to cancel skipped email job %s: %v", job.ID, err)
+		}
+		return
+	case err != nil:
+		s.logger.Errorf("Failed to read enrollment %s of email job %s: %v", ref.Enrollment, job.ID, err)
+		s.emails.ProcessEmailJob(job)
+		return
+	}
+
+	s.emails.ProcessEmailJob(job)
+
+	status, err := s.emails.GetEmailStatus(ctx, job.ID)
+	if err != nil {
+		s.logger.Errorf("Failed to read status of step %d of enrollment %s: %v", ref.Step+1, ref.Enrollment, err)
+		return
+	}
+	switch status.State {
+	case domain.JobSent:
+		s.advance(ctx, enrollment, job)
+	case domain.JobDeadLettered:
+		s.end(ctx, enrollment, job, domain.EnrollmentFailed, status.Reason)
+	case domain.JobCancelled:
+		s.end(ctx, enrollment, job, domain.EnrollmentExited, "cancelled")
+	}
+	// A step waiting for a retry comes back through here.
+}
+
+// catchUp moves an enrollment on to the step of job, which the step before
+// scheduled but has not recorded yet: the step is due right away, or the
+// worker crashed in between. It returns the enrollment as it is now.
+func (s *sequenceService) catchUp(ctx context.Context, enrollment domain.Enrollment, job domain.EmailJob) (domain.Enrollment, error) {
+	step := job.Sequence.Step
+	updated, err := s.store.UpdateEnrollment(ctx, enrollment.ID, func(e *domain.Enrollment) error {
+		if e.State != domain.EnrollmentActive || e.Step != step-1 {
+			return errEnrollmentFinished // Exited meanwhile, or recorded by now
+		}
+		e.Step = step
+		e.JobID = job.ID
+		e.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, errEnrollmentFinished) {
+		return s.store.GetEnrollment(ctx, enrollment.ID)
+	}
+	return updated, err
+}
+
+// advance schedules the step after the one job sent, or completes the
+// enrollment after the last step. The next step is queued before the
+// enrollment records it, so that the enrollment never waits for a job that
+// does not exist; if it cannot record it, the next step is cancelled.
+func (s *sequenceService) advance(ctx context.Context, enrollment domain.Enrollment, job domain.EmailJob) {
+	seq, err := s.store.GetSequence(ctx, enrollment.Sequence)
+	if err != nil {
+		s.logger.Errorf("Failed to read sequence %s of enrollment %s: %v", enrollment.Sequence, enrollment.ID, err)
+		s.end(ctx, enrollment, job, domain.EnrollmentFailed, fmt.Sprintf("Failed to read sequence: %v", err))
+		return
+	}
+	step := job.Sequence.Step + 1
+	if step >= len(seq.Steps) {
+		s.end(ctx, enrollment, job, domain.EnrollmentCompleted, "")
+		return
+	}
+	next, err := stepJob(seq, enrollment, step, time.Now())
+	if err != nil {
+		s.logger.Errorf("Failed to render step %d of enrollment %s: %v", step+1, enrollment.ID, err)
+		s.end(ctx, enrollment, job, domain.EnrollmentFailed, fmt.Sprintf("Failed to render step %d: %v", step+1, err))
+		return
+	}
+
+	// Most exits during the send are seen here, before a step is queued
+	// only to be cancelled.
+	if current, err := s.store.GetEnrollment(ctx, enrollment.ID); err == nil && (current.State != domain.EnrollmentActive || current.JobID != job.ID) {
+		return
+	}
+	if err := s.emails.EnqueueEmail(ctx, next); err != nil {
+		s.logger.Errorf("Failed to schedule step %d of enrollment %s: %v", step+1, enrollment.ID, err)
+		s.end(ctx, enrollment, job, domain.EnrollmentFailed, fmt.Sprintf("Failed to schedule step %d: %v", step+1, err))
+		return
+	}
+
+	_, err = s.store.UpdateEnrollment(ctx, enrollment.ID, func(e *domain.Enrollment) error {
+		if e.State == domain.EnrollmentActive && e.JobID == next.ID {
+			return nil // Caught up by the worker sending it
+		}
+		if e.State != domain.EnrollmentActive || e.JobID != job.ID {
+			return errEnrollmentFinished // Exited meanwhile, or a redelivered step
+		}
+		e.Step = step
+		e.JobID = next.ID
+		e.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errEnrollmentFinished) {
+			s.logger.Errorf("Failed to advance enrollment %s: %v", enrollment.ID, err)
+		}
+		if _, err := s.emails.CancelEmail(ctx, next.ID); err != nil && !errors.Is(err, ports.ErrJobNotCancellable) {
+			s.logger.Errorf("Failed to cancel step %d of enrollment %s: %v", step+1, enrollment.ID, err)
+		}
+		return
+	}
+	s.logger.Printf("Scheduled step %d of %d of enrollment %s", step+1, len(seq.Steps), enrollment.ID)
+}
+
+// end finishes an enrollment whose current step is job.
+func (s *sequenceService) end(ctx context.Context, enrollment domain.Enrollment, job domain.EmailJob, state domain.EnrollmentState, reason string) {
+	_, err := s.store.UpdateEnrollment(ctx, enrollment.ID, func(e *domain.Enrollment) error {
+		if e.State != domain.EnrollmentActive || e.JobID != job.ID {
+			return errEnrollmentFinished
+		}
+		finish(e, state, reason)
+		return nil
+	})
+	if errors.Is(err, errEnrollmentFinished) {
+		return
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to finish enrollment %s: %v", enrollment.ID, err)
+		return
+	}
+	s.logger.Printf("Enrollment %s in sequence %s %s", enrollment.ID, enrollment.Sequence, state)
+	s.finishedCounter.WithLabelValues(enrollment.Sequence, string(state)).Inc()
+}
+
+// finish moves an enrollment to a final state.
+func finish(e *domain.Enrollment, state domain.EnrollmentState, reason string) {
+	now := time.Now()
+	e.State = state
+	e.Reason = reason
+	e.UpdatedAt = now
+	e.FinishedAt = &now
+}
+
+// stepJob returns the job sending a step of a sequence to an enrollment. It
+// is held until the step's offset after the enrollment, unless that time has
+// passed by now.
+func stepJob(seq domain.Sequence, enrollment domain.Enrollment, step int, now time.Time) (domain.EmailJob, error) {
+	subject, body, err := seq.Steps[step].RenderTemplates(enrollment.Data)
+	if err != nil {
+		return domain.EmailJob{}, err
+	}
+	job := domain.EmailJob{
+		ID:         domain.NewJobID(),
+		To:         enrollment.To,
+		Subject:    subject,
+		Body:       body,
+		BodyFormat: seq.Steps[step].BodyFormat,
+		Render:     seq.Steps[step].Render,
+		Priority:   seq.Priority,
+		Queue:      seq.Queue,
+		Type:       seq.Type,
+		Sequence:   &domain.SequenceRef{Enrollment: enrollment.ID, Step: step},
+	}
+	if sendAt := enrollment.EnrolledAt.Add(seq.Steps[step].Offset()); sendAt.After(now) {
+		job.SendAt = &sendAt
+	}
+	return job, nil
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	sequencememory "email-queue-service/internal/infrastructure/sequence/memory"
+	"email-queue-service/internal/pkg/logger"
+)
+
+type testSequenceService struct {
+	*sequenceService
+	*testService
+	store              *sequencememory.Store
+	enrolled, finished *prometheus.CounterVec
+}
+
+// newTestSequenceService returns a sequence service sending through the
+// test email service, with the welcome sequence defined.
+func newTestSequenceService(t *testing.T) *testSequenceService {
+	t.Helper()
+	ts := &testSequenceService{
+		testService: newTestService(t, 3),
+		store:       sequencememory.NewStore(time.Hour),
+		enrolled:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_enrolled_total"}, []string{"sequence"}),
+		finished:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_finished_total"}, []string{"sequence", "state"}),
+	}
+	ts.sequenceService = NewSequenceService(ts.store, ts.emailService, logger.NewLogger(), ts.enrolled, ts.finished).(*sequenceService)
+
+	generateText := false
+	welcome := domain.Sequence{
+		Name:   "welcome",
+		ExitOn: []string{"converted"},
+		Steps: []domain.SequenceStep{
+			{Subject: "Hi {{.name}}", Body: "Welcome aboard"},
+			{Delay: "48h", Subject: "Tips for {{.name}}", Body: "Some tips", Render: &domain.RenderOptions{GenerateText: &generateText}},
+		},
+	}
+	if _, err := ts.sequenceService.PutSequence(context.Background(), welcome); err != nil {
+		t.Fatalf("PutSequence() error = %v", err)
+	}
+	return ts
+}
+
+// ProcessEmailJob and GetEnrollment are promoted from both services; the
+// tests go through the sequence service.
+func (ts *testSequenceService) ProcessEmailJob(job domain.EmailJob) {
+	ts.sequenceService.ProcessEmailJob(job)
+}
+
+func (ts *testSequenceService) enrollment(t *testing.T, id string) domain.Enrollment {
+	t.Helper()
+	enrollment, err := ts.sequenceService.GetEnrollment(context.Background(), "welcome", id)
+	if err != nil {
+		t.Fatalf("GetEnrollment(%s) error = %v", id, err)
+	}
+	return enrollment
+}
+
+func (ts *testSequenceService) enroll(t *testing.T) domain.Enrollment {
+	t.Helper()
+	enrollment, err := ts.Enroll(context.Background(), "welcome", "a@example.com", map[string]string{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+	return enrollment
+}
+
+func TestSequenceEnrollAdvancesThroughSteps(t *testing.T) {
+	ts := newTestSequenceService(t)
+	enrollment := ts.enroll(t)
+
+	queued := ts.queue.enqueued()
+	if len(queued) != 1 || queued[0].ID != enrollment.JobID || queued[0].Subject != "Hi Ada" {
+		t.Fatalf("enqueued %+v, want the rendered first step as job %s", queued, enrollment.JobID)
+	}
+	if ref := queued[0].Sequence; ref == nil || ref.Enrollment != enrollment.ID || ref.Step != 0 {
+		t.Errorf("first step refers to %+v, want step 0 of %s", ref, enrollment.ID)
+	}
+	if n := testutil.ToFloat64(ts.enrolled.WithLabelValues("welcome")); n != 1 {
+		t.Errorf("enrolled counter = %v, want 1", n)
+	}
+
+	// Sending the first step schedules the second one for 48h after enrolling.
+	ts.ProcessEmailJob(queued[0])
+	scheduled := ts.scheduler.scheduled()
+	if len(scheduled) != 1 {
+		t.Fatalf("scheduled %d steps, want the second one", len(scheduled))
+	}
+	second := scheduled[0].job
+	if want := enrollment.EnrolledAt.Add(48 * time.Hour); !scheduled[0].at.Equal(want) || second.Subject != "Tips for Ada" {
+		t.Errorf("scheduled %q at %s, want the second step at %s", second.Subject, scheduled[0].at, want)
+	}
+	if second.Render == nil || second.Render.GenerateText == nil || *second.Render.GenerateText {
+		t.Errorf("second step render options = %+v, want those of the step", second.Render)
+	}
+	if got := ts.enrollment(t, enrollment.ID); got.Step != 1 || got.JobID != second.ID || got.State != domain.EnrollmentActive {
+		t.Errorf("enrollment = %+v, want active at step 1 with job %s", got, second.ID)
+	}
+
+	ts.ProcessEmailJob(second)
+	got := ts.enrollment(t, enrollment.ID)
+	if got.State != domain.EnrollmentCompleted || got.FinishedAt == nil {
+		t.Errorf("enrollment = %+v, want completed", got)
+	}
+	if n := len(ts.sent); n != 2 {
+		t.Errorf("sent %d messages, want 2", n)
+	}
+	if n := testutil.ToFloat64(ts.finished.WithLabelValues("welcome", string(domain.EnrollmentCompleted))); n != 1 {
+		t.Errorf("completed counter = %v, want 1", n)
+	}
+}
+
+func TestSequenceEnrollRejectsInvalidEnrollments(t *testing.T) {
+	ts := newTestSequenceService(t)
+	ctx := context.Background()
+
+	if _, err := ts.Enroll(ctx, "unknown", "a@example.com", nil); !errors.Is(err, ports.ErrSequenceNotFound) {
+		t.Errorf("Enroll() in an unknown sequence error = %v, want ErrSequenceNotFound", err)
+	}
+	// The second step needs the name as well, which is checked up front.
+	if _, err := ts.Enroll(ctx, "welcome", "a@example.com", nil); !errors.Is(err, ports.ErrInvalidEnrollment) {
+		t.Errorf("Enroll() without template data error = %v, want ErrInvalidEnrollment", err)
+	}
+	if _, err := ts.Enroll(ctx, "welcome", "not-an-address", map[string]string{"name": "Ada"}); !errors.Is(err, ports.ErrInvalidEnrollment) {
+		t.Errorf("Enroll() of an invalid address error = %v, want ErrInvalidEnrollment", err)
+	}
+
+	// An enrollment whose first step cannot be queued is removed again.
+	ts.queue.err = &ports.QueueFullError{}
+	if _, err := ts.Enroll(ctx, "welcome", "a@example.com", map[string]string{"name": "Ada"}); !errors.Is(err, ports.ErrQueueFull) {
+		t.Errorf("Enroll() into a full queue error = %v, want ErrQueueFull", err)
+	}
+	if n := len(ts.queue.enqueued()); n != 0 {
+		t.Errorf("enqueued %d jobs, want 0", n)
+	}
+	if n := testutil.ToFloat64(ts.enrolled.WithLabelValues("welcome")); n != 0 {
+		t.Errorf("enrolled counter = %v, want 0", n)
+	}
+}
+
+func TestSequenceTriggerEventExits(t *testing.T) {
+	ts := newTestSequenceService(t)
+	ctx := context.Background()
+	enrollment := ts.enroll(t)
+
+	if _, err := ts.TriggerEvent(ctx, "welcome", enrollment.ID, "clicked"); !errors.Is(err, ports.ErrUnknownEvent) {
+		t.Errorf("TriggerEvent() of an unknown event error = %v, want ErrUnknownEvent", err)
+	}
+	if _, err := ts.TriggerEvent(ctx, "welcome", "unknown", "converted"); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Errorf("TriggerEvent() of an unknown enrollment error = %v, want ErrEnrollmentNotFound", err)
+	}
+
+	exited, err := ts.TriggerEvent(ctx, "welcome", enrollment.ID, "converted")
+	if err != nil {
+		t.Fatalf("TriggerEvent() error = %v", err)
+	}
+	if exited.State != domain.EnrollmentExited || exited.Reason != "converted" {
+		t.Errorf("TriggerEvent() = %+v, want exited on converted", exited)
+	}
+	if status := ts.status(t, enrollment.JobID); status.State != domain.JobCancelled {
+		t.Errorf("pending step is %s, want cancelled", status.State)
+	}
+
+	// The queued step is skipped by the worker.
+	ts.ProcessEmailJob(ts.queue.enqueued()[0])
+	if n := len(ts.sent); n != 0 {
+		t.Errorf("sent %d messages after the exit, want 0", n)
+	}
+
+	again, err := ts.TriggerEvent(ctx, "welcome", enrollment.ID, "converted")
+	if !errors.Is(err, ports.ErrEnrollmentFinished) || again.State != domain.EnrollmentExited {
+		t.Errorf("second TriggerEvent() = %s, %v; want the exited enrollment and ErrEnrollmentFinished", again.State, err)
+	}
+	if n := testutil.ToFloat64(ts.finished.WithLabelValues("welcome", string(domain.EnrollmentExited))); n != 1 {
+		t.Errorf("exited counter = %v, want 1", n)
+	}
+}
+
+func TestSequenceSkipsRedeliveredStep(t *testing.T) {
+	ts := newTestSequenceService(t)
+	enrollment := ts.enroll(t)
+	first := ts.queue.enqueued()[0]
+	ts.ProcessEmailJob(first)
+	advanced := ts.enrollment(t, enrollment.ID)
+
+	// The queue delivers the first step again, e.g. because its ack was
+	// lost. The enrollment's JobID is the second step's now, so the job is
+	// neither sent again nor does it advance the enrollment a second time.
+	ts.ProcessEmailJob(first)
+	if n := len(ts.sent); n != 1 {
+		t.Errorf("sent %d messages, want the redelivered step skipped", n)
+	}
+	if n := len(ts.scheduler.scheduled()); n != 1 {
+		t.Errorf("scheduled %d steps, want 1", n)
+	}
+	if got := ts.enrollment(t, enrollment.ID); got.Step != 1 || got.JobID != advanced.JobID {
+		t.Errorf("enrollment = step %d, job %s; want step 1, job %s", got.Step, got.JobID, advanced.JobID)
+	}
+}
+
+func TestSequenceCatchesUpWithUnrecordedStep(t *testing.T) {
+	ts := newTestSequenceService(t)
+	enrollment := ts.enroll(t)
+	first := ts.queue.enqueued()[0]
+
+	// The worker sent the first step and queued the second one, but crashed
+	// before the enrollment recorded it.
+	ts.emailService.ProcessEmailJob(first)
+	seq, err := ts.store.GetSequence(context.Background(), "welcome")
+	if err != nil {
+		t.Fatalf("GetSequence() error = %v", err)
+	}
+	second, err := stepJob(seq, enrollment, 1, time.Now())
+	if err != nil {
+		t.Fatalf("stepJob() error = %v", err)
+	}
+
+	// The second step is sent all the same.
+	ts.ProcessEmailJob(second)
+	if n := len(ts.sent); n != 2 {
+		t.Fatalf("sent %d messages, want both steps", n)
+	}
+	if got := ts.enrollment(t, enrollment.ID); got.State != domain.EnrollmentCompleted || got.Step != 1 || got.JobID != second.ID {
+		t.Errorf("enrollment = %s at step %d with job %s, want completed at step 1 with job %s", got.State, got.Step, got.JobID, second.ID)
+	}
+
+	// The first step, delivered again as it was not acknowledged, is skipped.
+	ts.ProcessEmailJob(first)
+	if n := len(ts.sent); n != 2 {
+		t.Errorf("sent %d messages, want the redelivered step skipped", n)
+	}
+}
+
+func TestSequenceExitDuringSend(t *testing.T) {
+	ts := newTestSequenceService(t)
+	enrollment := ts.enroll(t)
+
+	// The exit event arrives while the worker is sending the first step.
+	ts.sender = func(msg domain.Message) error {
+		if _, err := ts.TriggerEvent(context.Background(), "welcome", enrollment.ID, "converted"); err != nil {
+			t.Errorf("TriggerEvent() during the send error = %v", err)
+		}
+		ts.sent = append(ts.sent, msg)
+		return nil
+	}
+	ts.ProcessEmailJob(ts.queue.enqueued()[0])
+
+	if n := len(ts.sent); n != 1 {
+		t.Errorf("sent %d messages, want the step in progress sent", n)
+	}
+	if n := len(ts.scheduler.scheduled()); n != 0 {
+		t.Errorf("scheduled %d steps after the exit, want 0", n)
+	}
+	if got := ts.enrollment(t, enrollment.ID); got.State != domain.EnrollmentExited || got.Step != 0 {
+		t.Errorf("enrollment = %s at step %d, want exited at step 0", got.State, got.Step)
+	}
+}
+
+func TestSequenceFailsOnDeadLetteredStep(t *testing.T) {
+	ts := newTestSequenceService(t)
+	ts.sendErr = domain.NewSMTPError(550, "5.1.1", "no such user")
+	enrollment := ts.enroll(t)
+
+	ts.ProcessEmailJob(ts.queue.enqueued()[0])
+	got := ts.enrollment(t, enrollment.ID)
+	if got.State != domain.EnrollmentFailed || got.Reason == "" {
+		t.Errorf("enrollment = %+v, want failed with the reason of the DLQ", got)
+	}
+	if n := len(ts.scheduler.scheduled()); n != 0 {
+		t.Errorf("scheduled %d steps after a failed step, want 0", n)
+	}
+}
+
+func TestSequenceGetEnrollmentOfAnotherSequence(t *testing.T) {
+	ts := newTestSequenceService(t)
+	enrollment := ts.enroll(t)
+
+	if _, err := ts.sequenceService.GetEnrollment(context.Background(), "other", enrollment.ID); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Errorf("GetEnrollment() under another sequence error = %v, want ErrEnrollmentNotFound", err)
+	}
+}
+
+func TestSequencePutSequenceKeepsCreationTime(t *testing.T) {
+	ts := newTestSequenceService(t)
+	ctx := context.Background()
+	original, err := ts.GetSequence(ctx, "welcome")
+	if err != nil {
+		t.Fatalf("GetSequence() error = %v", err)
+	}
+
+	updated, err := ts.sequenceService.PutSequence(ctx, domain.Sequence{Name: "welcome", Steps: original.Steps[:1]})
+	if err != nil {
+		t.Fatalf("PutSequence() error = %v", err)
+	}
+	if !updated.CreatedAt.Equal(original.CreatedAt) || updated.UpdatedAt.Before(original.UpdatedAt) {
+		t.Errorf("replaced sequence created %s, updated %s; want created %s", updated.CreatedAt, updated.UpdatedAt, original.CreatedAt)
+	}
+	if _, err := ts.sequenceService.PutSequence(ctx, domain.Sequence{Name: "Bad Name"}); err == nil {
+		t.Errorf("PutSequence() of an invalid sequence succeeded, want an error")
+	}
+}
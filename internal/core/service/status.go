@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// newJobStatus returns the status of a job that was just accepted.
+func newJobStatus(job domain.EmailJob, state domain.JobState) domain.JobStatus {
+	now := time.Now()
+	return domain.JobStatus{
+		ID:        job.ID,
+		State:     state,
+		To:        job.To,
+		Subject:   job.Subject,
+		Type:      job.Type,
+		Priority:  job.Lane(),
+		Queue:     job.QueueName(),
+		SendAt:    job.SendAt,
+		ExpiresAt: job.ExpiresAt,
+		Attempts:  []domain.Attempt{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// storeStatus stores the status of a job that is being accepted. It is
+// stored before the job is queued, so that a worker picking the job up right
+// away finds it.
+func (s *emailService) storeStatus(ctx context.Context, job domain.EmailJob, state domain.JobState) {
+	if _, err := s.jobStates.Update(ctx, job.ID, func(status *domain.JobStatus) error {
+		*status = newJobStatus(job, state)
+		return nil
+	}); err != nil {
+		s.logger.Errorf("Failed to store status of job %s: %v", job.ID, err)
+	}
+}
+
+// track applies update to the stored status of a job. Failures are only
+// logged: the status API must never hold up delivery. Jobs queued without
+// going through the service (e.g. with EnqueueTx) get a status on their
+// first update.
+func (s *emailService) track(job domain.EmailJob, update func(*domain.JobStatus)) {
+	trackStatus(s.jobStates, s.logger, job, update)
+}
+
+// trackStatus applies update to the status of a job in jobStates, as
+// emailService.track does.
+func trackStatus(jobStates ports.JobStateStore, l *logger.Logger, job domain.EmailJob, update func(*domain.JobStatus)) {
+	if job.ID == "" {
+		return // Queued before jobs had IDs
+	}
+	_, err := jobStates.Update(context.Background(), job.ID, func(status *domain.JobStatus) error {
+		if status.CreatedAt.IsZero() {
+			*status = newJobStatus(job, domain.JobQueued)
+		}
+		update(status)
+		status.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		l.Errorf("Failed to update status of job %s: %v", job.ID, err)
+	}
+}
+
+var (
+	// errJobCancelled stops claim from updating the status of a cancelled job.
+	errJobCancelled = errors.New("job was cancelled")
+	// errJobExpired stops claim from updating the status of an expired job.
+	errJobExpired = errors.New("job expired")
+)
+
+// claim marks a job as being processed by attempt, unless it was cancelled
+// or has expired, and returns errJobCancelled or errJobExpired if it may not
+// be sent. Workers and CancelEmail race here: the store applies their updates
+// one after the other, so exactly one of them wins. If the store fails, the
+// job is sent rather than held up.
+func (s *emailService) claim(job domain.EmailJob, attempt domain.Attempt) error {
+	if job.Expired(attempt.StartedAt) {
+		return s.claimExpired(job)
+	}
+	if job.ID == "" {
+		return nil // Queued before jobs had IDs
+	}
+	_, err := s.jobStates.Update(context.Background(), job.ID, func(status *domain.JobStatus) error {
+		if status.State == domain.JobCancelled {
+			return errJobCancelled
+		}
+		if status.CreatedAt.IsZero() {
+			*status = newJobStatus(job, domain.JobQueued)
+		}
+		status.State = domain.JobProcessing
+		status.Provider = attempt.Provider
+		status.NextAttemptAt = nil
+		status.Attempts = append(status.Attempts, attempt)
+		status.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, errJobCancelled) {
+		return err
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to update status of job %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// claimExpired returns errJobExpired for an expired job, or errJobCancelled
+// if it was cancelled before it expired, so the worker does not dead-letter
+// a cancelled job.
+func (s *emailService) claimExpired(job domain.EmailJob) error {
+	if job.ID == "" {
+		return errJobExpired
+	}
+	status, err := s.jobStates.Get(context.Background(), job.ID)
+	if err == nil && status.State == domain.JobCancelled {
+		return errJobCancelled
+	}
+	return errJobExpired
+}
+
+// forgetStatus removes the status of a job that could not be accepted.
+func (s *emailService) forgetStatus(job domain.EmailJob) {
+	if err := s.jobStates.Delete(context.Background(), job.ID); err != nil {
+		s.logger.Errorf("Failed to remove status of job %s: %v", job.ID, err)
+	}
+}
+
+// finishAttempt records the end of attempt number n of a job.
+func finishAttempt(status *domain.JobStatus, n int, deliveryErr *domain.DeliveryError) {
+	now := time.Now()
+	for i := len(status.Attempts) - 1; i >= 0; i-- {
+		if status.Attempts[i].Number == n {
+			status.Attempts[i].FinishedAt = &now
+			status.Attempts[i].Error = deliveryErr
+			return
+		}
+	}
+}
+
+// statusQueue returns the queue of a job status. Statuses stored before
+// named queues existed belong to the default queue.
+func statusQueue(status domain.JobStatus) string {
+	if status.Queue == "" {
+		return domain.DefaultQueue
+	}
+	return status.Queue
+}
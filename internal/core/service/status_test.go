@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func TestStatusFollowsSuccessfulDelivery(t *testing.T) {
+	ts := newTestService(t, 3)
+	ctx := context.Background()
+
+	job := testJob("")
+	job.Type = "receipt"
+	job.Priority = domain.PriorityHigh
+	if err := ts.EnqueueEmail(ctx, job); err != nil {
+		t.Fatalf("EnqueueEmail() error = %v", err)
+	}
+	queued := ts.queue.enqueued()
+	if len(queued) != 1 || queued[0].ID == "" {
+		t.Fatalf("enqueued %v, want one job with an ID", queued)
+	}
+	id := queued[0].ID
+
+	status := ts.status(t, id)
+	if status.State != domain.JobQueued || status.Type != "receipt" || status.Priority != domain.PriorityHigh || status.Queue != domain.DefaultQueue {
+		t.Errorf("status after enqueue = %+v, want queued with the job's type, priority and queue", status)
+	}
+
+	ts.ProcessEmailJob(queued[0])
+	status = ts.status(t, id)
+	if status.State != domain.JobSent || status.Provider != simulatedProvider {
+		t.Errorf("status after sending = %s via %q, want sent via %s", status.State, status.Provider, simulatedProvider)
+	}
+	if len(status.Attempts) != 1 || status.Attempts[0].FinishedAt == nil || status.Attempts[0].Error != nil {
+		t.Errorf("attempts = %+v, want one finished attempt without error", status.Attempts)
+	}
+	if len(ts.sent) != 1 || ts.sent[0].To != job.To {
+		t.Errorf("sent %v, want the job's message", ts.sent)
+	}
+}
+
+func TestStatusRecordsEveryAttempt(t *testing.T) {
+	ts := newTestService(t, 3)
+	job := testJob("flaky")
+
+	ts.sendErr = domain.NewSMTPError(451, "4.3.0", "temporary server error")
+	ts.ProcessEmailJob(job)
+	retry := ts.scheduler.scheduled()[0].job
+
+	ts.sendErr = nil
+	ts.ProcessEmailJob(retry)
+
+	status := ts.status(t, "flaky")
+	if status.State != domain.JobSent || len(status.Attempts) != 2 {
+		t.Fatalf("status = %s with %d attempts, want sent after 2", status.State, len(status.Attempts))
+	}
+	if first := status.Attempts[0]; first.Number != 1 || first.Error == nil || first.Error.EnhancedCode != "4.3.0" {
+		t.Errorf("first attempt = %+v, want attempt 1 with its failure", first)
+	}
+	if second := status.Attempts[1]; second.Number != 2 || second.Error != nil {
+		t.Errorf("second attempt = %+v, want attempt 2 without error", second)
+	}
+	if status.NextAttemptAt != nil {
+		t.Errorf("next attempt = %v after the job was sent, want none", status.NextAttemptAt)
+	}
+}
+
+func TestStatusIsForgottenWhenEnqueueFails(t *testing.T) {
+	ts := newTestService(t, 3)
+	ts.queue.err = &ports.QueueFullError{}
+
+	err := ts.EnqueueEmail(context.Background(), testJob("rejected"))
+	if !errors.Is(err, ports.ErrQueueFull) {
+		t.Fatalf("EnqueueEmail() error = %v, want ErrQueueFull", err)
+	}
+	if _, err := ts.GetEmailStatus(context.Background(), "rejected"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("GetEmailStatus() of a rejected job error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestStatusOfJobsQueuedWithoutTheService(t *testing.T) {
+	ts := newTestService(t, 3)
+
+	// Jobs queued with EnqueueTx get their status on the first attempt.
+	ts.ProcessEmailJob(testJob("tx"))
+	if status := ts.status(t, "tx"); status.State != domain.JobSent || status.CreatedAt.IsZero() {
+		t.Errorf("status = %+v, want a sent job with a creation time", status)
+	}
+
+	// Jobs queued before jobs had IDs get no status at all.
+	ts.ProcessEmailJob(testJob(""))
+	if len(ts.sent) != 2 {
+		t.Errorf("sent %d messages, want 2", len(ts.sent))
+	}
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+type buffer struct {
+	jobs      []domain.EmailJob
+	owner     string
+	startedAt time.Time
+	claimedAt time.Time
+}
+
+// Store implements the ports.DigestStore interface in memory. Buffers are
+// only known to this instance and lost on restart.
+type Store struct {
+	mu      sync.Mutex
+	buffers map[string]*buffer
+	taken   map[string][]domain.EmailJob // Keyed by digest job
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{buffers: make(map[string]*buffer), taken: make(map[string][]domain.EmailJob)}
+}
+
+// Add appends a job to a buffer.
+func (s *Store) Add(ctx context.Context, key string, job domain.EmailJob, digestID string, now, staleBefore time.Time) (bool, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buffers[key]
+	switch {
+	case !ok:
+		b = &buffer{owner: digestID, startedAt: now, claimedAt: now}
+		s.buffers[key] = b
+	case b.claimedAt.Before(staleBefore):
+		b.owner, b.claimedAt = digestID, now
+	}
+	b.jobs = append(b.jobs, job)
+	return b.owner == digestID, b.startedAt, nil
+}
+
+// Take sets a buffer aside for its digest job and returns its jobs.
+func (s *Store) Take(ctx context.Context, key, digestID string) ([]domain.EmailJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jobs, ok := s.taken[digestID]; ok {
+		return jobs, nil
+	}
+	b, ok := s.buffers[key]
+	if !ok || b.owner != digestID {
+		return nil, nil
+	}
+	delete(s.buffers, key)
+	s.taken[digestID] = b.jobs
+	return b.jobs, nil
+}
+
+// Delete removes the jobs a digest job took.
+func (s *Store) Delete(ctx context.Context, key, digestID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.taken, digestID)
+	return nil
+}
+
+// Ensure Store implements the ports.DigestStore interface
+var _ ports.DigestStore = (*Store)(nil)
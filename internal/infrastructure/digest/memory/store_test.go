@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestStoreTakesBufferOfOwner(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+	now := time.Now()
+	stale := now.Add(-time.Hour)
+
+	owned, startedAt, err := s.Add(ctx, "activity:a@example.com", domain.EmailJob{ID: "job-1"}, "digest-1", now, stale)
+	if err != nil || !owned || !startedAt.Equal(now) {
+		t.Fatalf("Add() to an empty buffer = %v, %s, %v; want owned, started now", owned, startedAt, err)
+	}
+	owned, startedAt, err = s.Add(ctx, "activity:a@example.com", domain.EmailJob{ID: "job-2"}, "digest-2", now.Add(time.Minute), stale)
+	if err != nil || owned || !startedAt.Equal(now) {
+		t.Fatalf("Add() to a buffer = %v, %s, %v; want not owned, started at the first job", owned, startedAt, err)
+	}
+
+	if jobs, err := s.Take(ctx, "activity:a@example.com", "digest-2"); err != nil || len(jobs) != 0 {
+		t.Fatalf("Take() by another digest job = %v, %v; want nothing", jobs, err)
+	}
+	jobs, err := s.Take(ctx, "activity:a@example.com", "digest-1")
+	if err != nil || len(jobs) != 2 || jobs[0].ID != "job-1" || jobs[1].ID != "job-2" {
+		t.Fatalf("Take() by the owner = %v, %v; want both jobs in order", jobs, err)
+	}
+	if jobs, _ := s.Take(ctx, "activity:a@example.com", "digest-1"); len(jobs) != 2 {
+		t.Errorf("Take() of a taken buffer = %v, want the same jobs again", jobs)
+	}
+
+	// The next job starts a new buffer, apart from the taken one.
+	owned, _, err = s.Add(ctx, "activity:a@example.com", domain.EmailJob{ID: "job-3"}, "digest-3", now.Add(2*time.Minute), stale)
+	if err != nil || !owned {
+		t.Fatalf("Add() after Take() = %v, %v; want owned", owned, err)
+	}
+	if err := s.Delete(ctx, "activity:a@example.com", "digest-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if jobs, _ := s.Take(ctx, "activity:a@example.com", "digest-1"); len(jobs) != 0 {
+		t.Errorf("Take() of a deleted buffer = %v, want nothing", jobs)
+	}
+	if jobs, _ := s.Take(ctx, "activity:a@example.com", "digest-3"); len(jobs) != 1 || jobs[0].ID != "job-3" {
+		t.Errorf("Take() of the new buffer = %v, want job-3", jobs)
+	}
+}
+
+func TestStoreClaimsStaleBuffer(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+	started := time.Now().Add(-3 * time.Hour)
+	if _, _, err := s.Add(ctx, "activity:a@example.com", domain.EmailJob{ID: "job-1"}, "lost", started, started); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	now := time.Now()
+	owned, startedAt, err := s.Add(ctx, "activity:a@example.com", domain.EmailJob{ID: "job-2"}, "digest-2", now, now.Add(-2*time.Hour))
+	if err != nil || !owned || !startedAt.Equal(started) {
+		t.Fatalf("Add() to a stale buffer = %v, %s, %v; want owned, started at the first job", owned, startedAt, err)
+	}
+	// The claim is fresh, so the next job joins the buffer.
+	if owned, _, _ := s.Add(ctx, "activity:a@example.com", domain.EmailJob{ID: "job-3"}, "digest-3", now, now.Add(-2*time.Hour)); owned {
+		t.Errorf("Add() to a claimed buffer is owned, want it to join the buffer")
+	}
+
+	if jobs, _ := s.Take(ctx, "activity:a@example.com", "lost"); len(jobs) != 0 {
+		t.Errorf("Take() by the former owner = %v, want nothing", jobs)
+	}
+	if jobs, _ := s.Take(ctx, "activity:a@example.com", "digest-2"); len(jobs) != 3 {
+		t.Errorf("Take() by the new owner = %v, want all 3 jobs", jobs)
+	}
+}
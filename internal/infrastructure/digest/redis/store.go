@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+const (
+	keyPrefix    = "email_digest:"
+	redisTimeout = 5 * time.Second
+)
+
+// addScript appends the job ARGV[1] to the buffer in KEYS[1], whose first
+// entry is "owner|started|claimed", with the times in Unix milliseconds. The
+// digest job ARGV[2] starts the buffer at ARGV[3] if it is empty, and claims
+// it if the owner claimed it before ARGV[4]. It returns the owner and the
+// start of the buffer.
+var addScript = redis.NewScript(`
+local head = redis.call('LINDEX', KEYS[1], 0)
+local owner, started, claimed
+if head then
+	owner, started, claimed = string.match(head, '^(.*)|(%d+)|(%d+)$')
+	if tonumber(claimed) < tonumber(ARGV[4]) then
+		owner = ARGV[2]
+		redis.call('LSET', KEYS[1], 0, owner .. '|' .. started .. '|' .. ARGV[3])
+	end
+else
+	owner, started = ARGV[2], ARGV[3]
+	redis.call('RPUSH', KEYS[1], owner .. '|' .. started .. '|' .. started)
+end
+redis.call('RPUSH', KEYS[1], ARGV[1])
+return {owner, started}
+`)
+
+// takeScript renames the buffer in KEYS[1] to KEYS[2], the buffer taken by
+// the digest job ARGV[1], if that job owns it. It returns "1" followed by
+// the jobs if the buffer was taken now, "0" followed by the jobs if it was
+// taken before, and nothing otherwise.
+var takeScript = redis.NewScript(`
+local taken = redis.call('LRANGE', KEYS[2], 1, -1)
+if #taken > 0 then
+	table.insert(taken, 1, '0')
+	return taken
+end
+local head = redis.call('LINDEX', KEYS[1], 0)
+if not head or string.match(head, '^(.*)|%d+|%d+$') ~= ARGV[1] then
+	return {}
+end
+redis.call('RENAME', KEYS[1], KEYS[2])
+local jobs = redis.call('LRANGE', KEYS[2], 1, -1)
+table.insert(jobs, 1, '1')
+return jobs
+`)
+
+// Store implements the ports.DigestStore interface in Redis, as one list per
+// buffer, so buffers survive restarts and are shared by all instances. The
+// first entry of a list records the owner of the buffer, the others are the
+// buffered jobs. A taken buffer is renamed to a key of its digest job. Both
+// keys share the buffer as their hash tag, which keeps every operation
+// atomic on a Redis Cluster. Entries that cannot be decoded are moved to the
+// DLQ.
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+	dlq    ports.DeadLetterQueue
+	logger *logger.Logger
+}
+
+// NewStore creates a Store whose keys start with prefix. Buffered entries
+// that cannot be decoded when the buffer is taken are stored in dlq, with
+// the entry as the body of the job.
+func NewStore(client redis.UniversalClient, prefix string, dlq ports.DeadLetterQueue, l *logger.Logger) *Store {
+	return &Store{client: client, prefix: prefix + keyPrefix, dlq: dlq, logger: l}
+}
+
+// Add appends a job to a buffer and claims the buffer in one script.
+func (s *Store) Add(ctx context.Context, key string, job domain.EmailJob, digestID string, now, staleBefore time.Time) (bool, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	value, err := json.Marshal(job)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("failed to marshal job: %w", err)
+	}
+	res, err := addScript.Run(ctx, s.client, []string{s.bufferKey(key)}, value, digestID, now.UnixMilli(), staleBefore.UnixMilli()).StringSlice()
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("failed to add job to digest buffer in Redis: %w", err)
+	}
+	if len(res) != 2 {
+		return false, time.Time{}, fmt.Errorf("unexpected reply adding job to digest buffer in Redis: %q", res)
+	}
+	started, err := strconv.ParseInt(res[1], 10, 64)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid start of digest buffer in Redis: %w", err)
+	}
+	return res[0] == digestID, time.UnixMilli(started), nil
+}
+
+// bufferKey returns the key of a buffer.
+func (s *Store) bufferKey(key string) string {
+	return s.prefix + "{" + key + "}"
+}
+
+// takenKey returns the key of a buffer taken by the digest job digestID.
+func (s *Store) takenKey(key, digestID string) string {
+	return s.bufferKey(key) + ":" + digestID
+}
+
+// Take renames a buffer to a key of its digest job and reads it in one
+// script, or reads the buffer the digest job took before.
+func (s *Store) Take(ctx context.Context, key, digestID string) ([]domain.EmailJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	values, err := takeScript.Run(ctx, s.client, []string{s.bufferKey(key), s.takenKey(key, digestID)}, digestID).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take digest buffer from Redis: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	takenNow := values[0] == "1"
+	jobs := make([]domain.EmailJob, 0, len(values)-1)
+	for _, value := range values[1:] {
+		var job domain.EmailJob
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			// The other jobs are not held back by it. It is dead-lettered
+			// when the buffer is first taken only.
+			if takenNow {
+				s.logger.Errorf("Failed to unmarshal job from digest buffer %s: %v. Moving to DLQ.", key, err)
+				s.dlq.Store(domain.EmailJob{Body: value}, fmt.Sprintf("Failed to unmarshal job from digest buffer %s: %v", key, err), nil)
+			}
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Delete deletes the buffer a digest job took.
+func (s *Store) Delete(ctx context.Context, key, digestID string) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.takenKey(key, digestID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete digest buffer from Redis: %w", err)
+	}
+	return nil
+}
+
+// Ensure Store implements the ports.DigestStore interface
+var _ ports.DigestStore = (*Store)(nil)
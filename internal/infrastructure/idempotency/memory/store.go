@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/ports"
+)
+
+// sweepInterval is how often expired keys are dropped from the map.
+const sweepInterval = time.Minute
+
+type entry struct {
+	record    ports.IdempotencyRecord
+	expiresAt time.Time
+}
+
+// Store implements the ports.IdempotencyStore interface in memory. Keys are
+// only known to the instance that stored them, so it is meant for a single
+// instance; use the Redis store when several instances share the traffic.
+type Store struct {
+	lease time.Duration
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+// NewStore creates a Store whose reservations expire after lease and whose
+// completed keys expire after ttl.
+func NewStore(lease, ttl time.Duration) *Store {
+	return &Store{
+		lease:     lease,
+		ttl:       ttl,
+		entries:   make(map[string]entry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve claims key unless another request holds it and it has not expired.
+func (s *Store) Reserve(ctx context.Context, key string, record ports.IdempotencyRecord) (ports.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now)
+	if e, ok := s.entries[key]; ok && now.Before(e.expiresAt) {
+		return e.record, false, nil
+	}
+	s.entries[key] = entry{record: record, expiresAt: now.Add(s.lease)}
+	return record, true, nil
+}
+
+// Complete marks the request that reserved key as handled and keeps it for
+// the TTL, if its reservation has not expired.
+func (s *Store) Complete(ctx context.Context, key string, record ports.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := s.entries[key]; ok && now.Before(e.expiresAt) && e.record.JobID == record.JobID {
+		record.Completed = true
+		s.entries[key] = entry{record: record, expiresAt: now.Add(s.ttl)}
+	}
+	return nil
+}
+
+// Release forgets key.
+func (s *Store) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweepLocked drops expired keys, at most once per sweepInterval.
+func (s *Store) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Ensure Store implements the ports.IdempotencyStore interface
+var _ ports.IdempotencyStore = (*Store)(nil)
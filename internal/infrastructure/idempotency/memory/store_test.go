@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/ports"
+)
+
+func TestStoreReserveCompleteRelease(t *testing.T) {
+	s := NewStore(time.Hour, time.Hour)
+	ctx := context.Background()
+	first := ports.IdempotencyRecord{JobID: "job-1", Fingerprint: "a"}
+
+	if got, ok, err := s.Reserve(ctx, "key", first); err != nil || !ok || got != first {
+		t.Fatalf("Reserve() = %+v, %v, %v; want the key reserved", got, ok, err)
+	}
+	got, ok, err := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2", Fingerprint: "b"})
+	if err != nil || ok || got != first {
+		t.Fatalf("second Reserve() = %+v, %v, %v; want the first record", got, ok, err)
+	}
+
+	if err := s.Complete(ctx, "key", first); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	got, _, _ = s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2"})
+	if !got.Completed || got.JobID != "job-1" {
+		t.Errorf("record after Complete = %+v, want job-1 completed", got)
+	}
+
+	if err := s.Release(ctx, "key"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, ok, _ := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-3"}); !ok {
+		t.Errorf("Reserve() after Release did not reserve the key")
+	}
+
+	// Completing a key nobody holds does not create it.
+	if err := s.Complete(ctx, "unknown", first); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if _, ok, _ := s.Reserve(ctx, "unknown", first); !ok {
+		t.Errorf("Complete() of an unknown key reserved it")
+	}
+}
+
+func TestStoreKeysExpire(t *testing.T) {
+	s := NewStore(20*time.Millisecond, 20*time.Millisecond)
+	ctx := context.Background()
+
+	s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"})
+	s.Complete(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"})
+	time.Sleep(30 * time.Millisecond)
+
+	got, ok, err := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2"})
+	if err != nil || !ok || got.JobID != "job-2" {
+		t.Errorf("Reserve() after expiry = %+v, %v, %v; want the key reserved again", got, ok, err)
+	}
+
+	// Expired keys are swept from the map.
+	s.Reserve(ctx, "other", ports.IdempotencyRecord{})
+	time.Sleep(30 * time.Millisecond)
+	s.mu.Lock()
+	s.lastSweep = time.Now().Add(-sweepInterval)
+	s.mu.Unlock()
+	s.Reserve(ctx, "new", ports.IdempotencyRecord{})
+	if _, ok := s.entries["other"]; ok {
+		t.Errorf("expired key was not swept")
+	}
+}
+
+func TestStoreReservationsExpireAfterLease(t *testing.T) {
+	s := NewStore(20*time.Millisecond, time.Hour)
+	ctx := context.Background()
+
+	s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"})
+	time.Sleep(30 * time.Millisecond)
+
+	// The request holding the key never completed, so a retry takes it over.
+	got, ok, err := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2"})
+	if err != nil || !ok || got.JobID != "job-2" {
+		t.Fatalf("Reserve() after the lease = %+v, %v, %v; want the key reserved again", got, ok, err)
+	}
+	// The late first request does not complete the key of the retry.
+	s.Complete(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"})
+	if got, _, _ := s.Reserve(ctx, "key", ports.IdempotencyRecord{}); got.JobID != "job-2" || got.Completed {
+		t.Fatalf("record after a late Complete = %+v, want job-2 still reserved", got)
+	}
+
+	// A completed key outlives the lease.
+	s.Complete(ctx, "key", ports.IdempotencyRecord{JobID: "job-2"})
+	time.Sleep(30 * time.Millisecond)
+	if got, ok, _ := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-3"}); ok || !got.Completed {
+		t.Errorf("Reserve() of a completed key after the lease = %+v, %v; want job-2 completed", got, ok)
+	}
+}
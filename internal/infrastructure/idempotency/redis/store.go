@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/ports"
+)
+
+const (
+	keyPrefix    = "email_idempotency:"
+	redisTimeout = 5 * time.Second
+)
+
+// completeScript replaces the record in KEYS[1] by ARGV[1], expiring after
+// ARGV[3] milliseconds, if it is still reserved for the job ARGV[2].
+var completeScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current or cjson.decode(current).job_id ~= ARGV[2] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+return 1
+`)
+
+// Store implements the ports.IdempotencyStore interface in Redis. Keys are
+// claimed with SET NX, so instances sharing the Redis server agree on which
+// request came first.
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+	lease  time.Duration
+	ttl    time.Duration
+}
+
+// NewStore creates a Store whose keys start with prefix. Reservations expire
+// after lease, completed keys after ttl.
+func NewStore(client redis.UniversalClient, prefix string, lease, ttl time.Duration) *Store {
+	return &Store{client: client, prefix: prefix + keyPrefix, lease: lease, ttl: ttl}
+}
+
+// Reserve claims key unless another request holds it.
+func (s *Store) Reserve(ctx context.Context, key string, record ports.IdempotencyRecord) (ports.IdempotencyRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return ports.IdempotencyRecord{}, false, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	// The existing key may expire between SET NX and GET; claim it again then.
+	for {
+		ok, err := s.client.SetNX(ctx, s.prefix+key, value, s.lease).Result()
+		if err != nil {
+			return ports.IdempotencyRecord{}, false, fmt.Errorf("failed to reserve idempotency key in Redis: %w", err)
+		}
+		if ok {
+			return record, true, nil
+		}
+
+		existing, err := s.client.Get(ctx, s.prefix+key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return ports.IdempotencyRecord{}, false, fmt.Errorf("failed to read idempotency key from Redis: %w", err)
+		}
+		var stored ports.IdempotencyRecord
+		if err := json.Unmarshal(existing, &stored); err != nil {
+			return ports.IdempotencyRecord{}, false, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+		}
+		return stored, false, nil
+	}
+}
+
+// Complete marks the request that reserved key as handled and keeps it for
+// the TTL, if its reservation has not expired.
+func (s *Store) Complete(ctx context.Context, key string, record ports.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	record.Completed = true
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+	if err := completeScript.Run(ctx, s.client, []string{s.prefix + key}, value, record.JobID, s.ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to complete idempotency key in Redis: %w", err)
+	}
+	return nil
+}
+
+// Release forgets key.
+func (s *Store) Release(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key in Redis: %w", err)
+	}
+	return nil
+}
+
+// Ensure Store implements the ports.IdempotencyStore interface
+var _ ports.IdempotencyStore = (*Store)(nil)
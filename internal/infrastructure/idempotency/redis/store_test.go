@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/ports"
+)
+
+func newTestStore(t *testing.T, lease, ttl time.Duration) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, "test:", lease, ttl), server
+}
+
+func TestStoreReserveCompleteRelease(t *testing.T) {
+	s, server := newTestStore(t, time.Minute, time.Hour)
+	ctx := context.Background()
+	first := ports.IdempotencyRecord{JobID: "job-1", Fingerprint: "a"}
+
+	if got, ok, err := s.Reserve(ctx, "key", first); err != nil || !ok || got != first {
+		t.Fatalf("Reserve() = %+v, %v, %v; want the key reserved", got, ok, err)
+	}
+	if !server.Exists("test:" + keyPrefix + "key") {
+		t.Fatalf("key was not stored under the prefix")
+	}
+	got, ok, err := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2", Fingerprint: "b"})
+	if err != nil || ok || got != first {
+		t.Fatalf("second Reserve() = %+v, %v, %v; want the first record", got, ok, err)
+	}
+
+	// The key is reserved for the lease, and kept for the TTL once completed.
+	if ttl := server.TTL("test:" + keyPrefix + "key"); ttl != time.Minute {
+		t.Errorf("TTL after Reserve = %s, want the lease of 1m", ttl)
+	}
+	server.FastForward(30 * time.Second)
+	if err := s.Complete(ctx, "key", first); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if ttl := server.TTL("test:" + keyPrefix + "key"); ttl != time.Hour {
+		t.Errorf("TTL after Complete = %s, want the TTL of 1h", ttl)
+	}
+	got, _, _ = s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2"})
+	if !got.Completed || got.JobID != "job-1" {
+		t.Errorf("record after Complete = %+v, want job-1 completed", got)
+	}
+
+	if err := s.Release(ctx, "key"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, ok, _ := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-3"}); !ok {
+		t.Errorf("Reserve() after Release did not reserve the key")
+	}
+}
+
+func TestStoreKeysExpire(t *testing.T) {
+	s, server := newTestStore(t, time.Minute, time.Hour)
+	ctx := context.Background()
+
+	s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"})
+	server.FastForward(time.Minute)
+
+	// A key that expired is neither completed nor brought back by Complete.
+	if err := s.Complete(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"}); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	got, ok, err := s.Reserve(ctx, "key", ports.IdempotencyRecord{JobID: "job-2"})
+	if err != nil || !ok || got.JobID != "job-2" {
+		t.Fatalf("Reserve() after expiry = %+v, %v, %v; want the key reserved again", got, ok, err)
+	}
+
+	// The late first request does not complete the key of the retry.
+	if err := s.Complete(ctx, "key", ports.IdempotencyRecord{JobID: "job-1"}); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if got, _, _ := s.Reserve(ctx, "key", ports.IdempotencyRecord{}); got.JobID != "job-2" || got.Completed {
+		t.Errorf("record after a late Complete = %+v, want job-2 still reserved", got)
+	}
+}
+
+func TestStoreReportsUnavailableServer(t *testing.T) {
+	s, server := newTestStore(t, time.Minute, time.Hour)
+	server.Close()
+
+	if _, _, err := s.Reserve(context.Background(), "key", ports.IdempotencyRecord{}); err == nil {
+		t.Errorf("Reserve() without a server succeeded, want an error")
+	}
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+// sweepInterval is how often expired statuses are dropped from the map.
+const sweepInterval = time.Minute
+
+type entry struct {
+	status    domain.JobStatus
+	expiresAt time.Time
+}
+
+// Store implements the ports.JobStateStore interface in memory. Statuses are
+// only known to this instance and lost on restart.
+type Store struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+// NewStore creates a Store whose statuses expire ttl after their last update.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:       ttl,
+		entries:   make(map[string]entry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Update applies update to the status of job id under the store's lock.
+func (s *Store) Update(ctx context.Context, id string, update func(*domain.JobStatus) error) (domain.JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now)
+	status := domain.JobStatus{ID: id}
+	if e, ok := s.entries[id]; ok && now.Before(e.expiresAt) {
+		status = clone(e.status)
+	}
+	if err := update(&status); err != nil {
+		return domain.JobStatus{}, err
+	}
+	s.entries[id] = entry{status: status, expiresAt: now.Add(s.ttl)}
+	return clone(status), nil
+}
+
+// Get returns the status of job id.
+func (s *Store) Get(ctx context.Context, id string) (domain.JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok || !time.Now().Before(e.expiresAt) {
+		return domain.JobStatus{}, ports.ErrJobNotFound
+	}
+	return clone(e.status), nil
+}
+
+// Delete removes the status of job id.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, id)
+	return nil
+}
+
+// sweepLocked drops expired statuses, at most once per sweepInterval.
+func (s *Store) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for id, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// clone copies the attempts of a status, so that callers cannot change the
+// stored one.
+func clone(status domain.JobStatus) domain.JobStatus {
+	status.Attempts = append([]domain.Attempt(nil), status.Attempts...)
+	return status
+}
+
+// Ensure Store implements the ports.JobStateStore interface
+var _ ports.JobStateStore = (*Store)(nil)
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func TestStoreUpdateAndGet(t *testing.T) {
+	s := NewStore(time.Hour)
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "job"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Fatalf("Get() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+
+	status, err := s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		if status.ID != "job" || status.State != "" {
+			t.Errorf("Update() of a new job got %+v, want an empty status with its ID", status)
+		}
+		status.State = domain.JobQueued
+		return nil
+	})
+	if err != nil || status.State != domain.JobQueued {
+		t.Fatalf("Update() = %+v, %v; want the queued status", status, err)
+	}
+
+	s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		status.State = domain.JobProcessing
+		status.Attempts = append(status.Attempts, domain.Attempt{Number: 1})
+		return nil
+	})
+	got, err := s.Get(ctx, "job")
+	if err != nil || got.State != domain.JobProcessing || len(got.Attempts) != 1 {
+		t.Fatalf("Get() = %+v, %v; want processing with one attempt", got, err)
+	}
+
+	// Changing a returned status does not change the stored one.
+	got.Attempts[0].Number = 99
+	if again, _ := s.Get(ctx, "job"); again.Attempts[0].Number != 1 {
+		t.Errorf("stored attempt changed through a returned status")
+	}
+}
+
+func TestStoreUpdateErrorKeepsStatus(t *testing.T) {
+	s := NewStore(time.Hour)
+	ctx := context.Background()
+	s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		status.State = domain.JobQueued
+		return nil
+	})
+
+	refused := errors.New("refused")
+	_, err := s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		status.State = domain.JobSent
+		return refused
+	})
+	if !errors.Is(err, refused) {
+		t.Fatalf("Update() error = %v, want the error of the update", err)
+	}
+	if got, _ := s.Get(ctx, "job"); got.State != domain.JobQueued {
+		t.Errorf("state after a failed update = %s, want queued", got.State)
+	}
+
+	// A failed update of an unknown job does not create it.
+	s.Update(ctx, "new", func(*domain.JobStatus) error { return refused })
+	if _, err := s.Get(ctx, "new"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("Get() after a failed first update error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestStoreStatusesExpireAndDelete(t *testing.T) {
+	s := NewStore(20 * time.Millisecond)
+	ctx := context.Background()
+	for _, id := range []string{"expiring", "deleted"} {
+		s.Update(ctx, id, func(status *domain.JobStatus) error {
+			status.State = domain.JobQueued
+			return nil
+		})
+	}
+
+	if err := s.Delete(ctx, "deleted"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "deleted"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("Get() after Delete error = %v, want ErrJobNotFound", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := s.Get(ctx, "expiring"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("Get() after the TTL error = %v, want ErrJobNotFound", err)
+	}
+	status, _ := s.Update(ctx, "expiring", func(*domain.JobStatus) error { return nil })
+	if status.State != "" {
+		t.Errorf("Update() after the TTL started from %s, want a new status", status.State)
+	}
+}
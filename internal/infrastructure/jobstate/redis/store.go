@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+const (
+	keyPrefix    = "email_job_state:"
+	redisTimeout = 5 * time.Second
+
+	// maxUpdateAttempts bounds the optimistic retries of a contended update.
+	maxUpdateAttempts = 10
+)
+
+// Store implements the ports.JobStateStore interface in Redis, as one JSON
+// document per job. Updates are optimistic transactions (WATCH/MULTI), so
+// instances updating the same job do not overwrite each other.
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewStore creates a Store whose keys start with prefix and whose statuses
+// expire ttl after their last update.
+func NewStore(client redis.UniversalClient, prefix string, ttl time.Duration) *Store {
+	return &Store{client: client, prefix: prefix + keyPrefix, ttl: ttl}
+}
+
+// Update applies update to the status of job id, retrying when another
+// client changed it in the meantime.
+func (s *Store) Update(ctx context.Context, id string, update func(*domain.JobStatus) error) (domain.JobStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	key := s.prefix + id
+	var status domain.JobStatus
+	txf := func(tx *redis.Tx) error {
+		var err error
+		status, err = s.get(ctx, tx, id)
+		if errors.Is(err, ports.ErrJobNotFound) {
+			status = domain.JobStatus{ID: id}
+		} else if err != nil {
+			return err
+		}
+		if err := update(&status); err != nil {
+			return err
+		}
+		value, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("failed to marshal job status: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, s.ttl)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateAttempts; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return domain.JobStatus{}, err
+		}
+		return status, nil
+	}
+	return domain.JobStatus{}, fmt.Errorf("failed to update job status in Redis: too much contention on job %s", id)
+}
+
+// Get returns the status of job id.
+func (s *Store) Get(ctx context.Context, id string) (domain.JobStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	return s.get(ctx, s.client, id)
+}
+
+func (s *Store) get(ctx context.Context, c redis.Cmdable, id string) (domain.JobStatus, error) {
+	value, err := c.Get(ctx, s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return domain.JobStatus{}, ports.ErrJobNotFound
+	}
+	if err != nil {
+		return domain.JobStatus{}, fmt.Errorf("failed to read job status from Redis: %w", err)
+	}
+	var status domain.JobStatus
+	if err := json.Unmarshal(value, &status); err != nil {
+		return domain.JobStatus{}, fmt.Errorf("failed to unmarshal job status: %w", err)
+	}
+	return status, nil
+}
+
+// Delete removes the status of job id.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.prefix+id).Err(); err != nil {
+		return fmt.Errorf("failed to delete job status from Redis: %w", err)
+	}
+	return nil
+}
+
+// Ensure Store implements the ports.JobStateStore interface
+var _ ports.JobStateStore = (*Store)(nil)
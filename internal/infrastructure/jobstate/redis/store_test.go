@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, "test:", time.Hour), server
+}
+
+func TestStoreUpdateAndGet(t *testing.T) {
+	s, server := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "job"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Fatalf("Get() of an unknown job error = %v, want ErrJobNotFound", err)
+	}
+	status, err := s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		status.State = domain.JobQueued
+		status.Attempts = []domain.Attempt{{Number: 1, Provider: "simulated"}}
+		return nil
+	})
+	if err != nil || status.ID != "job" || status.State != domain.JobQueued {
+		t.Fatalf("Update() = %+v, %v; want the queued status of job", status, err)
+	}
+
+	got, err := s.Get(ctx, "job")
+	if err != nil || got.State != domain.JobQueued || len(got.Attempts) != 1 || got.Attempts[0].Provider != "simulated" {
+		t.Errorf("Get() = %+v, %v; want the stored status", got, err)
+	}
+	if ttl := server.TTL("test:" + keyPrefix + "job"); ttl != time.Hour {
+		t.Errorf("TTL = %s, want 1h", ttl)
+	}
+
+	if err := s.Delete(ctx, "job"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get(ctx, "job"); !errors.Is(err, ports.ErrJobNotFound) {
+		t.Errorf("Get() after Delete error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestStoreUpdateErrorKeepsStatus(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		status.State = domain.JobQueued
+		return nil
+	})
+
+	refused := errors.New("refused")
+	if _, err := s.Update(ctx, "job", func(status *domain.JobStatus) error {
+		status.State = domain.JobSent
+		return refused
+	}); !errors.Is(err, refused) {
+		t.Fatalf("Update() error = %v, want the error of the update", err)
+	}
+	if got, _ := s.Get(ctx, "job"); got.State != domain.JobQueued {
+		t.Errorf("state after a failed update = %s, want queued", got.State)
+	}
+}
+
+func TestStoreConcurrentUpdatesAreNotLost(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+
+	const updates = 8
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			if _, err := s.Update(ctx, "job", func(status *domain.JobStatus) error {
+				status.Attempts = append(status.Attempts, domain.Attempt{Number: n})
+				return nil
+			}); err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+
+	got, err := s.Get(ctx, "job")
+	if err != nil || len(got.Attempts) != updates {
+		t.Errorf("Get() = %d attempts, %v; want all %d updates applied", len(got.Attempts), err, updates)
+	}
+}
@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"context"
+
+	"email-queue-service/internal/core/ports"
+)
+
+// Elector implements the ports.LeaderElector interface for a single
+// instance, which always leads. It goes with stores that are only known to
+// this instance, where there is nobody to share the work with.
+type Elector struct{}
+
+// NewElector creates an Elector.
+func NewElector() *Elector {
+	return &Elector{}
+}
+
+// Campaign reports that this instance is the leader.
+func (e *Elector) Campaign(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Resign does nothing.
+func (e *Elector) Resign(ctx context.Context) error {
+	return nil
+}
+
+// Ensure Elector implements the ports.LeaderElector interface
+var _ ports.LeaderElector = (*Elector)(nil)
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/ports"
+)
+
+const redisTimeout = 5 * time.Second
+
+// campaignScript takes the lease in KEYS[1] for ARGV[1] if it is free, or
+// extends it to ARGV[2] milliseconds if ARGV[1] holds it already.
+var campaignScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if not holder then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// resignScript deletes the lease in KEYS[1] if ARGV[1] holds it.
+var resignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Elector implements the ports.LeaderElector interface with a lease in
+// Redis: a key holding the name of the leader that expires unless the
+// leader renews it. If the leader dies, another instance takes over once
+// the lease has run out.
+type Elector struct {
+	client redis.UniversalClient
+	key    string
+	id     string
+	lease  time.Duration
+}
+
+// NewElector creates an Elector for the instance id competing for the lease
+// named name, whose key starts with prefix.
+func NewElector(client redis.UniversalClient, prefix, name, id string, lease time.Duration) *Elector {
+	return &Elector{client: client, key: prefix + name, id: id, lease: lease}
+}
+
+// Campaign takes or renews the lease.
+func (e *Elector) Campaign(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	won, err := campaignScript.Run(ctx, e.client, []string{e.key}, e.id, e.lease.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to campaign for leadership in Redis: %w", err)
+	}
+	return won == 1, nil
+}
+
+// Resign releases the lease if this instance holds it.
+func (e *Elector) Resign(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if err := resignScript.Run(ctx, e.client, []string{e.key}, e.id).Err(); err != nil {
+		return fmt.Errorf("failed to resign leadership in Redis: %w", err)
+	}
+	return nil
+}
+
+// Ensure Elector implements the ports.LeaderElector interface
+var _ ports.LeaderElector = (*Elector)(nil)
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestElectorLease(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+
+	a := NewElector(client, "test:", "leader", "a", 30*time.Second)
+	b := NewElector(client, "test:", "leader", "b", 30*time.Second)
+	campaign := func(e *Elector) bool {
+		t.Helper()
+		won, err := e.Campaign(ctx)
+		if err != nil {
+			t.Fatalf("Campaign(%s) error = %v", e.id, err)
+		}
+		return won
+	}
+
+	if !campaign(a) || campaign(b) {
+		t.Fatalf("the first instance to campaign does not lead alone")
+	}
+	// Renewing extends the lease.
+	server.FastForward(20 * time.Second)
+	if !campaign(a) {
+		t.Fatalf("the leader lost its lease while renewing it")
+	}
+	server.FastForward(20 * time.Second)
+	if campaign(b) {
+		t.Fatalf("another instance took over a renewed lease")
+	}
+
+	// Another instance takes over once the lease runs out.
+	server.FastForward(31 * time.Second)
+	if !campaign(b) || campaign(a) {
+		t.Fatalf("another instance did not take over an expired lease")
+	}
+
+	// Only the leader can resign.
+	if err := a.Resign(ctx); err != nil {
+		t.Fatalf("Resign() error = %v", err)
+	}
+	if campaign(a) {
+		t.Fatalf("resigning by another instance released the lease")
+	}
+	if err := b.Resign(ctx); err != nil {
+		t.Fatalf("Resign() error = %v", err)
+	}
+	if !campaign(a) {
+		t.Errorf("the lease is not free after the leader resigned")
+	}
+}
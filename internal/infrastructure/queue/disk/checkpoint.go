@@ -0,0 +1,75 @@
+package disk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const checkpointFile = "consumer.checkpoint"
+
+// checkpoint is the persisted consumer position. Every record below Committed
+// has been acknowledged; Acked lists the records at or above it that were
+// acknowledged out of order, so they are not redelivered after a restart.
+type checkpoint struct {
+	Committed uint64   `json:"committed"`
+	Acked     []uint64 `json:"acked,omitempty"`
+}
+
+func loadCheckpoint(dir string) (checkpoint, error) {
+	var cp checkpoint
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("invalid checkpoint file: %w", err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint atomically replaces the checkpoint file.
+func saveCheckpoint(dir string, committed uint64, acked map[uint64]struct{}) error {
+	cp := checkpoint{Committed: committed}
+	for seq := range acked {
+		cp.Acked = append(cp.Acked, seq)
+	}
+	sort.Slice(cp.Acked, func(i, j int) bool { return cp.Acked[i] < cp.Acked[j] })
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, checkpointFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, checkpointFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeFileSync writes data to path and fsyncs it before returning.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,584 @@
+package disk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// FsyncPolicy controls when appended jobs are flushed to stable storage.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways fsyncs every job before Enqueue returns.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncBatch groups the enqueues that arrive while an fsync is running
+	// into the next one (group commit); Enqueue returns once its batch is on disk.
+	FsyncBatch FsyncPolicy = "batch"
+	// FsyncInterval fsyncs every SyncInterval without making Enqueue wait, so
+	// a power loss can drop the jobs of the last interval.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+)
+
+const (
+	checkpointInterval  = time.Second
+	defaultSyncInterval = 10 * time.Millisecond
+	defaultSegmentBytes = 64 << 20
+)
+
+// Options configures a DiskQueue.
+type Options struct {
+	Dir          string
+	Fsync        FsyncPolicy
+	SyncInterval time.Duration
+	SegmentBytes int64
+}
+
+// pendingJob is a job waiting in the queue together with its log position.
+type pendingJob struct {
+	seq uint64
+	job domain.EmailJob
+}
+
+// syncBatch is a group of enqueues waiting for the same fsync.
+type syncBatch struct {
+	done chan struct{}
+	err  error
+}
+
+// DiskQueue implements the ports.Queue interface on an append-only,
+// segmented write-ahead log shared by all priority lanes. Jobs are kept in
+// per-lane memory lists for delivery, so
+// dequeueing is as fast as with MemoryQueue; the log is only read back
+// during crash recovery. Acknowledged positions are checkpointed and
+// segments whose jobs have all been acknowledged are deleted.
+type DiskQueue struct {
+	opts       Options
+	logger     *logger.Logger
+	queueDepth *metrics.QueueDepth
+
+	mu        sync.Mutex
+	closed    bool
+	failed    error // Sticky write error; the log cannot be appended to after it
+	ready     map[domain.Priority][]pendingJob
+	inFlight  map[uint64]domain.EmailJob
+	nextSeq   uint64
+	committed uint64              // Every job below this sequence number is acknowledged
+	acked     map[uint64]struct{} // Jobs at or above committed acknowledged out of order
+	dirty     bool                // Checkpoint is behind the in-memory state
+	segments  []segment
+	active    *os.File
+	writer    *bufio.Writer
+	size      int64 // Size of the active segment
+	unsynced  bool
+	batch     *syncBatch
+
+	checkpointMu sync.Mutex // Serializes checkpoint writes
+	syncRequest  chan struct{}
+	notify       chan struct{}
+	done         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewDiskQueue opens (or creates) the queue in opts.Dir and recovers every
+// job that was not acknowledged before the last shutdown or crash.
+func NewDiskQueue(opts Options, l *logger.Logger, queueDepth *metrics.QueueDepth) (*DiskQueue, error) {
+	switch opts.Fsync {
+	case FsyncAlways, FsyncBatch, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unsupported fsync policy %q", opts.Fsync)
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	q := &DiskQueue{
+		opts:        opts,
+		logger:      l,
+		queueDepth:  queueDepth,
+		ready:       make(map[domain.Priority][]pendingJob),
+		inFlight:    make(map[uint64]domain.EmailJob),
+		acked:       make(map[uint64]struct{}),
+		batch:       &syncBatch{done: make(chan struct{})},
+		syncRequest: make(chan struct{}, 1),
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+	for _, lane := range domain.Priorities {
+		q.queueDepth.Set(lane, float64(len(q.ready[lane])))
+	}
+
+	q.wg.Add(1)
+	go q.runSyncer()
+	return q, nil
+}
+
+// recover replays the log from the last checkpoint and opens the newest
+// segment for appending. A torn record at the end of a segment is truncated.
+func (q *DiskQueue) recover() error {
+	cp, err := loadCheckpoint(q.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	for _, seq := range cp.Acked {
+		q.acked[seq] = struct{}{}
+	}
+
+	segments, err := listSegments(q.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list segments: %w", err)
+	}
+
+	q.nextSeq = cp.Committed
+	var activeSize int64
+	for _, seg := range segments {
+		end, err := readSegment(seg.path, func(r record) {
+			if r.seq >= q.nextSeq {
+				q.nextSeq = r.seq + 1
+			}
+			if r.seq < cp.Committed {
+				return
+			}
+			if _, ok := q.acked[r.seq]; ok {
+				return
+			}
+			var job domain.EmailJob
+			if err := json.Unmarshal(r.payload, &job); err != nil {
+				q.logger.Errorf("Dropping undecodable job %d from %s: %v", r.seq, seg.path, err)
+				q.acked[r.seq] = struct{}{}
+				return
+			}
+			q.ready[job.Lane()] = append(q.ready[job.Lane()], pendingJob{seq: r.seq, job: job})
+		})
+		if errors.Is(err, errCorruptRecord) {
+			q.logger.Warnf("Truncating torn or corrupt record at offset %d of %s", end, seg.path)
+			if err := os.Truncate(seg.path, end); err != nil {
+				return fmt.Errorf("failed to truncate %s: %w", seg.path, err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", seg.path, err)
+		}
+		activeSize = end
+	}
+
+	// Every job below the oldest one still pending has been acknowledged.
+	q.committed = q.nextSeq
+	for _, jobs := range q.ready {
+		if len(jobs) > 0 && jobs[0].seq < q.committed {
+			q.committed = jobs[0].seq
+		}
+	}
+	for seq := range q.acked {
+		if seq < q.committed {
+			delete(q.acked, seq)
+		}
+	}
+
+	if len(segments) == 0 {
+		return q.openSegment(q.nextSeq)
+	}
+	last := segments[len(segments)-1]
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", last.path, err)
+	}
+	q.segments = segments
+	q.active = f
+	q.writer = bufio.NewWriterSize(f, 64<<10)
+	q.size = activeSize
+
+	if n := q.readyCount(); n > 0 {
+		q.logger.Printf("Recovered %d pending jobs from %s", n, q.opts.Dir)
+	}
+	return nil
+}
+
+// openSegment creates a new active segment starting at base.
+func (q *DiskQueue) openSegment(base uint64) error {
+	seg := segment{base: base, path: filepath.Join(q.opts.Dir, segmentName(base))}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+	if err := syncDir(q.opts.Dir); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync queue directory: %w", err)
+	}
+	q.segments = append(q.segments, seg)
+	q.active = f
+	q.writer = bufio.NewWriterSize(f, 64<<10)
+	q.size = 0
+	return nil
+}
+
+// Enqueue appends a job to the log and makes it available to Dequeue in its
+// priority lane. Depending on the fsync policy, it returns once the job is on
+// disk; ctx is only checked up front, so the result always tells whether the
+// job was written.
+func (q *DiskQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+	}
+	if q.failed != nil {
+		q.mu.Unlock()
+		return fmt.Errorf("queue log is unavailable: %w", q.failed)
+	}
+
+	seq := q.nextSeq
+	if err := q.append(record{seq: seq, payload: payload}); err != nil {
+		q.failed = err
+		q.mu.Unlock()
+		return fmt.Errorf("failed to write job to queue log: %w", err)
+	}
+	q.nextSeq++
+
+	job.Receipt = ""
+	q.pushReady(pendingJob{seq: seq, job: job})
+
+	batch := q.batch
+	q.mu.Unlock()
+
+	if q.opts.Fsync == FsyncBatch {
+		select {
+		case q.syncRequest <- struct{}{}:
+		default:
+		}
+		<-batch.done
+		if batch.err != nil {
+			return fmt.Errorf("failed to sync queue log: %w", batch.err)
+		}
+	}
+	return nil
+}
+
+// append writes a record to the active segment according to the fsync
+// policy and rolls over to a new segment when it is full. q.mu must be held.
+func (q *DiskQueue) append(r record) error {
+	buf := encodeRecord(nil, r)
+	if _, err := q.writer.Write(buf); err != nil {
+		return err
+	}
+	q.size += int64(len(buf))
+	q.unsynced = true
+
+	switch q.opts.Fsync {
+	case FsyncAlways:
+		if err := q.sync(); err != nil {
+			return err
+		}
+	case FsyncNever:
+		if err := q.writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if q.size >= q.opts.SegmentBytes {
+		if err := q.sync(); err != nil {
+			return err
+		}
+		if err := q.active.Close(); err != nil {
+			return err
+		}
+		return q.openSegment(q.nextSeq + 1)
+	}
+	return nil
+}
+
+// sync flushes and fsyncs the active segment. q.mu must be held.
+func (q *DiskQueue) sync() error {
+	if err := q.writer.Flush(); err != nil {
+		return err
+	}
+	if q.opts.Fsync != FsyncNever {
+		if err := q.active.Sync(); err != nil {
+			return err
+		}
+	}
+	q.unsynced = false
+	return nil
+}
+
+// pushReady appends a job to its lane and wakes a waiting Dequeue. q.mu
+// must be held.
+func (q *DiskQueue) pushReady(p pendingJob) {
+	lane := p.job.Lane()
+	q.ready[lane] = append(q.ready[lane], p)
+	q.queueDepth.Set(lane, float64(len(q.ready[lane])))
+	q.signal()
+}
+
+// readyCount returns the number of jobs waiting in all lanes. q.mu must be held.
+func (q *DiskQueue) readyCount() int {
+	n := 0
+	for _, jobs := range q.ready {
+		n += len(jobs)
+	}
+	return n
+}
+
+// signal wakes up one waiting Dequeue. q.mu must be held.
+func (q *DiskQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Dequeue retrieves a job from the first lane in lanes that has one. Once
+// the queue is closed, the remaining jobs are still handed out before
+// ErrQueueClosed is returned.
+func (q *DiskQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		q.mu.Lock()
+		for _, lane := range lanes {
+			jobs := q.ready[lane]
+			if len(jobs) == 0 {
+				continue
+			}
+			next := jobs[0]
+			jobs[0] = pendingJob{}
+			q.ready[lane] = jobs[1:]
+			q.inFlight[next.seq] = next.job
+			q.queueDepth.Set(lane, float64(len(q.ready[lane])))
+			if q.readyCount() > 0 {
+				q.signal() // Pass the wake-up on to the next waiting worker
+			}
+			q.mu.Unlock()
+
+			next.job.Receipt = strconv.FormatUint(next.seq, 10)
+			return next.job, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-q.done:
+		case <-ctx.Done():
+			return domain.EmailJob{}, ctx.Err()
+		}
+	}
+}
+
+// Ack marks a job as done. The checkpoint moves past it on the next sync,
+// or right away if the queue is already closed.
+func (q *DiskQueue) Ack(job domain.EmailJob) error {
+	seq, err := strconv.ParseUint(job.Receipt, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid receipt %q: %w", job.Receipt, err)
+	}
+
+	q.mu.Lock()
+	if _, ok := q.inFlight[seq]; !ok {
+		q.mu.Unlock()
+		return fmt.Errorf("unknown receipt %q, job was not dequeued or already acknowledged", job.Receipt)
+	}
+	delete(q.inFlight, seq)
+	q.acked[seq] = struct{}{}
+	for {
+		if _, ok := q.acked[q.committed]; !ok {
+			break
+		}
+		delete(q.acked, q.committed)
+		q.committed++
+	}
+	q.dirty = true
+	closed := q.closed
+	q.mu.Unlock()
+
+	if closed {
+		return q.checkpoint()
+	}
+	return nil
+}
+
+// Nack puts a job back at the end of its lane. Its record is still in the
+// log unacknowledged, so nothing has to be written.
+func (q *DiskQueue) Nack(job domain.EmailJob) error {
+	seq, err := strconv.ParseUint(job.Receipt, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid receipt %q: %w", job.Receipt, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	original, ok := q.inFlight[seq]
+	if !ok {
+		return fmt.Errorf("unknown receipt %q, job was not dequeued or already acknowledged", job.Receipt)
+	}
+	delete(q.inFlight, seq)
+	q.pushReady(pendingJob{seq: seq, job: original})
+	return nil
+}
+
+// runSyncer completes fsync batches, writes checkpoints and deletes fully
+// acknowledged segments in the background.
+func (q *DiskQueue) runSyncer() {
+	defer q.wg.Done()
+
+	syncTicker := time.NewTicker(q.opts.SyncInterval)
+	defer syncTicker.Stop()
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.syncRequest:
+			q.syncBatch()
+		case <-syncTicker.C:
+			q.syncBatch()
+		case <-checkpointTicker.C:
+			if err := q.checkpoint(); err != nil {
+				q.logger.Errorf("Failed to write queue checkpoint: %v", err)
+			}
+		}
+	}
+}
+
+// syncBatch fsyncs everything written since the last sync and releases the
+// enqueues waiting on it.
+func (q *DiskQueue) syncBatch() {
+	q.mu.Lock()
+	batch := q.batch
+	q.batch = &syncBatch{done: make(chan struct{})}
+	if q.unsynced && q.failed == nil {
+		if err := q.sync(); err != nil {
+			q.failed = err
+			batch.err = err
+			q.logger.Errorf("Failed to sync queue log: %v", err)
+		}
+	}
+	q.mu.Unlock()
+	close(batch.done)
+}
+
+// checkpoint persists the consumer position and then compacts the log.
+func (q *DiskQueue) checkpoint() error {
+	q.checkpointMu.Lock()
+	defer q.checkpointMu.Unlock()
+
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	committed := q.committed
+	acked := make(map[uint64]struct{}, len(q.acked))
+	for seq := range q.acked {
+		acked[seq] = struct{}{}
+	}
+	q.dirty = false
+	q.mu.Unlock()
+
+	if err := saveCheckpoint(q.opts.Dir, committed, acked); err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+		return err
+	}
+	q.compact(committed)
+	return nil
+}
+
+// compact deletes segments that only hold jobs below the committed position.
+// The active segment is never deleted.
+func (q *DiskQueue) compact(committed uint64) {
+	q.mu.Lock()
+	var obsolete []segment
+	for len(q.segments) > 1 && q.segments[1].base <= committed {
+		obsolete = append(obsolete, q.segments[0])
+		q.segments = q.segments[1:]
+	}
+	q.mu.Unlock()
+
+	for _, seg := range obsolete {
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			q.logger.Errorf("Failed to delete compacted segment %s: %v", seg.path, err)
+		}
+	}
+	if len(obsolete) > 0 {
+		if err := syncDir(q.opts.Dir); err != nil {
+			q.logger.Errorf("Failed to sync queue directory: %v", err)
+		}
+	}
+}
+
+// Close stops accepting new jobs, syncs the log and writes a final
+// checkpoint. Jobs still queued are delivered until the queue is drained,
+// and acknowledgements keep being recorded after Close.
+func (q *DiskQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.done)
+	q.mu.Unlock()
+
+	q.wg.Wait()
+	q.syncBatch()
+
+	q.mu.Lock()
+	if err := q.active.Close(); err != nil {
+		q.logger.Errorf("Failed to close queue log: %v", err)
+	}
+	q.mu.Unlock()
+
+	if err := q.checkpoint(); err != nil {
+		q.logger.Errorf("Failed to write queue checkpoint: %v", err)
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *DiskQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Ensure DiskQueue implements the ports.Queue interface
+var _ ports.Queue = (*DiskQueue)(nil)
@@ -0,0 +1,336 @@
+package disk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+func newTestDepth() *metrics.QueueDepth {
+	return metrics.NewQueueDepth(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"}),
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"}),
+	)
+}
+
+func openTestQueue(t testing.TB, opts Options) *DiskQueue {
+	t.Helper()
+	if opts.Fsync == "" {
+		opts.Fsync = FsyncAlways
+	}
+	q, err := NewDiskQueue(opts, logger.NewLogger(), newTestDepth())
+	if err != nil {
+		t.Fatalf("NewDiskQueue() error = %v", err)
+	}
+	return q
+}
+
+// crashCopy copies the files of a queue directory as they are on disk right
+// now, which is what a restart after a crash at this point would find.
+func crashCopy(t *testing.T, dir string) string {
+	t.Helper()
+	copyDir := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		src, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.Create(filepath.Join(copyDir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		src.Close()
+		dst.Close()
+	}
+	return copyDir
+}
+
+func enqueueIDs(t *testing.T, q *DiskQueue, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := q.Enqueue(context.Background(), domain.EmailJob{ID: id, To: id + "@example.com"}); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", id, err)
+		}
+	}
+}
+
+// drainIDs dequeues every ready job without acknowledging it.
+func drainIDs(t *testing.T, q *DiskQueue) []string {
+	t.Helper()
+	var ids []string
+	for {
+		q.mu.Lock()
+		n := q.readyCount()
+		q.mu.Unlock()
+		if n == 0 {
+			return ids
+		}
+		job, err := q.Dequeue(context.Background(), domain.Priorities)
+		if err != nil {
+			t.Fatalf("Dequeue() error = %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+}
+
+func dequeueID(t *testing.T, q *DiskQueue) domain.EmailJob {
+	t.Helper()
+	job, err := q.Dequeue(context.Background(), domain.Priorities)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	return job
+}
+
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	segments, err := listSegments(dir)
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("listSegments() = %v, %v", segments, err)
+	}
+	return segments[len(segments)-1].path
+}
+
+func equalIDs(got, want []string) bool {
+	sort.Strings(got)
+	sort.Strings(want)
+	return fmt.Sprint(got) == fmt.Sprint(want)
+}
+
+func TestDiskQueueRedeliversUnacknowledgedJobsAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	q := openTestQueue(t, Options{Dir: dir})
+	defer q.Close()
+
+	enqueueIDs(t, q, "1", "2", "3")
+	acked := dequeueID(t, q)
+	if err := q.Ack(acked); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	nacked := dequeueID(t, q)
+	if err := q.Nack(nacked); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	dequeueID(t, q) // In flight when the process dies
+	if err := q.checkpoint(); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	restarted := openTestQueue(t, Options{Dir: crashCopy(t, dir)})
+	defer restarted.Close()
+	if got := drainIDs(t, restarted); !equalIDs(got, []string{"2", "3"}) {
+		t.Errorf("recovered %v, want [2 3]", got)
+	}
+}
+
+func TestDiskQueueKeepsOutOfOrderAcksAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	q := openTestQueue(t, Options{Dir: dir})
+	enqueueIDs(t, q, "1", "2", "3", "4")
+	first := dequeueID(t, q)
+	dequeueID(t, q) // Still in flight at shutdown
+	third := dequeueID(t, q)
+	for _, job := range []domain.EmailJob{third, first} {
+		if err := q.Ack(job); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+	q.Close()
+
+	restarted := openTestQueue(t, Options{Dir: dir})
+	defer restarted.Close()
+	if got := drainIDs(t, restarted); !equalIDs(got, []string{"2", "4"}) {
+		t.Errorf("recovered %v, want [2 4]", got)
+	}
+}
+
+func TestDiskQueueTruncatesTornTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, path string)
+	}{
+		{
+			name: "partial header",
+			damage: func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0})
+			},
+		},
+		{
+			name: "partial payload",
+			damage: func(t *testing.T, path string) {
+				buf := encodeRecord(nil, record{seq: 99, payload: []byte(`{"id":"torn"}`)})
+				appendBytes(t, path, buf[:len(buf)-4])
+			},
+		},
+		{
+			name: "checksum mismatch",
+			damage: func(t *testing.T, path string) {
+				buf := encodeRecord(nil, record{seq: 99, payload: []byte(`{"id":"torn"}`)})
+				buf[len(buf)-2] ^= 0xff
+				appendBytes(t, path, buf)
+			},
+		},
+		{
+			name: "impossible length",
+			damage: func(t *testing.T, path string) {
+				buf := encodeRecord(nil, record{seq: 99, payload: []byte(`{"id":"torn"}`)})
+				buf[0] = 0xff
+				appendBytes(t, path, buf)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := openTestQueue(t, Options{Dir: dir})
+			enqueueIDs(t, q, "1", "2")
+			crashed := crashCopy(t, dir)
+			q.Close()
+
+			path := lastSegment(t, crashed)
+			intact, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, path)
+
+			restarted := openTestQueue(t, Options{Dir: crashed})
+			if got := drainIDs(t, restarted); !equalIDs(got, []string{"1", "2"}) {
+				t.Errorf("recovered %v, want [1 2]", got)
+			}
+			if info, err := os.Stat(path); err != nil || info.Size() != intact.Size() {
+				t.Errorf("segment size after recovery = %v (%v), want the torn record truncated to %d", info.Size(), err, intact.Size())
+			}
+
+			// Jobs appended after the truncation are read back after the next restart.
+			enqueueIDs(t, restarted, "3")
+			restarted.Close()
+			again := openTestQueue(t, Options{Dir: crashed})
+			defer again.Close()
+			if got := drainIDs(t, again); !equalIDs(got, []string{"1", "2", "3"}) {
+				t.Errorf("recovered %v after appending past a truncated tail, want [1 2 3]", got)
+			}
+		})
+	}
+}
+
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskQueueReplaysAfterCompaction(t *testing.T) {
+	dir := t.TempDir()
+	// Every record fills a segment of its own.
+	q := openTestQueue(t, Options{Dir: dir, SegmentBytes: 1})
+	defer q.Close()
+
+	enqueueIDs(t, q, "1", "2", "3", "4", "5")
+	for i := 0; i < 3; i++ {
+		if err := q.Ack(dequeueID(t, q)); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+	if err := q.checkpoint(); err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("%d segments left after compaction, want 3 (jobs 4 and 5 and the active one)", len(segments))
+	}
+	if segments[0].base != 3 {
+		t.Errorf("oldest segment starts at %d, want 3", segments[0].base)
+	}
+
+	crashed := crashCopy(t, dir)
+	restarted := openTestQueue(t, Options{Dir: crashed, SegmentBytes: 1})
+	if got := drainIDs(t, restarted); !equalIDs(got, []string{"4", "5"}) {
+		t.Errorf("recovered %v, want [4 5]", got)
+	}
+
+	// New jobs continue the sequence instead of reusing compacted numbers.
+	enqueueIDs(t, restarted, "6")
+	restarted.Close()
+	again := openTestQueue(t, Options{Dir: crashed, SegmentBytes: 1})
+	defer again.Close()
+	if got := drainIDs(t, again); !equalIDs(got, []string{"4", "5", "6"}) {
+		t.Errorf("recovered %v, want [4 5 6]", got)
+	}
+}
+
+func TestDiskQueueLanes(t *testing.T) {
+	q := openTestQueue(t, Options{Dir: t.TempDir()})
+	defer q.Close()
+
+	ctx := context.Background()
+	for _, job := range []domain.EmailJob{
+		{ID: "bulk", Priority: domain.PriorityBulk},
+		{ID: "normal"},
+		{ID: "high", Priority: domain.PriorityHigh},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	for _, want := range []string{"high", "normal", "bulk"} {
+		if got := dequeueID(t, q).ID; got != want {
+			t.Errorf("Dequeue() = %s, want %s", got, want)
+		}
+	}
+}
+
+func BenchmarkDiskQueueEnqueueAck(b *testing.B) {
+	for _, policy := range []FsyncPolicy{FsyncNever, FsyncInterval, FsyncBatch} {
+		b.Run(string(policy), func(b *testing.B) {
+			q := openTestQueue(b, Options{Dir: b.TempDir(), Fsync: policy})
+			defer q.Close()
+
+			ctx := context.Background()
+			job := domain.EmailJob{To: "bench@example.com", Subject: "Benchmark", Body: "Hello from the benchmark"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := q.Enqueue(ctx, job); err != nil {
+						b.Fatal(err)
+					}
+					dequeued, err := q.Dequeue(ctx, domain.Priorities)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := q.Ack(dequeued); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package disk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/infrastructure/queue/memory"
+)
+
+// The schedule journal is a single log in the segment record format. The
+// record sequence number is the scheduled job ID; a record with a payload
+// schedules the job and an empty record removes it again.
+const (
+	scheduleJournalFile = "scheduled.journal"
+
+	// The journal is rewritten once it holds this many removed jobs and
+	// they outnumber the live ones.
+	journalCompactThreshold = 1024
+)
+
+type scheduleEntry struct {
+	At  time.Time       `json:"at"`
+	Job domain.EmailJob `json:"job"`
+}
+
+// ScheduleJournal implements the memory.Journal interface on disk, so that
+// jobs scheduled for later survive restarts of the on-disk backend.
+type ScheduleJournal struct {
+	dir string
+
+	mu      sync.Mutex
+	file    *os.File
+	live    map[uint64]memory.ScheduledJob
+	removed int
+}
+
+// OpenScheduleJournal opens the schedule journal in dir and returns it along
+// with the jobs that were scheduled but not yet promoted.
+func OpenScheduleJournal(dir string) (*ScheduleJournal, []memory.ScheduledJob, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	j := &ScheduleJournal{dir: dir, live: make(map[uint64]memory.ScheduledJob)}
+	path := filepath.Join(dir, scheduleJournalFile)
+	_, err := readSegment(path, func(r record) {
+		if len(r.payload) == 0 {
+			delete(j.live, r.seq)
+			return
+		}
+		var entry scheduleEntry
+		if err := json.Unmarshal(r.payload, &entry); err != nil {
+			return
+		}
+		j.live[r.seq] = memory.ScheduledJob{ID: r.seq, At: entry.At, Job: entry.Job}
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errCorruptRecord) {
+		return nil, nil, fmt.Errorf("failed to read schedule journal: %w", err)
+	}
+
+	// Rewriting drops removed jobs and any torn record at the end of the log.
+	if err := j.rewrite(); err != nil {
+		return nil, nil, err
+	}
+
+	pending := make([]memory.ScheduledJob, 0, len(j.live))
+	for _, sj := range j.live {
+		pending = append(pending, sj)
+	}
+	return j, pending, nil
+}
+
+// Append durably records a scheduled job before it is acknowledged.
+func (j *ScheduleJournal) Append(sj memory.ScheduledJob) error {
+	payload, err := json.Marshal(scheduleEntry{At: sj.At, Job: sj.Job})
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled job: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("schedule journal is closed")
+	}
+	if _, err := j.file.Write(encodeRecord(nil, record{seq: sj.ID, payload: payload})); err != nil {
+		return fmt.Errorf("failed to write schedule journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync schedule journal: %w", err)
+	}
+	j.live[sj.ID] = sj
+	return nil
+}
+
+// Remove records that a job was promoted. It is not synced: losing it only
+// means the job is promoted once more after a crash.
+func (j *ScheduleJournal) Remove(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("schedule journal is closed")
+	}
+	if _, err := j.file.Write(encodeRecord(nil, record{seq: id})); err != nil {
+		return fmt.Errorf("failed to write schedule journal: %w", err)
+	}
+	delete(j.live, id)
+	j.removed++
+
+	if j.removed >= journalCompactThreshold && j.removed > len(j.live) {
+		return j.rewrite()
+	}
+	return nil
+}
+
+// rewrite atomically replaces the journal with one holding only live jobs
+// and reopens it for appending. j.mu must be held or j not yet shared.
+func (j *ScheduleJournal) rewrite() error {
+	var buf []byte
+	for id, sj := range j.live {
+		payload, err := json.Marshal(scheduleEntry{At: sj.At, Job: sj.Job})
+		if err != nil {
+			return fmt.Errorf("failed to marshal scheduled job: %w", err)
+		}
+		buf = encodeRecord(buf, record{seq: id, payload: payload})
+	}
+
+	path := filepath.Join(j.dir, scheduleJournalFile)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, buf); err != nil {
+		return fmt.Errorf("failed to rewrite schedule journal: %w", err)
+	}
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rewrite schedule journal: %w", err)
+	}
+	if err := syncDir(j.dir); err != nil {
+		return fmt.Errorf("failed to rewrite schedule journal: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open schedule journal: %w", err)
+	}
+	j.file = f
+	j.removed = 0
+	return nil
+}
+
+// Close syncs and closes the journal.
+func (j *ScheduleJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Sync()
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	j.file = nil
+	return err
+}
+
+// Ensure ScheduleJournal implements the memory.Journal interface
+var _ memory.Journal = (*ScheduleJournal)(nil)
@@ -0,0 +1,134 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/infrastructure/queue/memory"
+)
+
+func openTestJournal(t *testing.T, dir string) (*ScheduleJournal, []memory.ScheduledJob) {
+	t.Helper()
+	j, pending, err := OpenScheduleJournal(dir)
+	if err != nil {
+		t.Fatalf("OpenScheduleJournal() error = %v", err)
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].ID < pending[b].ID })
+	return j, pending
+}
+
+func TestScheduleJournalRecoversLiveJobs(t *testing.T) {
+	dir := t.TempDir()
+	j, pending := openTestJournal(t, dir)
+	if len(pending) != 0 {
+		t.Fatalf("new journal holds %v, want nothing", pending)
+	}
+
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for i, jobID := range []string{"a", "b", "c"} {
+		id := uint64(i + 1)
+		sj := memory.ScheduledJob{ID: id, At: at.Add(time.Duration(id) * time.Minute), Job: domain.EmailJob{ID: jobID, To: "a@example.com", Retries: int(id)}}
+		if err := j.Append(sj); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	if err := j.Remove(2); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	// Appends are synced before they return, so a crash copy holds them.
+	crashed := crashCopy(t, dir)
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for _, d := range []string{dir, crashed} {
+		reopened, pending := openTestJournal(t, d)
+		if len(pending) != 2 || pending[0].ID != 1 || pending[1].ID != 3 {
+			t.Fatalf("recovered %v, want jobs 1 and 3", pending)
+		}
+		if got := pending[1]; got.Job.ID != "c" || got.Job.Retries != 3 || !got.At.Equal(at.Add(3*time.Minute)) {
+			t.Errorf("recovered %+v, want job c due at %s", got, at.Add(3*time.Minute))
+		}
+		reopened.Close()
+	}
+}
+
+func TestScheduleJournalDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := openTestJournal(t, dir)
+	if err := j.Append(memory.ScheduledJob{ID: 1, At: time.Now(), Job: domain.EmailJob{ID: "kept"}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	j.Close()
+
+	path := filepath.Join(dir, scheduleJournalFile)
+	buf := encodeRecord(nil, record{seq: 2, payload: []byte(`{"job":{"id":"torn"}}`)})
+	appendBytes(t, path, buf[:len(buf)-3])
+
+	reopened, pending := openTestJournal(t, dir)
+	if len(pending) != 1 || pending[0].Job.ID != "kept" {
+		t.Fatalf("recovered %v, want only the intact job", pending)
+	}
+
+	// The rewrite on open removed the torn record, so later appends are read back.
+	if err := reopened.Append(memory.ScheduledJob{ID: 2, At: time.Now(), Job: domain.EmailJob{ID: "after"}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	reopened.Close()
+	again, pending := openTestJournal(t, dir)
+	defer again.Close()
+	if len(pending) != 2 || pending[1].Job.ID != "after" {
+		t.Errorf("recovered %v, want the intact job and the one appended after the tear", pending)
+	}
+}
+
+func TestScheduleJournalCompactsRemovedJobs(t *testing.T) {
+	dir := t.TempDir()
+	j, _ := openTestJournal(t, dir)
+	defer j.Close()
+
+	for id := uint64(1); id <= journalCompactThreshold; id++ {
+		if err := j.Append(memory.ScheduledJob{ID: id, At: time.Now(), Job: domain.EmailJob{ID: "x"}}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		if err := j.Remove(id); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	}
+	if err := j.Append(memory.ScheduledJob{ID: journalCompactThreshold + 1, At: time.Now(), Job: domain.EmailJob{ID: "live"}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, scheduleJournalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := len(encodeRecord(nil, record{seq: 1, payload: []byte(`{"at":"2026-10-18T12:00:00Z","job":{"id":"live"}}`)}))
+	if info.Size() > int64(4*single) {
+		t.Errorf("journal is %d bytes after removing %d jobs, want it compacted", info.Size(), journalCompactThreshold)
+	}
+	if j.removed != 0 {
+		t.Errorf("%d removed jobs counted after compaction, want 0", j.removed)
+	}
+}
+
+func TestScheduleJournalRejectsWritesAfterClose(t *testing.T) {
+	j, _ := openTestJournal(t, t.TempDir())
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if err := j.Append(memory.ScheduledJob{ID: 1}); err == nil {
+		t.Errorf("Append() after Close succeeded, want an error")
+	}
+	if err := j.Remove(1); err == nil {
+		t.Errorf("Remove() after Close succeeded, want an error")
+	}
+}
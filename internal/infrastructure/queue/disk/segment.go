@@ -0,0 +1,131 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every record in a segment is laid out as
+//
+//	[4 byte payload length][4 byte CRC-32C of seq+payload][8 byte sequence number][payload]
+//
+// all big-endian. A torn write at the end of the log is detected by a short
+// read or a checksum mismatch and truncated away during recovery.
+const (
+	recordHeaderSize = 16
+	segmentSuffix    = ".log"
+	maxRecordSize    = 16 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// record is a single log entry.
+type record struct {
+	seq     uint64
+	payload []byte
+}
+
+// segment describes one log file. A segment holds the records with sequence
+// numbers from base up to (but excluding) the base of the next segment.
+type segment struct {
+	base uint64
+	path string
+}
+
+func segmentName(base uint64) string {
+	return fmt.Sprintf("%020d%s", base, segmentSuffix)
+}
+
+// listSegments returns the segments in dir ordered by base sequence number.
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{base: base, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].base < segments[j].base })
+	return segments, nil
+}
+
+// encodeRecord appends the on-disk form of a record to buf.
+func encodeRecord(buf []byte, r record) []byte {
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(r.payload)))
+	binary.BigEndian.PutUint64(header[8:16], r.seq)
+	crc := crc32.Update(0, crcTable, header[8:16])
+	crc = crc32.Update(crc, crcTable, r.payload)
+	binary.BigEndian.PutUint32(header[4:8], crc)
+	buf = append(buf, header[:]...)
+	return append(buf, r.payload...)
+}
+
+// readSegment calls fn for every intact record of a segment and returns the
+// byte offset just past the last intact record. Reading stops at the first
+// torn or corrupt record, which is reported as errCorruptRecord.
+func readSegment(path string, fn func(record)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReaderSize(f, 64<<10)
+	var offset int64
+	var header [recordHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(rd, header[:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, errCorruptRecord
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return offset, errCorruptRecord
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(rd, payload); err != nil {
+			return offset, errCorruptRecord
+		}
+		crc := crc32.Update(0, crcTable, header[8:16])
+		crc = crc32.Update(crc, crcTable, payload)
+		if crc != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, errCorruptRecord
+		}
+		fn(record{seq: binary.BigEndian.Uint64(header[8:16]), payload: payload})
+		offset += recordHeaderSize + int64(size)
+	}
+}
+
+// syncDir fsyncs a directory so that file creations, renames and removals in
+// it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
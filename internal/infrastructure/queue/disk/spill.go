@@ -0,0 +1,267 @@
+package disk
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// A spill file starts with the offset of its first unread record, followed
+// by records in the segment format:
+//
+//	[8 byte read offset][record][record]...
+//
+// The read offset is rewritten in place whenever a record is popped, so a
+// restarted process continues where the last one stopped reading.
+const spillHeaderSize = 8
+
+// SpillFile is a FIFO of jobs in a single file. Jobs are appended at the end
+// and read from the front; once every job has been read, the file is
+// truncated. It is not safe for concurrent use.
+type SpillFile struct {
+	path    string
+	f       *os.File
+	readOff int64 // Offset of the first unread record
+	size    int64 // Offset just past the last intact record
+	count   int   // Unread records
+	nextSeq uint64
+}
+
+// OpenSpillFile opens (or creates) the spill file at path. A torn record at
+// its end is truncated away.
+func OpenSpillFile(path string) (*SpillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	s := &SpillFile{path: path, f: f, readOff: spillHeaderSize, size: spillHeaderSize}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the header and counts the unread records.
+func (s *SpillFile) load() error {
+	var header [spillHeaderSize]byte
+	n, err := s.f.ReadAt(header[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read spill file header: %w", err)
+	}
+	if n < spillHeaderSize {
+		return s.reset()
+	}
+	if off := int64(binary.BigEndian.Uint64(header[:])); off > spillHeaderSize {
+		s.readOff = off
+	}
+
+	for off := int64(spillHeaderSize); ; {
+		r, size, err := s.readAt(off)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if err := s.f.Truncate(off); err != nil {
+				return fmt.Errorf("failed to truncate spill file: %w", err)
+			}
+			break
+		}
+		if off >= s.readOff {
+			s.count++
+		}
+		s.nextSeq = r.seq + 1
+		off += size
+		s.size = off
+	}
+	if s.readOff > s.size {
+		s.readOff = s.size
+	}
+	if s.count == 0 {
+		return s.reset()
+	}
+	return nil
+}
+
+// readAt reads the record at off and returns it with its size on disk. It
+// returns io.EOF at the end of the file and errCorruptRecord for a torn or
+// corrupt record.
+func (s *SpillFile) readAt(off int64) (record, int64, error) {
+	var header [recordHeaderSize]byte
+	n, err := s.f.ReadAt(header[:], off)
+	if n == 0 && errors.Is(err, io.EOF) {
+		return record{}, 0, io.EOF
+	}
+	if n < recordHeaderSize {
+		return record{}, 0, errCorruptRecord
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return record{}, 0, errCorruptRecord
+	}
+	payload := make([]byte, size)
+	if n, _ := s.f.ReadAt(payload, off+recordHeaderSize); n < int(size) {
+		return record{}, 0, errCorruptRecord
+	}
+	crc := crc32.Update(0, crcTable, header[8:16])
+	crc = crc32.Update(crc, crcTable, payload)
+	if crc != binary.BigEndian.Uint32(header[4:8]) {
+		return record{}, 0, errCorruptRecord
+	}
+	return record{seq: binary.BigEndian.Uint64(header[8:16]), payload: payload}, recordHeaderSize + int64(size), nil
+}
+
+// Len returns the number of unread jobs.
+func (s *SpillFile) Len() int {
+	return s.count
+}
+
+// Append adds a job at the end. The record is handed to the operating system
+// right away, so it survives a crash of the process; Sync makes it survive a
+// power loss.
+func (s *SpillFile) Append(job domain.EmailJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	buf := encodeRecord(nil, record{seq: s.nextSeq, payload: payload})
+	if _, err := s.f.WriteAt(buf, s.size); err != nil {
+		return fmt.Errorf("failed to append to spill file: %w", err)
+	}
+	s.nextSeq++
+	s.size += int64(len(buf))
+	s.count++
+	return nil
+}
+
+// Peek returns the first unread job without removing it.
+func (s *SpillFile) Peek() (domain.EmailJob, error) {
+	if s.count == 0 {
+		return domain.EmailJob{}, errors.New("spill file is empty")
+	}
+	r, _, err := s.readAt(s.readOff)
+	if err != nil {
+		return domain.EmailJob{}, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	var job domain.EmailJob
+	if err := json.Unmarshal(r.payload, &job); err != nil {
+		return domain.EmailJob{}, fmt.Errorf("failed to unmarshal spilled job: %w", err)
+	}
+	return job, nil
+}
+
+// Pop removes the first unread job. Once the file is read to its end, it is
+// truncated so it does not grow without bound.
+func (s *SpillFile) Pop() error {
+	if s.count == 0 {
+		return errors.New("spill file is empty")
+	}
+	_, size, err := s.readAt(s.readOff)
+	if err != nil {
+		return fmt.Errorf("failed to read spill file: %w", err)
+	}
+	s.count--
+	if s.count == 0 {
+		return s.reset()
+	}
+	s.readOff += size
+	return s.writeHeader()
+}
+
+// Prepend puts jobs in front of the unread ones, keeping their order. The
+// file is rewritten to a temporary file that replaces it atomically.
+func (s *SpillFile) Prepend(jobs []domain.EmailJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	buf := make([]byte, spillHeaderSize)
+	binary.BigEndian.PutUint64(buf, spillHeaderSize)
+	var seq uint64
+	for _, job := range jobs {
+		payload, err := json.Marshal(job)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to marshal job: %w", err)
+		}
+		buf = encodeRecord(buf, record{seq: seq, payload: payload})
+		seq++
+	}
+	for off := s.readOff; off < s.size; {
+		r, size, err := s.readAt(off)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to read spill file: %w", err)
+		}
+		buf = encodeRecord(buf, record{seq: seq, payload: r.payload})
+		seq++
+		off += size
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync spill file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to replace spill file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync spill directory: %w", err)
+	}
+
+	s.f.Close()
+	s.f = tmp
+	s.readOff = spillHeaderSize
+	s.size = int64(len(buf))
+	s.count += len(jobs)
+	s.nextSeq = seq
+	return nil
+}
+
+// reset empties the file.
+func (s *SpillFile) reset() error {
+	if err := s.f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate spill file: %w", err)
+	}
+	s.readOff = spillHeaderSize
+	s.size = spillHeaderSize
+	s.count = 0
+	s.nextSeq = 0
+	return s.writeHeader()
+}
+
+func (s *SpillFile) writeHeader() error {
+	var header [spillHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:], uint64(s.readOff))
+	if _, err := s.f.WriteAt(header[:], 0); err != nil {
+		return fmt.Errorf("failed to update spill file header: %w", err)
+	}
+	return nil
+}
+
+// Sync flushes the file to stable storage.
+func (s *SpillFile) Sync() error {
+	return s.f.Sync()
+}
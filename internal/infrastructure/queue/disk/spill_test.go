@@ -0,0 +1,113 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func openTestSpill(t *testing.T, path string) *SpillFile {
+	t.Helper()
+	s, err := OpenSpillFile(path)
+	if err != nil {
+		t.Fatalf("OpenSpillFile() error = %v", err)
+	}
+	t.Cleanup(func() { s.f.Close() })
+	return s
+}
+
+func appendSpilled(t *testing.T, s *SpillFile, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := s.Append(domain.EmailJob{ID: id, To: id + "@example.com"}); err != nil {
+			t.Fatalf("Append(%s) error = %v", id, err)
+		}
+	}
+}
+
+// popSpilled reads the spill file empty and returns the job IDs in order.
+func popSpilled(t *testing.T, s *SpillFile) []string {
+	t.Helper()
+	var ids []string
+	for s.Len() > 0 {
+		job, err := s.Peek()
+		if err != nil {
+			t.Fatalf("Peek() error = %v", err)
+		}
+		if err := s.Pop(); err != nil {
+			t.Fatalf("Pop() error = %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
+
+func TestSpillFileContinuesWhereReadingStopped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normal.spill")
+	s := openTestSpill(t, path)
+	appendSpilled(t, s, "1", "2", "3")
+
+	if job, err := s.Peek(); err != nil || job.ID != "1" {
+		t.Fatalf("Peek() = %s, %v; want 1", job.ID, err)
+	}
+	if err := s.Pop(); err != nil {
+		t.Fatalf("Pop() error = %v", err)
+	}
+
+	// A restarted process does not read the popped job again.
+	reopened := openTestSpill(t, path)
+	if n := reopened.Len(); n != 2 {
+		t.Fatalf("reopened spill file holds %d jobs, want 2", n)
+	}
+	if got := popSpilled(t, reopened); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("read %v, want [2 3]", got)
+	}
+
+	// Read to its end, the file is truncated.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != spillHeaderSize {
+		t.Errorf("empty spill file is %d bytes, want only the header", info.Size())
+	}
+	if _, err := reopened.Peek(); err == nil {
+		t.Errorf("Peek() of an empty spill file succeeded, want an error")
+	}
+}
+
+func TestSpillFileDropsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normal.spill")
+	s := openTestSpill(t, path)
+	appendSpilled(t, s, "kept")
+
+	buf := encodeRecord(nil, record{seq: 1, payload: []byte(`{"id":"torn"}`)})
+	appendBytes(t, path, buf[:len(buf)-3])
+
+	reopened := openTestSpill(t, path)
+	appendSpilled(t, reopened, "after")
+	if got := popSpilled(t, reopened); len(got) != 2 || got[0] != "kept" || got[1] != "after" {
+		t.Errorf("read %v, want the intact job and the one appended after the tear", got)
+	}
+}
+
+func TestSpillFilePrependKeepsOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "normal.spill")
+	s := openTestSpill(t, path)
+	appendSpilled(t, s, "read", "3", "4")
+	if err := s.Pop(); err != nil {
+		t.Fatalf("Pop() error = %v", err)
+	}
+
+	if err := s.Prepend([]domain.EmailJob{{ID: "1"}, {ID: "2"}}); err != nil {
+		t.Fatalf("Prepend() error = %v", err)
+	}
+	appendSpilled(t, s, "5")
+
+	reopened := openTestSpill(t, path)
+	if got := popSpilled(t, reopened); len(got) != 5 || got[0] != "1" || got[1] != "2" || got[2] != "3" || got[4] != "5" {
+		t.Errorf("read %v, want [1 2 3 4 5]", got)
+	}
+}
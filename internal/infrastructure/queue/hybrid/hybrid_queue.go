@@ -0,0 +1,237 @@
+package hybrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/infrastructure/queue/disk"
+	"email-queue-service/internal/infrastructure/queue/memory"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// HybridQueue implements the ports.Queue interface with a bounded in-memory
+// buffer, a MemoryQueue, backed by one spill file per priority lane. Jobs
+// that do not fit into the buffer are appended to the spill file of their
+// lane, and so is every later job of that lane until the file is read empty,
+// which keeps each lane in FIFO order. Spilled jobs are moved into the
+// buffer as workers make room. On Close, the jobs still in the buffer are
+// written in front of the spilled ones, so a graceful shutdown loses nothing.
+type HybridQueue struct {
+	mem        *memory.MemoryQueue
+	spills     map[domain.Priority]*disk.SpillFile
+	dir        string
+	logger     *logger.Logger
+	queueDepth *metrics.QueueDepth
+
+	mu     sync.Mutex // Serializes spilling and refilling, so lanes stay in order
+	closed bool
+}
+
+// NewHybridQueue creates a HybridQueue whose buffer holds capacity jobs and
+// whose spill files live in dir. Jobs spilled before the last shutdown or
+// crash are recovered and refill the buffer right away.
+func NewHybridQueue(capacity int, dir string, l *logger.Logger, queueDepth *metrics.QueueDepth) (*HybridQueue, error) {
+	q := &HybridQueue{
+		mem:        memory.NewMemoryQueue(capacity, memory.Overflow{Policy: memory.OverflowReject}, l, queueDepth),
+		spills:     make(map[domain.Priority]*disk.SpillFile),
+		dir:        dir,
+		logger:     l,
+		queueDepth: queueDepth,
+	}
+	recovered := 0
+	for _, lane := range domain.Priorities {
+		spill, err := disk.OpenSpillFile(filepath.Join(dir, string(lane)+".spill"))
+		if err != nil {
+			return nil, err
+		}
+		q.spills[lane] = spill
+		q.queueDepth.Set(lane, float64(spill.Len()))
+		recovered += spill.Len()
+	}
+	if recovered > 0 {
+		l.Printf("Recovered %d spilled jobs from %s", recovered, dir)
+	}
+
+	q.mu.Lock()
+	q.refillLocked()
+	q.mu.Unlock()
+	return q, nil
+}
+
+// Enqueue adds a job to the buffer, or to the spill file of its lane if the
+// buffer is full or the lane already has spilled jobs.
+func (q *HybridQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.enqueueLocked(ctx, job)
+}
+
+// EnqueueBatch adds jobs under a single lock, so the batch is not
+// interleaved with other producers.
+func (q *HybridQueue) EnqueueBatch(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	if err := ctx.Err(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range jobs {
+		errs[i] = q.enqueueLocked(ctx, job)
+	}
+	return errs
+}
+
+// enqueueLocked adds a job to the buffer or spills it. q.mu must be held.
+func (q *HybridQueue) enqueueLocked(ctx context.Context, job domain.EmailJob) error {
+	if q.closed {
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+	}
+
+	if q.spills[job.Lane()].Len() == 0 {
+		err := q.mem.Enqueue(ctx, job)
+		if !errors.Is(err, ports.ErrQueueFull) {
+			return err
+		}
+	}
+	return q.spillLocked(job)
+}
+
+// spillLocked appends a job to the spill file of its lane. q.mu must be held.
+func (q *HybridQueue) spillLocked(job domain.EmailJob) error {
+	job.Receipt = ""
+	if err := q.spills[job.Lane()].Append(job); err != nil {
+		return fmt.Errorf("failed to spill job to disk: %w", err)
+	}
+	q.queueDepth.Inc(job.Lane())
+	return nil
+}
+
+// refillLocked moves spilled jobs into the buffer while it has room, from
+// the highest priority lane first. q.mu must be held.
+func (q *HybridQueue) refillLocked() {
+	for _, lane := range domain.Priorities {
+		spill := q.spills[lane]
+		for spill.Len() > 0 {
+			job, err := spill.Peek()
+			if err != nil {
+				q.logger.Errorf("Failed to read spilled %s priority job: %v", lane, err)
+				break
+			}
+			if err := q.mem.Enqueue(context.Background(), job); err != nil {
+				if !errors.Is(err, ports.ErrQueueFull) {
+					q.logger.Errorf("Failed to refill %s priority job from disk: %v", lane, err)
+				}
+				return
+			}
+			// Popped only once it is in the buffer, so a failure in between
+			// delivers the job twice rather than losing it.
+			if err := spill.Pop(); err != nil {
+				q.logger.Errorf("Failed to remove refilled %s priority job from disk: %v", lane, err)
+				break
+			}
+			q.queueDepth.Dec(lane)
+		}
+	}
+}
+
+// Dequeue retrieves a job from the buffer and refills the room it leaves
+// from disk. Once the queue is closed, the jobs left in the buffer are on
+// disk, so there is nothing to drain.
+func (q *HybridQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	if q.IsClosed() {
+		return domain.EmailJob{}, ports.ErrQueueClosed
+	}
+	job, err := q.mem.Dequeue(ctx, lanes)
+	if err != nil {
+		return domain.EmailJob{}, err
+	}
+
+	q.mu.Lock()
+	if !q.closed {
+		q.refillLocked()
+	}
+	q.mu.Unlock()
+	return job, nil
+}
+
+// Ack marks a dequeued job as done.
+func (q *HybridQueue) Ack(job domain.EmailJob) error {
+	return q.mem.Ack(job)
+}
+
+// Nack puts a dequeued job back at the end of its lane, on disk if the
+// buffer is full or the queue was closed in the meantime.
+func (q *HybridQueue) Nack(job domain.EmailJob) error {
+	err := q.mem.Nack(job)
+	if !errors.Is(err, ports.ErrQueueFull) && !errors.Is(err, ports.ErrQueueClosed) {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.spillLocked(job)
+}
+
+// Close stops accepting new jobs and persists the jobs left in the buffer
+// in front of the spilled ones, so that the next start delivers them first.
+func (q *HybridQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.mem.Close()
+
+	// A closed MemoryQueue hands out its remaining jobs without blocking.
+	tail := make(map[domain.Priority][]domain.EmailJob)
+	persisted := 0
+	for {
+		job, err := q.mem.Dequeue(context.Background(), domain.Priorities)
+		if err != nil {
+			break
+		}
+		job.Receipt = ""
+		tail[job.Lane()] = append(tail[job.Lane()], job)
+	}
+	for _, lane := range domain.Priorities {
+		spill := q.spills[lane]
+		if err := spill.Prepend(tail[lane]); err != nil {
+			q.logger.Errorf("Failed to persist %d queued %s priority jobs: %v", len(tail[lane]), lane, err)
+			continue
+		}
+		q.queueDepth.Add(lane, float64(len(tail[lane])))
+		persisted += len(tail[lane])
+		if err := spill.Sync(); err != nil {
+			q.logger.Errorf("Failed to sync %s priority spill file: %v", lane, err)
+		}
+	}
+	q.logger.Printf("Hybrid queue closed, persisted %d queued jobs to %s.", persisted, q.dir)
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *HybridQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Ensure HybridQueue implements the ports.BatchQueue interface
+var _ ports.BatchQueue = (*HybridQueue)(nil)
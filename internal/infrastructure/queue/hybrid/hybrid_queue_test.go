@@ -0,0 +1,154 @@
+package hybrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+func openTestQueue(t *testing.T, capacity int, dir string) (*HybridQueue, *prometheus.GaugeVec) {
+	t.Helper()
+	lanes := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"})
+	depth := metrics.NewQueueDepth(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"}), lanes)
+	q, err := NewHybridQueue(capacity, dir, logger.NewLogger(), depth)
+	if err != nil {
+		t.Fatalf("NewHybridQueue() error = %v", err)
+	}
+	return q, lanes
+}
+
+func enqueueIDs(t *testing.T, q *HybridQueue, lane domain.Priority, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := q.Enqueue(context.Background(), domain.EmailJob{ID: id, Priority: lane}); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", id, err)
+		}
+	}
+}
+
+func dequeueIDs(t *testing.T, q *HybridQueue, n int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		job, err := q.Dequeue(ctx, domain.Priorities)
+		cancel()
+		if err != nil {
+			t.Fatalf("Dequeue() error = %v", err)
+		}
+		if err := q.Ack(job); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
+
+func TestHybridQueueSpillsInOrder(t *testing.T) {
+	q, lanes := openTestQueue(t, 2, t.TempDir())
+	defer q.Close()
+
+	enqueueIDs(t, q, domain.PriorityNormal, "1", "2", "3", "4", "5")
+	if n := q.spills[domain.PriorityNormal].Len(); n != 3 {
+		t.Errorf("spill file holds %d jobs, want the 3 that did not fit", n)
+	}
+	if got := testutil.ToFloat64(lanes.WithLabelValues(string(domain.PriorityNormal))); got != 5 {
+		t.Errorf("normal lane depth = %v, want the buffered and spilled jobs", got)
+	}
+
+	// Jobs enqueued while the lane has spilled jobs queue up behind them,
+	// even when the buffer has room again.
+	first := dequeueIDs(t, q, 1)
+	enqueueIDs(t, q, domain.PriorityNormal, "6")
+	got := append(first, dequeueIDs(t, q, 5)...)
+	if fmt.Sprint(got) != "[1 2 3 4 5 6]" {
+		t.Errorf("dequeued %v, want [1 2 3 4 5 6]", got)
+	}
+	if got := testutil.ToFloat64(lanes.WithLabelValues(string(domain.PriorityNormal))); got != 0 {
+		t.Errorf("normal lane depth after draining = %v, want 0", got)
+	}
+}
+
+func TestHybridQueueRefillsHigherLanesFirst(t *testing.T) {
+	q, _ := openTestQueue(t, 1, t.TempDir())
+	defer q.Close()
+
+	enqueueIDs(t, q, domain.PriorityNormal, "normal-1", "normal-2")
+	enqueueIDs(t, q, domain.PriorityHigh, "high")
+
+	if got := dequeueIDs(t, q, 3); fmt.Sprint(got) != "[normal-1 high normal-2]" {
+		t.Errorf("dequeued %v, want the spilled high priority job refilled first", got)
+	}
+}
+
+func TestHybridQueuePersistsBufferOnClose(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := openTestQueue(t, 2, dir)
+	enqueueIDs(t, q, domain.PriorityNormal, "1", "2", "3")
+	enqueueIDs(t, q, domain.PriorityHigh, "high")
+	q.Close()
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "late"}); !errors.Is(err, ports.ErrQueueClosed) {
+		t.Errorf("Enqueue() after Close error = %v, want ErrQueueClosed", err)
+	}
+	if _, err := q.Dequeue(context.Background(), domain.Priorities); !errors.Is(err, ports.ErrQueueClosed) {
+		t.Errorf("Dequeue() after Close error = %v, want ErrQueueClosed", err)
+	}
+
+	// The buffered jobs come back in front of the spilled ones.
+	reopened, lanes := openTestQueue(t, 10, dir)
+	defer reopened.Close()
+	if got := testutil.ToFloat64(lanes.WithLabelValues(string(domain.PriorityNormal))); got != 3 {
+		t.Errorf("recovered normal lane depth = %v, want 3", got)
+	}
+	if got := dequeueIDs(t, reopened, 4); fmt.Sprint(got) != "[high 1 2 3]" {
+		t.Errorf("dequeued %v after restart, want [high 1 2 3]", got)
+	}
+}
+
+func TestHybridQueueNackSpillsWhenBufferIsFull(t *testing.T) {
+	q, _ := openTestQueue(t, 1, t.TempDir())
+	defer q.Close()
+
+	enqueueIDs(t, q, domain.PriorityNormal, "1")
+	job, err := q.Dequeue(context.Background(), domain.Priorities)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	enqueueIDs(t, q, domain.PriorityBulk, "bulk")
+
+	if err := q.Nack(job); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	if n := q.spills[domain.PriorityNormal].Len(); n != 1 {
+		t.Errorf("spill file holds %d jobs, want the nacked job", n)
+	}
+	if got := dequeueIDs(t, q, 2); fmt.Sprint(got) != "[bulk 1]" {
+		t.Errorf("dequeued %v, want the nacked job after the buffered one", got)
+	}
+}
+
+func TestHybridQueueEnqueueBatch(t *testing.T) {
+	q, _ := openTestQueue(t, 1, t.TempDir())
+	defer q.Close()
+
+	jobs := []domain.EmailJob{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	for i, err := range q.EnqueueBatch(context.Background(), jobs) {
+		if err != nil {
+			t.Errorf("EnqueueBatch() error of job %d = %v", i, err)
+		}
+	}
+	if got := dequeueIDs(t, q, 3); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("dequeued %v, want [1 2 3]", got)
+	}
+}
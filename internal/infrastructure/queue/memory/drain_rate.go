@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// drainRateAlpha is the weight of the latest second in the drain rate.
+	drainRateAlpha = 0.3
+
+	minRetryAfter = time.Second
+	maxRetryAfter = time.Minute
+)
+
+// drainRate estimates how many jobs per second leave the queue, as an
+// exponentially weighted moving average over one-second buckets. It is not
+// safe for concurrent use; the queue guards it with its mutex.
+type drainRate struct {
+	rate        float64 // Jobs per second
+	count       int     // Jobs drained in the current bucket
+	bucketStart time.Time
+}
+
+func newDrainRate(now time.Time) *drainRate {
+	return &drainRate{bucketStart: now}
+}
+
+// observe records a job leaving the queue.
+func (d *drainRate) observe(now time.Time) {
+	d.roll(now)
+	d.count++
+}
+
+// roll folds the current bucket into the average once it is a second old.
+// Idle seconds count as buckets without jobs, so the rate decays while the
+// workers are stuck.
+func (d *drainRate) roll(now time.Time) {
+	elapsed := now.Sub(d.bucketStart).Seconds()
+	if elapsed < 1 {
+		return
+	}
+	weight := 1 - math.Pow(1-drainRateAlpha, elapsed)
+	d.rate = weight*(float64(d.count)/elapsed) + (1-weight)*d.rate
+	d.count = 0
+	d.bucketStart = now
+}
+
+// retryAfter returns how long draining backlog jobs takes at the current
+// rate, between minRetryAfter and maxRetryAfter, rounded up to whole seconds
+// for the Retry-After header.
+func (d *drainRate) retryAfter(now time.Time, backlog int) time.Duration {
+	d.roll(now)
+	if d.rate <= 0 {
+		return maxRetryAfter
+	}
+	wait := time.Duration(math.Ceil(float64(backlog)/d.rate)) * time.Second
+	switch {
+	case wait < minRetryAfter:
+		return minRetryAfter
+	case wait > maxRetryAfter:
+		return maxRetryAfter
+	}
+	return wait
+}
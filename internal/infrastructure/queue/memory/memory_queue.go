@@ -1,72 +1,332 @@
 package memory
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
-
-	"github.com/prometheus/client_golang/prometheus"
+	"time"
 
 	"email-queue-service/internal/core/domain"
 	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// OverflowPolicy decides what Enqueue does when the queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowReject fails the enqueue right away.
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowBlock waits up to Overflow.Timeout for a worker to make room.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest makes room by moving the oldest job of the lowest
+	// priority lane to the DLQ. Only jobs of the same or a lower priority
+	// than the new one are dropped; without such a job, the enqueue fails.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
 )
 
-// MemoryQueue implements an in-memory job queue using a Go channel.
+// Overflow configures how a full MemoryQueue handles new jobs.
+type Overflow struct {
+	Policy  OverflowPolicy
+	Timeout time.Duration         // How long OverflowBlock waits for room
+	DLQ     ports.DeadLetterQueue // Receives the jobs OverflowDropOldest drops, once the lock is released
+}
+
+// droppedJob is a job dropped by OverflowDropOldest that is yet to be moved
+// to the DLQ.
+type droppedJob struct {
+	job    domain.EmailJob
+	reason string
+}
+
+// MemoryQueue implements an in-memory job queue using a Go channel per
+// priority lane. The lanes share the capacity of the queue.
 type MemoryQueue struct {
-	Jobs     chan domain.EmailJob
-	capacity int
-	mu       sync.Mutex // Protects access to the channel state (e.g., closed status)
-	closed   bool
-	queueLengthGauge prometheus.Gauge
+	lanes       map[domain.Priority]chan domain.EmailJob
+	capacity    int
+	overflow    Overflow
+	size        int        // Jobs buffered across all lanes
+	mu          sync.Mutex // Protects access to the channel state (e.g., closed status)
+	closed      bool
+	room        chan struct{} // Closed and replaced whenever a job leaves the queue
+	drained     *drainRate
+	queueDepth  *metrics.QueueDepth
+	logger      *logger.Logger
+	inFlight    map[string]domain.EmailJob // Dequeued jobs awaiting Ack/Nack, keyed by receipt
+	nextReceipt uint64
+	dropped     []droppedJob // Dropped under q.mu, dead-lettered by unlock
 }
 
-// NewMemoryQueue creates a new MemoryQueue with the given capacity.
-func NewMemoryQueue(capacity int, queueLengthGauge prometheus.Gauge) *MemoryQueue {
+// NewMemoryQueue creates a new MemoryQueue with the given capacity. overflow
+// decides what happens to jobs enqueued while it is full.
+func NewMemoryQueue(capacity int, overflow Overflow, l *logger.Logger, queueDepth *metrics.QueueDepth) *MemoryQueue {
+	if overflow.Policy == "" {
+		overflow.Policy = OverflowReject
+	}
 	q := &MemoryQueue{
-		Jobs:     make(chan domain.EmailJob, capacity),
-		capacity: capacity,
-		closed:   false,
-		queueLengthGauge: queueLengthGauge,
+		lanes:      make(map[domain.Priority]chan domain.EmailJob),
+		capacity:   capacity,
+		overflow:   overflow,
+		closed:     false,
+		room:       make(chan struct{}),
+		drained:    newDrainRate(time.Now()),
+		queueDepth: queueDepth,
+		logger:     l,
+		inFlight:   make(map[string]domain.EmailJob),
+	}
+	for _, lane := range domain.Priorities {
+		q.lanes[lane] = make(chan domain.EmailJob, capacity)
 	}
-	q.queueLengthGauge.Set(0) // Initialize gauge
 	return q
 }
 
-// Enqueue adds a job to the queue. Returns an error if the queue is full or closed.
-func (q *MemoryQueue) Enqueue(job domain.EmailJob) error {
+// Enqueue adds a job to the queue. Returns an error if the queue is full or
+// closed, after waiting for room if the overflow policy is OverflowBlock.
+func (q *MemoryQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(q.overflow.Timeout)
+
 	q.mu.Lock()
-	defer q.mu.Unlock()
+	defer q.unlock()
+
+	return q.enqueueWaitLocked(ctx, job, deadline)
+}
 
+// EnqueueBatch adds jobs to the queue in order, under a single lock for as
+// long as there is room. Jobs that do not fit are handled by the overflow
+// policy; with OverflowBlock the lock is released while waiting for room, so
+// jobs of other producers may land between those of the batch, and the whole
+// batch shares one deadline.
+func (q *MemoryQueue) EnqueueBatch(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	if err := ctx.Err(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	deadline := time.Now().Add(q.overflow.Timeout)
+
+	q.mu.Lock()
+	defer q.unlock()
+
+	for i, job := range jobs {
+		errs[i] = q.enqueueWaitLocked(ctx, job, deadline)
+	}
+	return errs
+}
+
+// enqueueWaitLocked pushes a job onto its lane. With OverflowBlock, it waits
+// for room until deadline, releasing q.mu meanwhile. q.mu must be held.
+func (q *MemoryQueue) enqueueWaitLocked(ctx context.Context, job domain.EmailJob, deadline time.Time) error {
+	for {
+		err := q.enqueueLocked(job)
+		if q.overflow.Policy != OverflowBlock || !errors.Is(err, ports.ErrQueueFull) {
+			return err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return err
+		}
+
+		room := q.room
+		q.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-room:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		q.mu.Lock()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// enqueueLocked pushes a job onto the channel, dropping an older job first
+// if the overflow policy is OverflowDropOldest. q.mu must be held.
+func (q *MemoryQueue) enqueueLocked(job domain.EmailJob) error {
 	if q.closed {
-		return fmt.Errorf("queue is closed, cannot enqueue new jobs")
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+	}
+
+	if q.size >= q.capacity && !(q.overflow.Policy == OverflowDropOldest && q.dropOldestLocked(job.Lane())) {
+		return &ports.QueueFullError{RetryAfter: q.drained.retryAfter(time.Now(), q.size)}
 	}
 
-	select {
-	case q.Jobs <- job:
-		q.queueLengthGauge.Inc() // Increment gauge on enqueue
-		return nil
-	default:
-		return fmt.Errorf("queue is full, cannot enqueue job")
+	// Every lane channel can hold the full capacity, so this never blocks.
+	job.Receipt = ""
+	q.lanes[job.Lane()] <- job
+	q.size++
+	q.queueDepth.Inc(job.Lane())
+	return nil
+}
+
+// dropOldestLocked drops the oldest job of the lowest priority lane, but no
+// higher than lane, for unlock to move to the DLQ, and reports whether it
+// made room. q.mu must be held.
+func (q *MemoryQueue) dropOldestLocked(lane domain.Priority) bool {
+	for i := len(domain.Priorities) - 1; i >= 0; i-- {
+		candidate := domain.Priorities[i]
+		select {
+		case dropped := <-q.lanes[candidate]:
+			q.size--
+			q.queueDepth.Dec(candidate)
+			q.logger.Warnf("Queue is full, dropping %s priority email to %s for a %s priority one", candidate, dropped.To, lane)
+			q.dropped = append(q.dropped, droppedJob{job: dropped, reason: fmt.Sprintf("Dropped from the full queue for a %s priority job", lane)})
+			return true
+		default:
+		}
+		if candidate == lane {
+			break
+		}
+	}
+	return false
+}
+
+// unlock releases q.mu and then moves the jobs dropped meanwhile to the DLQ.
+// The DLQ updates the status and metrics of the jobs, so it is not called
+// with q.mu held.
+func (q *MemoryQueue) unlock() {
+	dropped := q.dropped
+	q.dropped = nil
+	q.mu.Unlock()
+
+	if q.overflow.DLQ == nil {
+		return
+	}
+	for _, d := range dropped {
+		q.overflow.DLQ.Store(d.job, d.reason, nil)
 	}
 }
 
-// Dequeue retrieves a job from the queue. Returns the job and true if successful,
-// or an empty job and false if the queue is closed and empty.
-func (q *MemoryQueue) Dequeue() (domain.EmailJob, bool) {
-	job, ok := <-q.Jobs
-	if ok {
-		q.queueLengthGauge.Dec() // Decrement gauge on dequeue
+// Dequeue retrieves a job from the first lane in lanes that has one. Once
+// the queue is closed, the remaining jobs are still handed out before
+// ErrQueueClosed is returned.
+func (q *MemoryQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	chans := make([]chan domain.EmailJob, len(lanes))
+	for i, lane := range lanes {
+		chans[i] = q.lanes[lane]
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		// Take from the first lane that has a job ready, in preference order.
+		open := 0
+		for i, ch := range chans {
+			if ch == nil {
+				continue
+			}
+			select {
+			case job, ok := <-ch:
+				if ok {
+					return q.delivered(job), nil
+				}
+				chans[i] = nil // Closed and drained
+				continue
+			default:
+			}
+			open++
+		}
+		if open == 0 {
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+
+		// Every lane is empty: wait for whichever receives a job first.
+		// There are at most three lanes, so a fixed select covers them.
+		var c [3]chan domain.EmailJob
+		copy(c[:], chans)
+		var job domain.EmailJob
+		var ok bool
+		var i int
+		select {
+		case job, ok = <-c[0]:
+			i = 0
+		case job, ok = <-c[1]:
+			i = 1
+		case job, ok = <-c[2]:
+			i = 2
+		case <-ctx.Done():
+			return domain.EmailJob{}, ctx.Err()
+		}
+		if ok {
+			return q.delivered(job), nil
+		}
+		chans[i] = nil
+	}
+}
+
+// delivered records a job taken from a lane as in flight.
+func (q *MemoryQueue) delivered(job domain.EmailJob) domain.EmailJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.size--
+	q.queueDepth.Dec(job.Lane())
+	q.drained.observe(time.Now())
+	q.signalRoomLocked()
+	q.nextReceipt++
+	job.Receipt = strconv.FormatUint(q.nextReceipt, 10)
+	q.inFlight[job.Receipt] = job
+	return job
+}
+
+// signalRoomLocked wakes the enqueues waiting for room. q.mu must be held.
+func (q *MemoryQueue) signalRoomLocked() {
+	close(q.room)
+	q.room = make(chan struct{})
+}
+
+// Ack marks a dequeued job as done.
+func (q *MemoryQueue) Ack(job domain.EmailJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.inFlight[job.Receipt]; !ok {
+		return fmt.Errorf("unknown receipt %q, job was not dequeued or already acknowledged", job.Receipt)
+	}
+	delete(q.inFlight, job.Receipt)
+	return nil
+}
+
+// Nack puts a dequeued job back on the queue. It fails if the queue has
+// been closed or is full in the meantime.
+func (q *MemoryQueue) Nack(job domain.EmailJob) error {
+	q.mu.Lock()
+	defer q.unlock()
+
+	original, ok := q.inFlight[job.Receipt]
+	if !ok {
+		return fmt.Errorf("unknown receipt %q, job was not dequeued or already acknowledged", job.Receipt)
+	}
+	delete(q.inFlight, job.Receipt)
+	if err := q.enqueueLocked(original); err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
 	}
-	return job, ok
+	return nil
 }
 
-// Close closes the job channel, signaling that no more jobs will be enqueued.
+// Close closes the lane channels, signaling that no more jobs will be enqueued.
 func (q *MemoryQueue) Close() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
 	if !q.closed {
-		close(q.Jobs)
+		for _, ch := range q.lanes {
+			close(ch)
+		}
 		q.closed = true
+		q.signalRoomLocked() // Waiting enqueues fail now
 	}
 }
 
@@ -77,5 +337,5 @@ func (q *MemoryQueue) IsClosed() bool {
 	return q.closed
 }
 
-// Ensure MemoryQueue implements the ports.Queue interface
-var _ ports.Queue = (*MemoryQueue)(nil)
+// Ensure MemoryQueue implements the ports.BatchQueue interface
+var _ ports.BatchQueue = (*MemoryQueue)(nil)
@@ -0,0 +1,286 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+type testDepthGauges struct {
+	total prometheus.Gauge
+	lanes *prometheus.GaugeVec
+}
+
+func newTestDepth() (*metrics.QueueDepth, testDepthGauges) {
+	g := testDepthGauges{
+		total: prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"}),
+		lanes: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"}),
+	}
+	return metrics.NewQueueDepth(g.total, g.lanes), g
+}
+
+func newTestQueue(capacity int, overflow Overflow) *MemoryQueue {
+	depth, _ := newTestDepth()
+	return NewMemoryQueue(capacity, overflow, logger.NewLogger(), depth)
+}
+
+func dequeueNow(t *testing.T, q *MemoryQueue, lanes ...domain.Priority) domain.EmailJob {
+	t.Helper()
+	if len(lanes) == 0 {
+		lanes = domain.Priorities
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	job, err := q.Dequeue(ctx, lanes)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	return job
+}
+
+func TestMemoryQueueLanes(t *testing.T) {
+	depth, gauges := newTestDepth()
+	q := NewMemoryQueue(10, Overflow{}, logger.NewLogger(), depth)
+	defer q.Close()
+
+	ctx := context.Background()
+	for _, job := range []domain.EmailJob{
+		{ID: "bulk", Priority: domain.PriorityBulk},
+		{ID: "normal-1"},
+		{ID: "high", Priority: domain.PriorityHigh},
+		{ID: "normal-2", Priority: domain.PriorityNormal},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(gauges.lanes.WithLabelValues(string(domain.PriorityNormal))); got != 2 {
+		t.Errorf("normal lane depth = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(gauges.total); got != 4 {
+		t.Errorf("queue depth = %v, want 4", got)
+	}
+
+	// The lane order of the caller wins over the priority of the lanes.
+	if got := dequeueNow(t, q, domain.PriorityBulk, domain.PriorityHigh).ID; got != "bulk" {
+		t.Errorf("Dequeue(bulk, high) = %s, want bulk", got)
+	}
+	for _, want := range []string{"high", "normal-1", "normal-2"} {
+		if got := dequeueNow(t, q).ID; got != want {
+			t.Errorf("Dequeue() = %s, want %s", got, want)
+		}
+	}
+	if got := testutil.ToFloat64(gauges.total); got != 0 {
+		t.Errorf("queue depth after draining = %v, want 0", got)
+	}
+}
+
+func TestMemoryQueueDoesNotServeLanesLeftOut(t *testing.T) {
+	q := newTestQueue(10, Overflow{})
+	defer q.Close()
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "bulk", Priority: domain.PriorityBulk}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if job, err := q.Dequeue(ctx, []domain.Priority{domain.PriorityHigh, domain.PriorityNormal}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Dequeue(high, normal) = %s, %v; want no job from the bulk lane", job.ID, err)
+	}
+}
+
+func TestMemoryQueueLanesShareCapacity(t *testing.T) {
+	q := newTestQueue(2, Overflow{})
+	defer q.Close()
+
+	ctx := context.Background()
+	for _, lane := range []domain.Priority{domain.PriorityHigh, domain.PriorityBulk} {
+		if err := q.Enqueue(ctx, domain.EmailJob{Priority: lane}); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", lane, err)
+		}
+	}
+	if err := q.Enqueue(ctx, domain.EmailJob{}); !errors.Is(err, ports.ErrQueueFull) {
+		t.Errorf("Enqueue() into a full queue error = %v, want ErrQueueFull", err)
+	}
+}
+
+// lockingDLQ keeps the jobs moved to the DLQ. Like the service's DLQ, it
+// takes locks of its own, here that of the queue, so it deadlocks if the
+// queue calls it with its lock held.
+type lockingDLQ struct {
+	q       *MemoryQueue
+	dropped []domain.EmailJob
+	reasons []string
+}
+
+func (d *lockingDLQ) Store(job domain.EmailJob, reason string, _ *domain.DeliveryError) {
+	d.q.IsClosed()
+	d.dropped = append(d.dropped, job)
+	d.reasons = append(d.reasons, reason)
+}
+
+func TestMemoryQueueDropOldest(t *testing.T) {
+	dlq := &lockingDLQ{}
+	q := newTestQueue(2, Overflow{Policy: OverflowDropOldest, DLQ: dlq})
+	dlq.q = q
+	defer q.Close()
+
+	ctx := context.Background()
+	for _, job := range []domain.EmailJob{
+		{ID: "normal", Priority: domain.PriorityNormal},
+		{ID: "bulk", Priority: domain.PriorityBulk},
+		{ID: "high", Priority: domain.PriorityHigh},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", job.ID, err)
+		}
+	}
+	// Nothing of a lower or the same priority is left to drop for a bulk job.
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "bulk-2", Priority: domain.PriorityBulk}); !errors.Is(err, ports.ErrQueueFull) {
+		t.Errorf("Enqueue(bulk-2) error = %v, want ErrQueueFull", err)
+	}
+	errs := q.EnqueueBatch(ctx, []domain.EmailJob{{ID: "high-2", Priority: domain.PriorityHigh}})
+	if errs[0] != nil {
+		t.Fatalf("EnqueueBatch(high-2) error = %v", errs[0])
+	}
+
+	if len(dlq.dropped) != 2 || dlq.dropped[0].ID != "bulk" || dlq.dropped[1].ID != "normal" {
+		t.Fatalf("dropped %v, want the bulk job, then the normal one", dlq.dropped)
+	}
+	if !strings.Contains(dlq.reasons[0], "high priority") {
+		t.Errorf("DLQ reason = %q, want the priority of the new job", dlq.reasons[0])
+	}
+	for _, want := range []string{"high", "high-2"} {
+		if got := dequeueNow(t, q).ID; got != want {
+			t.Errorf("Dequeue() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestMemoryQueueDequeueHonoursContext(t *testing.T) {
+	q := newTestQueue(10, Overflow{})
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(ctx, domain.Priorities); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Dequeue() of an empty queue error = %v, want DeadlineExceeded", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.Enqueue(cancelled, domain.EmailJob{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Enqueue() with a cancelled context error = %v, want Canceled", err)
+	}
+}
+
+func TestMemoryQueueDrainsAfterClose(t *testing.T) {
+	q := newTestQueue(10, Overflow{})
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "1"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// A blocked Dequeue is woken up by Close.
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := q.Dequeue(ctx, []domain.Priority{domain.PriorityBulk})
+		blocked <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ports.ErrQueueClosed) {
+			t.Errorf("blocked Dequeue() error = %v, want ErrQueueClosed", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close() did not wake up a blocked Dequeue")
+	}
+
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "2"}); !errors.Is(err, ports.ErrQueueClosed) {
+		t.Errorf("Enqueue() after Close error = %v, want ErrQueueClosed", err)
+	}
+	job := dequeueNow(t, q)
+	if job.ID != "1" {
+		t.Errorf("Dequeue() after Close = %s, want the queued job", job.ID)
+	}
+	if err := q.Ack(job); err != nil {
+		t.Errorf("Ack() after Close error = %v", err)
+	}
+	if _, err := q.Dequeue(ctx, domain.Priorities); !errors.Is(err, ports.ErrQueueClosed) {
+		t.Errorf("Dequeue() of a drained queue error = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestMemoryQueueEnqueueBatch(t *testing.T) {
+	q := newTestQueue(2, Overflow{})
+	defer q.Close()
+
+	errs := q.EnqueueBatch(context.Background(), []domain.EmailJob{{ID: "1"}, {ID: "2"}, {ID: "3"}})
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("EnqueueBatch() errors = %v, want the first two jobs queued", errs)
+	}
+	if !errors.Is(errs[2], ports.ErrQueueFull) {
+		t.Errorf("error of the job that did not fit = %v, want ErrQueueFull", errs[2])
+	}
+	for _, want := range []string{"1", "2"} {
+		if got := dequeueNow(t, q).ID; got != want {
+			t.Errorf("Dequeue() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestMemoryQueueEnqueueBatchWaitsForRoom(t *testing.T) {
+	q := newTestQueue(1, Overflow{Policy: OverflowBlock, Timeout: 3 * time.Second})
+	defer q.Close()
+
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "first"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	batch := make(chan []error, 1)
+	go func() {
+		batch <- q.EnqueueBatch(ctx, []domain.EmailJob{{ID: "batch-1"}, {ID: "batch-2"}})
+	}()
+	// The batch releases the lock while it waits, so another producer is
+	// not locked out and may land between the jobs of the batch.
+	time.Sleep(10 * time.Millisecond)
+	other := make(chan error, 1)
+	go func() {
+		other <- q.Enqueue(ctx, domain.EmailJob{ID: "other"})
+	}()
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, dequeueNow(t, q).ID)
+	}
+	if errs := <-batch; errs[0] != nil || errs[1] != nil {
+		t.Errorf("EnqueueBatch() errors = %v, want both jobs queued once there was room", errs)
+	}
+	if err := <-other; err != nil {
+		t.Errorf("Enqueue() while a batch waits error = %v", err)
+	}
+	if got[0] != "first" || indexOf(got, "batch-1") > indexOf(got, "batch-2") || indexOf(got, "other") < 0 {
+		t.Errorf("dequeued %v, want the first job, then the batch in order and the other job", got)
+	}
+}
+
+func indexOf(ids []string, id string) int {
+	for i, got := range ids {
+		if got == id {
+			return i
+		}
+	}
+	return -1
+}
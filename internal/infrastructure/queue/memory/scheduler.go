@@ -0,0 +1,250 @@
+package memory
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// promoteRetryDelay is how long a due job waits before another promotion
+// attempt when the target queue rejects it (e.g. because it is full).
+const promoteRetryDelay = time.Second
+
+// ScheduledJob is a job held by a Scheduler until At.
+type ScheduledJob struct {
+	ID  uint64
+	At  time.Time
+	Job domain.EmailJob
+}
+
+// Journal persists scheduled jobs so that a Scheduler can be rebuilt after a
+// restart. Append is called before Schedule returns and Remove once the job
+// has been handed to the target queue, possibly while Append runs, so
+// implementations must be safe for concurrent use.
+type Journal interface {
+	Append(job ScheduledJob) error
+	Remove(id uint64) error
+	Close() error
+}
+
+// Scheduler implements the ports.Scheduler interface with an in-memory timer
+// heap. Without a Journal, jobs that are not due yet are lost on restart.
+type Scheduler struct {
+	target    ports.Queue
+	journal   Journal
+	logger    *logger.Logger
+	scheduled *metrics.ScheduledJobs
+
+	mu     sync.Mutex
+	jobs   scheduleHeap
+	nextID uint64
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a Scheduler that promotes due jobs into target.
+func NewScheduler(target ports.Queue, l *logger.Logger, scheduled *metrics.ScheduledJobs) *Scheduler {
+	return NewJournaledScheduler(target, nil, nil, l, scheduled)
+}
+
+// NewJournaledScheduler creates a Scheduler that records its jobs in journal.
+// pending holds the jobs recovered from the journal; they are promoted as
+// soon as they are due, right away if their time passed while stopped.
+func NewJournaledScheduler(target ports.Queue, journal Journal, pending []ScheduledJob, l *logger.Logger, scheduled *metrics.ScheduledJobs) *Scheduler {
+	s := &Scheduler{
+		target:    target,
+		journal:   journal,
+		logger:    l,
+		scheduled: scheduled,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	retries := 0
+	for _, job := range pending {
+		heap.Push(&s.jobs, job)
+		if job.ID >= s.nextID {
+			s.nextID = job.ID + 1
+		}
+		if job.Job.Retries > 0 {
+			retries++
+		}
+	}
+	s.scheduled.Set(float64(len(s.jobs)), float64(retries))
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Schedule holds a job until at and then enqueues it into the target queue.
+// Jobs can still be scheduled after the target queue was closed, e.g. retries
+// of jobs that were being processed; they are kept until Close.
+func (s *Scheduler) Schedule(ctx context.Context, job domain.EmailJob, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("scheduler is closed, cannot schedule new jobs")
+	}
+
+	job.Receipt = ""
+	sj := ScheduledJob{ID: s.nextID, At: at, Job: job}
+	if s.journal != nil {
+		if err := s.journal.Append(sj); err != nil {
+			return err
+		}
+	}
+	s.nextID++
+	heap.Push(&s.jobs, sj)
+	s.scheduled.Inc(job)
+
+	// Only the earliest job decides when the loop has to wake up.
+	if s.jobs[0].ID == sj.ID {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run sleeps until the earliest job is due and promotes every due job.
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		next := s.promoteDue(time.Now())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next.IsZero() {
+			timer.Reset(time.Hour)
+		} else {
+			timer.Reset(time.Until(next))
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// promoteDue moves every job due at now into the target queue and returns
+// the time the next job is due, or the zero time if there is none. The due
+// jobs are taken off the heap under s.mu and enqueued after releasing it, so
+// a target that blocks while full does not hold up Schedule.
+func (s *Scheduler) promoteDue(now time.Time) time.Time {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return time.Time{}
+	}
+	var due []ScheduledJob
+	for len(s.jobs) > 0 && !s.jobs[0].At.After(now) {
+		due = append(due, heap.Pop(&s.jobs).(ScheduledJob))
+	}
+	s.mu.Unlock()
+
+	var retry []ScheduledJob
+	targetClosed := false
+	for i, sj := range due {
+		if err := s.target.Enqueue(context.Background(), sj.Job); err != nil {
+			if s.target.IsClosed() {
+				// Keep this job and the rest until the target is back or the scheduler closes.
+				retry = append(retry, due[i:]...)
+				targetClosed = true
+				break
+			}
+			s.logger.Warnf("Failed to promote scheduled job for %s, retrying in %s: %v", sj.Job.To, promoteRetryDelay, err)
+			sj.At = now.Add(promoteRetryDelay)
+			retry = append(retry, sj)
+			continue
+		}
+		s.scheduled.Dec(sj.Job)
+		if s.journal != nil {
+			if err := s.journal.Remove(sj.ID); err != nil {
+				// The job is queued already; at worst it is promoted again after a restart.
+				s.logger.Errorf("Failed to remove promoted job %d from the schedule journal: %v", sj.ID, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sj := range retry {
+		heap.Push(&s.jobs, sj)
+	}
+	if targetClosed || len(s.jobs) == 0 {
+		return time.Time{}
+	}
+	return s.jobs[0].At
+}
+
+// Close stops promoting jobs and closes the journal. Jobs that are not due
+// yet are kept by the journal, if there is one, and dropped otherwise.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	if s.journal != nil {
+		if err := s.journal.Close(); err != nil {
+			s.logger.Errorf("Failed to close the schedule journal: %v", err)
+		}
+	} else if len(s.jobs) > 0 {
+		s.logger.Warnf("Dropping %d scheduled jobs that are not due yet", len(s.jobs))
+	}
+}
+
+// scheduleHeap orders scheduled jobs by due time, then by scheduling order.
+type scheduleHeap []ScheduledJob
+
+func (h scheduleHeap) Len() int { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool {
+	if h[i].At.Equal(h[j].At) {
+		return h[i].ID < h[j].ID
+	}
+	return h[i].At.Before(h[j].At)
+}
+func (h scheduleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scheduleHeap) Push(x interface{}) { *h = append(*h, x.(ScheduledJob)) }
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// Ensure Scheduler implements the ports.Scheduler interface
+var _ ports.Scheduler = (*Scheduler)(nil)
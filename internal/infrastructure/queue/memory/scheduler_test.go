@@ -0,0 +1,334 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// recordingQueue records the jobs promoted into it. While reject is set it
+// turns every job away as if it were full, and while block is set Enqueue
+// waits for it to be closed, like a full queue with OverflowBlock.
+type recordingQueue struct {
+	mu     sync.Mutex
+	jobs   []string
+	reject bool
+	closed bool
+	block  chan struct{}
+}
+
+func (q *recordingQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if q.block != nil {
+		<-q.block
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ports.ErrQueueClosed
+	}
+	if q.reject {
+		return &ports.QueueFullError{}
+	}
+	q.jobs = append(q.jobs, job.ID)
+	return nil
+}
+
+func (q *recordingQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	return domain.EmailJob{}, ports.ErrQueueClosed
+}
+
+func (q *recordingQueue) Ack(job domain.EmailJob) error  { return nil }
+func (q *recordingQueue) Nack(job domain.EmailJob) error { return nil }
+
+func (q *recordingQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+}
+
+func (q *recordingQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+func (q *recordingQueue) setReject(reject bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reject = reject
+}
+
+func (q *recordingQueue) promoted() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.jobs...)
+}
+
+// waitPromoted waits until n jobs were promoted into q.
+func waitPromoted(t *testing.T, q *recordingQueue, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if jobs := q.promoted(); len(jobs) >= n {
+			return jobs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("promoted %v, want %d jobs", q.promoted(), n)
+	return nil
+}
+
+// memoryJournal is a Journal that keeps its records in memory.
+type memoryJournal struct {
+	mu      sync.Mutex
+	live    map[uint64]ScheduledJob
+	removed []uint64
+	closed  bool
+}
+
+func newMemoryJournal() *memoryJournal {
+	return &memoryJournal{live: make(map[uint64]ScheduledJob)}
+}
+
+func (j *memoryJournal) Append(sj ScheduledJob) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return errors.New("journal is closed")
+	}
+	j.live[sj.ID] = sj
+	return nil
+}
+
+func (j *memoryJournal) Remove(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.live, id)
+	j.removed = append(j.removed, id)
+	return nil
+}
+
+func (j *memoryJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	return nil
+}
+
+type testScheduledGauges struct {
+	total, retries prometheus.Gauge
+}
+
+func newTestScheduledJobs() (*metrics.ScheduledJobs, testScheduledGauges) {
+	g := testScheduledGauges{
+		total:   prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_jobs"}),
+		retries: prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_retries"}),
+	}
+	return metrics.NewScheduledJobs(g.total, g.retries), g
+}
+
+func TestSchedulerPromotesDueJobsInOrder(t *testing.T) {
+	target := &recordingQueue{}
+	scheduled, gauges := newTestScheduledJobs()
+	s := NewScheduler(target, logger.NewLogger(), scheduled)
+	defer s.Close()
+
+	ctx := context.Background()
+	base := time.Now().Add(time.Hour)
+	for _, sj := range []struct {
+		id string
+		at time.Time
+	}{
+		{"late", base.Add(2 * time.Hour)},
+		{"first", base},
+		{"second", base}, // Same time: promoted in scheduling order
+		{"retry", base.Add(time.Minute)},
+	} {
+		job := domain.EmailJob{ID: sj.id, To: "a@example.com"}
+		if sj.id == "retry" {
+			job.Retries = 1
+		}
+		if err := s.Schedule(ctx, job, sj.at); err != nil {
+			t.Fatalf("Schedule(%s) error = %v", sj.id, err)
+		}
+	}
+	if total, retries := testutil.ToFloat64(gauges.total), testutil.ToFloat64(gauges.retries); total != 4 || retries != 1 {
+		t.Errorf("scheduled gauges = %v total, %v retries; want 4 and 1", total, retries)
+	}
+
+	next := s.promoteDue(base.Add(time.Hour))
+	if got := target.promoted(); len(got) != 3 || got[0] != "first" || got[1] != "second" || got[2] != "retry" {
+		t.Errorf("promoted %v, want [first second retry]", got)
+	}
+	if !next.Equal(base.Add(2 * time.Hour)) {
+		t.Errorf("promoteDue() = %s, want the due time of the late job", next)
+	}
+	if total, retries := testutil.ToFloat64(gauges.total), testutil.ToFloat64(gauges.retries); total != 1 || retries != 0 {
+		t.Errorf("scheduled gauges = %v total, %v retries after promotion; want 1 and 0", total, retries)
+	}
+}
+
+func TestSchedulerPromotesPastDueJobsRightAway(t *testing.T) {
+	target := &recordingQueue{}
+	scheduled, _ := newTestScheduledJobs()
+	s := NewScheduler(target, logger.NewLogger(), scheduled)
+	defer s.Close()
+
+	if err := s.Schedule(context.Background(), domain.EmailJob{ID: "late"}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	if got := waitPromoted(t, target, 1); got[0] != "late" {
+		t.Errorf("promoted %v, want [late]", got)
+	}
+}
+
+func TestSchedulerRetriesRejectedJobs(t *testing.T) {
+	target := &recordingQueue{reject: true}
+	scheduled, _ := newTestScheduledJobs()
+	s := NewScheduler(target, logger.NewLogger(), scheduled)
+	defer s.Close()
+
+	now := time.Now().Add(time.Hour)
+	if err := s.Schedule(context.Background(), domain.EmailJob{ID: "1"}, now); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	if next := s.promoteDue(now); !next.Equal(now.Add(promoteRetryDelay)) {
+		t.Errorf("promoteDue() of a rejected job = %s, want a retry after %s", next, promoteRetryDelay)
+	}
+	if got := target.promoted(); len(got) != 0 {
+		t.Fatalf("promoted %v into a full queue", got)
+	}
+
+	target.setReject(false)
+	if next := s.promoteDue(now.Add(promoteRetryDelay)); !next.IsZero() {
+		t.Errorf("promoteDue() = %s with nothing left, want the zero time", next)
+	}
+	if got := target.promoted(); len(got) != 1 || got[0] != "1" {
+		t.Errorf("promoted %v after the queue made room, want [1]", got)
+	}
+}
+
+func TestSchedulerSchedulesWhileTargetBlocks(t *testing.T) {
+	target := &recordingQueue{block: make(chan struct{})}
+	scheduled, _ := newTestScheduledJobs()
+	s := NewScheduler(target, logger.NewLogger(), scheduled)
+	defer s.Close()
+
+	ctx := context.Background()
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "due"}, time.Now()); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond) // The loop is now stuck promoting the due job
+
+	scheduledLater := make(chan error, 1)
+	go func() {
+		scheduledLater <- s.Schedule(ctx, domain.EmailJob{ID: "later"}, time.Now().Add(time.Hour))
+	}()
+	select {
+	case err := <-scheduledLater:
+		if err != nil {
+			t.Fatalf("Schedule() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Schedule() blocked while the target queue was full")
+	}
+
+	close(target.block)
+	if got := waitPromoted(t, target, 1); got[0] != "due" {
+		t.Errorf("promoted %v, want [due]", got)
+	}
+}
+
+func TestSchedulerKeepsJobsWhileTargetIsClosed(t *testing.T) {
+	target := &recordingQueue{}
+	scheduled, _ := newTestScheduledJobs()
+	s := NewScheduler(target, logger.NewLogger(), scheduled)
+	defer s.Close()
+
+	target.Close()
+	now := time.Now().Add(time.Hour)
+	if err := s.Schedule(context.Background(), domain.EmailJob{ID: "retry", Retries: 1}, now); err != nil {
+		t.Fatalf("Schedule() after the target was closed error = %v", err)
+	}
+	if next := s.promoteDue(now); !next.IsZero() {
+		t.Errorf("promoteDue() = %s while the target is closed, want the zero time", next)
+	}
+
+	s.mu.Lock()
+	held := len(s.jobs)
+	s.mu.Unlock()
+	if held != 1 {
+		t.Errorf("scheduler holds %d jobs, want the job kept", held)
+	}
+}
+
+func TestJournaledSchedulerRecoversPendingJobs(t *testing.T) {
+	target := &recordingQueue{}
+	journal := newMemoryJournal()
+	scheduled, gauges := newTestScheduledJobs()
+	pending := []ScheduledJob{
+		{ID: 7, At: time.Now().Add(time.Hour), Job: domain.EmailJob{ID: "later", Retries: 2}},
+		{ID: 5, At: time.Now().Add(-time.Minute), Job: domain.EmailJob{ID: "overdue"}},
+	}
+	for _, sj := range pending {
+		journal.Append(sj)
+	}
+	s := NewJournaledScheduler(target, journal, pending, logger.NewLogger(), scheduled)
+
+	// The job that fell due while stopped is promoted and removed from the journal.
+	if got := waitPromoted(t, target, 1); got[0] != "overdue" {
+		t.Errorf("promoted %v, want [overdue]", got)
+	}
+	if total, retries := testutil.ToFloat64(gauges.total), testutil.ToFloat64(gauges.retries); total != 1 || retries != 1 {
+		t.Errorf("scheduled gauges = %v total, %v retries; want 1 and 1", total, retries)
+	}
+
+	// New jobs continue the recovered IDs instead of reusing one.
+	if err := s.Schedule(context.Background(), domain.EmailJob{ID: "new"}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	s.Close()
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if len(journal.removed) != 1 || journal.removed[0] != 5 {
+		t.Errorf("journal removed %v, want [5]", journal.removed)
+	}
+	if sj, ok := journal.live[8]; !ok || sj.Job.ID != "new" {
+		t.Errorf("journal holds %v, want the new job as 8", journal.live)
+	}
+	if len(journal.live) != 2 {
+		t.Errorf("journal holds %d jobs after Close, want the 2 that are not due", len(journal.live))
+	}
+	if !journal.closed {
+		t.Errorf("Close() did not close the journal")
+	}
+}
+
+func TestSchedulerRejectsJobsAfterClose(t *testing.T) {
+	scheduled, _ := newTestScheduledJobs()
+	s := NewScheduler(&recordingQueue{}, logger.NewLogger(), scheduled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "1"}, time.Now()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Schedule() with a cancelled context error = %v, want Canceled", err)
+	}
+
+	s.Close()
+	s.Close() // Closing twice is fine
+
+	if err := s.Schedule(context.Background(), domain.EmailJob{ID: "1"}, time.Now()); err == nil {
+		t.Errorf("Schedule() after Close succeeded, want an error")
+	}
+}
@@ -0,0 +1,465 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+const (
+	streamName     = "EMAIL_JOBS"
+	subjectPrefix  = "email.jobs"
+	consumerPrefix = "email_workers"
+	natsTimeout    = 5 * time.Second
+
+	// pollInterval bounds how long an idle Dequeue waits without a wake-up,
+	// so that jobs redelivered after their AckWait are picked up.
+	pollInterval = time.Second
+
+	// maxDeliveriesAdvisory is the subject prefix JetStream publishes to when
+	// a message was delivered MaxDeliver times without being acknowledged.
+	maxDeliveriesAdvisory = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES." + streamName + "."
+	dlqQueueGroup         = "email_dlq"
+)
+
+// laneSubject returns the subject the jobs of a priority lane are published on.
+func laneSubject(lane domain.Priority) string {
+	return subjectPrefix + "." + string(lane)
+}
+
+// consumerName returns the durable consumer of a priority lane. All
+// instances share it, so every job goes to exactly one of them.
+func consumerName(lane domain.Priority) string {
+	return consumerPrefix + "_" + string(lane)
+}
+
+// Connect connects to the NATS servers in url (comma-separated for a
+// cluster) and keeps reconnecting for as long as the service runs.
+func Connect(url, name string, l *logger.Logger) (*nats.Conn, error) {
+	conn, err := nats.Connect(url,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				l.Warnf("Disconnected from NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			l.Printf("Reconnected to NATS at %s", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", url, err)
+	}
+	return conn, nil
+}
+
+// advisory is the part of a JetStream max deliveries advisory that is used.
+type advisory struct {
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// inFlightMsg is a delivered job awaiting Ack/Nack, with the time its
+// AckWait runs out unless the lease is extended.
+type inFlightMsg struct {
+	msg       jetstream.Msg
+	leaseEnds time.Time
+}
+
+// JetStreamQueue implements the ports.Queue interface on a NATS JetStream
+// work queue stream, with a durable pull consumer per priority lane. Jobs are
+// acknowledged explicitly; a job that is not acknowledged within AckWait is
+// redelivered, unless its worker reports progress, and one that was
+// delivered MaxDeliver times is moved to the DLQ. Idle workers are woken by a subscription on the job subjects and
+// otherwise poll, which also picks up redelivered jobs.
+type JetStreamQueue struct {
+	conn       *nats.Conn
+	js         jetstream.JetStream
+	stream     jetstream.Stream
+	consumers  map[domain.Priority]jetstream.Consumer
+	dlq        ports.DeadLetterQueue
+	logger     *logger.Logger
+	queueDepth *metrics.QueueDepth
+	ackWait    time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	inFlight map[string]inFlightMsg // Delivered jobs awaiting Ack/Nack, keyed by receipt
+	subs     []*nats.Subscription
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+// NewJetStreamQueue creates the stream and the lane consumers, or updates
+// them to the given settings. Jobs not acknowledged within ackWait are
+// redelivered; after maxDeliver deliveries they are stored in dlq instead.
+func NewJetStreamQueue(conn *nats.Conn, dlq ports.DeadLetterQueue, l *logger.Logger, queueDepth *metrics.QueueDepth, ackWait time.Duration, maxDeliver int) (*JetStreamQueue, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      streamName,
+		Subjects:  []string{subjectPrefix + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream stream: %w", err)
+	}
+
+	q := &JetStreamQueue{
+		conn:       conn,
+		js:         js,
+		stream:     stream,
+		consumers:  make(map[domain.Priority]jetstream.Consumer),
+		dlq:        dlq,
+		logger:     l,
+		queueDepth: queueDepth,
+		ackWait:    ackWait,
+		inFlight:   make(map[string]inFlightMsg),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	for _, lane := range domain.Priorities {
+		consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+			Durable:       consumerName(lane),
+			FilterSubject: laneSubject(lane),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       ackWait,
+			MaxDeliver:    maxDeliver,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JetStream consumer: %w", err)
+		}
+		q.consumers[lane] = consumer
+		q.queueDepth.Set(lane, float64(consumer.CachedInfo().NumPending))
+	}
+
+	// Every published job wakes an idle worker, collapsing bursts into a
+	// single wake-up.
+	wakeSub, err := conn.Subscribe(subjectPrefix+".>", func(*nats.Msg) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to new jobs: %w", err)
+	}
+	// Advisories go to one instance of the queue group, so that each job is
+	// dead-lettered once.
+	dlqSub, err := conn.QueueSubscribe(maxDeliveriesAdvisory+">", dlqQueueGroup, q.deadLetter)
+	if err != nil {
+		wakeSub.Unsubscribe()
+		return nil, fmt.Errorf("failed to subscribe to JetStream advisories: %w", err)
+	}
+	q.subs = []*nats.Subscription{wakeSub, dlqSub}
+	return q, nil
+}
+
+// Enqueue publishes a job on the subject of its priority lane and waits for
+// JetStream to store it.
+func (q *JetStreamQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if q.IsClosed() {
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+	}
+
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+
+	if _, err := q.js.Publish(ctx, laneSubject(job.Lane()), jobBytes); err != nil {
+		return fmt.Errorf("failed to enqueue job to JetStream: %w", err)
+	}
+	q.queueDepth.Inc(job.Lane())
+	return nil
+}
+
+// EnqueueBatch publishes jobs asynchronously and then waits for every
+// acknowledgement, so the batch takes about one round trip.
+func (q *JetStreamQueue) EnqueueBatch(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	if q.IsClosed() {
+		for i := range errs {
+			errs[i] = fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+		}
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+
+	futures := make([]jetstream.PubAckFuture, len(jobs))
+	for i, job := range jobs {
+		jobBytes, err := json.Marshal(job)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to marshal job: %w", err)
+			continue
+		}
+		futures[i], err = q.js.PublishAsync(laneSubject(job.Lane()), jobBytes)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to enqueue job to JetStream: %w", err)
+		}
+	}
+
+	for i, future := range futures {
+		if future == nil {
+			continue
+		}
+		select {
+		case <-future.Ok():
+			q.queueDepth.Inc(jobs[i].Lane())
+		case err := <-future.Err():
+			errs[i] = fmt.Errorf("failed to enqueue job to JetStream: %w", err)
+		case <-ctx.Done():
+			errs[i] = fmt.Errorf("failed to enqueue job to JetStream: %w", ctx.Err())
+		}
+	}
+	return errs
+}
+
+// Dequeue fetches the next job from the first lane in lanes that has one,
+// waiting for a wake-up or the next poll while there is none. Queued jobs
+// stay in the stream once the queue is closed, so there is nothing to drain.
+func (q *JetStreamQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	for {
+		if q.IsClosed() {
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		for _, lane := range lanes {
+			job, ok, err := q.fetch(lane)
+			if err != nil {
+				q.logger.Errorf("Failed to dequeue job from JetStream: %v", err)
+				break
+			}
+			if ok {
+				// More jobs may be ready; let another waiting worker look.
+				select {
+				case q.wake <- struct{}{}:
+				default:
+				}
+				return job, nil
+			}
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(pollInterval):
+		case <-q.done:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// fetch takes a job from the consumer of lane without waiting, and reports
+// whether there was one.
+func (q *JetStreamQueue) fetch(lane domain.Priority) (domain.EmailJob, bool, error) {
+	// The AckWait of a delivered job started no earlier than the fetch.
+	fetchedAt := time.Now()
+	batch, err := q.consumers[lane].FetchNoWait(1)
+	if err != nil {
+		return domain.EmailJob{}, false, err
+	}
+	var msg jetstream.Msg
+	for m := range batch.Messages() {
+		msg = m
+	}
+	if msg == nil {
+		if err := batch.Error(); err != nil && !errors.Is(err, jetstream.ErrNoMessages) {
+			return domain.EmailJob{}, false, err
+		}
+		return domain.EmailJob{}, false, nil
+	}
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		return domain.EmailJob{}, false, fmt.Errorf("failed to read message metadata: %w", err)
+	}
+	if meta.NumDelivered == 1 {
+		// Redeliveries were counted out of the lane when first delivered.
+		q.queueDepth.Dec(lane)
+	}
+
+	var job domain.EmailJob
+	if err := json.Unmarshal(msg.Data(), &job); err != nil {
+		// A payload that cannot be decoded will never succeed; drop it instead of redelivering it.
+		q.logger.Errorf("Failed to unmarshal job %d from JetStream, dropping it: %v", meta.Sequence.Stream, err)
+		if err := msg.Term(); err != nil {
+			q.logger.Errorf("Failed to drop job %d from JetStream: %v", meta.Sequence.Stream, err)
+		}
+		return domain.EmailJob{}, false, nil
+	}
+	// The lane of the consumer wins, so the job is always acknowledged there.
+	job.Priority = lane
+	job.Receipt = strconv.FormatUint(meta.Sequence.Stream, 10)
+
+	q.mu.Lock()
+	q.inFlight[job.Receipt] = inFlightMsg{msg: msg, leaseEnds: fetchedAt.Add(q.ackWait)}
+	q.mu.Unlock()
+	return job, true, nil
+}
+
+// Ack acknowledges a job, which removes it from the work queue stream, and
+// waits for JetStream to confirm it.
+func (q *JetStreamQueue) Ack(job domain.EmailJob) error {
+	msg, err := q.takeInFlight(job)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+
+	if err := msg.DoubleAck(ctx); err != nil {
+		return fmt.Errorf("failed to ack job in JetStream: %w", err)
+	}
+	return nil
+}
+
+// Nack asks JetStream to redeliver a job right away. The redelivery counts
+// towards MaxDeliver.
+func (q *JetStreamQueue) Nack(job domain.EmailJob) error {
+	msg, err := q.takeInFlight(job)
+	if err != nil {
+		return err
+	}
+	if err := msg.Nak(); err != nil {
+		return fmt.Errorf("failed to nack job in JetStream: %w", err)
+	}
+	return nil
+}
+
+func (q *JetStreamQueue) takeInFlight(job domain.EmailJob) (jetstream.Msg, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.inFlight[job.Receipt]
+	if !ok {
+		return nil, fmt.Errorf("unknown receipt %q, job was not dequeued or already acknowledged", job.Receipt)
+	}
+	delete(q.inFlight, job.Receipt)
+	return entry.msg, nil
+}
+
+// LeaseDuration returns the AckWait, after which JetStream redelivers a job
+// that is still unacknowledged.
+func (q *JetStreamQueue) LeaseDuration() time.Duration {
+	return q.ackWait
+}
+
+// ExtendLease tells JetStream that a job is still being sent, which restarts
+// its AckWait. Once the AckWait ran out, the job may have been redelivered
+// to another worker, so the lease counts as lost.
+func (q *JetStreamQueue) ExtendLease(job domain.EmailJob) error {
+	q.mu.Lock()
+	entry, ok := q.inFlight[job.Receipt]
+	q.mu.Unlock()
+	now := time.Now()
+	if !ok || now.After(entry.leaseEnds) {
+		return ports.ErrLeaseLost
+	}
+
+	if err := entry.msg.InProgress(); err != nil {
+		return fmt.Errorf("failed to extend job lease in JetStream: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if current, ok := q.inFlight[job.Receipt]; ok && current.msg == entry.msg {
+		current.leaseEnds = now.Add(q.ackWait)
+		q.inFlight[job.Receipt] = current
+	}
+	return nil
+}
+
+// deadLetter handles a max deliveries advisory: the job JetStream gave up on
+// is stored in the DLQ and deleted from the stream, where it would otherwise
+// stay unacknowledged forever.
+func (q *JetStreamQueue) deadLetter(m *nats.Msg) {
+	var adv advisory
+	if err := json.Unmarshal(m.Data, &adv); err != nil {
+		q.logger.Errorf("Failed to unmarshal JetStream advisory: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+
+	raw, err := q.stream.GetMsg(ctx, adv.StreamSeq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return // Acknowledged after all
+	}
+	if err != nil {
+		q.logger.Errorf("Failed to read job %d from JetStream: %v", adv.StreamSeq, err)
+		return
+	}
+
+	var job domain.EmailJob
+	if err := json.Unmarshal(raw.Data, &job); err != nil {
+		q.logger.Errorf("Failed to unmarshal job %d from JetStream, dropping it: %v", adv.StreamSeq, err)
+	} else {
+		q.logger.Errorf("Email to %s was delivered %d times without being acknowledged. Moving to DLQ.", job.To, adv.Deliveries)
+		q.dlq.Store(job, fmt.Sprintf("Not acknowledged after %d deliveries", adv.Deliveries), job.LastError)
+	}
+	if err := q.stream.DeleteMsg(ctx, adv.StreamSeq); err != nil && !errors.Is(err, jetstream.ErrMsgNotFound) {
+		q.logger.Errorf("Failed to delete job %d from JetStream: %v", adv.StreamSeq, err)
+	}
+}
+
+// Close stops handing out jobs and handling advisories. The NATS connection
+// is left open so that in-flight jobs can still be acknowledged, and is
+// closed by its owner.
+func (q *JetStreamQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		for _, sub := range q.subs {
+			if err := sub.Unsubscribe(); err != nil {
+				q.logger.Errorf("Failed to unsubscribe from %s: %v", sub.Subject, err)
+			}
+		}
+		close(q.done)
+		q.closed = true
+		q.logger.Println("JetStream queue closed.")
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *JetStreamQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Ensure JetStreamQueue implements the ports.BatchQueue and ports.LeaseExtender interfaces
+var (
+	_ ports.BatchQueue    = (*JetStreamQueue)(nil)
+	_ ports.LeaseExtender = (*JetStreamQueue)(nil)
+)
@@ -0,0 +1,252 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// recordingDLQ records the jobs dead-lettered into it.
+type recordingDLQ struct {
+	mu      sync.Mutex
+	jobs    []domain.EmailJob
+	reasons []string
+}
+
+func (d *recordingDLQ) Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.jobs = append(d.jobs, job)
+	d.reasons = append(d.reasons, reason)
+}
+
+func (d *recordingDLQ) stored() []domain.EmailJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]domain.EmailJob(nil), d.jobs...)
+}
+
+// newTestQueue starts an embedded NATS server with JetStream and opens a
+// queue on it. The lane depth gauges are returned for inspection.
+func newTestQueue(t *testing.T, ackWait time.Duration, maxDeliver int) (*JetStreamQueue, *recordingDLQ, *prometheus.GaugeVec) {
+	t.Helper()
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("nats.Connect() error = %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	lanes := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"})
+	depth := metrics.NewQueueDepth(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"}), lanes)
+	dlq := &recordingDLQ{}
+	q, err := NewJetStreamQueue(conn, dlq, logger.NewLogger(), depth, ackWait, maxDeliver)
+	if err != nil {
+		t.Fatalf("NewJetStreamQueue() error = %v", err)
+	}
+	t.Cleanup(q.Close)
+	return q, dlq, lanes
+}
+
+func dequeue(t *testing.T, q *JetStreamQueue, timeout time.Duration) domain.EmailJob {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	job, err := q.Dequeue(ctx, domain.Priorities)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	return job
+}
+
+// streamMsgs returns the number of jobs the stream still holds.
+func streamMsgs(t *testing.T, q *JetStreamQueue) uint64 {
+	t.Helper()
+	info, err := q.stream.Info(context.Background())
+	if err != nil {
+		t.Fatalf("stream Info() error = %v", err)
+	}
+	return info.State.Msgs
+}
+
+func TestJetStreamQueueAckRemovesJob(t *testing.T) {
+	q, _, lanes := newTestQueue(t, time.Minute, 3)
+	ctx := context.Background()
+
+	for _, job := range []domain.EmailJob{
+		{ID: "bulk", To: "a@example.com", Priority: domain.PriorityBulk},
+		{ID: "high", To: "a@example.com", Priority: domain.PriorityHigh},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", job.ID, err)
+		}
+	}
+	if got := testutil.ToFloat64(lanes.WithLabelValues(string(domain.PriorityHigh))); got != 1 {
+		t.Errorf("high lane depth = %v, want 1", got)
+	}
+
+	// The higher lane is served first.
+	job := dequeue(t, q, time.Second)
+	if job.ID != "high" || job.Priority != domain.PriorityHigh || job.Receipt == "" {
+		t.Fatalf("Dequeue() = %+v, want the high priority job with a receipt", job)
+	}
+	if got := testutil.ToFloat64(lanes.WithLabelValues(string(domain.PriorityHigh))); got != 0 {
+		t.Errorf("high lane depth after Dequeue = %v, want 0", got)
+	}
+	if err := q.Ack(job); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if err := q.Ack(job); err == nil {
+		t.Errorf("second Ack() succeeded, want an unknown receipt error")
+	}
+	if n := streamMsgs(t, q); n != 1 {
+		t.Errorf("stream holds %d jobs after Ack, want 1", n)
+	}
+
+	if err := q.Ack(dequeue(t, q, time.Second)); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if n := streamMsgs(t, q); n != 0 {
+		t.Errorf("stream holds %d jobs after both were acknowledged, want 0", n)
+	}
+}
+
+func TestJetStreamQueueNackRedeliversJob(t *testing.T) {
+	q, dlq, _ := newTestQueue(t, time.Minute, 3)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	first := dequeue(t, q, time.Second)
+	if err := q.Nack(first); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+
+	again := dequeue(t, q, 3*time.Second)
+	if again.ID != "1" || again.Receipt != first.Receipt {
+		t.Errorf("redelivered %+v, want job 1 with receipt %s", again, first.Receipt)
+	}
+	if err := q.Ack(again); err != nil {
+		t.Fatalf("Ack() of the redelivered job error = %v", err)
+	}
+	if n := len(dlq.stored()); n != 0 {
+		t.Errorf("DLQ holds %d jobs, want 0", n)
+	}
+}
+
+func TestJetStreamQueueRedeliversAfterAckWait(t *testing.T) {
+	q, _, _ := newTestQueue(t, 200*time.Millisecond, 3)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	dequeue(t, q, time.Second) // The worker dies without acknowledging it
+
+	// The next poll picks up the redelivery.
+	if job := dequeue(t, q, 3*time.Second); job.ID != "1" {
+		t.Errorf("redelivered %s, want 1", job.ID)
+	}
+}
+
+func TestJetStreamQueueExtendLease(t *testing.T) {
+	q, _, _ := newTestQueue(t, 300*time.Millisecond, 3)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	slow := dequeue(t, q, time.Second)
+	if got := q.LeaseDuration(); got != 300*time.Millisecond {
+		t.Errorf("LeaseDuration() = %s, want the AckWait", got)
+	}
+
+	// The job outlives its AckWait several times without being redelivered.
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err := q.ExtendLease(slow); err != nil {
+			t.Fatalf("ExtendLease() error = %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if job, err := q.Dequeue(ctx, domain.Priorities); err == nil {
+		t.Fatalf("Dequeue() = %+v while the lease is extended, want nothing", job)
+	}
+
+	// Without progress reports, the AckWait runs out and the lease is lost.
+	time.Sleep(400 * time.Millisecond)
+	if err := q.ExtendLease(slow); !errors.Is(err, ports.ErrLeaseLost) {
+		t.Errorf("ExtendLease() after the AckWait error = %v, want ErrLeaseLost", err)
+	}
+	if job := dequeue(t, q, 3*time.Second); job.ID != "1" {
+		t.Errorf("redelivered %s, want 1", job.ID)
+	}
+	if err := q.ExtendLease(domain.EmailJob{Receipt: "42"}); !errors.Is(err, ports.ErrLeaseLost) {
+		t.Errorf("ExtendLease() of an unknown receipt error = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestJetStreamQueueDeadLettersAfterMaxDeliver(t *testing.T) {
+	q, dlq, _ := newTestQueue(t, time.Minute, 2)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := q.Nack(dequeue(t, q, 3*time.Second)); err != nil {
+			t.Fatalf("Nack() error = %v", err)
+		}
+	}
+
+	// JetStream gives up on the job when the next worker asks for it, and
+	// nothing is delivered again.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if job, err := q.Dequeue(ctx, domain.Priorities); err == nil {
+		t.Errorf("Dequeue() = %+v after MaxDeliver deliveries, want nothing", job)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dlq.stored()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := dlq.stored(); len(got) != 1 || got[0].ID != "1" {
+		t.Fatalf("DLQ holds %v, want job 1", got)
+	}
+	if n := streamMsgs(t, q); n != 0 {
+		t.Errorf("stream holds %d jobs after dead-lettering, want the job deleted", n)
+	}
+}
+
+func TestJetStreamQueueRejectsJobsAfterClose(t *testing.T) {
+	q, _, _ := newTestQueue(t, time.Minute, 3)
+	q.Close()
+	q.Close() // Closing twice is fine
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1"}); err == nil {
+		t.Errorf("Enqueue() after Close succeeded, want an error")
+	}
+	if errs := q.EnqueueBatch(context.Background(), []domain.EmailJob{{ID: "1"}}); errs[0] == nil {
+		t.Errorf("EnqueueBatch() after Close succeeded, want an error")
+	}
+	if _, err := q.Dequeue(context.Background(), domain.Priorities); err == nil {
+		t.Errorf("Dequeue() after Close succeeded, want an error")
+	}
+}
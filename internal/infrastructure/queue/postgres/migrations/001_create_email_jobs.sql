@@ -0,0 +1,28 @@
+-- Jobs waiting for, or leased to, a worker. Rows are deleted once acknowledged.
+CREATE TABLE IF NOT EXISTS email_jobs (
+    id           BIGSERIAL PRIMARY KEY,
+    payload      JSONB       NOT NULL,
+    run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_by    TEXT,
+    locked_until TIMESTAMPTZ,
+    attempts     INTEGER     NOT NULL DEFAULT 0,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Claims scan jobs in id order among those that are due.
+CREATE INDEX IF NOT EXISTS email_jobs_claim_idx ON email_jobs (run_at, id);
+
+-- Wake up waiting workers whenever a job becomes visible. Notifications are
+-- only delivered on commit, so jobs enqueued with EnqueueTx are picked up
+-- together with the business writes of their transaction.
+CREATE OR REPLACE FUNCTION email_jobs_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('email_jobs', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS email_jobs_notify ON email_jobs;
+CREATE TRIGGER email_jobs_notify
+    AFTER INSERT ON email_jobs
+    FOR EACH STATEMENT EXECUTE PROCEDURE email_jobs_notify();
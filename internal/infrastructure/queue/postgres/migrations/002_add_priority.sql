@@ -0,0 +1,5 @@
+-- Priority lane of a job: high, normal or bulk. Existing jobs are normal.
+ALTER TABLE email_jobs ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal';
+
+-- Claims scan one lane at a time, in id order.
+CREATE INDEX IF NOT EXISTS email_jobs_lane_claim_idx ON email_jobs (priority, id);
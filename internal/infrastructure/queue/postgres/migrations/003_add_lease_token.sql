@@ -0,0 +1,4 @@
+-- Token of the current claim of a job. Every claim gets a new one, so that a
+-- worker whose lease expired cannot ack or nack the job after another worker
+-- of the same instance claimed it again.
+ALTER TABLE email_jobs ADD COLUMN IF NOT EXISTS lease_token TEXT;
@@ -0,0 +1,517 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+const (
+	notifyChannel = "email_jobs"
+	dbTimeout     = 5 * time.Second
+	gaugeInterval = 5 * time.Second
+	// deadLetterBatchSize bounds how many exhausted jobs one statement
+	// moves to the DLQ.
+	deadLetterBatchSize = 100
+)
+
+const insertJobSQL = `INSERT INTO email_jobs (payload, priority, run_at) VALUES ($1, $2, COALESCE($3::timestamptz, now()))`
+
+// claimJobSQL leases the oldest due job of a lane that no other worker holds. SKIP
+// LOCKED lets concurrent workers claim different rows without blocking on
+// each other, and a lease that expired (its worker died) makes the row
+// claimable again. Every claim stores a new lease token, which the later
+// statements on the job must present. A job claimed $5 times already (0 for
+// no limit) is left for deadLetterJobsSQL instead.
+const claimJobSQL = `
+UPDATE email_jobs
+SET locked_by = $1, lease_token = $4, locked_until = now() + $2::interval, attempts = attempts + 1
+WHERE id = (
+	SELECT id FROM email_jobs
+	WHERE priority = $3 AND run_at <= now() AND (locked_until IS NULL OR locked_until < now())
+		AND ($5::int = 0 OR attempts < $5::int)
+	ORDER BY id
+	FOR UPDATE SKIP LOCKED
+	LIMIT 1
+)
+RETURNING id, payload`
+
+const (
+	ackJobSQL    = `DELETE FROM email_jobs WHERE id = $1 AND lease_token = $2`
+	nackJobSQL   = `UPDATE email_jobs SET locked_by = NULL, locked_until = NULL, lease_token = NULL WHERE id = $1 AND lease_token = $2`
+	extendJobSQL = `UPDATE email_jobs SET locked_until = now() + $3::interval WHERE id = $1 AND lease_token = $2`
+)
+
+// deadLetterJobsSQL deletes up to $2 jobs that were claimed $1 times without
+// being acknowledged and whose last lease ran out, and returns them for the
+// DLQ.
+const deadLetterJobsSQL = `
+DELETE FROM email_jobs
+WHERE id IN (
+	SELECT id FROM email_jobs
+	WHERE attempts >= $1 AND (locked_until IS NULL OR locked_until < now())
+	ORDER BY id
+	FOR UPDATE SKIP LOCKED
+	LIMIT $2
+)
+RETURNING id, payload, attempts`
+
+const countReadySQL = `SELECT priority, count(*) FROM email_jobs WHERE run_at <= now() AND (locked_until IS NULL OR locked_until < now()) AND ($1::int = 0 OR attempts < $1::int) GROUP BY priority`
+
+const countScheduledSQL = `SELECT count(*), count(*) FILTER (WHERE (payload->>'retries')::int > 0) FROM email_jobs WHERE run_at > now()`
+
+// PostgresQueue implements the ports.Queue interface on a Postgres table.
+// Jobs are claimed with SELECT ... FOR UPDATE SKIP LOCKED under a lease and
+// deleted once acknowledged. The receipt of a job carries its row id and the
+// token of the claim, so only the worker holding the current lease can ack,
+// nack or extend it. Idle workers are woken by LISTEN/NOTIFY and
+// otherwise poll, which also picks up jobs whose lease expired. Jobs claimed
+// maxDeliver times without being acknowledged are moved to the DLQ.
+type PostgresQueue struct {
+	db           *sql.DB
+	listener     *pq.Listener
+	dlq          ports.DeadLetterQueue
+	logger       *logger.Logger
+	queueDepth   *metrics.QueueDepth
+	scheduled    *metrics.ScheduledJobs
+	consumer     string
+	lease        time.Duration
+	pollInterval time.Duration
+	maxDeliver   int
+
+	mu     sync.Mutex
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+// NewPostgresQueue creates a new PostgresQueue. dsn is used for the
+// dedicated LISTEN connection; db must point to the same database. Jobs
+// claimed maxDeliver times (0 disables the limit) whose last lease ran out
+// are stored in dlq instead of being claimed again.
+func NewPostgresQueue(db *sql.DB, dsn string, dlq ports.DeadLetterQueue, l *logger.Logger, queueDepth *metrics.QueueDepth, scheduled *metrics.ScheduledJobs, consumer string, lease, pollInterval time.Duration, maxDeliver int) (*PostgresQueue, error) {
+	q := &PostgresQueue{
+		db:           db,
+		dlq:          dlq,
+		logger:       l,
+		queueDepth:   queueDepth,
+		scheduled:    scheduled,
+		consumer:     consumer,
+		lease:        lease,
+		pollInterval: pollInterval,
+		maxDeliver:   maxDeliver,
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+
+	q.listener = pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			l.Errorf("Postgres listener error: %v", err)
+		}
+	})
+	if err := q.listener.Listen(notifyChannel); err != nil {
+		q.listener.Close()
+		return nil, fmt.Errorf("failed to listen for new jobs: %w", err)
+	}
+
+	q.deadLetterExhausted()
+	q.refreshGauge()
+	go q.forwardNotifications()
+	go q.runGaugeRefresher()
+	return q, nil
+}
+
+// Enqueue inserts a job into the jobs table.
+func (q *PostgresQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if q.IsClosed() {
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	if err := insertJob(ctx, q.db, job, nil); err != nil {
+		return err
+	}
+	q.queueDepth.Inc(job.Lane())
+	return nil
+}
+
+// Schedule inserts a job that becomes claimable at at. The run_at column
+// holds it back, so scheduled jobs are as durable as queued ones and need
+// no separate promoter. Jobs can still be scheduled after Close, e.g.
+// retries of jobs that were being processed, as long as the database is open.
+func (q *PostgresQueue) Schedule(ctx context.Context, job domain.EmailJob, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	if err := insertJob(ctx, q.db, job, &at); err != nil {
+		return err
+	}
+	q.scheduled.Inc(job)
+	return nil
+}
+
+// EnqueueTx inserts a job as part of the caller's transaction, so that it is
+// only queued if the transaction commits (transactional outbox). A job with
+// a send_at time is held back until then.
+func (q *PostgresQueue) EnqueueTx(tx *sql.Tx, job domain.EmailJob) error {
+	if job.ID == "" {
+		job.ID = domain.NewJobID()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return insertJob(ctx, tx, job, job.SendAt)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertJob(ctx context.Context, db execer, job domain.EmailJob, runAt *time.Time) error {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, insertJobSQL, jobBytes, string(job.Lane()), runAt); err != nil {
+		return fmt.Errorf("failed to enqueue job to Postgres: %w", err)
+	}
+	return nil
+}
+
+// Dequeue claims the next due job from the first lane in lanes that has one,
+// waiting for a notification or the next poll while there is none. Queued
+// jobs stay in the table once the queue is closed, so there is nothing to
+// drain.
+func (q *PostgresQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	for {
+		if q.IsClosed() {
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		// FOR UPDATE cannot be combined with UNION, so the lanes are
+		// claimed from one query at a time.
+		var job domain.EmailJob
+		err := sql.ErrNoRows
+		for _, lane := range lanes {
+			job, err = q.claim(ctx, lane)
+			if !errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+		}
+		if err == nil {
+			// More jobs may be due; let another waiting worker look.
+			select {
+			case q.wake <- struct{}{}:
+			default:
+			}
+			return job, nil
+		}
+		if ctx.Err() != nil {
+			return domain.EmailJob{}, ctx.Err()
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			q.logger.Errorf("Failed to dequeue job from Postgres: %v", err)
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(q.pollInterval):
+		case <-q.done:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (q *PostgresQueue) claim(ctx context.Context, lane domain.Priority) (domain.EmailJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	token, err := newLeaseToken()
+	if err != nil {
+		return domain.EmailJob{}, err
+	}
+
+	var id int64
+	var payload []byte
+	err = q.db.QueryRowContext(ctx, claimJobSQL, q.consumer, q.leaseInterval(), string(lane), token, q.maxDeliver).Scan(&id, &payload)
+	if err != nil {
+		return domain.EmailJob{}, err
+	}
+	q.queueDepth.Dec(lane)
+
+	var job domain.EmailJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		// A payload that cannot be decoded will never succeed; drop it instead of redelivering it forever.
+		q.logger.Errorf("Failed to unmarshal job %d from Postgres, dropping it: %v", id, err)
+		q.deleteJob(id, token)
+		return domain.EmailJob{}, sql.ErrNoRows
+	}
+	job.Receipt = strconv.FormatInt(id, 10) + ":" + token
+	return job, nil
+}
+
+// newLeaseToken returns a random token identifying a single claim.
+func newLeaseToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate lease token: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// parseReceipt splits a receipt into the row id and lease token of a claim.
+func parseReceipt(receipt string) (int64, string, error) {
+	idPart, token, ok := strings.Cut(receipt, ":")
+	if !ok || token == "" {
+		return 0, "", fmt.Errorf("invalid receipt %q: missing lease token", receipt)
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid receipt %q: %w", receipt, err)
+	}
+	return id, token, nil
+}
+
+// leaseInterval returns the lease as a Postgres interval.
+func (q *PostgresQueue) leaseInterval() string {
+	return fmt.Sprintf("%d milliseconds", q.lease.Milliseconds())
+}
+
+// Ack deletes a completed job. Only the current lease holder can delete it,
+// so a worker whose lease expired cannot remove a job another worker holds,
+// even one of the same instance.
+func (q *PostgresQueue) Ack(job domain.EmailJob) error {
+	id, token, err := parseReceipt(job.Receipt)
+	if err != nil {
+		return err
+	}
+	return q.deleteJob(id, token)
+}
+
+func (q *PostgresQueue) deleteJob(id int64, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := q.db.ExecContext(ctx, ackJobSQL, id, token); err != nil {
+		return fmt.Errorf("failed to ack job in Postgres: %w", err)
+	}
+	return nil
+}
+
+// Nack releases the lease so the job can be claimed again right away. A
+// worker whose lease expired leaves the job alone.
+func (q *PostgresQueue) Nack(job domain.EmailJob) error {
+	id, token, err := parseReceipt(job.Receipt)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := q.db.ExecContext(ctx, nackJobSQL, id, token)
+	if err != nil {
+		return fmt.Errorf("failed to nack job in Postgres: %w", err)
+	}
+	if released, err := result.RowsAffected(); err == nil && released > 0 {
+		q.queueDepth.Inc(job.Lane())
+	}
+	return nil
+}
+
+// LeaseDuration returns how long a claim lasts.
+func (q *PostgresQueue) LeaseDuration() time.Duration {
+	return q.lease
+}
+
+// ExtendLease moves the lease of a claimed job to a full lease from now.
+func (q *PostgresQueue) ExtendLease(job domain.EmailJob) error {
+	id, token, err := parseReceipt(job.Receipt)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := q.db.ExecContext(ctx, extendJobSQL, id, token, q.leaseInterval())
+	if err != nil {
+		return fmt.Errorf("failed to extend job lease in Postgres: %w", err)
+	}
+	extended, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to extend job lease in Postgres: %w", err)
+	}
+	if extended == 0 {
+		return ports.ErrLeaseLost
+	}
+	return nil
+}
+
+// forwardNotifications drains the listener so it never blocks on a full
+// channel, collapsing bursts of notifications into a single wake-up.
+func (q *PostgresQueue) forwardNotifications() {
+	for range q.listener.Notify {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runGaugeRefresher keeps the lane and scheduled gauges in line with the
+// table, which other instances and EnqueueTx callers also write to, and
+// moves exhausted jobs to the DLQ.
+func (q *PostgresQueue) runGaugeRefresher() {
+	ticker := time.NewTicker(gaugeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			q.deadLetterExhausted()
+			q.refreshGauge()
+		}
+	}
+}
+
+// deadLetterExhausted moves the jobs that were claimed maxDeliver times
+// without being acknowledged to the DLQ, e.g. jobs whose worker crashes
+// every time it sends them. The rows are deleted first, so that a job is
+// dead-lettered by a single instance.
+func (q *PostgresQueue) deadLetterExhausted() {
+	if q.maxDeliver <= 0 {
+		return
+	}
+	for {
+		n, err := q.deadLetterBatch()
+		if err != nil {
+			q.logger.Errorf("Failed to move exhausted jobs from Postgres to the DLQ: %v", err)
+			return
+		}
+		if n < deadLetterBatchSize {
+			return
+		}
+	}
+}
+
+// deadLetterBatch moves up to deadLetterBatchSize exhausted jobs to the DLQ
+// and returns how many it found.
+func (q *PostgresQueue) deadLetterBatch() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx, deadLetterJobsSQL, q.maxDeliver, deadLetterBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var id int64
+		var payload []byte
+		var attempts int
+		if err := rows.Scan(&id, &payload, &attempts); err != nil {
+			return n, err
+		}
+		n++
+		var job domain.EmailJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			q.logger.Errorf("Failed to unmarshal job %d from Postgres, dropping it: %v", id, err)
+			continue
+		}
+		q.logger.Errorf("Email to %s was delivered %d times without being acknowledged. Moving to DLQ.", job.To, attempts)
+		q.dlq.Store(job, fmt.Sprintf("Not acknowledged after %d deliveries", attempts), job.LastError)
+	}
+	return n, rows.Err()
+}
+
+func (q *PostgresQueue) refreshGauge() {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx, countReadySQL, q.maxDeliver)
+	if err != nil {
+		q.logger.Errorf("Failed to count queued jobs in Postgres: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	counts := make(map[domain.Priority]int64)
+	for rows.Next() {
+		var lane string
+		var count int64
+		if err := rows.Scan(&lane, &count); err != nil {
+			q.logger.Errorf("Failed to count queued jobs in Postgres: %v", err)
+			return
+		}
+		counts[domain.Priority(lane)] = count
+	}
+	if err := rows.Err(); err != nil {
+		q.logger.Errorf("Failed to count queued jobs in Postgres: %v", err)
+		return
+	}
+	for _, lane := range domain.Priorities {
+		q.queueDepth.Set(lane, float64(counts[lane]))
+	}
+
+	var scheduled, retries int64
+	if err := q.db.QueryRowContext(ctx, countScheduledSQL).Scan(&scheduled, &retries); err != nil {
+		q.logger.Errorf("Failed to count scheduled jobs in Postgres: %v", err)
+		return
+	}
+	q.scheduled.Set(float64(scheduled), float64(retries))
+}
+
+// Close stops handing out jobs. Queued jobs stay in the table for the next
+// run; the database handle is left open so in-flight jobs can still be
+// acknowledged, and is closed by its owner.
+func (q *PostgresQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+		q.listener.Close()
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *PostgresQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Ensure PostgresQueue implements the ports.Queue, ports.Scheduler and ports.LeaseExtender interfaces
+var (
+	_ ports.Queue         = (*PostgresQueue)(nil)
+	_ ports.Scheduler     = (*PostgresQueue)(nil)
+	_ ports.LeaseExtender = (*PostgresQueue)(nil)
+)
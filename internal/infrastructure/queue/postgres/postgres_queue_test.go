@@ -0,0 +1,318 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+// openTestDB connects to the database named by POSTGRES_TEST_URL and gives
+// the test an empty, migrated email_jobs table. The test is skipped when the
+// variable is not set.
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_TEST_URL")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_URL not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `DROP TABLE IF EXISTS email_jobs, email_schema_migrations`); err != nil {
+		t.Fatalf("failed to reset the database: %v", err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	// Migrations are idempotent.
+	if err := Migrate(ctx, db); err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+	return db, dsn
+}
+
+// recordingDLQ keeps the jobs moved to the DLQ.
+type recordingDLQ struct {
+	mu   sync.Mutex
+	jobs []domain.EmailJob
+}
+
+func (d *recordingDLQ) Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.jobs = append(d.jobs, job)
+}
+
+func (d *recordingDLQ) stored() []domain.EmailJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]domain.EmailJob(nil), d.jobs...)
+}
+
+func newTestQueue(t *testing.T, db *sql.DB, dsn string, consumer string, lease time.Duration) *PostgresQueue {
+	t.Helper()
+	return newTestQueueWithDLQ(t, db, dsn, consumer, lease, &recordingDLQ{}, 5)
+}
+
+func newTestQueueWithDLQ(t *testing.T, db *sql.DB, dsn string, consumer string, lease time.Duration, dlq ports.DeadLetterQueue, maxDeliver int) *PostgresQueue {
+	t.Helper()
+	depth := metrics.NewQueueDepth(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"}),
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"}),
+	)
+	scheduled := metrics.NewScheduledJobs(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_retries"}),
+	)
+	q, err := NewPostgresQueue(db, dsn, dlq, logger.NewLogger(), depth, scheduled, consumer, lease, 20*time.Millisecond, maxDeliver)
+	if err != nil {
+		t.Fatalf("NewPostgresQueue() error = %v", err)
+	}
+	t.Cleanup(q.Close)
+	return q
+}
+
+func dequeueNow(t *testing.T, q *PostgresQueue) domain.EmailJob {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, err := q.Dequeue(ctx, domain.Priorities)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	return job
+}
+
+func countJobs(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM email_jobs`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestParseReceipt(t *testing.T) {
+	tests := []struct {
+		receipt   string
+		wantID    int64
+		wantToken string
+		wantErr   bool
+	}{
+		{receipt: "42:abc", wantID: 42, wantToken: "abc"},
+		{receipt: "42", wantErr: true},
+		{receipt: "42:", wantErr: true},
+		{receipt: "x:abc", wantErr: true},
+		{receipt: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		id, token, err := parseReceipt(tt.receipt)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseReceipt(%q) error = %v, want error %v", tt.receipt, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (id != tt.wantID || token != tt.wantToken) {
+			t.Errorf("parseReceipt(%q) = %d, %q, want %d, %q", tt.receipt, id, token, tt.wantID, tt.wantToken)
+		}
+	}
+}
+
+func TestPostgresQueueLanesAndAck(t *testing.T) {
+	db, dsn := openTestDB(t)
+	q := newTestQueue(t, db, dsn, "worker-1", time.Minute)
+	ctx := context.Background()
+
+	for _, job := range []domain.EmailJob{
+		{ID: "bulk", To: "a@example.com", Priority: domain.PriorityBulk},
+		{ID: "normal", To: "a@example.com"},
+		{ID: "high", To: "a@example.com", Priority: domain.PriorityHigh},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	for _, want := range []string{"high", "normal", "bulk"} {
+		job := dequeueNow(t, q)
+		if job.ID != want {
+			t.Errorf("Dequeue() = %s, want %s", job.ID, want)
+		}
+		if err := q.Ack(job); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+	if n := countJobs(t, db); n != 0 {
+		t.Errorf("%d jobs left after Ack, want 0", n)
+	}
+}
+
+func TestPostgresQueueNackReleasesJob(t *testing.T) {
+	db, dsn := openTestDB(t)
+	q := newTestQueue(t, db, dsn, "worker-1", time.Minute)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	first := dequeueNow(t, q)
+	if err := q.Nack(first); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	second := dequeueNow(t, q)
+	if second.ID != "1" || second.Receipt == first.Receipt {
+		t.Errorf("Dequeue() after Nack = %s with receipt %q, want job 1 with a new receipt", second.ID, second.Receipt)
+	}
+}
+
+func TestPostgresQueueStaleLeaseHolderCannotAckOrNack(t *testing.T) {
+	db, dsn := openTestDB(t)
+	// Both queues share the consumer name, like the workers of one instance.
+	slow := newTestQueue(t, db, dsn, "instance-1", 100*time.Millisecond)
+	fast := newTestQueue(t, db, dsn, "instance-1", time.Minute)
+
+	if err := slow.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	stale := dequeueNow(t, slow)
+	time.Sleep(200 * time.Millisecond) // The lease of the slow worker runs out
+	current := dequeueNow(t, fast)
+	if current.ID != "1" {
+		t.Fatalf("Dequeue() after the lease expired = %s, want 1", current.ID)
+	}
+
+	if err := slow.ExtendLease(stale); !errors.Is(err, ports.ErrLeaseLost) {
+		t.Errorf("ExtendLease() with a stale receipt error = %v, want ErrLeaseLost", err)
+	}
+	if err := slow.Nack(stale); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	if err := slow.Ack(stale); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if n := countJobs(t, db); n != 1 {
+		t.Fatalf("%d jobs left after a stale Ack, want 1", n)
+	}
+	var lockedBy sql.NullString
+	if err := db.QueryRow(`SELECT locked_by FROM email_jobs`).Scan(&lockedBy); err != nil || !lockedBy.Valid {
+		t.Errorf("job lease released by a stale Nack (locked_by = %v, %v)", lockedBy, err)
+	}
+
+	if err := fast.Ack(current); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if n := countJobs(t, db); n != 0 {
+		t.Errorf("%d jobs left after the lease holder's Ack, want 0", n)
+	}
+}
+
+func TestPostgresQueueExtendLease(t *testing.T) {
+	db, dsn := openTestDB(t)
+	q := newTestQueue(t, db, dsn, "worker-1", 200*time.Millisecond)
+	other := newTestQueue(t, db, dsn, "worker-2", time.Minute)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	job := dequeueNow(t, q)
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err := q.ExtendLease(job); err != nil {
+			t.Fatalf("ExtendLease() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if stolen, err := other.Dequeue(ctx, domain.Priorities); err == nil {
+		t.Errorf("Dequeue() claimed job %s whose lease was extended", stolen.ID)
+	}
+}
+
+func TestPostgresQueueDeadLettersAfterMaxDeliver(t *testing.T) {
+	db, dsn := openTestDB(t)
+	dlq := &recordingDLQ{}
+	q := newTestQueueWithDLQ(t, db, dsn, "worker-1", 100*time.Millisecond, dlq, 2)
+
+	if err := q.Enqueue(context.Background(), domain.EmailJob{ID: "poison", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	// The worker dies during both deliveries.
+	for i := 0; i < 2; i++ {
+		dequeueNow(t, q)
+		time.Sleep(200 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if job, err := q.Dequeue(ctx, domain.Priorities); err == nil {
+		t.Fatalf("Dequeue() = %s after max deliver claims, want nothing", job.ID)
+	}
+
+	q.deadLetterExhausted()
+	if stored := dlq.stored(); len(stored) != 1 || stored[0].ID != "poison" {
+		t.Fatalf("DLQ holds %v, want the poison job", stored)
+	}
+	if n := countJobs(t, db); n != 0 {
+		t.Errorf("%d jobs left after dead-lettering, want 0", n)
+	}
+}
+
+func TestPostgresQueueSchedule(t *testing.T) {
+	db, dsn := openTestDB(t)
+	q := newTestQueue(t, db, dsn, "worker-1", time.Minute)
+
+	if err := q.Schedule(context.Background(), domain.EmailJob{ID: "later", To: "a@example.com"}, time.Now().Add(300*time.Millisecond)); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if job, err := q.Dequeue(ctx, domain.Priorities); err == nil {
+		t.Fatalf("Dequeue() returned scheduled job %s before it was due", job.ID)
+	}
+	if job := dequeueNow(t, q); job.ID != "later" {
+		t.Errorf("Dequeue() = %s, want later", job.ID)
+	}
+}
+
+func TestPostgresQueueEnqueueTx(t *testing.T) {
+	db, dsn := openTestDB(t)
+	q := newTestQueue(t, db, dsn, "worker-1", time.Minute)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueTx(tx, domain.EmailJob{To: "rolled-back@example.com"}); err != nil {
+		t.Fatalf("EnqueueTx() error = %v", err)
+	}
+	tx.Rollback()
+	if n := countJobs(t, db); n != 0 {
+		t.Fatalf("%d jobs after a rolled back EnqueueTx, want 0", n)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueTx(tx, domain.EmailJob{To: "committed@example.com"}); err != nil {
+		t.Fatalf("EnqueueTx() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if job := dequeueNow(t, q); job.To != "committed@example.com" || job.ID == "" {
+		t.Errorf("Dequeue() = %+v, want the committed job with an ID", job)
+	}
+}
@@ -0,0 +1,202 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// Mode selects how the service reaches Redis.
+type Mode string
+
+const (
+	// ModeStandalone talks to a single Redis server.
+	ModeStandalone Mode = "standalone"
+	// ModeSentinel asks the Sentinels for the current master and follows
+	// it when they fail over to a replica.
+	ModeSentinel Mode = "sentinel"
+	// ModeCluster routes every command to the node owning its slot and
+	// follows slot migrations and failovers within the cluster.
+	ModeCluster Mode = "cluster"
+)
+
+const (
+	connectBackoffMin = 500 * time.Millisecond
+	connectBackoffMax = 10 * time.Second
+
+	// monitorInterval is how often a Monitor pings Redis while it answers.
+	monitorInterval = 5 * time.Second
+)
+
+// ClientOptions configures NewRedisClient.
+type ClientOptions struct {
+	Mode Mode
+	// Addrs is the Redis server in standalone mode, the Sentinels in
+	// sentinel mode and the seed nodes in cluster mode.
+	Addrs    []string
+	Password string
+	DB       int // Not supported in cluster mode
+	// MasterName is the name of the master monitored by the Sentinels.
+	MasterName       string
+	SentinelPassword string
+	// ConnectWait is how long NewRedisClient keeps retrying to reach Redis
+	// before it returns a client that is not connected yet.
+	ConnectWait time.Duration
+}
+
+// NewRedisClient creates a Redis client for the configured mode and waits
+// until Redis answers a ping, retrying with exponential backoff, so that the
+// service can start before Redis or while a failover is in progress. If
+// Redis does not answer within ConnectWait, the client is returned all the
+// same, so that the service can start degraded; the client connects by
+// itself once Redis is back, which a Monitor reports. Only an invalid
+// configuration is an error.
+func NewRedisClient(opts ClientOptions, l *logger.Logger) (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	switch opts.Mode {
+	case ModeSentinel:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelPassword: opts.SentinelPassword,
+			Password:         opts.Password,
+			DB:               opts.DB,
+		})
+	case ModeCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    opts.Addrs,
+			Password: opts.Password,
+		})
+	case ModeStandalone, "":
+		if len(opts.Addrs) != 1 {
+			return nil, fmt.Errorf("standalone mode needs exactly one Redis address, got %d", len(opts.Addrs))
+		}
+		client = redis.NewClient(&redis.Options{
+			Addr:     opts.Addrs[0],
+			Password: opts.Password,
+			DB:       opts.DB,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported Redis mode %q", opts.Mode)
+	}
+
+	if err := waitForRedis(client, opts.ConnectWait, l); err != nil {
+		l.Errorf("%v. Starting without Redis and reconnecting in the background.", err)
+	}
+	return client, nil
+}
+
+// waitForRedis pings Redis until it answers or wait has passed.
+func waitForRedis(client redis.UniversalClient, wait time.Duration, l *logger.Logger) error {
+	deadline := time.Now().Add(wait)
+	backoff := connectBackoffMin
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		err := client.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("could not connect to Redis after %d attempts: %w", attempt, err)
+		}
+		l.Warnf("Could not connect to Redis (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > connectBackoffMax {
+			backoff = connectBackoffMax
+		}
+	}
+}
+
+// Monitor implements the ports.HealthChecker interface for Redis. It pings
+// Redis in the background, with exponential backoff while it does not
+// answer, and logs when the connection is lost and back.
+type Monitor struct {
+	client   redis.UniversalClient
+	logger   *logger.Logger
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu  sync.Mutex
+	err error // Of the last ping, nil while Redis answers
+}
+
+// NewMonitor pings Redis once and keeps pinging it until Close is called.
+func NewMonitor(client redis.UniversalClient, l *logger.Logger) *Monitor {
+	m := &Monitor{client: client, logger: l, stop: make(chan struct{})}
+	m.err = m.ping()
+	go m.run()
+	return m
+}
+
+// Name returns "redis".
+func (m *Monitor) Name() string {
+	return "redis"
+}
+
+// Check reports the result of the last ping without contacting Redis.
+func (m *Monitor) Check(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return fmt.Errorf("%w: Redis: %v", ports.ErrUnavailable, m.err)
+	}
+	return nil
+}
+
+// Close stops pinging Redis. The client is left to its owner.
+func (m *Monitor) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *Monitor) run() {
+	backoff := connectBackoffMin
+	for {
+		wait := monitorInterval
+		if m.Check(context.Background()) != nil {
+			wait = backoff
+			backoff *= 2
+			if backoff > connectBackoffMax {
+				backoff = connectBackoffMax
+			}
+		} else {
+			backoff = connectBackoffMin
+		}
+
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		err := m.ping()
+		m.mu.Lock()
+		lost, back := err != nil && m.err == nil, err == nil && m.err != nil
+		m.err = err
+		m.mu.Unlock()
+		if lost {
+			m.logger.Errorf("Lost the connection to Redis, reconnecting in the background: %v", err)
+		}
+		if back {
+			m.logger.Printf("Connected to Redis")
+		}
+	}
+}
+
+func (m *Monitor) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return m.client.Ping(ctx).Err()
+}
+
+// Ensure Monitor implements the ports.HealthChecker interface
+var _ ports.HealthChecker = (*Monitor)(nil)
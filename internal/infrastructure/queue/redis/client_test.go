@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+func TestNewRedisClientWaitsForRedis(t *testing.T) {
+	// Take a free address and start Redis on it only after a while.
+	server := miniredis.NewMiniRedis()
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addr()
+	server.Close()
+	go func() {
+		time.Sleep(700 * time.Millisecond)
+		server.StartAddr(addr)
+	}()
+	t.Cleanup(server.Close)
+
+	client, err := NewRedisClient(ClientOptions{Addrs: []string{addr}, ConnectWait: 10 * time.Second}, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("NewRedisClient() error = %v", err)
+	}
+	client.Close()
+}
+
+func TestNewRedisClientStartsWithoutRedis(t *testing.T) {
+	server := miniredis.NewMiniRedis()
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addr()
+	server.Close()
+
+	client, err := NewRedisClient(ClientOptions{Addrs: []string{addr}, ConnectWait: time.Second}, logger.NewLogger())
+	if err != nil {
+		t.Fatalf("NewRedisClient() without Redis error = %v, want a client that is not connected yet", err)
+	}
+	defer client.Close()
+	monitor := NewMonitor(client, logger.NewLogger())
+	defer monitor.Close()
+	if err := monitor.Check(context.Background()); !errors.Is(err, ports.ErrUnavailable) {
+		t.Fatalf("Check() without Redis error = %v, want ErrUnavailable", err)
+	}
+
+	// The monitor notices Redis coming up, and the client works.
+	if err := server.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for monitor.Check(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Check() error = %v after Redis came up, want nil", monitor.Check(context.Background()))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := client.Set(context.Background(), "key", "value", 0).Err(); err != nil {
+		t.Errorf("Set() after Redis came up error = %v", err)
+	}
+}
+
+func TestNewRedisClientModes(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	tests := []struct {
+		name    string
+		opts    ClientOptions
+		cluster bool
+		wantErr bool
+	}{
+		{name: "standalone", opts: ClientOptions{Addrs: []string{server.Addr()}}},
+		{name: "cluster", opts: ClientOptions{Mode: ModeCluster, Addrs: []string{server.Addr()}}, cluster: true},
+		{name: "standalone with two addresses", opts: ClientOptions{Addrs: []string{server.Addr(), server.Addr()}}, wantErr: true},
+		{name: "unknown mode", opts: ClientOptions{Mode: "ring", Addrs: []string{server.Addr()}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.ConnectWait = time.Second
+			client, err := NewRedisClient(tt.opts, logger.NewLogger())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRedisClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			if _, ok := client.(*redis.ClusterClient); ok != tt.cluster {
+				t.Errorf("NewRedisClient() = %T, want a cluster client %v", client, tt.cluster)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// KeyBase returns the base of the Redis keys of a named queue: prefix
+// followed by email_jobs for the default queue, or by email_jobs:<queue> for
+// the others. prefix lets several environments or services share a Redis
+// server.
+func KeyBase(prefix, queue string) string {
+	if queue == domain.DefaultQueue {
+		return prefix + "email_jobs"
+	}
+	return prefix + "email_jobs:" + queue
+}
+
+// keyspace names the Redis keys of a queue and its scheduler, all of which
+// start with the queue's key base.
+//
+// On a Redis Cluster the base is a hash tag, e.g. {email_jobs}, so that
+// every key of the queue maps to the same slot: the lanes, processing lists
+// and in-flight set are used together in scripts, transactions and
+// multi-key reads, which a cluster only allows within one slot. Elsewhere
+// the keys keep their plain names, so that queued jobs survive an upgrade.
+type keyspace struct {
+	base string
+}
+
+// keyspaceFor returns the keyspace of the queue with the given key base on
+// the deployment client talks to.
+func keyspaceFor(client redis.UniversalClient, base string) keyspace {
+	if _, ok := client.(*redis.ClusterClient); ok {
+		return keyspace{base: "{" + base + "}"}
+	}
+	return keyspace{base: base}
+}
+
+// queue returns the list of the normal lane, which is also the base of the
+// other list keys.
+func (k keyspace) queue() string {
+	return k.base + "_queue"
+}
+
+// lane returns the list holding the jobs of a priority lane. The normal
+// lane keeps the original queue key, so jobs queued before lanes existed
+// are still delivered.
+func (k keyspace) lane(lane domain.Priority) string {
+	if lane == domain.PriorityNormal {
+		return k.queue()
+	}
+	return k.queue() + ":" + string(lane)
+}
+
+// lanes returns the lists of every priority lane, in the order of
+// domain.Priorities.
+func (k keyspace) lanes() []string {
+	keys := make([]string, len(domain.Priorities))
+	for i, lane := range domain.Priorities {
+		keys[i] = k.lane(lane)
+	}
+	return keys
+}
+
+// processingPrefix is the prefix of the per-consumer processing lists of
+// the reliable mode.
+func (k keyspace) processingPrefix() string {
+	return k.queue() + ":processing:"
+}
+
+// inFlight returns the sorted set of "<consumer>\n<payload>" members scored
+// by their visibility deadline, used by the reliable mode.
+func (k keyspace) inFlight() string {
+	return k.queue() + ":inflight"
+}
+
+// stream returns the stream holding the jobs of a priority lane. The normal
+// lane keeps the original stream key.
+func (k keyspace) stream(lane domain.Priority) string {
+	if lane == domain.PriorityNormal {
+		return k.base + "_stream"
+	}
+	return k.base + "_stream:" + string(lane)
+}
+
+// streamLane is the inverse of stream.
+func (k keyspace) streamLane(stream string) domain.Priority {
+	for _, lane := range domain.Priorities {
+		if k.stream(lane) == stream {
+			return lane
+		}
+	}
+	return domain.PriorityNormal
+}
+
+// scheduled returns the sorted set of "<id>\n<payload>" members scored by
+// the time the job is due, in unix milliseconds. The random id keeps
+// identical jobs scheduled for different times apart.
+func (k keyspace) scheduled() string {
+	return k.base + "_scheduled"
+}
+
+// scheduledRetries returns the set holding the ids of the scheduled jobs
+// that are retries, so that they can be counted on their own.
+func (k keyspace) scheduledRetries() string {
+	return k.base + "_scheduled_retries"
+}
@@ -4,131 +4,550 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/prometheus/client_golang/prometheus"
 
 	"email-queue-service/internal/core/domain"
 	"email-queue-service/internal/core/ports"
 	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
 )
 
 const (
-	redisQueueKey = "email_jobs_queue"
-	redisTimeout  = 5 * time.Second
+	redisTimeout = 5 * time.Second
+
+	reaperBatchSize = 100
+
+	// blockTimeout bounds every blocking read, so that Close and context
+	// cancellation are noticed while the queue is idle.
+	blockTimeout = time.Second
+
+	// pollInterval is how long a reliable Dequeue waits before it looks at
+	// the lanes again once they were all empty.
+	pollInterval = 100 * time.Millisecond
 )
 
-// RedisQueue implements the ports.Queue interface using Redis LIST commands.
-type RedisQueue struct {
-	client           *redis.Client
-	logger           *logger.Logger
-	queueLengthGauge prometheus.Gauge
-	closed           bool
-}
+// laneKeyLua maps a job payload to the list of its priority lane among
+// KEYS[first], KEYS[first+1] and KEYS[first+2], the high, normal and bulk
+// lanes as passed by keyspace.lanes. Payloads that cannot be decoded go to
+// the normal lane. Every key comes in through KEYS, so that a cluster can
+// check they share a slot.
+const laneKeyLua = `
+local function lane_key(first, payload)
+	local ok, job = pcall(cjson.decode, payload)
+	if ok and type(job) == 'table' then
+		if job.priority == 'high' then
+			return KEYS[first]
+		elseif job.priority == 'bulk' then
+			return KEYS[first + 2]
+		end
+	end
+	return KEYS[first + 1]
+end
+`
 
-// NewRedisClient creates and pings a new Redis client.
-func NewRedisClient(addr, password string, db int) *redis.Client {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+// moveFirstScript moves the head of the first non-empty list of KEYS[1..n-2]
+// to the processing list KEYS[n-1], trying the lanes in the order given, and
+// adds it to the in-flight set KEYS[n] with the visibility deadline ARGV[1].
+// ARGV[2] is the prefix of the in-flight members of this consumer.
+var moveFirstScript = redis.NewScript(`
+for i = 1, #KEYS - 2 do
+	local payload = redis.call('LMOVE', KEYS[i], KEYS[#KEYS - 1], 'LEFT', 'RIGHT')
+	if payload then
+		redis.call('ZADD', KEYS[#KEYS], ARGV[1], ARGV[2] .. payload)
+		return payload
+	end
+end
+return false
+`)
 
-	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
-	defer cancel()
+// ackScript removes a delivery from its processing list and the in-flight set.
+var ackScript = redis.NewScript(`
+redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[2])
+return 1
+`)
 
-	_, err := client.Ping(ctx).Result()
-	if err != nil {
-		log.Fatalf("Could not connect to Redis: %v", err)
-	}
-	return client
+// nackScript returns a delivery to its lane, unless the reaper already did.
+var nackScript = redis.NewScript(`
+redis.call('ZREM', KEYS[2], ARGV[2])
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) > 0 then
+	redis.call('RPUSH', KEYS[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// extendScript pushes back the visibility deadline of a delivery that is
+// still in flight.
+var extendScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// recoverScript returns every job in the processing list KEYS[1] to its
+// lane among KEYS[3..5] and removes it from the in-flight set KEYS[2].
+var recoverScript = redis.NewScript(laneKeyLua + `
+local recovered = 0
+while true do
+	local payload = redis.call('LPOP', KEYS[1])
+	if not payload then
+		break
+	end
+	redis.call('ZREM', KEYS[2], ARGV[1] .. payload)
+	redis.call('RPUSH', lane_key(3, payload), payload)
+	recovered = recovered + 1
+end
+return recovered
+`)
+
+// reapScript returns deliveries whose visibility deadline ARGV[1] has passed
+// to their lane among KEYS[2..4] and reports how many were requeued. The
+// members of the in-flight set KEYS[1] come in ARGV[2..] as pairs of member
+// and the index in KEYS of the processing list of its consumer; a member
+// that was acknowledged or extended since it was read is left alone.
+var reapScript = redis.NewScript(laneKeyLua + `
+local requeued = 0
+for i = 2, #ARGV, 2 do
+	local member = ARGV[i]
+	local deadline = redis.call('ZSCORE', KEYS[1], member)
+	if deadline and tonumber(deadline) <= tonumber(ARGV[1]) then
+		redis.call('ZREM', KEYS[1], member)
+		local payload = string.sub(member, string.find(member, '\n', 1, true) + 1)
+		if redis.call('LREM', KEYS[tonumber(ARGV[i + 1])], 1, payload) > 0 then
+			redis.call('RPUSH', lane_key(2, payload), payload)
+			requeued = requeued + 1
+		end
+	end
+end
+return requeued
+`)
+
+// RedisQueue implements the ports.Queue interface using Redis LIST commands,
+// with one list per priority lane.
+//
+// In reliable mode, Dequeue atomically moves a job into a per-consumer
+// processing list and records its visibility deadline, in one script,
+// instead of popping it, and the job only leaves Redis once it is
+// acknowledged. A reaper returns jobs that were not
+// acknowledged within the visibility timeout, e.g. because the worker crashed.
+type RedisQueue struct {
+	client     redis.UniversalClient
+	keys       keyspace
+	logger     *logger.Logger
+	queueDepth *metrics.QueueDepth
+	mu         sync.Mutex
+	closed     bool
+
+	reliable          bool
+	consumer          string
+	visibilityTimeout time.Duration
+	stopReaper        chan struct{}
 }
 
 // NewRedisQueue creates a new RedisQueue instance.
-func NewRedisQueue(client *redis.Client, l *logger.Logger, queueLengthGauge prometheus.Gauge) *RedisQueue {
+func NewRedisQueue(client redis.UniversalClient, keyBase string, l *logger.Logger, queueDepth *metrics.QueueDepth) *RedisQueue {
 	q := &RedisQueue{
-		client:           client,
-		logger:           l,
-		queueLengthGauge: queueLengthGauge,
-		closed:           false,
+		client:     client,
+		keys:       keyspaceFor(client, keyBase),
+		logger:     l,
+		queueDepth: queueDepth,
+		closed:     false,
 	}
-	// Initialize gauge with current queue length
+	// Initialize gauges with current lane lengths
+	q.refreshDepth()
+	return q
+}
+
+// refreshDepth sets the lane gauges to the lengths of the lane lists.
+func (q *RedisQueue) refreshDepth() {
 	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
 	defer cancel()
-	length, err := client.LLen(ctx, redisQueueKey).Result()
-	if err != nil {
-		l.Errorf("Failed to get initial Redis queue length: %v", err)
-	} else {
-		q.queueLengthGauge.Set(float64(length))
+
+	for _, lane := range domain.Priorities {
+		length, err := q.client.LLen(ctx, q.keys.lane(lane)).Result()
+		if err != nil {
+			q.logger.Errorf("Failed to get Redis queue length of lane %s: %v", lane, err)
+			continue
+		}
+		q.queueDepth.Set(lane, float64(length))
 	}
+}
+
+// NewReliableRedisQueue creates a RedisQueue in reliable mode. The consumer
+// name must be unique per service instance and stable across its restarts:
+// jobs left in its processing list by a previous run are requeued on startup.
+func NewReliableRedisQueue(client redis.UniversalClient, keyBase string, l *logger.Logger, queueDepth *metrics.QueueDepth, consumer string, visibilityTimeout time.Duration) *RedisQueue {
+	q := NewRedisQueue(client, keyBase, l, queueDepth)
+	q.reliable = true
+	q.consumer = consumer
+	q.visibilityTimeout = visibilityTimeout
+	q.stopReaper = make(chan struct{})
+
+	q.recoverProcessingList()
+	go q.runReaper()
 	return q
 }
 
-// Enqueue adds a job to the Redis queue.
-func (q *RedisQueue) Enqueue(job domain.EmailJob) error {
-	if q.closed {
-		return fmt.Errorf("queue is closed, cannot enqueue new jobs")
+// Enqueue adds a job to the list of its priority lane.
+func (q *RedisQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if q.IsClosed() {
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
 	}
+	return q.push(ctx, job)
+}
 
+// push appends a job to its lane.
+func (q *RedisQueue) push(ctx context.Context, job domain.EmailJob) error {
 	jobBytes, err := json.Marshal(job)
 	if err != nil {
 		return fmt.Errorf("failed to marshal job: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
 	defer cancel()
 
 	// RPUSH adds the job to the tail of the list
-	err = q.client.RPush(ctx, redisQueueKey, jobBytes).Err()
+	err = q.client.RPush(ctx, q.keys.lane(job.Lane()), jobBytes).Err()
 	if err != nil {
 		return fmt.Errorf("failed to enqueue job to Redis: %w", err)
 	}
-	q.queueLengthGauge.Inc()
+	q.queueDepth.Inc(job.Lane())
 	return nil
 }
 
-// Dequeue retrieves a job from the Redis queue.
-func (q *RedisQueue) Dequeue() (domain.EmailJob, bool) {
-	ctx := context.Background() // Use a long-lived context for blocking pop
+// EnqueueBatch adds jobs to the lists of their priority lanes with pipelined
+// RPUSH commands, in a single round trip.
+func (q *RedisQueue) EnqueueBatch(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	if q.IsClosed() {
+		for i := range errs {
+			errs[i] = fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+		}
+		return errs
+	}
 
-	// BLPOP blocks until an element is available, or timeout occurs
-	// Timeout of 0 means block indefinitely
-	result, err := q.client.BLPop(ctx, 0, redisQueueKey).Result()
-	if err == redis.Nil {
-		// No elements in the list, and BLPOP timed out (shouldn't happen with 0 timeout)
-		return domain.EmailJob{}, false
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	cmds := make([]*redis.IntCmd, len(jobs))
+	pipe := q.client.Pipeline()
+	for i, job := range jobs {
+		jobBytes, err := json.Marshal(job)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to marshal job: %w", err)
+			continue
+		}
+		cmds[i] = pipe.RPush(ctx, q.keys.lane(job.Lane()), jobBytes)
 	}
-	if err != nil {
-		q.logger.Errorf("Failed to dequeue job from Redis: %v", err)
-		return domain.EmailJob{}, false
+	// Exec reports the first failed command; every command is checked below.
+	_, _ = pipe.Exec(ctx)
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		if err := cmd.Err(); err != nil {
+			errs[i] = fmt.Errorf("failed to enqueue job to Redis: %w", err)
+			continue
+		}
+		q.queueDepth.Inc(jobs[i].Lane())
+	}
+	return errs
+}
+
+// Dequeue retrieves a job from the first lane in lanes that has one. Queued
+// jobs stay in Redis once the queue is closed, so there is nothing to drain.
+func (q *RedisQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	if q.reliable {
+		return q.dequeueReliable(ctx, lanes)
+	}
+
+	keys := make([]string, len(lanes))
+	for i, lane := range lanes {
+		keys[i] = q.keys.lane(lane)
+	}
+
+	for {
+		if q.IsClosed() {
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		// BLPOP pops from the first non-empty key in the order given. It
+		// blocks for at most blockTimeout, so Close and cancellation are
+		// noticed while the queue is idle.
+		result, err := q.client.BLPop(ctx, blockTimeout, keys...).Result()
+		if err == redis.Nil {
+			continue // Nothing arrived within the block time
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return domain.EmailJob{}, ctx.Err()
+			}
+			return domain.EmailJob{}, fmt.Errorf("failed to dequeue job from Redis: %w", err)
+		}
+
+		// result[0] is the key, result[1] is the value
+		jobBytes := []byte(result[1])
+		var job domain.EmailJob
+		if err := json.Unmarshal(jobBytes, &job); err != nil {
+			q.logger.Errorf("Failed to unmarshal job from Redis, dropping it: %v", err)
+			continue
+		}
+		q.queueDepth.Dec(job.Lane())
+		return job, nil
+	}
+}
+
+// dequeueReliable moves a job into this consumer's processing list and
+// records its visibility deadline in one script, so that no job sits in a
+// processing list without a deadline the reaper can find. A script cannot
+// block, so while the lanes are empty it looks at them again every
+// pollInterval.
+func (q *RedisQueue) dequeueReliable(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	keys := make([]string, 0, len(lanes)+2)
+	for _, lane := range lanes {
+		keys = append(keys, q.keys.lane(lane))
 	}
+	keys = append(keys, q.processingKey(), q.keys.inFlight())
 
-	// result[0] is the key, result[1] is the value
-	jobBytes := []byte(result[1])
+	for {
+		if q.IsClosed() {
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		deadline := time.Now().Add(q.visibilityTimeout)
+		payload, err := moveFirstScript.Run(ctx, q.client, keys, deadline.Unix(), q.inFlightMember("")).Text()
+		if err == redis.Nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return domain.EmailJob{}, ctx.Err()
+			}
+			return domain.EmailJob{}, fmt.Errorf("failed to dequeue job from Redis: %w", err)
+		}
+
+		if job, ok := q.delivered(payload); ok {
+			return job, nil
+		}
+	}
+}
+
+// delivered decodes a job that was just moved to the processing list.
+func (q *RedisQueue) delivered(payload string) (domain.EmailJob, bool) {
 	var job domain.EmailJob
-	if err := json.Unmarshal(jobBytes, &job); err != nil {
-		q.logger.Errorf("Failed to unmarshal job from Redis: %v", err)
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		// A payload that cannot be decoded will never succeed; drop it instead of redelivering it forever.
+		q.logger.Errorf("Failed to unmarshal job from Redis, dropping it: %v", err)
+		q.ack(payload)
 		return domain.EmailJob{}, false
 	}
-	q.queueLengthGauge.Dec()
+	q.queueDepth.Dec(job.Lane())
+	job.Receipt = payload
 	return job, true
 }
 
-// Close closes the Redis client connection.
+// Ack removes a job from the processing list. It is a no-op outside reliable mode.
+func (q *RedisQueue) Ack(job domain.EmailJob) error {
+	if !q.reliable {
+		return nil
+	}
+	return q.ack(job.Receipt)
+}
+
+func (q *RedisQueue) ack(payload string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := []string{q.processingKey(), q.keys.inFlight()}
+	if err := ackScript.Run(ctx, q.client, keys, payload, q.inFlightMember(payload)).Err(); err != nil {
+		return fmt.Errorf("failed to ack job in Redis: %w", err)
+	}
+	return nil
+}
+
+// Nack moves a job from the processing list back to its lane. Outside
+// reliable mode the job has already left Redis, so it is pushed again, even
+// if the queue was closed in the meantime.
+func (q *RedisQueue) Nack(job domain.EmailJob) error {
+	if !q.reliable {
+		return q.push(context.Background(), job)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := []string{q.processingKey(), q.keys.inFlight(), q.keys.lane(job.Lane())}
+	requeued, err := nackScript.Run(ctx, q.client, keys, job.Receipt, q.inFlightMember(job.Receipt)).Int()
+	if err != nil {
+		return fmt.Errorf("failed to nack job in Redis: %w", err)
+	}
+	if requeued > 0 {
+		q.queueDepth.Inc(job.Lane())
+	}
+	return nil
+}
+
+// LeaseDuration returns the visibility timeout in reliable mode, and 0
+// otherwise, as jobs then leave Redis when they are dequeued.
+func (q *RedisQueue) LeaseDuration() time.Duration {
+	if !q.reliable {
+		return 0
+	}
+	return q.visibilityTimeout
+}
+
+// ExtendLease moves the visibility deadline of a job in the processing list
+// to a full visibility timeout from now. It is a no-op outside reliable mode.
+func (q *RedisQueue) ExtendLease(job domain.EmailJob) error {
+	if !q.reliable {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	deadline := time.Now().Add(q.visibilityTimeout)
+	extended, err := extendScript.Run(ctx, q.client, []string{q.keys.inFlight()}, q.inFlightMember(job.Receipt), deadline.Unix()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to extend job lease in Redis: %w", err)
+	}
+	if extended == 0 {
+		return ports.ErrLeaseLost
+	}
+	return nil
+}
+
+// recoverProcessingList requeues jobs a previous run of this consumer left behind.
+func (q *RedisQueue) recoverProcessingList() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := append([]string{q.processingKey(), q.keys.inFlight()}, q.keys.lanes()...)
+	recovered, err := recoverScript.Run(ctx, q.client, keys, q.inFlightMember("")).Int()
+	if err != nil {
+		q.logger.Errorf("Failed to recover processing list %s: %v", q.processingKey(), err)
+		return
+	}
+	if recovered > 0 {
+		q.refreshDepth()
+		q.logger.Printf("Recovered %d unacknowledged jobs from a previous run of consumer %s", recovered, q.consumer)
+	}
+}
+
+// runReaper periodically returns jobs whose visibility timeout expired.
+func (q *RedisQueue) runReaper() {
+	interval := q.visibilityTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopReaper:
+			return
+		case <-ticker.C:
+			q.reap()
+		}
+	}
+}
+
+func (q *RedisQueue) reap() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	expired, err := q.client.ZRangeByScore(ctx, q.keys.inFlight(), &redis.ZRangeBy{Min: "-inf", Max: now, Count: reaperBatchSize}).Result()
+	if err != nil {
+		q.logger.Errorf("Failed to read expired jobs: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	// The processing lists are named after their consumer, so they are
+	// looked up here and handed to the script as keys.
+	keys := append([]string{q.keys.inFlight()}, q.keys.lanes()...)
+	listIndex := make(map[string]int)
+	args := []interface{}{now}
+	for _, member := range expired {
+		consumer, _, ok := strings.Cut(member, "\n")
+		if !ok {
+			continue
+		}
+		index, ok := listIndex[consumer]
+		if !ok {
+			keys = append(keys, q.keys.processingPrefix()+consumer)
+			index = len(keys) // Lua indexes KEYS from 1
+			listIndex[consumer] = index
+		}
+		args = append(args, member, index)
+	}
+	requeued, err := reapScript.Run(ctx, q.client, keys, args...).Int()
+	if err != nil {
+		q.logger.Errorf("Failed to requeue expired jobs: %v", err)
+		return
+	}
+	if requeued > 0 {
+		q.refreshDepth()
+		q.logger.Warnf("Requeued %d jobs whose visibility timeout expired", requeued)
+	}
+}
+
+func (q *RedisQueue) processingKey() string {
+	return q.keys.processingPrefix() + q.consumer
+}
+
+func (q *RedisQueue) inFlightMember(payload string) string {
+	return q.consumer + "\n" + payload
+}
+
+// Close stops handing out jobs and stops the reaper. The Redis client is
+// left open so that in-flight jobs can still be acknowledged, and is closed
+// by its owner.
 func (q *RedisQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	if !q.closed {
-		q.client.Close()
+		if q.reliable {
+			close(q.stopReaper)
+		}
 		q.closed = true
-		q.logger.Println("Redis client closed.")
+		q.logger.Println("Redis queue closed.")
 	}
 }
 
 // IsClosed returns true if the queue is closed.
 func (q *RedisQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	return q.closed
 }
 
-// Ensure RedisQueue implements the ports.Queue interface
-var _ ports.Queue = (*RedisQueue)(nil)
+// Ensure RedisQueue implements the ports.BatchQueue and ports.LeaseExtender interfaces
+var (
+	_ ports.BatchQueue    = (*RedisQueue)(nil)
+	_ ports.LeaseExtender = (*RedisQueue)(nil)
+)
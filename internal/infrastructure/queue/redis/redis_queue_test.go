@@ -0,0 +1,423 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+const testKeyBase = "test:email_jobs"
+
+// newTestRedis starts an in-process Redis server and returns a client for it.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// newTestDepth returns queue depth gauges that are not registered anywhere.
+func newTestDepth() *metrics.QueueDepth {
+	return metrics.NewQueueDepth(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"}),
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"}),
+	)
+}
+
+func newTestReliableQueue(t *testing.T, client redis.UniversalClient, consumer string) *RedisQueue {
+	t.Helper()
+	q := NewReliableRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth(), consumer, time.Minute)
+	t.Cleanup(q.Close)
+	return q
+}
+
+func dequeueNow(t *testing.T, q ports.Queue, lanes ...domain.Priority) domain.EmailJob {
+	t.Helper()
+	if len(lanes) == 0 {
+		lanes = domain.Priorities
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	job, err := q.Dequeue(ctx, lanes)
+	if err != nil {
+		t.Fatalf("Dequeue() error = %v", err)
+	}
+	return job
+}
+
+func TestRedisQueueLanes(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+	defer q.Close()
+
+	ctx := context.Background()
+	for _, job := range []domain.EmailJob{
+		{ID: "bulk", To: "a@example.com", Priority: domain.PriorityBulk},
+		{ID: "normal", To: "a@example.com"},
+		{ID: "high", To: "a@example.com", Priority: domain.PriorityHigh},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	for _, want := range []string{"high", "normal", "bulk"} {
+		if got := dequeueNow(t, q).ID; got != want {
+			t.Errorf("Dequeue() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestReliableAckRemovesJob(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestReliableQueue(t, client, "worker-1")
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	job := dequeueNow(t, q)
+	if n := client.LLen(ctx, q.processingKey()).Val(); n != 1 {
+		t.Fatalf("processing list holds %d jobs before Ack, want 1", n)
+	}
+	if n := client.ZCard(ctx, q.keys.inFlight()).Val(); n != 1 {
+		t.Fatalf("in-flight set holds %d jobs before Ack, want 1", n)
+	}
+
+	if err := q.Ack(job); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if n := client.LLen(ctx, q.processingKey()).Val(); n != 0 {
+		t.Errorf("processing list holds %d jobs after Ack, want 0", n)
+	}
+	if n := client.ZCard(ctx, q.keys.inFlight()).Val(); n != 0 {
+		t.Errorf("in-flight set holds %d jobs after Ack, want 0", n)
+	}
+	if n := client.LLen(ctx, q.keys.queue()).Val(); n != 0 {
+		t.Errorf("lane holds %d jobs after Ack, want 0", n)
+	}
+}
+
+func TestReliableNackRequeuesJob(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestReliableQueue(t, client, "worker-1")
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "1", To: "a@example.com", Priority: domain.PriorityHigh}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	job := dequeueNow(t, q)
+	if err := q.Nack(job); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	if n := client.LLen(ctx, q.keys.lane(domain.PriorityHigh)).Val(); n != 1 {
+		t.Fatalf("high lane holds %d jobs after Nack, want 1", n)
+	}
+	if n := client.ZCard(ctx, q.keys.inFlight()).Val(); n != 0 {
+		t.Errorf("in-flight set holds %d jobs after Nack, want 0", n)
+	}
+
+	// A second Nack of the same delivery must not requeue it twice.
+	if err := q.Nack(job); err != nil {
+		t.Fatalf("second Nack() error = %v", err)
+	}
+	if n := client.LLen(ctx, q.keys.lane(domain.PriorityHigh)).Val(); n != 1 {
+		t.Errorf("high lane holds %d jobs after a second Nack, want 1", n)
+	}
+
+	if got := dequeueNow(t, q).ID; got != "1" {
+		t.Errorf("Dequeue() after Nack = %s, want 1", got)
+	}
+}
+
+func TestReliableReaperRequeuesExpiredJobs(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestReliableQueue(t, client, "worker-1")
+	ctx := context.Background()
+
+	for _, job := range []domain.EmailJob{
+		{ID: "expired", To: "a@example.com", Priority: domain.PriorityBulk},
+		{ID: "leased", To: "a@example.com"},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	leased := dequeueNow(t, q)
+	expired := dequeueNow(t, q)
+	if expired.ID != "expired" {
+		t.Fatalf("Dequeue() = %s, want expired", expired.ID)
+	}
+
+	// Move the deadline of one delivery into the past.
+	client.ZAdd(ctx, q.keys.inFlight(), &redis.Z{Score: 1, Member: q.inFlightMember(expired.Receipt)})
+	q.reap()
+
+	if n := client.LLen(ctx, q.keys.lane(domain.PriorityBulk)).Val(); n != 1 {
+		t.Errorf("bulk lane holds %d jobs after reaping, want 1", n)
+	}
+	if n := client.LLen(ctx, q.processingKey()).Val(); n != 1 {
+		t.Errorf("processing list holds %d jobs after reaping, want 1", n)
+	}
+
+	// The worker that lost the lease can no longer extend or requeue it.
+	if err := q.ExtendLease(expired); !errors.Is(err, ports.ErrLeaseLost) {
+		t.Errorf("ExtendLease() of a reaped job error = %v, want ErrLeaseLost", err)
+	}
+	if err := q.Nack(expired); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+	if n := client.LLen(ctx, q.keys.lane(domain.PriorityBulk)).Val(); n != 1 {
+		t.Errorf("bulk lane holds %d jobs after a late Nack, want 1", n)
+	}
+	if err := q.Ack(leased); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+}
+
+func TestReliableExtendLease(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestReliableQueue(t, client, "worker-1")
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	job := dequeueNow(t, q)
+
+	// Pretend the lease is about to run out, then extend it.
+	client.ZAdd(ctx, q.keys.inFlight(), &redis.Z{Score: float64(time.Now().Unix() + 1), Member: q.inFlightMember(job.Receipt)})
+	if err := q.ExtendLease(job); err != nil {
+		t.Fatalf("ExtendLease() error = %v", err)
+	}
+	deadline := client.ZScore(ctx, q.keys.inFlight(), q.inFlightMember(job.Receipt)).Val()
+	if min := float64(time.Now().Add(q.visibilityTimeout).Unix() - 1); deadline < min {
+		t.Errorf("deadline after ExtendLease = %v, want at least %v", deadline, min)
+	}
+
+	q.reap()
+	if n := client.LLen(ctx, q.keys.queue()).Val(); n != 0 {
+		t.Errorf("reaper requeued an extended job, lane holds %d", n)
+	}
+	if q.LeaseDuration() != time.Minute {
+		t.Errorf("LeaseDuration() = %s, want 1m", q.LeaseDuration())
+	}
+	if d := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth()).LeaseDuration(); d != 0 {
+		t.Errorf("LeaseDuration() outside reliable mode = %s, want 0", d)
+	}
+}
+
+func TestReliableStartupRecovery(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+
+	crashed := NewReliableRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth(), "worker-1", time.Minute)
+	for _, job := range []domain.EmailJob{
+		{ID: "high", To: "a@example.com", Priority: domain.PriorityHigh},
+		{ID: "normal", To: "a@example.com"},
+	} {
+		if err := crashed.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	dequeueNow(t, crashed)
+	dequeueNow(t, crashed)
+	crashed.Close() // Crash without acknowledging
+
+	// Another consumer's processing list is left alone.
+	other := newTestReliableQueue(t, client, "worker-2")
+	if n := client.LLen(ctx, other.processingKey()).Val(); n != 0 {
+		t.Fatalf("worker-2 processing list holds %d jobs, want 0", n)
+	}
+	if n := client.LLen(ctx, crashed.processingKey()).Val(); n != 2 {
+		t.Fatalf("worker-1 processing list holds %d jobs, want 2", n)
+	}
+
+	restarted := newTestReliableQueue(t, client, "worker-1")
+	if n := client.LLen(ctx, restarted.processingKey()).Val(); n != 0 {
+		t.Errorf("processing list holds %d jobs after recovery, want 0", n)
+	}
+	if n := client.ZCard(ctx, restarted.keys.inFlight()).Val(); n != 0 {
+		t.Errorf("in-flight set holds %d jobs after recovery, want 0", n)
+	}
+	if n := client.LLen(ctx, restarted.keys.lane(domain.PriorityHigh)).Val(); n != 1 {
+		t.Errorf("high lane holds %d jobs after recovery, want 1", n)
+	}
+	if n := client.LLen(ctx, restarted.keys.queue()).Val(); n != 1 {
+		t.Errorf("normal lane holds %d jobs after recovery, want 1", n)
+	}
+}
+
+func TestRedisQueueDequeueStopsOnCloseAndCancel(t *testing.T) {
+	_, client := newTestRedis(t)
+	for _, reliable := range []bool{false, true} {
+		var q *RedisQueue
+		if reliable {
+			q = NewReliableRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth(), "worker-1", time.Minute)
+		} else {
+			q = NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		_, err := q.Dequeue(ctx, domain.Priorities)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("reliable=%v: Dequeue() of an empty queue error = %v, want DeadlineExceeded", reliable, err)
+		}
+		if waited := time.Since(start); waited > blockTimeout+time.Second {
+			t.Errorf("reliable=%v: Dequeue() took %s to notice the deadline", reliable, waited)
+		}
+
+		q.Close()
+		if _, err := q.Dequeue(context.Background(), domain.Priorities); !errors.Is(err, ports.ErrQueueClosed) {
+			t.Errorf("reliable=%v: Dequeue() after Close error = %v, want ErrQueueClosed", reliable, err)
+		}
+		if err := q.Enqueue(context.Background(), domain.EmailJob{}); !errors.Is(err, ports.ErrQueueClosed) {
+			t.Errorf("reliable=%v: Enqueue() after Close error = %v, want ErrQueueClosed", reliable, err)
+		}
+	}
+}
+
+func TestRedisQueueSurvivesServerRestart(t *testing.T) {
+	server, client := newTestRedis(t)
+	q := newTestReliableQueue(t, client, "worker-1")
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "before", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// While Redis is down, the queue reports errors instead of losing jobs.
+	server.Close()
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "lost", To: "a@example.com"}); err == nil {
+		t.Errorf("Enqueue() while Redis is down succeeded, want an error")
+	}
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if job, err := q.Dequeue(short, domain.Priorities); err == nil {
+		t.Errorf("Dequeue() while Redis is down = %s, want an error", job.ID)
+	}
+
+	// The client reconnects once Redis is back, and the queued job is still there.
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "after", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() after the restart error = %v", err)
+	}
+	for _, want := range []string{"before", "after"} {
+		job := dequeueNow(t, q)
+		if job.ID != want {
+			t.Errorf("Dequeue() = %s, want %s", job.ID, want)
+		}
+		if err := q.Ack(job); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+}
+
+// scriptKeys records the keys of every script a client runs.
+type scriptKeys struct {
+	keys [][]string
+}
+
+func (h *scriptKeys) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	args := cmd.Args()
+	if name := cmd.Name(); (name == "eval" || name == "evalsha") && len(args) > 2 {
+		n, _ := args[2].(int)
+		var keys []string
+		for _, key := range args[3 : 3+n] {
+			keys = append(keys, key.(string))
+		}
+		h.keys = append(h.keys, keys)
+	}
+	return ctx, nil
+}
+
+// requireKeys checks that the scripts run since the first ran scripts were
+// handed every key in want.
+func (h *scriptKeys) requireKeys(t *testing.T, ran int, name string, want []string) {
+	t.Helper()
+	passed := make(map[string]bool)
+	for _, keys := range h.keys[ran:] {
+		for _, key := range keys {
+			passed[key] = true
+		}
+	}
+	for _, key := range want {
+		if !passed[key] {
+			t.Errorf("%s script was not handed key %s", name, key)
+		}
+	}
+}
+
+func (h *scriptKeys) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+func (h *scriptKeys) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+func (h *scriptKeys) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+func TestReliableScriptsUseOneClusterSlot(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+	defer client.Close()
+	hook := &scriptKeys{}
+	client.AddHook(hook)
+	ctx := context.Background()
+
+	q := newTestReliableQueue(t, client, "worker-1")
+	for _, job := range []domain.EmailJob{
+		{ID: "reaped", To: "a@example.com", Priority: domain.PriorityHigh},
+		{ID: "recovered", To: "a@example.com", Priority: domain.PriorityBulk},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	ran := len(hook.keys)
+	reaped := dequeueNow(t, q)
+	hook.requireKeys(t, ran, "dequeue", []string{q.keys.lane(domain.PriorityHigh), q.processingKey(), q.keys.inFlight()})
+	client.ZAdd(ctx, q.keys.inFlight(), &redis.Z{Score: 1, Member: q.inFlightMember(reaped.Receipt)})
+	ran = len(hook.keys)
+	q.reap()
+	hook.requireKeys(t, ran, "reap", append(q.keys.lanes(), q.processingKey()))
+	if n := client.LLen(ctx, q.keys.lane(domain.PriorityHigh)).Val(); n != 1 {
+		t.Errorf("high lane holds %d jobs after reaping, want 1", n)
+	}
+
+	dequeueNow(t, q, domain.PriorityBulk)
+	q.Close()
+	ran = len(hook.keys)
+	newTestReliableQueue(t, client, "worker-1") // Recovers the bulk job
+	hook.requireKeys(t, ran, "recovery", append(q.keys.lanes(), q.processingKey()))
+	if n := client.LLen(ctx, q.keys.lane(domain.PriorityBulk)).Val(); n != 1 {
+		t.Errorf("bulk lane holds %d jobs after recovery, want 1", n)
+	}
+
+	// A cluster only runs a script whose keys all hash to one slot, which the
+	// hash tag of the queue guarantees as long as no key is built in Lua.
+	tag := "{" + testKeyBase + "}"
+	if len(hook.keys) == 0 {
+		t.Fatalf("no scripts ran")
+	}
+	for _, keys := range hook.keys {
+		for _, key := range keys {
+			if !strings.HasPrefix(key, tag) {
+				t.Errorf("script key %q lies outside the hash tag %s", key, tag)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+const (
+	promoteInterval  = time.Second
+	promoteBatchSize = 100
+
+	// promoteBudget bounds how long a tick keeps promoting batches of due
+	// jobs; the next tick carries on with the rest.
+	promoteBudget = 5 * time.Second
+
+	// promoteLease is how long a claimed job is hidden from other promoters.
+	// If the promoter dies before removing it, the job becomes due again.
+	promoteLease = 30 * time.Second
+)
+
+// claimDueScript leases up to ARGV[2] due jobs by pushing their score past
+// the lease, so that concurrent promoters on other instances skip them.
+var claimDueScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(due) do
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[3], member)
+end
+return due
+`)
+
+// Scheduler implements the ports.Scheduler interface with a Redis sorted
+// set. A promoter loop on every instance moves due jobs into the target
+// queue, which may be any queue backend.
+type Scheduler struct {
+	client    redis.UniversalClient
+	keys      keyspace
+	target    ports.Queue
+	logger    *logger.Logger
+	scheduled *metrics.ScheduledJobs
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a Scheduler and starts its promoter loop.
+func NewScheduler(client redis.UniversalClient, keyBase string, target ports.Queue, l *logger.Logger, scheduled *metrics.ScheduledJobs) *Scheduler {
+	s := &Scheduler{
+		client:    client,
+		keys:      keyspaceFor(client, keyBase),
+		target:    target,
+		logger:    l,
+		scheduled: scheduled,
+		done:      make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Schedule adds a job to the sorted set, scored by its due time. Jobs can
+// still be scheduled after the target queue was closed, e.g. retries of jobs
+// that were being processed, and are promoted after the next start.
+func (s *Scheduler) Schedule(ctx context.Context, job domain.EmailJob, at time.Time) error {
+	if s.isClosed() {
+		return fmt.Errorf("scheduler is closed, cannot schedule new jobs")
+	}
+
+	job.Receipt = ""
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return fmt.Errorf("failed to generate schedule id: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	scheduleID := hex.EncodeToString(id[:])
+	member := scheduleID + "\n" + string(jobBytes)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, s.keys.scheduled(), &redis.Z{Score: float64(at.UnixMilli()), Member: member})
+		if job.Retries > 0 {
+			pipe.SAdd(ctx, s.keys.scheduledRetries(), scheduleID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule job in Redis: %w", err)
+	}
+	s.scheduled.Inc(job)
+	return nil
+}
+
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(promoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.promoteDue()
+		}
+	}
+}
+
+// promoteDue promotes batches of due jobs until none is left or
+// promoteBudget has passed, so that a backlog, e.g. of jobs that fell due
+// while no instance ran, is not held to one batch per tick. It stops early
+// if a whole batch fails to be promoted, as the next one would too, and
+// nothing is claimed while the target queue or the scheduler is closed.
+func (s *Scheduler) promoteDue() {
+	stopAt := time.Now().Add(promoteBudget)
+	for !s.target.IsClosed() && !s.isClosed() {
+		claimed, handled := s.promoteBatch()
+		if claimed < promoteBatchSize || handled == 0 || time.Now().After(stopAt) {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	s.refreshGauges(ctx)
+}
+
+// promoteBatch claims up to promoteBatchSize due jobs, enqueues them and only
+// then removes them from the sorted set, so a crash in between leads to a
+// duplicate rather than a lost job. It reports how many jobs it claimed and
+// how many of them left the set.
+func (s *Scheduler) promoteBatch() (claimed, handled int) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := time.Now()
+	leaseUntil := strconv.FormatInt(now.Add(promoteLease).UnixMilli(), 10)
+	members, err := claimDueScript.Run(ctx, s.client, []string{s.keys.scheduled()}, now.UnixMilli(), promoteBatchSize, leaseUntil).StringSlice()
+	if err != nil {
+		s.logger.Errorf("Failed to claim scheduled jobs: %v", err)
+		return 0, 0
+	}
+
+	for _, member := range members {
+		sep := strings.IndexByte(member, '\n')
+		var job domain.EmailJob
+		if sep < 0 || json.Unmarshal([]byte(member[sep+1:]), &job) != nil {
+			s.logger.Errorf("Dropping scheduled job that cannot be decoded: %q", member)
+		} else if err := s.target.Enqueue(ctx, job); err != nil {
+			// Leave it in the set; it becomes due again once the lease expires.
+			s.logger.Errorf("Failed to promote scheduled job for %s: %v", job.To, err)
+			continue
+		}
+		_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, s.keys.scheduled(), member)
+			if sep > 0 {
+				pipe.SRem(ctx, s.keys.scheduledRetries(), member[:sep])
+			}
+			return nil
+		})
+		if err != nil {
+			s.logger.Errorf("Failed to remove promoted job from the schedule: %v", err)
+		}
+		handled++
+	}
+	return len(members), handled
+}
+
+func (s *Scheduler) refreshGauges(ctx context.Context) {
+	var total, retries *redis.IntCmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = pipe.ZCard(ctx, s.keys.scheduled())
+		retries = pipe.SCard(ctx, s.keys.scheduledRetries())
+		return nil
+	})
+	if err == nil {
+		s.scheduled.Set(float64(total.Val()), float64(retries.Val()))
+	}
+}
+
+// Close stops the promoter loop. Scheduled jobs stay in Redis. The client is
+// closed by its owner.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Ensure Scheduler implements the ports.Scheduler interface
+var _ ports.Scheduler = (*Scheduler)(nil)
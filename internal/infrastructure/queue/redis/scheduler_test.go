@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+func newTestScheduler(t *testing.T, client redis.UniversalClient, target *RedisQueue) (*Scheduler, prometheus.Gauge, prometheus.Gauge) {
+	t.Helper()
+	total := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_jobs"})
+	retries := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_retries"})
+	s := NewScheduler(client, testKeyBase, target, logger.NewLogger(), metrics.NewScheduledJobs(total, retries))
+	t.Cleanup(s.Close)
+	return s, total, retries
+}
+
+func TestSchedulerPromotesDueJobs(t *testing.T) {
+	_, client := newTestRedis(t)
+	target := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+	defer target.Close()
+	s, total, retries := newTestScheduler(t, client, target)
+	ctx := context.Background()
+
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "due", To: "a@example.com", Retries: 1, Receipt: "stale"}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "later", To: "a@example.com"}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	if n := client.SCard(ctx, s.keys.scheduledRetries()).Val(); n != 1 {
+		t.Errorf("retry set holds %d jobs, want 1", n)
+	}
+
+	s.promoteDue()
+	job := dequeueNow(t, target)
+	if job.ID != "due" {
+		t.Errorf("promoted %s, want due", job.ID)
+	}
+	if job.Receipt == "stale" {
+		t.Errorf("promoted job kept the receipt of an earlier delivery")
+	}
+	if n := client.LLen(ctx, target.keys.queue()).Val(); n != 0 {
+		t.Errorf("lane holds %d more jobs, want the later job to stay scheduled", n)
+	}
+	if n := client.ZCard(ctx, s.keys.scheduled()).Val(); n != 1 {
+		t.Errorf("schedule holds %d jobs after promotion, want 1", n)
+	}
+	if n := client.SCard(ctx, s.keys.scheduledRetries()).Val(); n != 0 {
+		t.Errorf("retry set holds %d jobs after promotion, want 0", n)
+	}
+	if got, gotRetries := testutil.ToFloat64(total), testutil.ToFloat64(retries); got != 1 || gotRetries != 0 {
+		t.Errorf("scheduled gauges = %v total, %v retries; want 1 and 0", got, gotRetries)
+	}
+}
+
+func TestSchedulerPromotesBacklogWithinATick(t *testing.T) {
+	_, client := newTestRedis(t)
+	target := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+	defer target.Close()
+	s, total, _ := newTestScheduler(t, client, target)
+	ctx := context.Background()
+
+	backlog := 2*promoteBatchSize + 50
+	for i := 0; i < backlog; i++ {
+		if err := s.Schedule(ctx, domain.EmailJob{To: "a@example.com"}, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("Schedule() error = %v", err)
+		}
+	}
+
+	s.promoteDue()
+	if n := client.LLen(ctx, target.keys.queue()).Val(); n != int64(backlog) {
+		t.Errorf("lane holds %d jobs after one tick, want all %d due jobs", n, backlog)
+	}
+	if got := testutil.ToFloat64(total); got != 0 {
+		t.Errorf("scheduled gauge = %v, want 0", got)
+	}
+}
+
+func TestSchedulerSkipsJobsClaimedByAnotherInstance(t *testing.T) {
+	_, client := newTestRedis(t)
+	target := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+	defer target.Close()
+	s, _, _ := newTestScheduler(t, client, target)
+	ctx := context.Background()
+
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "1", To: "a@example.com"}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+
+	// Another promoter claimed the job and died before enqueueing it.
+	now := time.Now()
+	leaseUntil := now.Add(promoteLease).UnixMilli()
+	claimed, err := claimDueScript.Run(ctx, client, []string{s.keys.scheduled()}, now.UnixMilli(), promoteBatchSize, leaseUntil).StringSlice()
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claimDueScript = %v, %v; want the job claimed", claimed, err)
+	}
+	s.promoteDue()
+	if n := client.LLen(ctx, target.keys.queue()).Val(); n != 0 {
+		t.Fatalf("a job leased by another promoter was promoted")
+	}
+
+	// Once the lease runs out, the job is due again.
+	client.ZAdd(ctx, s.keys.scheduled(), &redis.Z{Score: float64(now.UnixMilli()), Member: claimed[0]})
+	s.promoteDue()
+	if got := dequeueNow(t, target).ID; got != "1" {
+		t.Errorf("promoted %s after the lease ran out, want 1", got)
+	}
+}
+
+func TestSchedulerDropsUndecodableJobs(t *testing.T) {
+	_, client := newTestRedis(t)
+	target := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+	defer target.Close()
+	s, _, _ := newTestScheduler(t, client, target)
+	ctx := context.Background()
+
+	client.ZAdd(ctx, s.keys.scheduled(), &redis.Z{Score: 1, Member: "garbage"})
+	s.promoteDue()
+	if n := client.ZCard(ctx, s.keys.scheduled()).Val(); n != 0 {
+		t.Errorf("schedule holds %d jobs, want the undecodable one dropped", n)
+	}
+}
+
+func TestSchedulerKeepsJobsWhileTargetIsClosed(t *testing.T) {
+	_, client := newTestRedis(t)
+	target := NewRedisQueue(client, testKeyBase, logger.NewLogger(), newTestDepth())
+	s, _, _ := newTestScheduler(t, client, target)
+	ctx := context.Background()
+
+	target.Close()
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "retry", To: "a@example.com", Retries: 1}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Schedule() after the target was closed error = %v", err)
+	}
+	s.promoteDue()
+
+	// Nothing is claimed, so another instance can promote the job right away.
+	due := client.ZRangeByScore(ctx, s.keys.scheduled(), &redis.ZRangeBy{Min: "-inf", Max: "+inf"}).Val()
+	if len(due) != 1 {
+		t.Fatalf("schedule holds %d jobs, want 1", len(due))
+	}
+	if score := client.ZScore(ctx, s.keys.scheduled(), due[0]).Val(); score > float64(time.Now().UnixMilli()) {
+		t.Errorf("job was leased while the target queue is closed")
+	}
+
+	s.Close()
+	if err := s.Schedule(ctx, domain.EmailJob{ID: "late"}, time.Now()); err == nil {
+		t.Errorf("Schedule() after Close succeeded, want an error")
+	}
+}
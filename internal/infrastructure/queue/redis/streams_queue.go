@@ -0,0 +1,709 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+	"email-queue-service/internal/pkg/metrics"
+)
+
+const (
+	redisStreamGroup = "email_workers"
+	streamJobField   = "job"
+
+	// streamReadBlock bounds each blocking XREADGROUP so that claimed jobs,
+	// Close and context cancellation are noticed while the stream is idle.
+	streamReadBlock = 2 * time.Second
+	claimBatchSize  = 100
+	// trimBatchSize bounds how many acknowledged entries of a lane are
+	// trimmed at a time.
+	trimBatchSize = 1000
+)
+
+// touchScript resets the idle time of the pending entry ARGV[1] of the
+// stream KEYS[1], as long as it is still pending on the consumer ARGV[2].
+// The delivery count is kept as it is.
+var touchScript = redis.NewScript(`
+local pending = redis.call('XPENDING', KEYS[1], ARGV[3], ARGV[1], ARGV[1], 1)
+if #pending == 0 or pending[1][2] ~= ARGV[2] then
+	return 0
+end
+redis.call('XCLAIM', KEYS[1], ARGV[3], ARGV[2], 0, ARGV[1], 'RETRYCOUNT', pending[1][4], 'JUSTID')
+return 1
+`)
+
+// streamEntry is an entry delivered to this consumer but not handed out yet.
+type streamEntry struct {
+	lane domain.Priority
+	msg  redis.XMessage
+}
+
+// StreamsQueue implements the ports.Queue interface on Redis Streams, one
+// per priority lane, read through a consumer group. Every service instance
+// is a consumer of the group; delivered jobs stay in the group's pending
+// entries list until they are acknowledged, and jobs left pending by a dead
+// consumer for longer than the claim idle time are taken over with
+// XAUTOCLAIM. Workers reset the idle time of the jobs they are still
+// sending, and jobs claimed more than maxDeliver times are moved to the DLQ.
+// Acknowledged jobs are either deleted right away or kept until the stream
+// is trimmed to maxLen entries.
+type StreamsQueue struct {
+	client     redis.UniversalClient
+	keys       keyspace
+	dlq        ports.DeadLetterQueue
+	logger     *logger.Logger
+	queueDepth *metrics.QueueDepth
+	consumer   string
+	claimIdle  time.Duration
+	maxLen     int64
+	maxDeliver int64
+
+	// claimCursors holds where the next XAUTOCLAIM of each lane continues,
+	// so that every pending entry is looked at in turn.
+	claimMu      sync.Mutex
+	claimCursors map[domain.Priority]string
+
+	mu     sync.Mutex
+	closed bool
+	// buffered holds entries claimed from dead consumers, and entries a
+	// blocking read returned beyond the one it handed out.
+	buffered  chan streamEntry
+	stopClaim chan struct{}
+}
+
+// NewStreamsQueue creates a new StreamsQueue instance and the consumer group
+// of every lane stream, or, if Redis cannot be reached yet, leaves the groups
+// to the first Dequeue after it can. With a maxLen, acknowledged entries are kept and the
+// oldest of them are trimmed once a lane stream holds more than maxLen
+// entries; entries that were not acknowledged yet are never trimmed. Without
+// one (0), acknowledged entries are deleted right away. Jobs claimed from
+// other consumers more than maxDeliver times (0 disables the limit) are
+// moved to dlq.
+func NewStreamsQueue(client redis.UniversalClient, keyBase string, dlq ports.DeadLetterQueue, l *logger.Logger, queueDepth *metrics.QueueDepth, consumer string, claimIdle time.Duration, maxLen int64, maxDeliver int64) (*StreamsQueue, error) {
+	q := &StreamsQueue{
+		client:       client,
+		keys:         keyspaceFor(client, keyBase),
+		dlq:          dlq,
+		logger:       l,
+		queueDepth:   queueDepth,
+		consumer:     consumer,
+		claimIdle:    claimIdle,
+		maxLen:       maxLen,
+		maxDeliver:   maxDeliver,
+		claimCursors: make(map[domain.Priority]string),
+		buffered:     make(chan streamEntry, claimBatchSize),
+		stopClaim:    make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := q.createGroups(ctx); err != nil {
+		// Redis is not reachable yet; Dequeue creates them once it is.
+		l.Errorf("%v. Creating them once Redis is reachable.", err)
+		go q.runClaimer()
+		return q, nil
+	}
+	for _, lane := range domain.Priorities {
+		// Initialize gauge with the number of entries not yet handed to a consumer
+		undelivered, err := q.countUndelivered(ctx, lane)
+		if err != nil {
+			l.Errorf("Failed to get initial Redis stream length of lane %s: %v", lane, err)
+			continue
+		}
+		q.queueDepth.Set(lane, float64(undelivered))
+	}
+
+	go q.runClaimer()
+	return q, nil
+}
+
+// createGroups creates the consumer group of every lane stream that has
+// none yet, starting at the first entry, so that jobs enqueued before it
+// existed are delivered too.
+func (q *StreamsQueue) createGroups(ctx context.Context) error {
+	for _, lane := range domain.Priorities {
+		err := q.client.XGroupCreateMkStream(ctx, q.keys.stream(lane), redisStreamGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("failed to create consumer group: %w", err)
+		}
+	}
+	return nil
+}
+
+// countUndelivered counts the entries of a lane stream that were not
+// delivered to the consumer group yet, which follow its last delivered ID.
+func (q *StreamsQueue) countUndelivered(ctx context.Context, lane domain.Priority) (int64, error) {
+	lastDelivered, err := lastDeliveredID(q.client.Do(ctx, "XINFO", "GROUPS", q.keys.stream(lane)))
+	if err != nil {
+		return 0, err
+	}
+	start := "(" + lastDelivered
+	var count int64
+	for {
+		entries, err := q.client.XRangeN(ctx, q.keys.stream(lane), start, "+", trimBatchSize).Result()
+		if err != nil {
+			return 0, err
+		}
+		count += int64(len(entries))
+		if len(entries) < trimBatchSize {
+			return count, nil
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// Enqueue adds a job to the stream of its priority lane.
+func (q *StreamsQueue) Enqueue(ctx context.Context, job domain.EmailJob) error {
+	if q.IsClosed() {
+		return fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+	}
+
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if err := q.addResult(q.add(ctx, q.client, job.Lane(), jobBytes)); err != nil {
+		return err
+	}
+	q.queueDepth.Inc(job.Lane())
+	return nil
+}
+
+// EnqueueBatch adds jobs to the streams of their priority lanes with
+// pipelined XADDs, in a single round trip.
+func (q *StreamsQueue) EnqueueBatch(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	if q.IsClosed() {
+		for i := range errs {
+			errs[i] = fmt.Errorf("%w, cannot enqueue new jobs", ports.ErrQueueClosed)
+		}
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	cmds := make([]*redis.StringCmd, len(jobs))
+	pipe := q.client.Pipeline()
+	for i, job := range jobs {
+		jobBytes, err := json.Marshal(job)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to marshal job: %w", err)
+			continue
+		}
+		cmds[i] = q.add(ctx, pipe, job.Lane(), jobBytes)
+	}
+	// Exec reports the first failed command; every command is checked below.
+	_, _ = pipe.Exec(ctx)
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		if err := q.addResult(cmd); err != nil {
+			errs[i] = err
+			continue
+		}
+		q.queueDepth.Inc(jobs[i].Lane())
+	}
+	return errs
+}
+
+// add runs XADD for a job. Streams are trimmed by the claimer rather than
+// with XADD's MAXLEN, which would also trim jobs that were never sent.
+func (q *StreamsQueue) add(ctx context.Context, c redis.Cmdable, lane domain.Priority, jobBytes []byte) *redis.StringCmd {
+	return c.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.keys.stream(lane),
+		Values: map[string]interface{}{streamJobField: jobBytes},
+	})
+}
+
+// addResult turns the reply of XADD into the error of an enqueue.
+func (q *StreamsQueue) addResult(cmd *redis.StringCmd) error {
+	if err := cmd.Err(); err != nil {
+		return fmt.Errorf("failed to enqueue job to Redis stream: %w", err)
+	}
+	return nil
+}
+
+// Dequeue retrieves the next job for this consumer. Jobs claimed from dead
+// consumers are handed out before new ones; new ones are read from the first
+// lane in lanes that has one. Entries not handed out when the queue is
+// closed stay pending and are claimed by another consumer.
+func (q *StreamsQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	for {
+		if q.IsClosed() {
+			return domain.EmailJob{}, ports.ErrQueueClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return domain.EmailJob{}, err
+		}
+
+		select {
+		case entry := <-q.buffered:
+			if job, ok := q.decode(entry); ok {
+				return job, nil
+			}
+			continue
+		default:
+		}
+
+		// Look at the lanes in preference order without blocking first.
+		found := false
+		for _, lane := range lanes {
+			entries, err := q.read(ctx, []domain.Priority{lane}, -1)
+			if err != nil {
+				return domain.EmailJob{}, err
+			}
+			if len(entries) > 0 {
+				found = true
+				if job, ok := q.decode(entries[0]); ok {
+					return job, nil
+				}
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		// Every lane is empty: block on all of them. Entries that arrive on
+		// several lanes at once are buffered for the next calls.
+		entries, err := q.read(ctx, lanes, streamReadBlock)
+		if err != nil {
+			return domain.EmailJob{}, err
+		}
+		for i := 1; i < len(entries); i++ {
+			select {
+			case q.buffered <- entries[i]:
+			default:
+				// Left pending; the claimer hands it out again after the claim idle time.
+			}
+		}
+		if len(entries) > 0 {
+			if job, ok := q.decode(entries[0]); ok {
+				return job, nil
+			}
+		}
+	}
+}
+
+// read runs XREADGROUP for new entries on the given lanes, taking at most one
+// entry per lane. A negative block does not wait at all.
+func (q *StreamsQueue) read(ctx context.Context, lanes []domain.Priority, block time.Duration) ([]streamEntry, error) {
+	streams := make([]string, 0, 2*len(lanes))
+	for _, lane := range lanes {
+		streams = append(streams, q.keys.stream(lane))
+	}
+	for range lanes {
+		streams = append(streams, ">")
+	}
+
+	result, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    redisStreamGroup,
+		Consumer: q.consumer,
+		Streams:  streams,
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil // Nothing arrived within the block time
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+		// Redis was not reachable when the queue was created.
+		if err := q.createGroups(ctx); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to dequeue job from Redis stream: %w", err)
+	}
+
+	var entries []streamEntry
+	for _, stream := range result {
+		lane := q.keys.streamLane(stream.Stream)
+		for _, msg := range stream.Messages {
+			q.queueDepth.Dec(lane)
+			entries = append(entries, streamEntry{lane: lane, msg: msg})
+		}
+	}
+	return entries, nil
+}
+
+// decode turns a stream entry into a job. Entries that cannot be decoded are
+// acknowledged and dropped, since they would never succeed.
+func (q *StreamsQueue) decode(entry streamEntry) (domain.EmailJob, bool) {
+	var job domain.EmailJob
+	payload, _ := entry.msg.Values[streamJobField].(string)
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		q.logger.Errorf("Failed to unmarshal job %s from Redis stream, dropping it: %v", entry.msg.ID, err)
+		q.ack(entry.lane, entry.msg.ID)
+		return domain.EmailJob{}, false
+	}
+	// The lane of the stream wins, so the entry is always acknowledged there.
+	job.Priority = entry.lane
+	job.Receipt = entry.msg.ID
+	return job, true
+}
+
+// Ack acknowledges a job. Its entry is deleted from the stream, unless the
+// stream keeps acknowledged entries until it is trimmed.
+func (q *StreamsQueue) Ack(job domain.EmailJob) error {
+	return q.ack(job.Lane(), job.Receipt)
+}
+
+func (q *StreamsQueue) ack(lane domain.Priority, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, q.keys.stream(lane), redisStreamGroup, id)
+		if q.maxLen <= 0 {
+			pipe.XDel(ctx, q.keys.stream(lane), id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ack job in Redis stream: %w", err)
+	}
+	return nil
+}
+
+// Nack re-adds a job at the end of the stream and deletes the original
+// entry in the same transaction.
+func (q *StreamsQueue) Nack(job domain.EmailJob) error {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: q.keys.stream(job.Lane()),
+			Values: map[string]interface{}{streamJobField: jobBytes},
+		})
+		pipe.XAck(ctx, q.keys.stream(job.Lane()), redisStreamGroup, job.Receipt)
+		pipe.XDel(ctx, q.keys.stream(job.Lane()), job.Receipt)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to nack job in Redis stream: %w", err)
+	}
+	q.queueDepth.Inc(job.Lane())
+	return nil
+}
+
+// LeaseDuration returns the claim idle time, after which another consumer
+// takes over a job that is still pending.
+func (q *StreamsQueue) LeaseDuration() time.Duration {
+	return q.claimIdle
+}
+
+// ExtendLease resets the idle time of a job that is still pending on this
+// consumer, so that it is not claimed while it is being sent.
+func (q *StreamsQueue) ExtendLease(job domain.EmailJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	extended, err := touchScript.Run(ctx, q.client, []string{q.keys.stream(job.Lane())}, job.Receipt, q.consumer, redisStreamGroup).Int()
+	if err != nil {
+		return fmt.Errorf("failed to extend job lease in Redis stream: %w", err)
+	}
+	if extended == 0 {
+		return ports.ErrLeaseLost
+	}
+	return nil
+}
+
+// runClaimer periodically takes over jobs that other consumers left pending
+// for longer than the claim idle time, and trims the streams.
+func (q *StreamsQueue) runClaimer() {
+	interval := q.claimIdle / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopClaim:
+			return
+		case <-ticker.C:
+			q.claim()
+			q.trim()
+		}
+	}
+}
+
+// claim pages through the pending entries of every lane with XAUTOCLAIM,
+// continuing where the last run stopped, until a full pass is done or the
+// buffer has no more room.
+func (q *StreamsQueue) claim() {
+	q.claimMu.Lock()
+	defer q.claimMu.Unlock()
+
+	for _, lane := range domain.Priorities {
+		for {
+			room := int64(cap(q.buffered) - len(q.buffered))
+			if room == 0 {
+				return
+			}
+			if !q.claimPage(lane, room) {
+				break
+			}
+		}
+	}
+}
+
+// claimPage claims up to count stale entries of a lane from where the cursor
+// of the lane stands, and reports whether there are more to look at.
+func (q *StreamsQueue) claimPage(lane domain.Priority, count int64) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	start := q.claimCursors[lane]
+	if start == "" {
+		start = "0-0"
+	}
+	next, messages, err := q.autoClaim(ctx, lane, start, count)
+	if err != nil {
+		q.logger.Errorf("Failed to claim stale jobs from Redis stream %s: %v", q.keys.stream(lane), err)
+		return false
+	}
+	q.claimCursors[lane] = next
+
+	messages = q.deadLetterExhausted(ctx, lane, messages)
+	if len(messages) > 0 {
+		q.logger.Warnf("Claimed %d %s priority jobs left pending by other consumers", len(messages), lane)
+	}
+	for _, msg := range messages {
+		select {
+		case q.buffered <- streamEntry{lane: lane, msg: msg}:
+		default:
+			// Still pending on this consumer; claimed again on a later run.
+		}
+	}
+	return next != "0-0"
+}
+
+// deadLetterExhausted moves the claimed entries that were delivered more
+// than maxDeliver times to the DLQ and returns the others.
+func (q *StreamsQueue) deadLetterExhausted(ctx context.Context, lane domain.Priority, messages []redis.XMessage) []redis.XMessage {
+	if q.maxDeliver <= 0 || len(messages) == 0 {
+		return messages
+	}
+
+	pending, err := q.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   q.keys.stream(lane),
+		Group:    redisStreamGroup,
+		Start:    messages[0].ID,
+		End:      messages[len(messages)-1].ID,
+		Count:    int64(len(messages)),
+		Consumer: q.consumer,
+	}).Result()
+	if err != nil {
+		// Handed out anyway; the delivery counts are looked at again on the next claim.
+		q.logger.Errorf("Failed to read delivery counts from Redis stream %s: %v", q.keys.stream(lane), err)
+		return messages
+	}
+	deliveries := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		deliveries[p.ID] = p.RetryCount
+	}
+
+	kept := messages[:0]
+	for _, msg := range messages {
+		count := deliveries[msg.ID]
+		if count <= q.maxDeliver {
+			kept = append(kept, msg)
+			continue
+		}
+		var job domain.EmailJob
+		payload, _ := msg.Values[streamJobField].(string)
+		if err := json.Unmarshal([]byte(payload), &job); err != nil {
+			q.logger.Errorf("Failed to unmarshal job %s from Redis stream, dropping it: %v", msg.ID, err)
+		} else {
+			q.logger.Errorf("Email to %s was delivered %d times without being acknowledged. Moving to DLQ.", job.To, count-1)
+			q.dlq.Store(job, fmt.Sprintf("Not acknowledged after %d deliveries", count-1), job.LastError)
+		}
+		if err := q.ack(lane, msg.ID); err != nil {
+			q.logger.Errorf("Failed to delete job %s from Redis stream: %v", msg.ID, err)
+		}
+	}
+	return kept
+}
+
+// trim deletes the oldest acknowledged entries of every lane stream that
+// holds more than maxLen entries.
+func (q *StreamsQueue) trim() {
+	if q.maxLen <= 0 {
+		return
+	}
+	for _, lane := range domain.Priorities {
+		if err := q.trimLane(lane); err != nil {
+			q.logger.Errorf("Failed to trim Redis stream %s: %v", q.keys.stream(lane), err)
+		}
+	}
+}
+
+// trimLane deletes up to trimBatchSize of the entries a lane stream holds
+// beyond maxLen, oldest first. Only entries in front of the oldest one that
+// was not acknowledged yet are deleted: those were delivered to the group
+// and are no longer pending. Entries are never acknowledged again once
+// they were, so the snapshot the range is computed from stays safe to act
+// on.
+func (q *StreamsQueue) trimLane(lane domain.Priority) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	stream := q.keys.stream(lane)
+	var length *redis.IntCmd
+	var info *redis.Cmd
+	var pending *redis.XPendingCmd
+	if _, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.XLen(ctx, stream)
+		info = pipe.Do(ctx, "XINFO", "GROUPS", stream)
+		pending = pipe.XPending(ctx, stream, redisStreamGroup)
+		return nil
+	}); err != nil {
+		return err
+	}
+	lastDelivered, err := lastDeliveredID(info)
+	if err != nil {
+		return err
+	}
+
+	excess := length.Val() - q.maxLen
+	if excess <= 0 {
+		return nil
+	}
+	if excess > trimBatchSize {
+		excess = trimBatchSize
+	}
+	end := lastDelivered
+	if p := pending.Val(); p.Count > 0 {
+		end = "(" + p.Lower
+	}
+	entries, err := q.client.XRangeN(ctx, stream, "-", end, excess).Result()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return q.client.XDel(ctx, stream, ids...).Err()
+}
+
+// lastDeliveredID returns the ID of the last entry delivered to the consumer
+// group from the reply of XINFO GROUPS. The reply is parsed by hand because
+// go-redis v8 does not accept the fields Redis 7 added to it.
+func lastDeliveredID(info *redis.Cmd) (string, error) {
+	groups, err := info.Slice()
+	if err != nil {
+		return "", err
+	}
+	for _, group := range groups {
+		fields, _ := group.([]interface{})
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if key, ok := fields[i].(string); ok {
+				values[key] = fields[i+1]
+			}
+		}
+		if values["name"] == redisStreamGroup {
+			id, _ := values["last-delivered-id"].(string)
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("consumer group %s not found", redisStreamGroup)
+}
+
+// autoClaim runs XAUTOCLAIM from start and returns the cursor to continue
+// from, which is 0-0 once the whole pending entries list was scanned. The
+// reply is parsed by hand because Redis 7 added a third element (deleted
+// IDs) that go-redis v8 does not accept.
+func (q *StreamsQueue) autoClaim(ctx context.Context, lane domain.Priority, start string, count int64) (string, []redis.XMessage, error) {
+	reply, err := q.client.Do(ctx, "XAUTOCLAIM", q.keys.stream(lane), redisStreamGroup, q.consumer,
+		q.claimIdle.Milliseconds(), start, "COUNT", count).Slice()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(reply) < 2 {
+		return "", nil, errors.New("unexpected XAUTOCLAIM reply")
+	}
+	next, _ := reply[0].(string)
+	if next == "" {
+		next = "0-0"
+	}
+	entries, _ := reply[1].([]interface{})
+
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		// Entries deleted from the stream while pending come back as nil.
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+		id, _ := fields[0].(string)
+		pairs, _ := fields[1].([]interface{})
+		values := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if key, ok := pairs[i].(string); ok {
+				values[key] = pairs[i+1]
+			}
+		}
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+	return next, messages, nil
+}
+
+// Close stops handing out and claiming jobs. The Redis client is left open
+// so that in-flight jobs can still be acknowledged, and is closed by its
+// owner.
+func (q *StreamsQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		close(q.stopClaim)
+		q.closed = true
+		q.logger.Println("Redis Streams queue closed.")
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *StreamsQueue) IsClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Ensure StreamsQueue implements the ports.BatchQueue and ports.LeaseExtender interfaces
+var (
+	_ ports.BatchQueue    = (*StreamsQueue)(nil)
+	_ ports.LeaseExtender = (*StreamsQueue)(nil)
+)
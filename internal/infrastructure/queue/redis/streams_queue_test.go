@@ -0,0 +1,289 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// recordingDLQ keeps the jobs moved to the DLQ.
+type recordingDLQ struct {
+	mu   sync.Mutex
+	jobs []domain.EmailJob
+}
+
+func (d *recordingDLQ) Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.jobs = append(d.jobs, job)
+}
+
+func (d *recordingDLQ) stored() []domain.EmailJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]domain.EmailJob(nil), d.jobs...)
+}
+
+const testClaimIdle = 50 * time.Millisecond
+
+func newTestStreamsQueue(t *testing.T, client redis.UniversalClient, dlq ports.DeadLetterQueue, consumer string, maxLen, maxDeliver int64) *StreamsQueue {
+	t.Helper()
+	q, err := NewStreamsQueue(client, testKeyBase, dlq, logger.NewLogger(), newTestDepth(), consumer, testClaimIdle, maxLen, maxDeliver)
+	if err != nil {
+		t.Fatalf("NewStreamsQueue() error = %v", err)
+	}
+	t.Cleanup(q.Close)
+	return q
+}
+
+// claimPage runs one XAUTOCLAIM page the way the claimer does.
+func claimPage(q *StreamsQueue, lane domain.Priority, count int64) bool {
+	q.claimMu.Lock()
+	defer q.claimMu.Unlock()
+	return q.claimPage(lane, count)
+}
+
+func TestStreamsQueueAckDeletesEntry(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestStreamsQueue(t, client, &recordingDLQ{}, "worker-1", 0, 5)
+	ctx := context.Background()
+
+	for _, job := range []domain.EmailJob{
+		{ID: "bulk", To: "a@example.com", Priority: domain.PriorityBulk},
+		{ID: "high", To: "a@example.com", Priority: domain.PriorityHigh},
+	} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	for _, want := range []string{"high", "bulk"} {
+		job := dequeueNow(t, q)
+		if job.ID != want {
+			t.Errorf("Dequeue() = %s, want %s", job.ID, want)
+		}
+		if err := q.Ack(job); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+	for _, lane := range domain.Priorities {
+		if n := client.XLen(ctx, q.keys.stream(lane)).Val(); n != 0 {
+			t.Errorf("stream of lane %s holds %d entries after Ack, want 0", lane, n)
+		}
+	}
+}
+
+func TestStreamsQueueTrimsAcknowledgedEntries(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestStreamsQueue(t, client, &recordingDLQ{}, "worker-1", 2, 5)
+	ctx := context.Background()
+	stream := q.keys.stream(domain.PriorityNormal)
+
+	errs := q.EnqueueBatch(ctx, []domain.EmailJob{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("EnqueueBatch() error of job %d = %v, want every job enqueued past the length", i+1, err)
+		}
+	}
+	trimLane(t, q, domain.PriorityNormal)
+	if n := client.XLen(ctx, stream).Val(); n != 4 {
+		t.Fatalf("stream holds %d entries, want 4: jobs that were never sent may not be trimmed", n)
+	}
+
+	jobs := make(map[string]domain.EmailJob)
+	for i := 0; i < 3; i++ {
+		job := dequeueNow(t, q)
+		jobs[job.ID] = job
+	}
+	for _, id := range []string{"1", "3"} {
+		if err := q.Ack(jobs[id]); err != nil {
+			t.Fatalf("Ack(%s) error = %v", id, err)
+		}
+	}
+	// Job 3 is acknowledged, but it comes after job 2, which is still pending.
+	trimLane(t, q, domain.PriorityNormal)
+	if got := streamIDs(t, client, stream); len(got) != 3 || got[0] != jobs["2"].Receipt {
+		t.Fatalf("stream holds %v, want job 1 trimmed", got)
+	}
+
+	if err := q.Ack(jobs["2"]); err != nil {
+		t.Fatalf("Ack(2) error = %v", err)
+	}
+	trimLane(t, q, domain.PriorityNormal)
+	if got := streamIDs(t, client, stream); len(got) != 2 || got[0] != jobs["3"].Receipt {
+		t.Errorf("stream holds %v, want the last acknowledged job and the one never sent", got)
+	}
+	if n, err := q.countUndelivered(ctx, domain.PriorityNormal); err != nil || n != 1 {
+		t.Errorf("countUndelivered() = %d, %v; want the one job never sent", n, err)
+	}
+}
+
+func trimLane(t *testing.T, q *StreamsQueue, lane domain.Priority) {
+	t.Helper()
+	if err := q.trimLane(lane); err != nil {
+		t.Fatalf("trimLane() error = %v", err)
+	}
+}
+
+func streamIDs(t *testing.T, client redis.UniversalClient, stream string) []string {
+	t.Helper()
+	entries, err := client.XRange(context.Background(), stream, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange() error = %v", err)
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func TestStreamsQueueClaimPagesThroughPendingEntries(t *testing.T) {
+	_, client := newTestRedis(t)
+	dead := newTestStreamsQueue(t, client, &recordingDLQ{}, "dead", 0, 5)
+	live := newTestStreamsQueue(t, client, &recordingDLQ{}, "live", 0, 5)
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := dead.Enqueue(ctx, domain.EmailJob{ID: id, To: "a@example.com"}); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+		dequeueNow(t, dead) // Never acknowledged
+	}
+	time.Sleep(2 * testClaimIdle)
+
+	// One entry per page: the pages move on through the pending entries
+	// instead of claiming the first one over and over.
+	passes := 0
+	for i := 0; i < 6 && len(live.buffered) < 3; i++ {
+		before := len(live.buffered)
+		if !claimPage(live, domain.PriorityNormal, 1) {
+			passes++
+		}
+		if n := len(live.buffered) - before; n > 1 {
+			t.Fatalf("claimPage() claimed %d entries, want at most 1", n)
+		}
+	}
+	if n := len(live.buffered); n != 3 {
+		t.Fatalf("claimed %d entries in pages of one, want 3", n)
+	}
+	if passes > 1 {
+		t.Errorf("needed %d passes over the pending entries, want at most 1 full pass and a partial one", passes)
+	}
+
+	claimed := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		job := dequeueNow(t, live)
+		claimed[job.ID] = true
+		if err := live.Ack(job); err != nil {
+			t.Fatalf("Ack() error = %v", err)
+		}
+	}
+	if len(claimed) != 3 {
+		t.Errorf("claimed %v, want each of the three jobs once", claimed)
+	}
+}
+
+func TestStreamsQueueExtendLease(t *testing.T) {
+	_, client := newTestRedis(t)
+	slow := newTestStreamsQueue(t, client, &recordingDLQ{}, "slow", 0, 5)
+	other := newTestStreamsQueue(t, client, &recordingDLQ{}, "other", 0, 5)
+	ctx := context.Background()
+
+	if err := slow.Enqueue(ctx, domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	job := dequeueNow(t, slow)
+	if slow.LeaseDuration() != testClaimIdle {
+		t.Errorf("LeaseDuration() = %s, want %s", slow.LeaseDuration(), testClaimIdle)
+	}
+
+	// A job whose lease keeps being extended is not claimed.
+	for i := 0; i < 4; i++ {
+		time.Sleep(testClaimIdle / 2)
+		if err := slow.ExtendLease(job); err != nil {
+			t.Fatalf("ExtendLease() error = %v", err)
+		}
+	}
+	claimPage(other, domain.PriorityNormal, 10)
+	if n := len(other.buffered); n != 0 {
+		t.Fatalf("a job with an extended lease was claimed")
+	}
+
+	// Once another consumer took it over, the lease is lost for good.
+	time.Sleep(2 * testClaimIdle)
+	claimPage(other, domain.PriorityNormal, 10)
+	if n := len(other.buffered); n != 1 {
+		t.Fatalf("claimed %d jobs after the lease ran out, want 1", n)
+	}
+	if err := slow.ExtendLease(job); !errors.Is(err, ports.ErrLeaseLost) {
+		t.Errorf("ExtendLease() of a claimed job error = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestStreamsQueueDeadLettersAfterMaxDeliver(t *testing.T) {
+	_, client := newTestRedis(t)
+	dlq := &recordingDLQ{}
+	crashing := newTestStreamsQueue(t, client, dlq, "crashing", 0, 2)
+	ctx := context.Background()
+
+	if err := crashing.Enqueue(ctx, domain.EmailJob{ID: "poison", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	dequeueNow(t, crashing) // First delivery
+
+	// The second delivery is claimed; the third would exceed max deliver.
+	for delivery := 2; delivery <= 3; delivery++ {
+		time.Sleep(2 * testClaimIdle)
+		claimPage(crashing, domain.PriorityNormal, 10)
+		select {
+		case <-crashing.buffered:
+		default:
+		}
+	}
+
+	stored := dlq.stored()
+	if len(stored) != 1 || stored[0].ID != "poison" {
+		t.Fatalf("DLQ holds %v, want the poison job", stored)
+	}
+	if n := client.XLen(ctx, crashing.keys.stream(domain.PriorityNormal)).Val(); n != 0 {
+		t.Errorf("stream holds %d entries after dead-lettering, want 0", n)
+	}
+	pending := client.XPending(ctx, crashing.keys.stream(domain.PriorityNormal), redisStreamGroup).Val()
+	if pending.Count != 0 {
+		t.Errorf("%d entries still pending after dead-lettering, want 0", pending.Count)
+	}
+}
+
+func TestStreamsQueueCreatesGroupsOnceRedisIsReachable(t *testing.T) {
+	server := miniredis.NewMiniRedis()
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addr()
+	server.Close()
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+
+	q := newTestStreamsQueue(t, client, &recordingDLQ{}, "worker-1", 0, 0)
+
+	if err := server.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+	if err := q.Enqueue(ctx, domain.EmailJob{ID: "1", To: "a@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if job := dequeueNow(t, q); job.ID != "1" {
+		t.Errorf("Dequeue() = job %s, want 1", job.ID)
+	}
+}
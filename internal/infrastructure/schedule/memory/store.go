@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+// Store implements the ports.ScheduleStore interface in memory. Schedules
+// are only known to this instance and lost on restart.
+type Store struct {
+	mu        sync.Mutex
+	schedules map[string]domain.Schedule
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{schedules: make(map[string]domain.Schedule)}
+}
+
+// UpdateSchedule applies update to schedule name under the store's lock.
+func (s *Store) UpdateSchedule(ctx context.Context, name string, update func(*domain.Schedule) error) (domain.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule := domain.Schedule{Name: name}
+	if stored, ok := s.schedules[name]; ok {
+		schedule = cloneSchedule(stored)
+	}
+	if err := update(&schedule); err != nil {
+		return domain.Schedule{}, err
+	}
+	s.schedules[name] = cloneSchedule(schedule)
+	return schedule, nil
+}
+
+// GetSchedule returns schedule name.
+func (s *Store) GetSchedule(ctx context.Context, name string) (domain.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[name]
+	if !ok {
+		return domain.Schedule{}, ports.ErrScheduleNotFound
+	}
+	return cloneSchedule(schedule), nil
+}
+
+// ListSchedules returns every schedule, ordered by name.
+func (s *Store) ListSchedules(ctx context.Context) ([]domain.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules := make([]domain.Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		schedules = append(schedules, cloneSchedule(schedule))
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules, nil
+}
+
+// DeleteSchedule removes schedule name.
+func (s *Store) DeleteSchedule(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[name]; !ok {
+		return ports.ErrScheduleNotFound
+	}
+	delete(s.schedules, name)
+	return nil
+}
+
+// cloneSchedule copies the recipients and runs to retry of a schedule, so
+// that callers cannot change the stored one.
+func cloneSchedule(schedule domain.Schedule) domain.Schedule {
+	recipients := make([]domain.ScheduleRecipient, len(schedule.Recipients))
+	for i, r := range schedule.Recipients {
+		if r.Data != nil {
+			data := make(map[string]string, len(r.Data))
+			for k, v := range r.Data {
+				data[k] = v
+			}
+			r.Data = data
+		}
+		recipients[i] = r
+	}
+	schedule.Recipients = recipients
+	if schedule.RetryRuns != nil {
+		schedule.RetryRuns = append([]time.Time(nil), schedule.RetryRuns...)
+	}
+	if schedule.LastRunAt != nil {
+		lastRunAt := *schedule.LastRunAt
+		schedule.LastRunAt = &lastRunAt
+	}
+	return schedule
+}
+
+// Ensure Store implements the ports.ScheduleStore interface
+var _ ports.ScheduleStore = (*Store)(nil)
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func TestStoreSchedules(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+
+	if _, err := s.GetSchedule(ctx, "weekly"); !errors.Is(err, ports.ErrScheduleNotFound) {
+		t.Fatalf("GetSchedule() of an unknown schedule error = %v, want ErrScheduleNotFound", err)
+	}
+	for _, name := range []string{"weekly", "daily"} {
+		if _, err := s.UpdateSchedule(ctx, name, func(schedule *domain.Schedule) error {
+			if !schedule.CreatedAt.IsZero() {
+				t.Errorf("UpdateSchedule() of a new schedule got %+v, want an empty one", schedule)
+			}
+			schedule.Cron = "@daily"
+			schedule.Recipients = []domain.ScheduleRecipient{{To: "a@example.com", Data: map[string]string{"name": "Ada"}}}
+			return nil
+		}); err != nil {
+			t.Fatalf("UpdateSchedule(%s) error = %v", name, err)
+		}
+	}
+
+	// A failing update changes nothing.
+	errStop := errors.New("stop")
+	if _, err := s.UpdateSchedule(ctx, "weekly", func(schedule *domain.Schedule) error {
+		schedule.Cron = "@hourly"
+		return errStop
+	}); !errors.Is(err, errStop) {
+		t.Fatalf("UpdateSchedule() error = %v, want the update's error", err)
+	}
+	got, err := s.GetSchedule(ctx, "weekly")
+	if err != nil || got.Name != "weekly" || got.Cron != "@daily" {
+		t.Fatalf("GetSchedule() = %+v, %v; want the stored schedule", got, err)
+	}
+
+	// Callers cannot change the stored schedule.
+	got.Recipients[0].Data["name"] = "Eve"
+	if again, _ := s.GetSchedule(ctx, "weekly"); again.Recipients[0].Data["name"] != "Ada" {
+		t.Errorf("stored recipient data = %v, want it unchanged by the caller", again.Recipients[0].Data)
+	}
+
+	schedules, err := s.ListSchedules(ctx)
+	if err != nil || len(schedules) != 2 || schedules[0].Name != "daily" || schedules[1].Name != "weekly" {
+		t.Errorf("ListSchedules() = %+v, %v; want both schedules by name", schedules, err)
+	}
+
+	if err := s.DeleteSchedule(ctx, "weekly"); err != nil {
+		t.Fatalf("DeleteSchedule() error = %v", err)
+	}
+	if err := s.DeleteSchedule(ctx, "weekly"); !errors.Is(err, ports.ErrScheduleNotFound) {
+		t.Errorf("DeleteSchedule() of a deleted schedule error = %v, want ErrScheduleNotFound", err)
+	}
+}
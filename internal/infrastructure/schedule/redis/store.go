@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+const (
+	schedulesKey = "email_schedules"
+	redisTimeout = 5 * time.Second
+
+	// maxUpdateAttempts bounds the optimistic retries of a contended update.
+	maxUpdateAttempts = 10
+)
+
+// Store implements the ports.ScheduleStore interface in Redis, as one hash
+// holding a JSON document per schedule. A single key keeps listing cheap
+// and works in Redis Cluster. Updates are optimistic transactions
+// (WATCH/MULTI), so instances updating schedules do not overwrite each
+// other and a run is claimed by one instance only.
+type Store struct {
+	client redis.UniversalClient
+	key    string
+}
+
+// NewStore creates a Store whose key starts with prefix.
+func NewStore(client redis.UniversalClient, prefix string) *Store {
+	return &Store{client: client, key: prefix + schedulesKey}
+}
+
+// UpdateSchedule applies update to schedule name, retrying when another
+// client changed the schedules in the meantime.
+func (s *Store) UpdateSchedule(ctx context.Context, name string, update func(*domain.Schedule) error) (domain.Schedule, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	var schedule domain.Schedule
+	txf := func(tx *redis.Tx) error {
+		var err error
+		schedule, err = s.getSchedule(ctx, tx, name)
+		if errors.Is(err, ports.ErrScheduleNotFound) {
+			schedule = domain.Schedule{Name: name}
+		} else if err != nil {
+			return err
+		}
+		if err := update(&schedule); err != nil {
+			return err
+		}
+		value, err := json.Marshal(schedule)
+		if err != nil {
+			return fmt.Errorf("failed to marshal schedule: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.key, name, value)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateAttempts; i++ {
+		err := s.client.Watch(ctx, txf, s.key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return domain.Schedule{}, err
+		}
+		return schedule, nil
+	}
+	return domain.Schedule{}, fmt.Errorf("failed to update schedule in Redis: too much contention on schedule %s", name)
+}
+
+// GetSchedule returns schedule name.
+func (s *Store) GetSchedule(ctx context.Context, name string) (domain.Schedule, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	return s.getSchedule(ctx, s.client, name)
+}
+
+func (s *Store) getSchedule(ctx context.Context, c redis.Cmdable, name string) (domain.Schedule, error) {
+	value, err := c.HGet(ctx, s.key, name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return domain.Schedule{}, ports.ErrScheduleNotFound
+	}
+	if err != nil {
+		return domain.Schedule{}, fmt.Errorf("failed to read schedule from Redis: %w", err)
+	}
+	var schedule domain.Schedule
+	if err := json.Unmarshal(value, &schedule); err != nil {
+		return domain.Schedule{}, fmt.Errorf("failed to unmarshal schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// ListSchedules returns every schedule, ordered by name.
+func (s *Store) ListSchedules(ctx context.Context) ([]domain.Schedule, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	values, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules from Redis: %w", err)
+	}
+	schedules := make([]domain.Schedule, 0, len(values))
+	for name, value := range values {
+		var schedule domain.Schedule
+		if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule %s: %w", name, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules, nil
+}
+
+// DeleteSchedule removes schedule name.
+func (s *Store) DeleteSchedule(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	deleted, err := s.client.HDel(ctx, s.key, name).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule from Redis: %w", err)
+	}
+	if deleted == 0 {
+		return ports.ErrScheduleNotFound
+	}
+	return nil
+}
+
+// Ensure Store implements the ports.ScheduleStore interface
+var _ ports.ScheduleStore = (*Store)(nil)
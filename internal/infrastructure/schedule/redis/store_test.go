@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, "test:"), server
+}
+
+func TestStoreSchedules(t *testing.T) {
+	s, server := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.GetSchedule(ctx, "weekly"); !errors.Is(err, ports.ErrScheduleNotFound) {
+		t.Fatalf("GetSchedule() of an unknown schedule error = %v, want ErrScheduleNotFound", err)
+	}
+	for _, name := range []string{"weekly", "daily"} {
+		if _, err := s.UpdateSchedule(ctx, name, func(schedule *domain.Schedule) error {
+			if !schedule.CreatedAt.IsZero() {
+				t.Errorf("UpdateSchedule() of a new schedule got %+v, want an empty one", schedule)
+			}
+			schedule.Cron = "@daily"
+			schedule.Recipients = []domain.ScheduleRecipient{{To: "a@example.com", Data: map[string]string{"name": "Ada"}}}
+			return nil
+		}); err != nil {
+			t.Fatalf("UpdateSchedule(%s) error = %v", name, err)
+		}
+	}
+
+	// A failing update changes nothing.
+	errStop := errors.New("stop")
+	if _, err := s.UpdateSchedule(ctx, "weekly", func(schedule *domain.Schedule) error {
+		schedule.Cron = "@hourly"
+		return errStop
+	}); !errors.Is(err, errStop) {
+		t.Fatalf("UpdateSchedule() error = %v, want the update's error", err)
+	}
+	got, err := s.GetSchedule(ctx, "weekly")
+	if err != nil || got.Name != "weekly" || got.Cron != "@daily" {
+		t.Fatalf("GetSchedule() = %+v, %v; want the stored schedule", got, err)
+	}
+
+	if !server.Exists("test:" + schedulesKey) {
+		t.Errorf("schedules are not stored in the prefixed hash")
+	}
+
+	schedules, err := s.ListSchedules(ctx)
+	if err != nil || len(schedules) != 2 || schedules[0].Name != "daily" || schedules[1].Name != "weekly" {
+		t.Errorf("ListSchedules() = %+v, %v; want both schedules by name", schedules, err)
+	}
+
+	if err := s.DeleteSchedule(ctx, "weekly"); err != nil {
+		t.Fatalf("DeleteSchedule() error = %v", err)
+	}
+	if err := s.DeleteSchedule(ctx, "weekly"); !errors.Is(err, ports.ErrScheduleNotFound) {
+		t.Errorf("DeleteSchedule() of a deleted schedule error = %v, want ErrScheduleNotFound", err)
+	}
+}
+
+func TestStoreConcurrentScheduleUpdates(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+
+	const updates = 5
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.UpdateSchedule(ctx, "weekly", func(schedule *domain.Schedule) error {
+				schedule.Recipients = append(schedule.Recipients, domain.ScheduleRecipient{To: "a@example.com"})
+				return nil
+			}); err != nil {
+				t.Errorf("UpdateSchedule() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, err := s.GetSchedule(ctx, "weekly"); err != nil || len(got.Recipients) != updates {
+		t.Errorf("GetSchedule() = %d recipients, %v; want every update applied", len(got.Recipients), err)
+	}
+}
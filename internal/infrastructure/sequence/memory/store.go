@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+// sweepInterval is how often expired enrollments are dropped from the map.
+const sweepInterval = time.Minute
+
+type entry struct {
+	enrollment domain.Enrollment
+	expiresAt  time.Time // Zero while the enrollment is active
+}
+
+// Store implements the ports.SequenceStore interface in memory. Sequences
+// and enrollments are only known to this instance and lost on restart, so
+// jobs of sequence steps that outlive a restart cannot continue their
+// sequence.
+type Store struct {
+	ttl time.Duration
+
+	mu          sync.Mutex
+	sequences   map[string]domain.Sequence
+	enrollments map[string]entry
+	lastSweep   time.Time
+}
+
+// NewStore creates a Store whose finished enrollments expire ttl after they
+// finished.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:         ttl,
+		sequences:   make(map[string]domain.Sequence),
+		enrollments: make(map[string]entry),
+		lastSweep:   time.Now(),
+	}
+}
+
+// PutSequence creates or replaces a sequence.
+func (s *Store) PutSequence(ctx context.Context, seq domain.Sequence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequences[seq.Name] = cloneSequence(seq)
+	return nil
+}
+
+// GetSequence returns the sequence name.
+func (s *Store) GetSequence(ctx context.Context, name string) (domain.Sequence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sequences[name]
+	if !ok {
+		return domain.Sequence{}, ports.ErrSequenceNotFound
+	}
+	return cloneSequence(seq), nil
+}
+
+// UpdateEnrollment applies update to enrollment id under the store's lock.
+func (s *Store) UpdateEnrollment(ctx context.Context, id string, update func(*domain.Enrollment) error) (domain.Enrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now)
+	enrollment := domain.Enrollment{ID: id}
+	if e, ok := s.enrollments[id]; ok && !expired(e, now) {
+		enrollment = cloneEnrollment(e.enrollment)
+	}
+	if err := update(&enrollment); err != nil {
+		return domain.Enrollment{}, err
+	}
+	e := entry{enrollment: cloneEnrollment(enrollment)}
+	if enrollment.State != domain.EnrollmentActive {
+		e.expiresAt = now.Add(s.ttl)
+	}
+	s.enrollments[id] = e
+	return cloneEnrollment(enrollment), nil
+}
+
+// GetEnrollment returns enrollment id.
+func (s *Store) GetEnrollment(ctx context.Context, id string) (domain.Enrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.enrollments[id]
+	if !ok || expired(e, time.Now()) {
+		return domain.Enrollment{}, ports.ErrEnrollmentNotFound
+	}
+	return cloneEnrollment(e.enrollment), nil
+}
+
+// DeleteEnrollment removes enrollment id.
+func (s *Store) DeleteEnrollment(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.enrollments, id)
+	return nil
+}
+
+// sweepLocked drops expired enrollments, at most once per sweepInterval.
+func (s *Store) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for id, e := range s.enrollments {
+		if expired(e, now) {
+			delete(s.enrollments, id)
+		}
+	}
+}
+
+func expired(e entry, now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// cloneSequence copies the slices of a sequence, so that callers cannot
+// change the stored one.
+func cloneSequence(seq domain.Sequence) domain.Sequence {
+	seq.Steps = append([]domain.SequenceStep(nil), seq.Steps...)
+	seq.ExitOn = append([]string(nil), seq.ExitOn...)
+	return seq
+}
+
+// cloneEnrollment copies the data of an enrollment, so that callers cannot
+// change the stored one.
+func cloneEnrollment(enrollment domain.Enrollment) domain.Enrollment {
+	if enrollment.Data != nil {
+		data := make(map[string]string, len(enrollment.Data))
+		for k, v := range enrollment.Data {
+			data[k] = v
+		}
+		enrollment.Data = data
+	}
+	return enrollment
+}
+
+// Ensure Store implements the ports.SequenceStore interface
+var _ ports.SequenceStore = (*Store)(nil)
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func TestStoreSequences(t *testing.T) {
+	s := NewStore(time.Hour)
+	ctx := context.Background()
+
+	if _, err := s.GetSequence(ctx, "welcome"); !errors.Is(err, ports.ErrSequenceNotFound) {
+		t.Fatalf("GetSequence() of an unknown sequence error = %v, want ErrSequenceNotFound", err)
+	}
+	seq := domain.Sequence{Name: "welcome", Steps: []domain.SequenceStep{{Subject: "Hi", Body: "Hello"}}}
+	if err := s.PutSequence(ctx, seq); err != nil {
+		t.Fatalf("PutSequence() error = %v", err)
+	}
+	seq.Steps[0].Subject = "Changed by the caller"
+
+	got, err := s.GetSequence(ctx, "welcome")
+	if err != nil || got.Steps[0].Subject != "Hi" {
+		t.Errorf("GetSequence() = %+v, %v; want the stored copy", got, err)
+	}
+}
+
+func TestStoreEnrollments(t *testing.T) {
+	s := NewStore(time.Hour)
+	ctx := context.Background()
+
+	if _, err := s.GetEnrollment(ctx, "e1"); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Fatalf("GetEnrollment() of an unknown enrollment error = %v, want ErrEnrollmentNotFound", err)
+	}
+	data := map[string]string{"name": "Ada"}
+	_, err := s.UpdateEnrollment(ctx, "e1", func(e *domain.Enrollment) error {
+		if e.ID != "e1" || !e.EnrolledAt.IsZero() {
+			t.Errorf("UpdateEnrollment() of a new enrollment got %+v, want one with only the ID", e)
+		}
+		e.State, e.Data = domain.EnrollmentActive, data
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateEnrollment() error = %v", err)
+	}
+	data["name"] = "Changed by the caller"
+
+	// A failing update stores nothing.
+	failed := errors.New("stop")
+	if _, err := s.UpdateEnrollment(ctx, "e1", func(e *domain.Enrollment) error {
+		e.Step = 5
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("UpdateEnrollment() error = %v, want the error of the update", err)
+	}
+	got, err := s.GetEnrollment(ctx, "e1")
+	if err != nil || got.Step != 0 || got.Data["name"] != "Ada" {
+		t.Errorf("GetEnrollment() = %+v, %v; want the stored enrollment unchanged", got, err)
+	}
+
+	if err := s.DeleteEnrollment(ctx, "e1"); err != nil {
+		t.Fatalf("DeleteEnrollment() error = %v", err)
+	}
+	if _, err := s.GetEnrollment(ctx, "e1"); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Errorf("GetEnrollment() after DeleteEnrollment error = %v, want ErrEnrollmentNotFound", err)
+	}
+}
+
+func TestStoreExpiresFinishedEnrollments(t *testing.T) {
+	s := NewStore(10 * time.Millisecond)
+	ctx := context.Background()
+
+	for id, state := range map[string]domain.EnrollmentState{"active": domain.EnrollmentActive, "done": domain.EnrollmentCompleted} {
+		if _, err := s.UpdateEnrollment(ctx, id, func(e *domain.Enrollment) error {
+			e.State = state
+			return nil
+		}); err != nil {
+			t.Fatalf("UpdateEnrollment() error = %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := s.GetEnrollment(ctx, "active"); err != nil {
+		t.Errorf("GetEnrollment() of an active enrollment error = %v, want it kept", err)
+	}
+	if _, err := s.GetEnrollment(ctx, "done"); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Errorf("GetEnrollment() of an expired enrollment error = %v, want ErrEnrollmentNotFound", err)
+	}
+}
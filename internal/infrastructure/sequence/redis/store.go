@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+const (
+	sequenceKeyPrefix   = "email_sequence:"
+	enrollmentKeyPrefix = "email_sequence_enrollment:"
+	redisTimeout        = 5 * time.Second
+
+	// maxUpdateAttempts bounds the optimistic retries of a contended update.
+	maxUpdateAttempts = 10
+)
+
+// Store implements the ports.SequenceStore interface in Redis, as one JSON
+// document per sequence and per enrollment. Active enrollments do not
+// expire, so they survive restarts however far apart their steps are.
+// Updates are optimistic transactions (WATCH/MULTI), so instances updating
+// the same enrollment do not overwrite each other.
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewStore creates a Store whose keys start with prefix and whose finished
+// enrollments expire ttl after they finished.
+func NewStore(client redis.UniversalClient, prefix string, ttl time.Duration) *Store {
+	return &Store{client: client, prefix: prefix, ttl: ttl}
+}
+
+// PutSequence creates or replaces a sequence.
+func (s *Store) PutSequence(ctx context.Context, seq domain.Sequence) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	value, err := json.Marshal(seq)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sequence: %w", err)
+	}
+	if err := s.client.Set(ctx, s.prefix+sequenceKeyPrefix+seq.Name, value, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store sequence in Redis: %w", err)
+	}
+	return nil
+}
+
+// GetSequence returns the sequence name.
+func (s *Store) GetSequence(ctx context.Context, name string) (domain.Sequence, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, s.prefix+sequenceKeyPrefix+name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return domain.Sequence{}, ports.ErrSequenceNotFound
+	}
+	if err != nil {
+		return domain.Sequence{}, fmt.Errorf("failed to read sequence from Redis: %w", err)
+	}
+	var seq domain.Sequence
+	if err := json.Unmarshal(value, &seq); err != nil {
+		return domain.Sequence{}, fmt.Errorf("failed to unmarshal sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// UpdateEnrollment applies update to enrollment id, retrying when another
+// client changed it in the meantime.
+func (s *Store) UpdateEnrollment(ctx context.Context, id string, update func(*domain.Enrollment) error) (domain.Enrollment, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	key := s.prefix + enrollmentKeyPrefix + id
+	var enrollment domain.Enrollment
+	txf := func(tx *redis.Tx) error {
+		var err error
+		enrollment, err = s.getEnrollment(ctx, tx, id)
+		if errors.Is(err, ports.ErrEnrollmentNotFound) {
+			enrollment = domain.Enrollment{ID: id}
+		} else if err != nil {
+			return err
+		}
+		if err := update(&enrollment); err != nil {
+			return err
+		}
+		value, err := json.Marshal(enrollment)
+		if err != nil {
+			return fmt.Errorf("failed to marshal enrollment: %w", err)
+		}
+		var ttl time.Duration // Active enrollments are kept until they finish
+		if enrollment.State != domain.EnrollmentActive {
+			ttl = s.ttl
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, ttl)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateAttempts; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return domain.Enrollment{}, err
+		}
+		return enrollment, nil
+	}
+	return domain.Enrollment{}, fmt.Errorf("failed to update enrollment in Redis: too much contention on enrollment %s", id)
+}
+
+// GetEnrollment returns enrollment id.
+func (s *Store) GetEnrollment(ctx context.Context, id string) (domain.Enrollment, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	return s.getEnrollment(ctx, s.client, id)
+}
+
+func (s *Store) getEnrollment(ctx context.Context, c redis.Cmdable, id string) (domain.Enrollment, error) {
+	value, err := c.Get(ctx, s.prefix+enrollmentKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return domain.Enrollment{}, ports.ErrEnrollmentNotFound
+	}
+	if err != nil {
+		return domain.Enrollment{}, fmt.Errorf("failed to read enrollment from Redis: %w", err)
+	}
+	var enrollment domain.Enrollment
+	if err := json.Unmarshal(value, &enrollment); err != nil {
+		return domain.Enrollment{}, fmt.Errorf("failed to unmarshal enrollment: %w", err)
+	}
+	return enrollment, nil
+}
+
+// DeleteEnrollment removes enrollment id.
+func (s *Store) DeleteEnrollment(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.prefix+enrollmentKeyPrefix+id).Err(); err != nil {
+		return fmt.Errorf("failed to delete enrollment from Redis: %w", err)
+	}
+	return nil
+}
+
+// Ensure Store implements the ports.SequenceStore interface
+var _ ports.SequenceStore = (*Store)(nil)
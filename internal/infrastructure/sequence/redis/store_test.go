@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, "test:", time.Hour), server
+}
+
+func TestStoreSequences(t *testing.T) {
+	s, server := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.GetSequence(ctx, "welcome"); !errors.Is(err, ports.ErrSequenceNotFound) {
+		t.Fatalf("GetSequence() of an unknown sequence error = %v, want ErrSequenceNotFound", err)
+	}
+	seq := domain.Sequence{Name: "welcome", ExitOn: []string{"converted"}, Steps: []domain.SequenceStep{{Delay: "48h", Subject: "Hi", Body: "Hello"}}}
+	if err := s.PutSequence(ctx, seq); err != nil {
+		t.Fatalf("PutSequence() error = %v", err)
+	}
+	got, err := s.GetSequence(ctx, "welcome")
+	if err != nil || got.Steps[0].Delay != "48h" || !got.ExitsOn("converted") {
+		t.Errorf("GetSequence() = %+v, %v; want the stored sequence", got, err)
+	}
+	if !server.Exists("test:" + sequenceKeyPrefix + "welcome") {
+		t.Errorf("sequence key does not start with the store's prefix")
+	}
+}
+
+func TestStoreEnrollments(t *testing.T) {
+	s, server := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.GetEnrollment(ctx, "e1"); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Fatalf("GetEnrollment() of an unknown enrollment error = %v, want ErrEnrollmentNotFound", err)
+	}
+	update := func(state domain.EnrollmentState) {
+		t.Helper()
+		if _, err := s.UpdateEnrollment(ctx, "e1", func(e *domain.Enrollment) error {
+			e.State = state
+			e.Step++
+			return nil
+		}); err != nil {
+			t.Fatalf("UpdateEnrollment() error = %v", err)
+		}
+	}
+
+	// Active enrollments are kept however long their steps are apart.
+	update(domain.EnrollmentActive)
+	key := "test:" + enrollmentKeyPrefix + "e1"
+	if ttl := server.TTL(key); ttl != 0 {
+		t.Errorf("active enrollment expires in %s, want no expiry", ttl)
+	}
+	update(domain.EnrollmentCompleted)
+	if ttl := server.TTL(key); ttl != time.Hour {
+		t.Errorf("finished enrollment expires in %s, want 1h", ttl)
+	}
+	if got, err := s.GetEnrollment(ctx, "e1"); err != nil || got.Step != 2 || got.State != domain.EnrollmentCompleted {
+		t.Errorf("GetEnrollment() = %+v, %v; want completed at step 2", got, err)
+	}
+
+	failed := errors.New("stop")
+	if _, err := s.UpdateEnrollment(ctx, "e1", func(e *domain.Enrollment) error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("UpdateEnrollment() error = %v, want the error of the update", err)
+	}
+
+	if err := s.DeleteEnrollment(ctx, "e1"); err != nil {
+		t.Fatalf("DeleteEnrollment() error = %v", err)
+	}
+	if _, err := s.GetEnrollment(ctx, "e1"); !errors.Is(err, ports.ErrEnrollmentNotFound) {
+		t.Errorf("GetEnrollment() after DeleteEnrollment error = %v, want ErrEnrollmentNotFound", err)
+	}
+}
+
+func TestStoreConcurrentEnrollmentUpdates(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+
+	const updates = 5
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.UpdateEnrollment(ctx, "e1", func(e *domain.Enrollment) error {
+				e.Step++
+				return nil
+			}); err != nil {
+				t.Errorf("UpdateEnrollment() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, err := s.GetEnrollment(ctx, "e1"); err != nil || got.Step != updates {
+		t.Errorf("GetEnrollment() = step %d, %v; want every update applied", got.Step, err)
+	}
+}
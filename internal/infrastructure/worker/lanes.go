@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// laneSelector decides in which order the workers try the priority lanes.
+//
+// The lane tried first rotates by smooth weighted round-robin, so with
+// weights 6/3/1 the high lane leads six of every ten dequeues. The other
+// lanes follow in the order of their current credit, so a worker never idles
+// while any lane has work. A lane that has not been served for
+// starvationLimit dequeues is moved to the front, which bounds the wait of
+// lanes with a small or zero weight while higher lanes are busy.
+type laneSelector struct {
+	mu              sync.Mutex
+	weights         map[domain.Priority]int
+	total           int
+	credit          map[domain.Priority]int
+	unserved        map[domain.Priority]int // Dequeues since the lane was last served
+	starvationLimit int
+}
+
+func newLaneSelector(weights map[domain.Priority]int, starvationLimit int) *laneSelector {
+	s := &laneSelector{
+		weights:         make(map[domain.Priority]int),
+		credit:          make(map[domain.Priority]int),
+		unserved:        make(map[domain.Priority]int),
+		starvationLimit: starvationLimit,
+	}
+	for _, lane := range domain.Priorities {
+		w := weights[lane]
+		if w < 0 {
+			w = 0
+		}
+		s.weights[lane] = w
+		s.total += w
+	}
+	return s
+}
+
+// order returns the lanes in the order the next dequeue should try them.
+func (s *laneSelector) order() []domain.Priority {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lanes := make([]domain.Priority, len(domain.Priorities))
+	copy(lanes, domain.Priorities)
+
+	if s.total > 0 {
+		for _, lane := range lanes {
+			s.credit[lane] += s.weights[lane]
+		}
+		// Highest credit first; lanes without weight only when the others are empty.
+		sort.SliceStable(lanes, func(i, j int) bool {
+			wi, wj := s.weights[lanes[i]] > 0, s.weights[lanes[j]] > 0
+			if wi != wj {
+				return wi
+			}
+			return s.credit[lanes[i]] > s.credit[lanes[j]]
+		})
+		s.credit[lanes[0]] -= s.total
+	}
+
+	if s.starvationLimit > 0 {
+		starved := -1
+		for i, lane := range lanes {
+			if s.unserved[lane] >= s.starvationLimit && (starved < 0 || s.unserved[lane] > s.unserved[lanes[starved]]) {
+				starved = i
+			}
+		}
+		if starved > 0 {
+			lane := lanes[starved]
+			copy(lanes[1:starved+1], lanes[:starved])
+			lanes[0] = lane
+		}
+	}
+	return lanes
+}
+
+// served records that a job of lane was dequeued.
+func (s *laneSelector) served(lane domain.Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range domain.Priorities {
+		s.unserved[l]++
+	}
+	s.unserved[lane] = 0
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestLaneSelectorFollowsWeights(t *testing.T) {
+	s := newLaneSelector(map[domain.Priority]int{domain.PriorityHigh: 6, domain.PriorityNormal: 3, domain.PriorityBulk: 1}, 0)
+
+	first := map[domain.Priority]int{}
+	for i := 0; i < 100; i++ {
+		lanes := s.order()
+		if len(lanes) != len(domain.Priorities) {
+			t.Fatalf("order() = %v, want every lane", lanes)
+		}
+		first[lanes[0]]++
+		s.served(lanes[0])
+	}
+	if first[domain.PriorityHigh] != 60 || first[domain.PriorityNormal] != 30 || first[domain.PriorityBulk] != 10 {
+		t.Errorf("lanes tried first %v, want 60/30/10 in 100 dequeues", first)
+	}
+}
+
+func TestLaneSelectorTriesZeroWeightLanesLast(t *testing.T) {
+	s := newLaneSelector(map[domain.Priority]int{domain.PriorityNormal: 1}, 0)
+
+	for i := 0; i < 5; i++ {
+		lanes := s.order()
+		if lanes[0] != domain.PriorityNormal {
+			t.Fatalf("order() = %v, want normal first", lanes)
+		}
+		if lanes[1] != domain.PriorityHigh || lanes[2] != domain.PriorityBulk {
+			t.Errorf("order() = %v, want the lanes without weight after it by priority", lanes)
+		}
+	}
+}
+
+func TestLaneSelectorServesStarvedLanes(t *testing.T) {
+	s := newLaneSelector(map[domain.Priority]int{domain.PriorityHigh: 1}, 3)
+
+	// The high lane always has work, so it is served on every dequeue.
+	for i := 0; i < 3; i++ {
+		lanes := s.order()
+		if lanes[0] != domain.PriorityHigh {
+			t.Fatalf("dequeue %d: order() = %v, want high first", i, lanes)
+		}
+		s.served(domain.PriorityHigh)
+	}
+
+	// Both other lanes waited three dequeues; the longer-waiting one goes
+	// first, which is the first in the lane order on a tie.
+	lanes := s.order()
+	if lanes[0] != domain.PriorityNormal || lanes[1] != domain.PriorityHigh {
+		t.Fatalf("order() = %v, want the starved normal lane moved in front of high", lanes)
+	}
+	s.served(domain.PriorityNormal)
+
+	lanes = s.order()
+	if lanes[0] != domain.PriorityBulk {
+		t.Errorf("order() = %v, want the starved bulk lane next", lanes)
+	}
+}
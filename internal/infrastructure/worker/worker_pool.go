@@ -1,29 +1,49 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"email-queue-service/internal/core/domain"
 	"email-queue-service/internal/core/ports"
 	"email-queue-service/internal/pkg/logger"
 )
 
+// dequeueRetryDelay is how long a worker waits after a failed dequeue (e.g.
+// a lost backend connection) before trying again.
+const dequeueRetryDelay = time.Second
+
+// heartbeatFraction is the share of the lease duration after which the lease
+// of a job being processed is extended, leaving room for a missed beat.
+const heartbeatFraction = 3
+
 // WorkerPool manages a pool of concurrent workers.
 type WorkerPool struct {
 	numWorkers int
 	jobQueue   ports.Queue // Changed to interface
+	lanes      *laneSelector
 	logger     *logger.Logger
 	wg         sync.WaitGroup // To wait for all workers to finish
-	stopChan   chan struct{}  // To signal workers to stop
+
+	// ctx is cancelled to make workers give up waiting for jobs.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-// NewWorkerPool creates a new WorkerPool.
-func NewWorkerPool(numWorkers int, jobQueue ports.Queue, l *logger.Logger) *WorkerPool {
+// NewWorkerPool creates a new WorkerPool. laneWeights sets the share of
+// dequeues each priority lane is tried first for; a lane left unserved for
+// starvationLimit dequeues is tried first regardless (0 disables this).
+func NewWorkerPool(numWorkers int, jobQueue ports.Queue, laneWeights map[domain.Priority]int, starvationLimit int, l *logger.Logger) *WorkerPool {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &WorkerPool{
 		numWorkers: numWorkers,
 		jobQueue:   jobQueue,
+		lanes:      newLaneSelector(laneWeights, starvationLimit),
 		logger:     l,
-		stopChan:   make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
@@ -35,40 +55,111 @@ func (wp *WorkerPool) Start(processor func(domain.EmailJob)) {
 	}
 }
 
-// worker is the goroutine function for each worker.
+// worker is the goroutine function for each worker. It processes jobs until
+// the queue is closed and drained, or the pool's context is cancelled.
 func (wp *WorkerPool) worker(id int, processor func(domain.EmailJob)) {
 	defer wp.wg.Done()
 	wp.logger.Printf("Worker %d started.", id)
 
 	for {
-		select {
-		case <-wp.stopChan:
-			// Received stop signal.
-			// If the queue is closed and empty, the Dequeue will return false.
-			// If the queue is still open but no more jobs are coming,
-			// we should still try to drain existing jobs.
-			wp.logger.Printf("Worker %d received stop signal. Attempting to drain remaining jobs...", id)
-			// Fall through to continue processing any jobs left in the queue buffer (for in-memory)
-			// or until Dequeue returns false (for Redis or closed in-memory).
-		default:
-			job, ok := wp.jobQueue.Dequeue()
-			if !ok {
-				// Queue is closed and empty, or an error occurred during dequeue (e.g., Redis connection lost)
-				wp.logger.Printf("Worker %d detected queue closed/empty or dequeue error. Exiting.", id)
+		job, err := wp.jobQueue.Dequeue(wp.ctx, wp.lanes.order())
+		if err != nil {
+			if errors.Is(err, ports.ErrQueueClosed) {
+				wp.logger.Printf("Worker %d detected queue closed and drained. Exiting.", id)
 				return
 			}
-			processor(job)
+			if wp.ctx.Err() != nil {
+				wp.logger.Printf("Worker %d stopped waiting for jobs. Exiting.", id)
+				return
+			}
+			wp.logger.Errorf("Worker %d failed to dequeue job: %v. Retrying in %s.", id, err, dequeueRetryDelay)
+			select {
+			case <-time.After(dequeueRetryDelay):
+			case <-wp.ctx.Done():
+			}
+			continue
 		}
+		wp.lanes.served(job.Lane())
+		wp.process(id, job, processor)
 	}
 }
 
-// Stop signals all workers to stop and waits for them to finish.
-func (wp *WorkerPool) Stop() {
-	// Signal all workers to stop.
-	close(wp.stopChan)
-	wp.logger.Println("Signaled workers to stop.")
+// process runs the processor on a job and acknowledges it afterwards. A job
+// whose processing panics is handed back to the queue instead. The lease of
+// the job is extended while it is processed.
+func (wp *WorkerPool) process(id int, job domain.EmailJob, processor func(domain.EmailJob)) {
+	stopHeartbeat := wp.heartbeat(id, job)
+	defer stopHeartbeat()
+	defer func() {
+		if r := recover(); r != nil {
+			wp.logger.Errorf("Worker %d panicked while processing email to %s: %v. Returning job to queue.", id, job.To, r)
+			if err := wp.jobQueue.Nack(job); err != nil {
+				wp.logger.Errorf("Worker %d failed to return job to queue: %v", id, err)
+			}
+		}
+	}()
+
+	processor(job)
+	if err := wp.jobQueue.Ack(job); err != nil {
+		wp.logger.Errorf("Worker %d failed to acknowledge job for %s: %v", id, job.To, err)
+	}
+}
 
-	// Wait for all workers to complete their current tasks and exit.
-	wp.wg.Wait()
+// heartbeat extends the lease of a job every third of the lease duration of
+// the queue, until the returned function is called. Queues that do not lease
+// jobs get no heartbeat.
+func (wp *WorkerPool) heartbeat(id int, job domain.EmailJob) (stop func()) {
+	extender, ok := wp.jobQueue.(ports.LeaseExtender)
+	if !ok || extender.LeaseDuration() <= 0 {
+		return func() {}
+	}
+	interval := extender.LeaseDuration() / heartbeatFraction
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := extender.ExtendLease(job)
+				if errors.Is(err, ports.ErrLeaseLost) {
+					wp.logger.Warnf("Worker %d lost the lease of the job for %s; it may be delivered again.", id, job.To)
+					return
+				}
+				if err != nil {
+					wp.logger.Errorf("Worker %d failed to extend the lease of the job for %s: %v", id, job.To, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Stop waits for the workers to drain the queue, which must be closed first.
+// Once ctx is done, workers stop waiting for more jobs; jobs already being
+// processed are still finished and acknowledged before Stop returns.
+func (wp *WorkerPool) Stop(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		wp.logger.Warnf("Workers did not drain the queue in time, abandoning remaining jobs.")
+		wp.cancel()
+		<-done
+	}
+	wp.cancel()
 	wp.logger.Println("All workers have finished.")
 }
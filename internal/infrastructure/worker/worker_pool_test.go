@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// fakeQueue hands out a fixed set of jobs and records what happens to them.
+// Once it runs out of jobs it reports being closed and drained, or, if idle
+// is set, blocks like an open queue until the context is done.
+type fakeQueue struct {
+	mu       sync.Mutex
+	jobs     []domain.EmailJob
+	idle     bool
+	closed   bool
+	lease    time.Duration
+	acked    []string
+	nacked   []string
+	extended map[string]int
+}
+
+func newFakeQueue(lease time.Duration, jobs ...domain.EmailJob) *fakeQueue {
+	return &fakeQueue{jobs: jobs, lease: lease, extended: make(map[string]int)}
+}
+
+func (q *fakeQueue) Enqueue(ctx context.Context, job domain.EmailJob) error { return nil }
+
+func (q *fakeQueue) Dequeue(ctx context.Context, lanes []domain.Priority) (domain.EmailJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) == 0 {
+		if q.idle {
+			q.mu.Unlock()
+			<-ctx.Done()
+			q.mu.Lock()
+			return domain.EmailJob{}, ctx.Err()
+		}
+		return domain.EmailJob{}, ports.ErrQueueClosed
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	return job, nil
+}
+
+func (q *fakeQueue) Ack(job domain.EmailJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.acked = append(q.acked, job.ID)
+	return nil
+}
+
+func (q *fakeQueue) Nack(job domain.EmailJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nacked = append(q.nacked, job.ID)
+	return nil
+}
+
+func (q *fakeQueue) Close()         {}
+func (q *fakeQueue) IsClosed() bool { return q.closed }
+
+func (q *fakeQueue) LeaseDuration() time.Duration { return q.lease }
+
+func (q *fakeQueue) ExtendLease(job domain.EmailJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.extended[job.ID]++
+	return nil
+}
+
+func runPool(q *fakeQueue, processor func(domain.EmailJob)) {
+	pool := NewWorkerPool(1, q, nil, 0, logger.NewLogger())
+	pool.Start(processor)
+	pool.Stop(context.Background())
+}
+
+func TestWorkerPoolAcksProcessedJobs(t *testing.T) {
+	q := newFakeQueue(0, domain.EmailJob{ID: "1"}, domain.EmailJob{ID: "2"})
+	var processed []string
+	runPool(q, func(job domain.EmailJob) { processed = append(processed, job.ID) })
+
+	if len(processed) != 2 || len(q.acked) != 2 || len(q.nacked) != 0 {
+		t.Errorf("processed %v, acked %v, nacked %v; want both jobs processed and acked", processed, q.acked, q.nacked)
+	}
+	if len(q.extended) != 0 {
+		t.Errorf("leases extended %v without a lease duration", q.extended)
+	}
+}
+
+func TestWorkerPoolNacksPanickingJobs(t *testing.T) {
+	q := newFakeQueue(0, domain.EmailJob{ID: "panics"}, domain.EmailJob{ID: "fine"})
+	runPool(q, func(job domain.EmailJob) {
+		if job.ID == "panics" {
+			panic("boom")
+		}
+	})
+
+	if len(q.nacked) != 1 || q.nacked[0] != "panics" {
+		t.Errorf("nacked %v, want [panics]", q.nacked)
+	}
+	if len(q.acked) != 1 || q.acked[0] != "fine" {
+		t.Errorf("acked %v, want [fine]", q.acked)
+	}
+}
+
+func TestWorkerPoolExtendsLeaseOfSlowJobs(t *testing.T) {
+	q := newFakeQueue(30*time.Millisecond, domain.EmailJob{ID: "slow"}, domain.EmailJob{ID: "fast"})
+	runPool(q, func(job domain.EmailJob) {
+		if job.ID == "slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+
+	if n := q.extended["slow"]; n < 3 {
+		t.Errorf("lease of the slow job extended %d times, want at least 3", n)
+	}
+	if n := q.extended["fast"]; n != 0 {
+		t.Errorf("lease of the fast job extended %d times, want 0", n)
+	}
+
+	// No heartbeat outlives the job it belongs to.
+	extended := q.extended["slow"]
+	time.Sleep(50 * time.Millisecond)
+	if q.extended["slow"] != extended {
+		t.Errorf("lease extended after the job was acknowledged")
+	}
+}
+
+func TestWorkerPoolStopFinishesJobsInProgress(t *testing.T) {
+	q := newFakeQueue(0, domain.EmailJob{ID: "slow"})
+	q.idle = true // The queue never reports being drained
+
+	started := make(chan struct{})
+	pool := NewWorkerPool(2, q, nil, 0, logger.NewLogger())
+	pool.Start(func(job domain.EmailJob) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop() did not return after its context was done")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.acked) != 1 || q.acked[0] != "slow" {
+		t.Errorf("acked %v, want the job in progress finished and acked before Stop returned", q.acked)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+// batchRequest is the body of POST /v1/emails/batch. Items are decoded one
+// by one, so that a malformed item only rejects itself.
+type batchRequest struct {
+	Emails []json.RawMessage `json:"emails"`
+}
+
+// batchItemError explains why a batch item was rejected.
+type batchItemError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// batchItemResult is the outcome of one batch item, at the item's index.
+type batchItemResult struct {
+	Index     int             `json:"index"`
+	Status    string          `json:"status"` // "accepted" or "rejected"
+	ID        string          `json:"id,omitempty"`
+	StatusURL string          `json:"status_url,omitempty"`
+	Error     *batchItemError `json:"error,omitempty"`
+}
+
+// batchResponse is the body of a response to POST /v1/emails/batch.
+type batchResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Results  []batchItemResult `json:"results"`
+}
+
+// SendEmailBatch handles the POST /v1/emails/batch endpoint. Every item is
+// validated on its own and the valid ones are enqueued together. It answers
+// 202 if every item was accepted and 207 with the per-item results otherwise,
+// with a Retry-After header if items were rejected because the queue is full.
+func (h *EmailHandler) SendEmailBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Errorf("Failed to decode batch request body: %v", err)
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Emails) == 0 {
+		http.Error(w, "Batch must contain at least one email", http.StatusBadRequest)
+		return
+	}
+	if len(req.Emails) > h.maxBatchSize {
+		http.Error(w, fmt.Sprintf("Batch must not contain more than %d emails", h.maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := make([]batchItemResult, len(req.Emails))
+	var jobs []domain.EmailJob
+	var jobIndex []int
+	for i, raw := range req.Emails {
+		results[i] = batchItemResult{Index: i, Status: "rejected"}
+
+		var job domain.EmailJob
+		if err := json.Unmarshal(raw, &job); err != nil {
+			results[i].Error = &batchItemError{Code: "invalid_payload", Message: "Invalid email payload"}
+			continue
+		}
+		if err := job.Validate(); err != nil {
+			results[i].Error = &batchItemError{Code: "validation_failed", Message: err.Error()}
+			continue
+		}
+		resetJob(&job)
+		job.ID = domain.NewJobID()
+		jobs = append(jobs, job)
+		jobIndex = append(jobIndex, i)
+	}
+
+	var wait time.Duration
+	if len(jobs) > 0 {
+		errs := h.emailService.EnqueueEmails(r.Context(), jobs)
+		for k, err := range errs {
+			result := &results[jobIndex[k]]
+			if err != nil {
+				result.Error = enqueueItemError(err)
+				if d := retryAfter(err); d > wait {
+					wait = d
+				}
+				continue
+			}
+			result.Status = "accepted"
+			result.ID = jobs[k].ID
+			result.StatusURL = statusURL(jobs[k].ID)
+		}
+	}
+
+	resp := batchResponse{Results: results}
+	for _, result := range results {
+		if result.Status == "accepted" {
+			resp.Accepted++
+		} else {
+			resp.Rejected++
+		}
+	}
+	h.logger.Printf("Batch of %d emails: %d accepted, %d rejected", len(results), resp.Accepted, resp.Rejected)
+
+	code := http.StatusAccepted
+	if resp.Rejected > 0 {
+		code = http.StatusMultiStatus
+	}
+	setRetryAfter(w, wait)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// enqueueItemError describes why the service did not accept a batch item.
+func enqueueItemError(err error) *batchItemError {
+	switch {
+	case errors.Is(err, ports.ErrUnknownQueue):
+		return &batchItemError{Code: "unknown_queue", Message: err.Error()}
+	case errors.Is(err, ports.ErrQueueClosed):
+		return &batchItemError{Code: "queue_closed", Message: "Email queue is shutting down"}
+	case errors.Is(err, ports.ErrQueueFull):
+		return &batchItemError{Code: "queue_full", Message: "Email queue is full"}
+	default:
+		return &batchItemError{Code: "enqueue_failed", Message: "Email job could not be enqueued"}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/ports"
+)
+
+func sendBatch(h *EmailHandler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.SendEmailBatch(rec, httptest.NewRequest(http.MethodPost, "/v1/emails/batch", strings.NewReader(body)))
+	return rec
+}
+
+func decodeBatch(t *testing.T, rec *httptest.ResponseRecorder) batchResponse {
+	t.Helper()
+	var resp batchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestSendEmailBatchAcceptsEveryItem(t *testing.T) {
+	es := &fakeEmailService{}
+	rec := sendBatch(newTestHandler(es), `{"emails":[`+testEmailBody+`,`+testEmailBody+`]}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", rec.Code)
+	}
+
+	resp := decodeBatch(t, rec)
+	if resp.Accepted != 2 || resp.Rejected != 0 || len(resp.Results) != 2 {
+		t.Fatalf("response = %+v, want both items accepted", resp)
+	}
+	accepted := es.accepted()
+	for i, result := range resp.Results {
+		if result.Index != i || result.Status != "accepted" || result.ID != accepted[i].ID || result.StatusURL != statusURL(result.ID) {
+			t.Errorf("result %d = %+v, want accepted as job %s", i, result, accepted[i].ID)
+		}
+	}
+	if accepted[0].ID == accepted[1].ID {
+		t.Errorf("both items got job ID %s", accepted[0].ID)
+	}
+}
+
+func TestSendEmailBatchReportsEachRejectedItem(t *testing.T) {
+	es := &fakeEmailService{rejectTo: map[string]error{
+		"full@example.com":    &ports.QueueFullError{RetryAfter: 3 * time.Second},
+		"closed@example.com":  ports.ErrQueueClosed,
+		"unknown@example.com": fmt.Errorf("%w %q", ports.ErrUnknownQueue, "nope"),
+	}}
+	body := `{"emails":[
+		` + testEmailBody + `,
+		"not an object",
+		{"to":"not-an-address","subject":"Hi","body":"Hello"},
+		{"to":"full@example.com","subject":"Hi","body":"Hello"},
+		{"to":"closed@example.com","subject":"Hi","body":"Hello"},
+		{"to":"unknown@example.com","subject":"Hi","body":"Hello"}
+	]}`
+	rec := sendBatch(newTestHandler(es), body)
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3 for the item rejected by the full queue", got)
+	}
+
+	resp := decodeBatch(t, rec)
+	if resp.Accepted != 1 || resp.Rejected != 5 {
+		t.Errorf("accepted %d, rejected %d; want 1 and 5", resp.Accepted, resp.Rejected)
+	}
+	wantCodes := []string{"", "invalid_payload", "validation_failed", "queue_full", "queue_closed", "unknown_queue"}
+	for i, want := range wantCodes {
+		result := resp.Results[i]
+		if result.Index != i {
+			t.Errorf("result %d has index %d", i, result.Index)
+		}
+		if want == "" {
+			if result.Status != "accepted" || result.Error != nil {
+				t.Errorf("result %d = %+v, want accepted", i, result)
+			}
+			continue
+		}
+		if result.Status != "rejected" || result.Error == nil || result.Error.Code != want || result.ID != "" {
+			t.Errorf("result %d = %+v, want rejected with %s", i, result, want)
+		}
+	}
+}
+
+func TestSendEmailBatchRejectsInvalidBatches(t *testing.T) {
+	es := &fakeEmailService{}
+	h := newTestHandler(es)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"malformed", `{"emails":`, http.StatusBadRequest},
+		{"empty", `{"emails":[]}`, http.StatusBadRequest},
+		{"too large", `{"emails":[` + strings.TrimSuffix(strings.Repeat(testEmailBody+",", 11), ",") + `]}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if rec := sendBatch(h, tt.body); rec.Code != tt.code {
+			t.Errorf("%s batch: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+	if n := len(es.accepted()); n != 0 {
+		t.Errorf("service accepted %d jobs, want 0", n)
+	}
+}
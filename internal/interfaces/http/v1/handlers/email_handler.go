@@ -1,24 +1,40 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"email-queue-service/internal/core/domain"
 	"email-queue-service/internal/core/ports"
 	"email-queue-service/internal/pkg/logger"
 )
 
+// IdempotencyKeyHeader carries a client-chosen key that makes retrying a
+// request safe: a repeated key is answered with the original response.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
 // EmailHandler handles HTTP requests related to emails.
 type EmailHandler struct {
 	emailService ports.EmailService
+	idempotency  ports.IdempotencyStore
+	maxBatchSize int
 	logger       *logger.Logger
 }
 
-// NewEmailHandler creates a new EmailHandler.
-func NewEmailHandler(es ports.EmailService, l *logger.Logger) *EmailHandler {
+// NewEmailHandler creates a new EmailHandler. Batches may hold up to
+// maxBatchSize jobs.
+func NewEmailHandler(es ports.EmailService, idempotency ports.IdempotencyStore, maxBatchSize int, l *logger.Logger) *EmailHandler {
 	return &EmailHandler{
 		emailService: es,
+		idempotency:  idempotency,
+		maxBatchSize: maxBatchSize,
 		logger:       l,
 	}
 }
@@ -43,14 +59,44 @@ func (h *EmailHandler) SendEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Initialize retries to 0 for new jobs
-	job.Retries = 0
+	resetJob(&job)
+	fingerprint := payloadFingerprint(job)
+	job.ID = domain.NewJobID()
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(w, "Idempotency-Key must not be longer than 255 characters", http.StatusBadRequest)
+		return
+	}
+	var record ports.IdempotencyRecord
+	if key != "" {
+		record = ports.IdempotencyRecord{JobID: job.ID, Fingerprint: fingerprint}
+		existing, reserved, err := h.idempotency.Reserve(r.Context(), key, record)
+		if err != nil {
+			h.logger.Errorf("Failed to reserve idempotency key: %v", err)
+			http.Error(w, "Service Unavailable: Idempotency store is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !reserved {
+			h.replay(w, existing, fingerprint)
+			return
+		}
+	}
 
-	err := h.emailService.EnqueueEmail(job)
+	err := h.emailService.EnqueueEmail(r.Context(), job)
 	if err != nil {
 		h.logger.Errorf("Error enqueuing email: %v", err)
+		if key != "" {
+			// Nothing was enqueued, so the client may retry with the same key.
+			if err := h.idempotency.Release(r.Context(), key); err != nil {
+				h.logger.Errorf("Failed to release idempotency key: %v", err)
+			}
+		}
 		// Check if the error indicates a full queue
-		if err.Error() == "failed to enqueue email: queue is full, cannot enqueue job" { // For in-memory queue
+		if errors.Is(err, ports.ErrUnknownQueue) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity) // The job names a queue that does not exist
+		} else if errors.Is(err, ports.ErrQueueFull) { // For in-memory queue
+			setRetryAfter(w, retryAfter(err))
 			http.Error(w, "Service Unavailable: Email queue is full", http.StatusServiceUnavailable) // 503 Service Unavailable
 		} else if err.Error() == "failed to enqueue email: failed to enqueue job to Redis: redis: client is closed" { // Example for Redis
 			http.Error(w, "Service Unavailable: Redis queue is unavailable", http.StatusServiceUnavailable)
@@ -60,6 +106,152 @@ func (h *EmailHandler) SendEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if key != "" {
+		if err := h.idempotency.Complete(r.Context(), key, record); err != nil {
+			// Repeats of this request get a 409 until the key expires.
+			h.logger.Errorf("Failed to complete idempotency key: %v", err)
+		}
+	}
+	writeAccepted(w, job.ID)
+}
+
+// replay answers a request whose idempotency key was used before.
+func (h *EmailHandler) replay(w http.ResponseWriter, existing ports.IdempotencyRecord, fingerprint string) {
+	switch {
+	case existing.Fingerprint != fingerprint:
+		http.Error(w, "Conflict: Idempotency-Key was already used with a different payload", http.StatusConflict)
+	case !existing.Completed:
+		http.Error(w, "Conflict: A request with this Idempotency-Key is still being processed", http.StatusConflict)
+	default:
+		h.logger.Printf("Replaying response for job %s", existing.JobID)
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeAccepted(w, existing.JobID)
+	}
+}
+
+// sendEmailResponse is the body of a 202 response to POST /send-email.
+type sendEmailResponse struct {
+	ID        string `json:"id"`
+	Message   string `json:"message"`
+	StatusURL string `json:"status_url"`
+}
+
+// statusURL returns the path of a job's status.
+func statusURL(jobID string) string {
+	return "/v1/emails/" + jobID
+}
+
+func writeAccepted(w http.ResponseWriter, jobID string) {
+	statusURL := statusURL(jobID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", statusURL)
 	w.WriteHeader(http.StatusAccepted) // 202 Accepted
-	w.Write([]byte("Email job enqueued successfully"))
+	json.NewEncoder(w).Encode(sendEmailResponse{
+		ID:        jobID,
+		Message:   "Email job enqueued successfully",
+		StatusURL: statusURL,
+	})
+}
+
+// GetEmail handles the GET /v1/emails/{id} endpoint.
+func (h *EmailHandler) GetEmail(w http.ResponseWriter, r *http.Request) {
+	status, err := h.emailService.GetEmailStatus(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ports.ErrJobNotFound) {
+		http.Error(w, "Email job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Error reading email job status: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// cancelEmailResponse is the body of a response to DELETE /v1/emails/{id}.
+type cancelEmailResponse struct {
+	ID        string          `json:"id"`
+	Cancelled bool            `json:"cancelled"`
+	State     domain.JobState `json:"state"`
+	Message   string          `json:"message"`
+}
+
+// CancelEmail handles the DELETE /v1/emails/{id} endpoint. It answers 200 if
+// the job was cancelled before a worker picked it up, and 409 with the
+// job's state if a worker got to it first.
+func (h *EmailHandler) CancelEmail(w http.ResponseWriter, r *http.Request) {
+	status, err := h.emailService.CancelEmail(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ports.ErrJobNotFound) {
+		http.Error(w, "Email job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil && !errors.Is(err, ports.ErrJobNotCancellable) {
+		h.logger.Errorf("Error cancelling email job: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := cancelEmailResponse{ID: status.ID, Cancelled: err == nil, State: status.State}
+	code := http.StatusOK
+	switch status.State {
+	case domain.JobCancelled:
+		resp.Message = "Email job cancelled"
+	case domain.JobSent:
+		resp.Message = "Email job was already sent"
+		code = http.StatusConflict
+	case domain.JobDeadLettered:
+		resp.Message = "Email job was already moved to the Dead Letter Queue"
+		code = http.StatusConflict
+	case domain.JobBuffered, domain.JobDigested:
+		resp.Message = "Email job is merged into a digest"
+		code = http.StatusConflict
+	default:
+		resp.Message = "Email job is already being sent"
+		code = http.StatusConflict
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// retryAfter returns when a full queue expects to have room again, or zero
+// if err does not say.
+func retryAfter(err error) time.Duration {
+	var full *ports.QueueFullError
+	if errors.As(err, &full) {
+		return full.RetryAfter
+	}
+	return 0
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+}
+
+// resetJob clears the fields the service keeps about a job's delivery, so
+// that clients cannot set them.
+func resetJob(job *domain.EmailJob) {
+	// Initialize retries to 0 for new jobs
+	job.Retries = 0
+	job.FirstAttemptAt = nil
+	job.NextAttemptAt = nil
+	job.RetryDelayMs = 0
+	job.LastError = nil
+	job.Sequence = nil
+	job.Digest = nil
+	job.ID = ""
+}
+
+// payloadFingerprint hashes the job as requested, so that a repeated
+// idempotency key can be told apart from a reused one.
+func payloadFingerprint(job domain.EmailJob) string {
+	payload, _ := json.Marshal(job) // EmailJob always marshals
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
 }
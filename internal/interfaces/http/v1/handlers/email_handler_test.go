@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	idempotencymemory "email-queue-service/internal/infrastructure/idempotency/memory"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// fakeEmailService records the jobs it accepts. Enqueues fail with err, or
+// with the error in rejectTo for the job's recipient, and statuses answers
+// GetEmailStatus and CancelEmail.
+type fakeEmailService struct {
+	mu       sync.Mutex
+	jobs     []domain.EmailJob
+	err      error
+	rejectTo map[string]error
+	statuses map[string]domain.JobStatus
+}
+
+func (s *fakeEmailService) EnqueueEmail(ctx context.Context, job domain.EmailJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.rejectTo[job.To]; err != nil {
+		return err
+	}
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+func (s *fakeEmailService) EnqueueEmails(ctx context.Context, jobs []domain.EmailJob) []error {
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		errs[i] = s.EnqueueEmail(ctx, job)
+	}
+	return errs
+}
+
+func (s *fakeEmailService) ProcessEmailJob(job domain.EmailJob) {}
+
+func (s *fakeEmailService) GetEmailStatus(ctx context.Context, id string) (domain.JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[id]
+	if !ok {
+		return domain.JobStatus{}, ports.ErrJobNotFound
+	}
+	return status, nil
+}
+
+func (s *fakeEmailService) CancelEmail(ctx context.Context, id string) (domain.JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[id]
+	if !ok {
+		return domain.JobStatus{}, ports.ErrJobNotFound
+	}
+	if !status.State.Cancellable() && status.State != domain.JobCancelled {
+		return status, ports.ErrJobNotCancellable
+	}
+	status.State = domain.JobCancelled
+	s.statuses[id] = status
+	return status, nil
+}
+
+func (s *fakeEmailService) accepted() []domain.EmailJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]domain.EmailJob(nil), s.jobs...)
+}
+
+func newTestHandler(es ports.EmailService) *EmailHandler {
+	return NewEmailHandler(es, idempotencymemory.NewStore(time.Minute, time.Hour), 10, logger.NewLogger())
+}
+
+const testEmailBody = `{"to":"a@example.com","subject":"Hi","body":"Hello"}`
+
+func sendEmail(h *EmailHandler, body, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	h.SendEmail(rec, req)
+	return rec
+}
+
+func acceptedID(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d (%s), want 202", rec.Code, rec.Body)
+	}
+	var resp sendEmailResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.ID == "" || rec.Header().Get("Location") != statusURL(resp.ID) {
+		t.Errorf("response = %+v, Location %q; want the job ID and its status URL", resp, rec.Header().Get("Location"))
+	}
+	return resp.ID
+}
+
+func TestSendEmailReplaysRepeatedIdempotencyKey(t *testing.T) {
+	es := &fakeEmailService{}
+	h := newTestHandler(es)
+
+	first := acceptedID(t, sendEmail(h, testEmailBody, "key-1"))
+	rec := sendEmail(h, testEmailBody, "key-1")
+	if got := acceptedID(t, rec); got != first {
+		t.Errorf("replayed job ID = %s, want %s", got, first)
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replayed response lacks the Idempotent-Replayed header")
+	}
+	if n := len(es.accepted()); n != 1 {
+		t.Errorf("service accepted %d jobs, want 1", n)
+	}
+
+	// Another key is another request.
+	if got := acceptedID(t, sendEmail(h, testEmailBody, "key-2")); got == first {
+		t.Errorf("a new key got the job ID of another key")
+	}
+	if n := len(es.accepted()); n != 2 {
+		t.Errorf("service accepted %d jobs, want 2", n)
+	}
+}
+
+func TestSendEmailRejectsReusedIdempotencyKey(t *testing.T) {
+	es := &fakeEmailService{}
+	h := newTestHandler(es)
+
+	acceptedID(t, sendEmail(h, testEmailBody, "key"))
+	rec := sendEmail(h, `{"to":"b@example.com","subject":"Hi","body":"Hello"}`, "key")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status of a reused key with another payload = %d, want 409", rec.Code)
+	}
+
+	// Fields the service sets are not part of the fingerprint.
+	rec = sendEmail(h, `{"to":"a@example.com","subject":"Hi","body":"Hello","retries":3}`, "key")
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status of a repeat that sets retries = %d, want 202", rec.Code)
+	}
+}
+
+func TestSendEmailRejectsKeyInProgress(t *testing.T) {
+	store := idempotencymemory.NewStore(time.Minute, time.Hour)
+	h := NewEmailHandler(&fakeEmailService{}, store, 10, logger.NewLogger())
+
+	// Another request reserved the key and is still being handled.
+	var job domain.EmailJob
+	json.Unmarshal([]byte(testEmailBody), &job)
+	store.Reserve(context.Background(), "key", ports.IdempotencyRecord{JobID: "other", Fingerprint: payloadFingerprint(job)})
+
+	if rec := sendEmail(h, testEmailBody, "key"); rec.Code != http.StatusConflict {
+		t.Errorf("status while the first request is in progress = %d, want 409", rec.Code)
+	}
+}
+
+func TestSendEmailReleasesKeyOnFailure(t *testing.T) {
+	es := &fakeEmailService{err: &ports.QueueFullError{RetryAfter: 1500 * time.Millisecond}}
+	h := newTestHandler(es)
+
+	rec := sendEmail(h, testEmailBody, "key")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("status = %d, Retry-After %q; want 503 and 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// The failed request can be retried with the same key.
+	es.mu.Lock()
+	es.err = nil
+	es.mu.Unlock()
+	acceptedID(t, sendEmail(h, testEmailBody, "key"))
+	if n := len(es.accepted()); n != 1 {
+		t.Errorf("service accepted %d jobs, want 1", n)
+	}
+}
+
+func TestSendEmailRejectsLongIdempotencyKey(t *testing.T) {
+	es := &fakeEmailService{}
+	h := newTestHandler(es)
+
+	if rec := sendEmail(h, testEmailBody, strings.Repeat("k", maxIdempotencyKeyLength+1)); rec.Code != http.StatusBadRequest {
+		t.Errorf("status of a long key = %d, want 400", rec.Code)
+	}
+	if n := len(es.accepted()); n != 0 {
+		t.Errorf("service accepted %d jobs, want 0", n)
+	}
+}
+
+func TestGetEmail(t *testing.T) {
+	es := &fakeEmailService{statuses: map[string]domain.JobStatus{
+		"job-1": {ID: "job-1", State: domain.JobRetrying, To: "a@example.com", Attempts: []domain.Attempt{{Number: 1}}},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/emails/{id}", newTestHandler(es).GetEmail)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/emails/job-1", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, content type %q; want 200 with JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var status domain.JobStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if status.ID != "job-1" || status.State != domain.JobRetrying || len(status.Attempts) != 1 {
+		t.Errorf("response = %+v, want the stored status", status)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/emails/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status of an unknown job = %d, want 404", rec.Code)
+	}
+}
+
+func TestCancelEmail(t *testing.T) {
+	es := &fakeEmailService{statuses: map[string]domain.JobStatus{
+		"queued":   {ID: "queued", State: domain.JobQueued},
+		"sent":     {ID: "sent", State: domain.JobSent},
+		"sending":  {ID: "sending", State: domain.JobProcessing},
+		"digested": {ID: "digested", State: domain.JobDigested},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /v1/emails/{id}", newTestHandler(es).CancelEmail)
+
+	tests := []struct {
+		id        string
+		code      int
+		cancelled bool
+		state     domain.JobState
+	}{
+		{"queued", http.StatusOK, true, domain.JobCancelled},
+		{"queued", http.StatusOK, true, domain.JobCancelled}, // Cancelling twice
+		{"sent", http.StatusConflict, false, domain.JobSent},
+		{"sending", http.StatusConflict, false, domain.JobProcessing},
+		{"digested", http.StatusConflict, false, domain.JobDigested},
+		{"unknown", http.StatusNotFound, false, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v1/emails/"+tt.id, nil))
+		if rec.Code != tt.code {
+			t.Errorf("DELETE %s status = %d, want %d", tt.id, rec.Code, tt.code)
+			continue
+		}
+		if tt.code == http.StatusNotFound {
+			continue
+		}
+		var resp cancelEmailResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.ID != tt.id || resp.Cancelled != tt.cancelled || resp.State != tt.state || resp.Message == "" {
+			t.Errorf("DELETE %s = %+v, want cancelled %v in state %s", tt.id, resp, tt.cancelled, tt.state)
+		}
+	}
+}
+
+func TestSendEmailIgnoresServerFields(t *testing.T) {
+	es := &fakeEmailService{}
+	h := newTestHandler(es)
+
+	// A client naming another recipient's digest buffer, as if the job were
+	// the digest sending it.
+	body := `{"to":"a@example.com","subject":"Hi","body":"Hello","digest_key":"activity",
+		"digest":{"buffer":"activity:victim@example.com"},"sequence":{"enrollment":"e1","step":1},"retries":5}`
+	acceptedID(t, sendEmail(h, body, ""))
+	batch := `{"emails":[` + body + `]}`
+	if rec := sendBatch(h, batch); rec.Code != http.StatusAccepted {
+		t.Fatalf("batch status = %d (%s), want 202", rec.Code, rec.Body)
+	}
+
+	for _, job := range es.accepted() {
+		if job.Digest != nil || job.Sequence != nil || job.Retries != 0 {
+			t.Errorf("accepted job = %+v, want the digest, sequence and retries cleared", job)
+		}
+		if job.DigestKey != "activity" {
+			t.Errorf("accepted job digest key = %q, want the client's digest key", job.DigestKey)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"email-queue-service/internal/core/ports"
+)
+
+// unavailableRetryAfter is the Retry-After of requests refused while a
+// backend is unavailable.
+const unavailableRetryAfter = 5 * time.Second
+
+// HealthHandler answers readiness probes and holds back API requests while
+// a backend the service depends on cannot be reached.
+type HealthHandler struct {
+	checks []ports.HealthChecker
+}
+
+// NewHealthHandler creates a new HealthHandler. Without checks, the service
+// is always ready.
+func NewHealthHandler(checks ...ports.HealthChecker) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+// readinessResponse is the body of GET /readyz.
+type readinessResponse struct {
+	Ready  bool              `json:"ready"`
+	Errors map[string]string `json:"errors,omitempty"` // Keyed by backend
+}
+
+// Ready handles the GET /readyz endpoint. It answers 200 OK while every
+// backend can be reached, and 503 Service Unavailable otherwise.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	resp := readinessResponse{Ready: true}
+	for _, check := range h.checks {
+		if err := check.Check(r.Context()); err != nil {
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]string)
+			}
+			resp.Ready = false
+			resp.Errors[check.Name()] = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RequireReady answers requests with 503 Service Unavailable while a
+// backend cannot be reached, rather than letting them fail on it. The
+// backend's monitor logs the outage, so the refused requests are not.
+func (h *HealthHandler) RequireReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range h.checks {
+			if err := check.Check(r.Context()); err != nil {
+				setRetryAfter(w, unavailableRetryAfter)
+				http.Error(w, "Service Unavailable: "+check.Name()+" is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
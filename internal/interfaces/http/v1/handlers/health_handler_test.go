@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"email-queue-service/internal/core/ports"
+)
+
+// fakeChecker reports a backend as unavailable while down is set.
+type fakeChecker struct {
+	down bool
+}
+
+func (c *fakeChecker) Name() string { return "redis" }
+
+func (c *fakeChecker) Check(ctx context.Context) error {
+	if c.down {
+		return fmt.Errorf("%w: Redis: connection refused", ports.ErrUnavailable)
+	}
+	return nil
+}
+
+func TestHealthHandlerReadiness(t *testing.T) {
+	checker := &fakeChecker{down: true}
+	h := NewHealthHandler(checker)
+	api := h.RequireReady(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "connection refused") {
+		t.Errorf("GET /readyz while Redis is down = %d %q, want 503 with the error", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("POST /send-email while Redis is down = %d (Retry-After %q), want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	checker.down = false
+	rec = httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /readyz = %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/send-email", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("POST /send-email = %d, want it handled", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// ScheduleHandler handles HTTP requests related to recurring schedules.
+type ScheduleHandler struct {
+	scheduleService ports.ScheduleService
+	logger          *logger.Logger
+}
+
+// NewScheduleHandler creates a new ScheduleHandler.
+func NewScheduleHandler(ss ports.ScheduleService, l *logger.Logger) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduleService: ss,
+		logger:          l,
+	}
+}
+
+// SchedulesDisabled answers the schedule endpoints with 501 Not Implemented
+// when no SCHEDULE_STORE is configured.
+func SchedulesDisabled(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Recurring schedules are disabled: set SCHEDULE_STORE", http.StatusNotImplemented)
+}
+
+// PutSchedule handles the PUT /v1/schedules/{name} endpoint. It creates the
+// schedule or replaces its definition.
+func (h *ScheduleHandler) PutSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule domain.Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		h.logger.Errorf("Failed to decode schedule: %v", err)
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	schedule.Name = r.PathValue("name")
+	if err := schedule.Validate(); err != nil {
+		h.logger.Warnf("Invalid schedule received: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	schedule, err := h.scheduleService.PutSchedule(r.Context(), schedule)
+	if errors.Is(err, ports.ErrInvalidSchedule) {
+		h.logger.Warnf("Invalid schedule received: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Error storing schedule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// ListSchedules handles the GET /v1/schedules endpoint.
+func (h *ScheduleHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := h.scheduleService.ListSchedules(r.Context())
+	if err != nil {
+		h.logger.Errorf("Error listing schedules: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// GetSchedule handles the GET /v1/schedules/{name} endpoint.
+func (h *ScheduleHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, err := h.scheduleService.GetSchedule(r.Context(), r.PathValue("name"))
+	if errors.Is(err, ports.ErrScheduleNotFound) {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Error reading schedule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// DeleteSchedule handles the DELETE /v1/schedules/{name} endpoint. It
+// answers 204 once the schedule is removed.
+func (h *ScheduleHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	err := h.scheduleService.DeleteSchedule(r.Context(), r.PathValue("name"))
+	if errors.Is(err, ports.ErrScheduleNotFound) {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Error deleting schedule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
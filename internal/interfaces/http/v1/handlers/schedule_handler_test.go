@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"email-queue-service/internal/pkg/logger"
+)
+
+func TestSchedulesDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	SchedulesDisabled(rec, httptest.NewRequest(http.MethodPut, "/v1/schedules/weekly", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "SCHEDULE_STORE") {
+		t.Errorf("body = %q, want it to name SCHEDULE_STORE", rec.Body)
+	}
+}
+
+func TestPutScheduleRejectsInvalidSchedules(t *testing.T) {
+	// Invalid schedules are rejected before they reach the service.
+	h := NewScheduleHandler(nil, logger.NewLogger())
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/schedules/{name}", h.PutSchedule)
+
+	tests := []struct {
+		path, body string
+		code       int
+	}{
+		{"/v1/schedules/weekly", `{"cron":`, http.StatusBadRequest},
+		{"/v1/schedules/weekly", `{"cron":"0 9 * *","subject":"Hi","body":"Hello","recipients":[{"to":"a@example.com"}]}`, http.StatusUnprocessableEntity},
+		{"/v1/schedules/Weekly", `{"cron":"0 9 * * mon","subject":"Hi","body":"Hello","recipients":[{"to":"a@example.com"}]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if rec := serve(mux, http.MethodPut, tt.path, tt.body); rec.Code != tt.code {
+			t.Errorf("PUT %s %s: status = %d, want %d", tt.path, tt.body, rec.Code, tt.code)
+		}
+	}
+}
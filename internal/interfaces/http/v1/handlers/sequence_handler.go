@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// SequenceHandler handles HTTP requests related to drip sequences.
+type SequenceHandler struct {
+	sequenceService ports.SequenceService
+	logger          *logger.Logger
+}
+
+// NewSequenceHandler creates a new SequenceHandler.
+func NewSequenceHandler(ss ports.SequenceService, l *logger.Logger) *SequenceHandler {
+	return &SequenceHandler{
+		sequenceService: ss,
+		logger:          l,
+	}
+}
+
+// PutSequence handles the PUT /v1/sequences/{name} endpoint. It creates the
+// sequence or replaces its definition.
+func (h *SequenceHandler) PutSequence(w http.ResponseWriter, r *http.Request) {
+	var seq domain.Sequence
+	if err := json.NewDecoder(r.Body).Decode(&seq); err != nil {
+		h.logger.Errorf("Failed to decode sequence: %v", err)
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	seq.Name = r.PathValue("name")
+	if err := seq.Validate(); err != nil {
+		h.logger.Warnf("Invalid sequence received: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	seq, err := h.sequenceService.PutSequence(r.Context(), seq)
+	if err != nil {
+		h.logger.Errorf("Error storing sequence: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seq)
+}
+
+// GetSequence handles the GET /v1/sequences/{name} endpoint.
+func (h *SequenceHandler) GetSequence(w http.ResponseWriter, r *http.Request) {
+	seq, err := h.sequenceService.GetSequence(r.Context(), r.PathValue("name"))
+	if errors.Is(err, ports.ErrSequenceNotFound) {
+		http.Error(w, "Sequence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Error reading sequence: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seq)
+}
+
+// enrollRequest is the body of POST /v1/sequences/{name}/enrollments.
+type enrollRequest struct {
+	To   string            `json:"to"`
+	Data map[string]string `json:"data"`
+}
+
+// Enroll handles the POST /v1/sequences/{name}/enrollments endpoint. It
+// answers 201 with the enrollment once its first step is queued.
+func (h *SequenceHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	var req enrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Errorf("Failed to decode enrollment: %v", err)
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PathValue("name")
+	enrollment, err := h.sequenceService.Enroll(r.Context(), name, req.To, req.Data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ports.ErrSequenceNotFound):
+			http.Error(w, "Sequence not found", http.StatusNotFound)
+		case errors.Is(err, ports.ErrInvalidEnrollment), errors.Is(err, ports.ErrUnknownQueue):
+			h.logger.Warnf("Invalid enrollment received: %v", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, ports.ErrQueueFull):
+			setRetryAfter(w, retryAfter(err))
+			http.Error(w, "Service Unavailable: Email queue is full", http.StatusServiceUnavailable)
+		default:
+			h.logger.Errorf("Error enrolling in sequence: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	location := "/v1/sequences/" + name + "/enrollments/" + enrollment.ID
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// GetEnrollment handles the GET /v1/sequences/{name}/enrollments/{id}
+// endpoint.
+func (h *SequenceHandler) GetEnrollment(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := h.sequenceService.GetEnrollment(r.Context(), r.PathValue("name"), r.PathValue("id"))
+	if errors.Is(err, ports.ErrEnrollmentNotFound) {
+		http.Error(w, "Enrollment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Error reading enrollment: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// eventRequest is the body of POST /v1/sequences/{name}/enrollments/{id}/events.
+type eventRequest struct {
+	Event string `json:"event"`
+}
+
+// TriggerEvent handles the POST /v1/sequences/{name}/enrollments/{id}/events
+// endpoint. It answers 200 with the exited enrollment, 422 for events that
+// are not exit conditions of the sequence, and 409 with the enrollment if it
+// had finished already.
+func (h *SequenceHandler) TriggerEvent(w http.ResponseWriter, r *http.Request) {
+	var req eventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Errorf("Failed to decode event: %v", err)
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.sequenceService.TriggerEvent(r.Context(), r.PathValue("name"), r.PathValue("id"), req.Event)
+	code := http.StatusOK
+	switch {
+	case errors.Is(err, ports.ErrSequenceNotFound):
+		http.Error(w, "Sequence not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ports.ErrEnrollmentNotFound):
+		http.Error(w, "Enrollment not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ports.ErrUnknownEvent):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, ports.ErrEnrollmentFinished):
+		code = http.StatusConflict
+	case err != nil:
+		h.logger.Errorf("Error triggering sequence event: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(enrollment)
+}
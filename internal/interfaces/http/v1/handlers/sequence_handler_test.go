@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+	"email-queue-service/internal/pkg/logger"
+)
+
+// fakeSequenceService knows the sequences in sequences and the enrollments
+// in enrollments. Enroll fails with enrollErr if it is set.
+type fakeSequenceService struct {
+	sequences   map[string]domain.Sequence
+	enrollments map[string]domain.Enrollment
+	enrollErr   error
+}
+
+func (s *fakeSequenceService) PutSequence(ctx context.Context, seq domain.Sequence) (domain.Sequence, error) {
+	s.sequences[seq.Name] = seq
+	return seq, nil
+}
+
+func (s *fakeSequenceService) GetSequence(ctx context.Context, name string) (domain.Sequence, error) {
+	seq, ok := s.sequences[name]
+	if !ok {
+		return domain.Sequence{}, ports.ErrSequenceNotFound
+	}
+	return seq, nil
+}
+
+func (s *fakeSequenceService) Enroll(ctx context.Context, sequence, to string, data map[string]string) (domain.Enrollment, error) {
+	if _, ok := s.sequences[sequence]; !ok {
+		return domain.Enrollment{}, ports.ErrSequenceNotFound
+	}
+	if s.enrollErr != nil {
+		return domain.Enrollment{}, s.enrollErr
+	}
+	e := domain.Enrollment{ID: fmt.Sprintf("e%d", len(s.enrollments)+1), Sequence: sequence, To: to, Data: data, State: domain.EnrollmentActive}
+	s.enrollments[e.ID] = e
+	return e, nil
+}
+
+func (s *fakeSequenceService) GetEnrollment(ctx context.Context, sequence, id string) (domain.Enrollment, error) {
+	e, ok := s.enrollments[id]
+	if !ok || e.Sequence != sequence {
+		return domain.Enrollment{}, ports.ErrEnrollmentNotFound
+	}
+	return e, nil
+}
+
+func (s *fakeSequenceService) TriggerEvent(ctx context.Context, sequence, id, event string) (domain.Enrollment, error) {
+	seq, err := s.GetSequence(ctx, sequence)
+	if err != nil {
+		return domain.Enrollment{}, err
+	}
+	if !seq.ExitsOn(event) {
+		return domain.Enrollment{}, ports.ErrUnknownEvent
+	}
+	e, err := s.GetEnrollment(ctx, sequence, id)
+	if err != nil {
+		return domain.Enrollment{}, err
+	}
+	if e.State != domain.EnrollmentActive {
+		return e, ports.ErrEnrollmentFinished
+	}
+	e.State, e.Reason = domain.EnrollmentExited, event
+	s.enrollments[id] = e
+	return e, nil
+}
+
+func (s *fakeSequenceService) ProcessEmailJob(job domain.EmailJob) {}
+
+func newTestSequenceMux() (*http.ServeMux, *fakeSequenceService) {
+	ss := &fakeSequenceService{
+		sequences: map[string]domain.Sequence{
+			"welcome": {Name: "welcome", ExitOn: []string{"converted"}, Steps: []domain.SequenceStep{{Subject: "Hi", Body: "Hello"}}},
+		},
+		enrollments: make(map[string]domain.Enrollment),
+	}
+	h := NewSequenceHandler(ss, logger.NewLogger())
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/sequences/{name}", h.PutSequence)
+	mux.HandleFunc("GET /v1/sequences/{name}", h.GetSequence)
+	mux.HandleFunc("POST /v1/sequences/{name}/enrollments", h.Enroll)
+	mux.HandleFunc("GET /v1/sequences/{name}/enrollments/{id}", h.GetEnrollment)
+	mux.HandleFunc("POST /v1/sequences/{name}/enrollments/{id}/events", h.TriggerEvent)
+	return mux, ss
+}
+
+func serve(mux *http.ServeMux, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestSequenceEndpoints(t *testing.T) {
+	mux, _ := newTestSequenceMux()
+
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPut, "/v1/sequences/onboarding", `{"steps":[{"subject":"Hi","body":"Hello"}]}`, http.StatusOK},
+		{http.MethodPut, "/v1/sequences/onboarding", `{"steps":[]}`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/v1/sequences/onboarding", `{"steps":`, http.StatusBadRequest},
+		{http.MethodGet, "/v1/sequences/onboarding", "", http.StatusOK},
+		{http.MethodGet, "/v1/sequences/unknown", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := serve(mux, tt.method, tt.path, tt.body); rec.Code != tt.code {
+			t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.code)
+		}
+	}
+
+	// The name in the path wins over one in the body.
+	rec := serve(mux, http.MethodGet, "/v1/sequences/onboarding", "")
+	var seq domain.Sequence
+	if err := json.NewDecoder(rec.Body).Decode(&seq); err != nil || seq.Name != "onboarding" {
+		t.Errorf("GET = %+v, %v; want the onboarding sequence", seq, err)
+	}
+}
+
+func TestEnrollmentEndpoints(t *testing.T) {
+	mux, ss := newTestSequenceMux()
+
+	rec := serve(mux, http.MethodPost, "/v1/sequences/welcome/enrollments", `{"to":"a@example.com","data":{"name":"Ada"}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("enroll status = %d (%s), want 201", rec.Code, rec.Body)
+	}
+	var enrollment domain.Enrollment
+	if err := json.NewDecoder(rec.Body).Decode(&enrollment); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if want := "/v1/sequences/welcome/enrollments/" + enrollment.ID; rec.Header().Get("Location") != want {
+		t.Errorf("Location = %q, want %q", rec.Header().Get("Location"), want)
+	}
+	events := "/v1/sequences/welcome/enrollments/" + enrollment.ID + "/events"
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		state              domain.EnrollmentState
+	}{
+		{http.MethodGet, "/v1/sequences/welcome/enrollments/" + enrollment.ID, "", http.StatusOK, domain.EnrollmentActive},
+		{http.MethodGet, "/v1/sequences/other/enrollments/" + enrollment.ID, "", http.StatusNotFound, ""},
+		{http.MethodPost, events, `{"event":"clicked"}`, http.StatusUnprocessableEntity, ""},
+		{http.MethodPost, events, `{"event":`, http.StatusBadRequest, ""},
+		{http.MethodPost, "/v1/sequences/welcome/enrollments/unknown/events", `{"event":"converted"}`, http.StatusNotFound, ""},
+		{http.MethodPost, events, `{"event":"converted"}`, http.StatusOK, domain.EnrollmentExited},
+		{http.MethodPost, events, `{"event":"converted"}`, http.StatusConflict, domain.EnrollmentExited}, // Finished already
+	}
+	for _, tt := range tests {
+		rec := serve(mux, tt.method, tt.path, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.code)
+			continue
+		}
+		if tt.state == "" {
+			continue
+		}
+		var got domain.Enrollment
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.State != tt.state {
+			t.Errorf("%s %s: enrollment = %+v, %v; want state %s", tt.method, tt.path, got, err, tt.state)
+		}
+	}
+
+	// Enrollment errors of the service.
+	errTests := []struct {
+		err        error
+		code       int
+		retryAfter string
+	}{
+		{fmt.Errorf("%w: step 2: missing name", ports.ErrInvalidEnrollment), http.StatusUnprocessableEntity, ""},
+		{&ports.QueueFullError{RetryAfter: 3 * time.Second}, http.StatusServiceUnavailable, "3"},
+		{fmt.Errorf("store is down"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range errTests {
+		ss.enrollErr = tt.err
+		rec := serve(mux, http.MethodPost, "/v1/sequences/welcome/enrollments", `{"to":"a@example.com"}`)
+		if rec.Code != tt.code {
+			t.Errorf("enroll failing with %v: status = %d, want %d", tt.err, rec.Code, tt.code)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("enroll failing with %v: Retry-After = %q, want %q", tt.err, got, tt.retryAfter)
+		}
+	}
+	if rec := serve(mux, http.MethodPost, "/v1/sequences/unknown/enrollments", `{"to":"a@example.com"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("enroll in an unknown sequence: status = %d, want 404", rec.Code)
+	}
+}
@@ -6,7 +6,25 @@ import (
 	"email-queue-service/internal/interfaces/http/v1/handlers"
 )
 
-// SetupRoutes registers the API routes with the given ServeMux.
-func SetupRoutes(mux *http.ServeMux, emailHandler *handlers.EmailHandler) {
+// SetupRoutes registers the API routes with the given ServeMux. Without a
+// scheduleHandler, the schedule routes answer that schedules are disabled.
+func SetupRoutes(mux *http.ServeMux, emailHandler *handlers.EmailHandler, sequenceHandler *handlers.SequenceHandler, scheduleHandler *handlers.ScheduleHandler) {
 	mux.HandleFunc("/send-email", emailHandler.SendEmail)
+	mux.HandleFunc("POST /v1/emails/batch", emailHandler.SendEmailBatch)
+	mux.HandleFunc("GET /v1/emails/{id}", emailHandler.GetEmail)
+	mux.HandleFunc("DELETE /v1/emails/{id}", emailHandler.CancelEmail)
+	mux.HandleFunc("PUT /v1/sequences/{name}", sequenceHandler.PutSequence)
+	mux.HandleFunc("GET /v1/sequences/{name}", sequenceHandler.GetSequence)
+	mux.HandleFunc("POST /v1/sequences/{name}/enrollments", sequenceHandler.Enroll)
+	mux.HandleFunc("GET /v1/sequences/{name}/enrollments/{id}", sequenceHandler.GetEnrollment)
+	mux.HandleFunc("POST /v1/sequences/{name}/enrollments/{id}/events", sequenceHandler.TriggerEvent)
+	if scheduleHandler == nil {
+		mux.HandleFunc("/v1/schedules", handlers.SchedulesDisabled)
+		mux.HandleFunc("/v1/schedules/{name}", handlers.SchedulesDisabled)
+		return
+	}
+	mux.HandleFunc("GET /v1/schedules", scheduleHandler.ListSchedules)
+	mux.HandleFunc("PUT /v1/schedules/{name}", scheduleHandler.PutSchedule)
+	mux.HandleFunc("GET /v1/schedules/{name}", scheduleHandler.GetSchedule)
+	mux.HandleFunc("DELETE /v1/schedules/{name}", scheduleHandler.DeleteSchedule)
 }
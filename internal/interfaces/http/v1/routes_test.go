@@ -0,0 +1,29 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"email-queue-service/internal/interfaces/http/v1/handlers"
+	"email-queue-service/internal/pkg/logger"
+)
+
+func TestSetupRoutesWithoutSchedules(t *testing.T) {
+	l := logger.NewLogger()
+	mux := http.NewServeMux()
+	SetupRoutes(mux, handlers.NewEmailHandler(nil, nil, 10, l), handlers.NewSequenceHandler(nil, l), nil)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/v1/schedules", nil),
+		httptest.NewRequest(http.MethodPut, "/v1/schedules/weekly", nil),
+		httptest.NewRequest(http.MethodGet, "/v1/schedules/weekly", nil),
+		httptest.NewRequest(http.MethodDelete, "/v1/schedules/weekly", nil),
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotImplemented {
+			t.Errorf("%s %s: status = %d, want 501", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+}
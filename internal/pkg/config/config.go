@@ -1,9 +1,45 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// Supported values for QUEUE_BACKEND.
+const (
+	QueueBackendMemory       = "memory"
+	QueueBackendRedis        = "redis"
+	QueueBackendRedisStreams = "redis-streams"
+	QueueBackendDisk         = "disk"
+	QueueBackendPostgres     = "postgres"
+	QueueBackendNATS         = "nats"
+	QueueBackendHybrid       = "hybrid"
+)
+
+// Supported values for REDIS_MODE.
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+// Overflow policies of the in-memory queue.
+const (
+	QueueOverflowReject     = "reject"
+	QueueOverflowBlock      = "block"
+	QueueOverflowDropOldest = "drop-oldest"
+)
+
+// Backends of the stores that keep request and job state next to the queue.
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
 )
 
 // Config holds the application's configuration.
@@ -11,12 +47,57 @@ type Config struct {
 	HTTPPort          int
 	WorkerCount       int
 	QueueCapacity     int
+	QueueOverflow     string
+	EnqueueTimeout    time.Duration
 	MaxRetries        int
 	RetryDelaySeconds int
-	UseRedisQueue     bool
+	QueueBackend      string
+	RedisMode         string
 	RedisAddr         string
+	RedisAddrs        []string
 	RedisPassword     string
 	RedisDB           int
+	RedisMasterName   string
+	SentinelPassword  string
+	RedisKeyPrefix    string
+	RedisConnectWait  time.Duration
+	RedisReliable     bool
+	ConsumerName      string
+	VisibilityTimeout time.Duration
+	StreamMaxLen      int64
+	StreamMaxDeliver  int64
+	NATSURL           string
+	NATSMaxDeliver    int
+	DiskQueueDir      string
+	DiskFsyncPolicy   string
+	DiskSyncInterval  time.Duration
+	DiskSegmentBytes  int64
+	DatabaseURL       string
+	SQLPollInterval   time.Duration
+	SQLMaxDeliver     int
+	SQLAutoMigrate    bool
+	InlineCSS         bool
+	GenerateTextBody  bool
+	PriorityWeights   map[domain.Priority]int
+	StarvationLimit   int
+	ShutdownTimeout   time.Duration
+	RetryPolicies     *domain.RetryPolicies
+	Queues            []QueueConfig // The default queue first
+	IdempotencyStore  string
+	IdempotencyTTL    time.Duration
+	IdempotencyLease  time.Duration
+	JobStateStore     string
+	JobStateTTL       time.Duration
+	SequenceStore     string
+	DigestStore       string
+	DigestWindow      time.Duration
+	DigestSubject     string
+	DigestTemplate    string
+	ScheduleStore     string // Empty if recurring schedules are disabled
+	ScheduleInterval  time.Duration
+	ScheduleLease     time.Duration
+	ScheduleGrace     time.Duration
+	BatchMaxSize      int
 }
 
 // LoadConfig loads configuration from environment variables or uses default values.
@@ -42,6 +123,21 @@ func LoadConfig() *Config {
 		log.Printf("QUEUE_CAPACITY not set or invalid, using default: %d", queueCapacity)
 	}
 
+	queueOverflow := os.Getenv("QUEUE_OVERFLOW_POLICY")
+	switch queueOverflow {
+	case QueueOverflowReject, QueueOverflowBlock, QueueOverflowDropOldest:
+	default:
+		if queueOverflow != "" {
+			log.Printf("QUEUE_OVERFLOW_POLICY %q is not supported, using default: %s", queueOverflow, QueueOverflowReject)
+		}
+		queueOverflow = QueueOverflowReject // Default: fail enqueues while the queue is full
+	}
+	enqueueTimeoutStr := os.Getenv("ENQUEUE_TIMEOUT_MS")
+	enqueueTimeoutMs, err := strconv.Atoi(enqueueTimeoutStr)
+	if err != nil || enqueueTimeoutMs <= 0 {
+		enqueueTimeoutMs = 2000 // Default time a blocked enqueue waits for room
+	}
+
 	maxRetriesStr := os.Getenv("MAX_RETRIES")
 	maxRetries, err := strconv.Atoi(maxRetriesStr)
 	if err != nil || maxRetries < 0 {
@@ -56,12 +152,142 @@ func LoadConfig() *Config {
 		log.Printf("RETRY_DELAY_SECONDS not set or invalid, using default: %d", retryDelaySeconds)
 	}
 
-	useRedisQueue := os.Getenv("USE_REDIS_QUEUE") == "true"
+	queueBackend := os.Getenv("QUEUE_BACKEND")
+	switch queueBackend {
+	case QueueBackendMemory, QueueBackendRedis, QueueBackendRedisStreams, QueueBackendDisk, QueueBackendPostgres, QueueBackendNATS, QueueBackendHybrid:
+	default:
+		if queueBackend != "" {
+			log.Printf("QUEUE_BACKEND %q is not supported, falling back to USE_REDIS_QUEUE", queueBackend)
+		}
+		queueBackend = QueueBackendMemory // Default queue backend
+		if os.Getenv("USE_REDIS_QUEUE") == "true" {
+			queueBackend = QueueBackendRedis
+		}
+	}
+	usesRedis := queueBackend == QueueBackendRedis || queueBackend == QueueBackendRedisStreams
+
+	idempotencyStore := os.Getenv("IDEMPOTENCY_STORE")
+	switch idempotencyStore {
+	case StoreMemory, StoreRedis:
+	default:
+		idempotencyStore = StoreMemory // Default: Redis if the queue uses it, so that instances share keys
+		if usesRedis {
+			idempotencyStore = StoreRedis
+		}
+	}
+	idempotencyTTLStr := os.Getenv("IDEMPOTENCY_TTL_SECONDS")
+	idempotencyTTLSeconds, err := strconv.Atoi(idempotencyTTLStr)
+	if err != nil || idempotencyTTLSeconds <= 0 {
+		idempotencyTTLSeconds = 24 * 60 * 60 // Default: keys are remembered for a day
+	}
+	idempotencyLeaseStr := os.Getenv("IDEMPOTENCY_LEASE_SECONDS")
+	idempotencyLeaseSeconds, err := strconv.Atoi(idempotencyLeaseStr)
+	if err != nil || idempotencyLeaseSeconds <= 0 {
+		idempotencyLeaseSeconds = 30 // Default time a key stays reserved for a request that never completes
+	}
+
+	jobStateStore := os.Getenv("JOB_STATE_STORE")
+	switch jobStateStore {
+	case StoreMemory, StoreRedis:
+	default:
+		jobStateStore = StoreMemory // Default: Redis if the queue uses it, so that every instance can answer
+		if usesRedis {
+			jobStateStore = StoreRedis
+		}
+	}
+	jobStateTTLStr := os.Getenv("JOB_STATE_TTL_SECONDS")
+	jobStateTTLSeconds, err := strconv.Atoi(jobStateTTLStr)
+	if err != nil || jobStateTTLSeconds <= 0 {
+		jobStateTTLSeconds = 7 * 24 * 60 * 60 // Default: statuses are kept for a week after their last update
+	}
+
+	sequenceStore := os.Getenv("SEQUENCE_STORE")
+	switch sequenceStore {
+	case StoreMemory, StoreRedis:
+	default:
+		sequenceStore = StoreMemory // Default: Redis if the queue uses it, so that enrollments survive restarts
+		if usesRedis {
+			sequenceStore = StoreRedis
+		}
+	}
+
+	digestStore := os.Getenv("DIGEST_STORE")
+	switch digestStore {
+	case StoreMemory, StoreRedis:
+	default:
+		digestStore = StoreMemory // Default: Redis if the queue uses it, so that buffers survive restarts
+		if usesRedis {
+			digestStore = StoreRedis
+		}
+	}
+	digestWindowStr := os.Getenv("DIGEST_WINDOW_SECONDS")
+	digestWindowSeconds, err := strconv.Atoi(digestWindowStr)
+	if err != nil || digestWindowSeconds <= 0 {
+		digestWindowSeconds = 15 * 60 // Default: jobs are collected for 15 minutes
+	}
+	digestSubject := os.Getenv("DIGEST_SUBJECT")        // Empty: the built-in subject
+	digestTemplate := os.Getenv("DIGEST_TEMPLATE_FILE") // Empty: the built-in template
+
+	scheduleStore := os.Getenv("SCHEDULE_STORE")
+	switch scheduleStore {
+	case StoreMemory, StoreRedis:
+	default:
+		scheduleStore = "" // Default: Redis if the queue uses it, so that instances share schedules and elect a leader, otherwise disabled
+		if usesRedis {
+			scheduleStore = StoreRedis
+		}
+	}
+	scheduleIntervalStr := os.Getenv("SCHEDULE_POLL_INTERVAL_SECONDS")
+	scheduleIntervalSeconds, err := strconv.Atoi(scheduleIntervalStr)
+	if err != nil || scheduleIntervalSeconds <= 0 {
+		scheduleIntervalSeconds = 10 // Default: due runs are fired within 10 seconds
+	}
+	scheduleLeaseStr := os.Getenv("SCHEDULE_LEADER_LEASE_SECONDS")
+	scheduleLeaseSeconds, err := strconv.Atoi(scheduleLeaseStr)
+	if err != nil || scheduleLeaseSeconds <= scheduleIntervalSeconds {
+		scheduleLeaseSeconds = 3 * scheduleIntervalSeconds // Default: the leader can miss two renewals before another instance takes over
+	}
+	scheduleGraceStr := os.Getenv("SCHEDULE_MISFIRE_GRACE_SECONDS")
+	scheduleGraceSeconds, err := strconv.Atoi(scheduleGraceStr)
+	if err != nil || scheduleGraceSeconds <= 0 {
+		scheduleGraceSeconds = 5 * 60 // Default: runs up to 5 minutes late still fire under catch_up skip
+	}
+	usesRedis = usesRedis || idempotencyStore == StoreRedis || jobStateStore == StoreRedis || sequenceStore == StoreRedis || digestStore == StoreRedis || scheduleStore == StoreRedis
+
 	redisAddr := os.Getenv("REDIS_ADDR")
-	if useRedisQueue && redisAddr == "" {
+	if usesRedis && redisAddr == "" {
 		redisAddr = "localhost:6379" // Default Redis address
 		log.Printf("REDIS_ADDR not set, using default: %s", redisAddr)
 	}
+	// REDIS_ADDR lists the Sentinels or the cluster seed nodes in those modes.
+	var redisAddrs []string
+	for _, addr := range strings.Split(redisAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			redisAddrs = append(redisAddrs, addr)
+		}
+	}
+
+	redisMode := os.Getenv("REDIS_MODE")
+	switch redisMode {
+	case RedisModeStandalone, RedisModeSentinel, RedisModeCluster:
+	default:
+		if redisMode != "" {
+			log.Printf("REDIS_MODE %q is not supported, using default: %s", redisMode, RedisModeStandalone)
+		}
+		redisMode = RedisModeStandalone // Default: a single Redis server
+	}
+	redisMasterName := os.Getenv("REDIS_MASTER_NAME")
+	if redisMode == RedisModeSentinel && redisMasterName == "" {
+		redisMasterName = "mymaster" // Default master name monitored by the Sentinels
+		log.Printf("REDIS_MASTER_NAME not set, using default: %s", redisMasterName)
+	}
+	sentinelPassword := os.Getenv("REDIS_SENTINEL_PASSWORD") // Can be empty
+	redisKeyPrefix := os.Getenv("REDIS_KEY_PREFIX")          // Can be empty
+	redisConnectWaitStr := os.Getenv("REDIS_CONNECT_TIMEOUT_SECONDS")
+	redisConnectWaitSeconds, err := strconv.Atoi(redisConnectWaitStr)
+	if err != nil || redisConnectWaitSeconds < 0 {
+		redisConnectWaitSeconds = 60 // Default time to keep retrying the first connection
+	}
 
 	redisPassword := os.Getenv("REDIS_PASSWORD") // Can be empty
 	redisDBStr := os.Getenv("REDIS_DB")
@@ -70,16 +296,244 @@ func LoadConfig() *Config {
 		redisDB = 0 // Default Redis DB
 		log.Printf("REDIS_DB not set or invalid, using default: %d", redisDB)
 	}
+	if redisMode == RedisModeCluster && redisDB != 0 {
+		log.Printf("REDIS_DB %d is not supported by Redis Cluster, using 0", redisDB)
+		redisDB = 0
+	}
+
+	// Reliable mode keeps dequeued jobs in Redis until they are acknowledged.
+	redisReliable := os.Getenv("REDIS_RELIABLE_QUEUE") == "true"
+	// The consumer name identifies this instance to the shared queue backends.
+	consumerName := os.Getenv("CONSUMER_NAME")
+	if consumerName == "" {
+		consumerName = os.Getenv("REDIS_CONSUMER_NAME")
+	}
+	if consumerName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "email-service"
+		}
+		consumerName = hostname
+	}
+	visibilityTimeoutStr := os.Getenv("VISIBILITY_TIMEOUT_SECONDS")
+	visibilityTimeoutSeconds, err := strconv.Atoi(visibilityTimeoutStr)
+	if err != nil || visibilityTimeoutSeconds <= 0 {
+		visibilityTimeoutSeconds = 60 // Default visibility timeout in seconds
+		if redisReliable || queueBackend == QueueBackendRedisStreams || queueBackend == QueueBackendNATS {
+			log.Printf("VISIBILITY_TIMEOUT_SECONDS not set or invalid, using default: %d", visibilityTimeoutSeconds)
+		}
+	}
+
+	streamMaxLenStr := os.Getenv("STREAM_MAX_LEN")
+	streamMaxLen, err := strconv.ParseInt(streamMaxLenStr, 10, 64)
+	if err != nil || streamMaxLen < 0 {
+		streamMaxLen = 0 // Default: acknowledged entries are deleted right away
+	}
+	streamMaxDeliverStr := os.Getenv("STREAM_MAX_DELIVER")
+	streamMaxDeliver, err := strconv.ParseInt(streamMaxDeliverStr, 10, 64)
+	if err != nil || streamMaxDeliver <= 0 {
+		streamMaxDeliver = 5 // Default deliveries of an unacknowledged job before it goes to the DLQ
+	}
+
+	natsURL := os.Getenv("NATS_URL")
+	if queueBackend == QueueBackendNATS && natsURL == "" {
+		natsURL = "nats://localhost:4222" // Default NATS server
+		log.Printf("NATS_URL not set, using default: %s", natsURL)
+	}
+	natsMaxDeliverStr := os.Getenv("NATS_MAX_DELIVER")
+	natsMaxDeliver, err := strconv.Atoi(natsMaxDeliverStr)
+	if err != nil || natsMaxDeliver <= 0 {
+		natsMaxDeliver = 5 // Default deliveries of an unacknowledged job before it goes to the DLQ
+	}
+
+	diskQueueDir := os.Getenv("DISK_QUEUE_DIR")
+	if diskQueueDir == "" {
+		diskQueueDir = "./data/queue" // Default on-disk queue directory
+	}
+	diskFsyncPolicy := os.Getenv("DISK_FSYNC_POLICY")
+	if diskFsyncPolicy == "" {
+		diskFsyncPolicy = "batch" // Default: group commit
+	}
+	diskSyncIntervalStr := os.Getenv("DISK_SYNC_INTERVAL_MS")
+	diskSyncIntervalMs, err := strconv.Atoi(diskSyncIntervalStr)
+	if err != nil || diskSyncIntervalMs <= 0 {
+		diskSyncIntervalMs = 10 // Default fsync interval in milliseconds
+	}
+	diskSegmentBytesStr := os.Getenv("DISK_SEGMENT_BYTES")
+	diskSegmentBytes, err := strconv.ParseInt(diskSegmentBytesStr, 10, 64)
+	if err != nil || diskSegmentBytes <= 0 {
+		diskSegmentBytes = 64 << 20 // Default segment size: 64 MiB
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if queueBackend == QueueBackendPostgres && databaseURL == "" {
+		databaseURL = "postgres://localhost:5432/email_service?sslmode=disable" // Default database URL
+		log.Printf("DATABASE_URL not set, using default: %s", databaseURL)
+	}
+	sqlPollIntervalStr := os.Getenv("SQL_POLL_INTERVAL_MS")
+	sqlPollIntervalMs, err := strconv.Atoi(sqlPollIntervalStr)
+	if err != nil || sqlPollIntervalMs <= 0 {
+		sqlPollIntervalMs = 1000 // Default poll interval in milliseconds
+	}
+	sqlMaxDeliverStr := os.Getenv("SQL_MAX_DELIVER")
+	sqlMaxDeliver, err := strconv.Atoi(sqlMaxDeliverStr)
+	if err != nil || sqlMaxDeliver <= 0 {
+		sqlMaxDeliver = 5 // Default deliveries of an unacknowledged job before it goes to the DLQ
+	}
+	sqlAutoMigrate := os.Getenv("SQL_AUTO_MIGRATE") != "false"
+
+	// HTML post-processing defaults; jobs can override both per request.
+	inlineCSS := os.Getenv("INLINE_CSS") != "false"
+	generateTextBody := os.Getenv("GENERATE_TEXT_BODY") != "false"
+
+	priorityWeights, err := parsePriorityWeights(os.Getenv("PRIORITY_WEIGHTS"))
+	if err != nil {
+		priorityWeights = map[domain.Priority]int{domain.PriorityHigh: 6, domain.PriorityNormal: 3, domain.PriorityBulk: 1} // Default lane weights
+		log.Printf("PRIORITY_WEIGHTS not set or invalid (%v), using default: high=6,normal=3,bulk=1", err)
+	}
+	starvationLimitStr := os.Getenv("PRIORITY_STARVATION_LIMIT")
+	starvationLimit, err := strconv.Atoi(starvationLimitStr)
+	if err != nil || starvationLimit < 0 {
+		starvationLimit = 50 // Default: serve every lane at least once per 50 dequeues
+	}
+
+	// RETRY_DELAY_SECONDS stays the base delay unless RETRY_POLICY sets another one.
+	baseRetryPolicy := domain.RetryPolicy{
+		Base:   time.Duration(retryDelaySeconds) * time.Second,
+		Factor: 2,
+		Cap:    time.Hour,
+		Jitter: domain.JitterFull,
+		MaxAge: 24 * time.Hour,
+	}
+	retryPolicyRules, ok := os.LookupEnv("RETRY_POLICY_RULES")
+	if !ok {
+		retryPolicyRules = defaultRetryPolicyRules
+	}
+	retryPolicySpec := os.Getenv("RETRY_POLICY")
+	retryPolicies, err := parseRetryPolicies(retryPolicySpec, retryPolicyRules, baseRetryPolicy)
+	if err != nil {
+		retryPolicySpec, retryPolicyRules = "", defaultRetryPolicyRules
+		retryPolicies, _ = parseRetryPolicies(retryPolicySpec, retryPolicyRules, baseRetryPolicy)
+		log.Printf("RETRY_POLICY or RETRY_POLICY_RULES invalid (%v), using the default retry policies", err)
+	}
+
+	// The default queue is served by WORKER_COUNT workers with the retry
+	// settings above; QUEUES adds named queues and can change it.
+	defaultQueue := QueueConfig{
+		Name:          domain.DefaultQueue,
+		Workers:       workerCount,
+		MaxRetries:    maxRetries,
+		RetryPolicies: retryPolicies,
+	}
+	queues, err := parseQueues(os.Getenv("QUEUES"), defaultQueue, retryPolicySpec, retryPolicyRules, baseRetryPolicy)
+	if err != nil {
+		queues = []QueueConfig{defaultQueue}
+		log.Printf("QUEUES invalid (%v), using only the default queue", err)
+	}
+	if err := checkQueuesSupported(queueBackend, queues); err != nil {
+		// Serving only the default queue would accept jobs for the named
+		// queues that no worker ever delivers.
+		log.Fatalf("QUEUES invalid: %v", err)
+	}
+
+	batchMaxSizeStr := os.Getenv("BATCH_MAX_SIZE")
+	batchMaxSize, err := strconv.Atoi(batchMaxSizeStr)
+	if err != nil || batchMaxSize <= 0 {
+		batchMaxSize = 1000 // Default number of jobs accepted per batch request
+	}
+
+	shutdownTimeoutStr := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS")
+	shutdownTimeoutSeconds, err := strconv.Atoi(shutdownTimeoutStr)
+	if err != nil || shutdownTimeoutSeconds <= 0 {
+		shutdownTimeoutSeconds = 30 // Default time workers get to drain the queue on shutdown
+	}
 
 	return &Config{
 		HTTPPort:          httpPort,
 		WorkerCount:       workerCount,
 		QueueCapacity:     queueCapacity,
+		QueueOverflow:     queueOverflow,
+		EnqueueTimeout:    time.Duration(enqueueTimeoutMs) * time.Millisecond,
 		MaxRetries:        maxRetries,
 		RetryDelaySeconds: retryDelaySeconds,
-		UseRedisQueue:     useRedisQueue,
+		QueueBackend:      queueBackend,
+		RedisMode:         redisMode,
 		RedisAddr:         redisAddr,
+		RedisAddrs:        redisAddrs,
 		RedisPassword:     redisPassword,
 		RedisDB:           redisDB,
+		RedisMasterName:   redisMasterName,
+		SentinelPassword:  sentinelPassword,
+		RedisKeyPrefix:    redisKeyPrefix,
+		RedisConnectWait:  time.Duration(redisConnectWaitSeconds) * time.Second,
+		RedisReliable:     redisReliable,
+		ConsumerName:      consumerName,
+		VisibilityTimeout: time.Duration(visibilityTimeoutSeconds) * time.Second,
+		StreamMaxLen:      streamMaxLen,
+		StreamMaxDeliver:  streamMaxDeliver,
+		NATSURL:           natsURL,
+		NATSMaxDeliver:    natsMaxDeliver,
+		DiskQueueDir:      diskQueueDir,
+		DiskFsyncPolicy:   diskFsyncPolicy,
+		DiskSyncInterval:  time.Duration(diskSyncIntervalMs) * time.Millisecond,
+		DiskSegmentBytes:  diskSegmentBytes,
+		DatabaseURL:       databaseURL,
+		SQLPollInterval:   time.Duration(sqlPollIntervalMs) * time.Millisecond,
+		SQLMaxDeliver:     sqlMaxDeliver,
+		SQLAutoMigrate:    sqlAutoMigrate,
+		InlineCSS:         inlineCSS,
+		GenerateTextBody:  generateTextBody,
+		PriorityWeights:   priorityWeights,
+		StarvationLimit:   starvationLimit,
+		ShutdownTimeout:   time.Duration(shutdownTimeoutSeconds) * time.Second,
+		RetryPolicies:     retryPolicies,
+		Queues:            queues,
+		IdempotencyStore:  idempotencyStore,
+		IdempotencyTTL:    time.Duration(idempotencyTTLSeconds) * time.Second,
+		IdempotencyLease:  time.Duration(idempotencyLeaseSeconds) * time.Second,
+		JobStateStore:     jobStateStore,
+		JobStateTTL:       time.Duration(jobStateTTLSeconds) * time.Second,
+		SequenceStore:     sequenceStore,
+		DigestStore:       digestStore,
+		DigestWindow:      time.Duration(digestWindowSeconds) * time.Second,
+		DigestSubject:     digestSubject,
+		DigestTemplate:    digestTemplate,
+		ScheduleStore:     scheduleStore,
+		ScheduleInterval:  time.Duration(scheduleIntervalSeconds) * time.Second,
+		ScheduleLease:     time.Duration(scheduleLeaseSeconds) * time.Second,
+		ScheduleGrace:     time.Duration(scheduleGraceSeconds) * time.Second,
+		BatchMaxSize:      batchMaxSize,
+	}
+}
+
+// parsePriorityWeights parses lane weights such as "high=6,normal=3,bulk=1".
+// Lanes that are not listed get a weight of zero.
+func parsePriorityWeights(s string) (map[domain.Priority]int, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty")
+	}
+	weights := make(map[domain.Priority]int)
+	total := 0
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected lane=weight, got %q", pair)
+		}
+		lane := domain.Priority(strings.TrimSpace(name))
+		switch lane {
+		case domain.PriorityHigh, domain.PriorityNormal, domain.PriorityBulk:
+		default:
+			return nil, fmt.Errorf("unknown lane %q", name)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for lane %s", value, lane)
+		}
+		weights[lane] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one lane needs a positive weight")
 	}
+	return weights, nil
 }
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestParsePriorityWeights(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[domain.Priority]int
+		wantErr bool
+	}{
+		{in: "high=6,normal=3,bulk=1", want: map[domain.Priority]int{domain.PriorityHigh: 6, domain.PriorityNormal: 3, domain.PriorityBulk: 1}},
+		{in: " high = 2 , bulk=0", want: map[domain.Priority]int{domain.PriorityHigh: 2, domain.PriorityBulk: 0}},
+		{in: "", wantErr: true},
+		{in: "high", wantErr: true},
+		{in: "urgent=1", wantErr: true},
+		{in: "high=-1,normal=2", wantErr: true},
+		{in: "high=x", wantErr: true},
+		{in: "high=0,normal=0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePriorityWeights(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePriorityWeights(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePriorityWeights(%q) error = %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parsePriorityWeights(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for lane, w := range tt.want {
+			if got[lane] != w {
+				t.Errorf("parsePriorityWeights(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLoadConfigScheduleStore(t *testing.T) {
+	tests := []struct {
+		backend, store string
+		want           string
+	}{
+		{backend: QueueBackendMemory, want: ""}, // Disabled without Redis
+		{backend: QueueBackendDisk, store: "unknown", want: ""},
+		{backend: QueueBackendMemory, store: StoreMemory, want: StoreMemory},
+		{backend: QueueBackendMemory, store: StoreRedis, want: StoreRedis},
+		{backend: QueueBackendRedis, want: StoreRedis},
+		{backend: QueueBackendRedisStreams, store: StoreMemory, want: StoreMemory},
+	}
+	for _, tt := range tests {
+		t.Setenv("USE_REDIS_QUEUE", "")
+		t.Setenv("QUEUE_BACKEND", tt.backend)
+		t.Setenv("SCHEDULE_STORE", tt.store)
+		if got := LoadConfig().ScheduleStore; got != tt.want {
+			t.Errorf("LoadConfig() with QUEUE_BACKEND=%s SCHEDULE_STORE=%s: ScheduleStore = %q, want %q", tt.backend, tt.store, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// queueNamePattern restricts queue names to what is safe in Redis keys,
+// directory names and metric labels.
+var queueNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// QueueConfig configures a named queue and the workers serving it.
+type QueueConfig struct {
+	Name          string
+	Workers       int
+	MaxRetries    int
+	RetryPolicies *domain.RetryPolicies
+}
+
+// parseQueues parses named queues such as
+// "transactional:workers=5,base=2s;marketing:workers=1,max_retries=1,max_age=2h".
+// Every queue starts from def; workers and max_retries replace its worker
+// count and retry limit, and the other fields change its default retry
+// policy, layered on retryPolicySpec, before the retry policy rules apply.
+// The default queue always exists and comes first; listing it changes it.
+func parseQueues(spec string, def QueueConfig, retryPolicySpec, retryPolicyRules string, baseRetryPolicy domain.RetryPolicy) ([]QueueConfig, error) {
+	queues := []QueueConfig{def}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, fields, _ := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !queueNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid queue name %q: use up to 64 lowercase letters, digits, '_' or '-'", name)
+		}
+
+		queue := def
+		queue.Name = name
+		var policySpec []string
+		for _, pair := range strings.Split(fields, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			field, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return nil, fmt.Errorf("expected field=value in queue %s, got %q", name, pair)
+			}
+			field, value = strings.TrimSpace(field), strings.TrimSpace(value)
+			switch field {
+			case "workers":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("invalid workers %q for queue %s", value, name)
+				}
+				queue.Workers = n
+			case "max_retries":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid max_retries %q for queue %s", value, name)
+				}
+				queue.MaxRetries = n
+			default:
+				policySpec = append(policySpec, field+"="+value)
+			}
+		}
+		if len(policySpec) > 0 {
+			policies, err := parseRetryPolicies(retryPolicySpec+","+strings.Join(policySpec, ","), retryPolicyRules, baseRetryPolicy)
+			if err != nil {
+				return nil, fmt.Errorf("queue %s: %w", name, err)
+			}
+			queue.RetryPolicies = policies
+		}
+
+		if name == domain.DefaultQueue {
+			queues[0] = queue
+			continue
+		}
+		for _, q := range queues {
+			if q.Name == name {
+				return nil, fmt.Errorf("queue %s is listed twice", name)
+			}
+		}
+		queues = append(queues, queue)
+	}
+	return queues, nil
+}
+
+// checkQueuesSupported reports an error if backend cannot serve queues. The
+// postgres and nats backends only have the default queue.
+func checkQueuesSupported(backend string, queues []QueueConfig) error {
+	if backend != QueueBackendPostgres && backend != QueueBackendNATS {
+		return nil
+	}
+	var named []string
+	for _, queue := range queues {
+		if queue.Name != domain.DefaultQueue {
+			named = append(named, queue.Name)
+		}
+	}
+	if len(named) > 0 {
+		return fmt.Errorf("the %s backend only supports the %s queue, remove %s from QUEUES or use another QUEUE_BACKEND",
+			backend, domain.DefaultQueue, strings.Join(named, ", "))
+	}
+	return nil
+}
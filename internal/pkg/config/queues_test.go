@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestParseQueues(t *testing.T) {
+	base := domain.RetryPolicy{Base: 5 * time.Second, Factor: 2, Jitter: domain.JitterNone}
+	def := QueueConfig{Name: domain.DefaultQueue, Workers: 3, MaxRetries: 4}
+
+	queues, err := parseQueues("transactional:workers=5,base=2s; marketing:max_retries=1 ;default:workers=2", def, "", "", base)
+	if err != nil {
+		t.Fatalf("parseQueues() error = %v", err)
+	}
+	if len(queues) != 3 {
+		t.Fatalf("parseQueues() = %d queues, want 3", len(queues))
+	}
+	if q := queues[0]; q.Name != domain.DefaultQueue || q.Workers != 2 || q.MaxRetries != 4 {
+		t.Errorf("default queue = %+v, want its workers changed", q)
+	}
+	if q := queues[1]; q.Name != "transactional" || q.Workers != 5 || q.RetryPolicies.For("", domain.FailureTransient).Base != 2*time.Second {
+		t.Errorf("transactional queue = %+v, want 5 workers and a 2s base", q)
+	}
+	if q := queues[2]; q.Name != "marketing" || q.Workers != 3 || q.MaxRetries != 1 {
+		t.Errorf("marketing queue = %+v, want the default workers and 1 retry", q)
+	}
+
+	for _, spec := range []string{"Bad Name:workers=1", "a:workers=0", "a:max_retries=-1", "a:workers", "a;a", "a:base=soon"} {
+		if _, err := parseQueues(spec, def, "", "", base); err == nil {
+			t.Errorf("parseQueues(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestCheckQueuesSupported(t *testing.T) {
+	def := QueueConfig{Name: domain.DefaultQueue}
+	named := []QueueConfig{def, {Name: "marketing"}}
+
+	tests := []struct {
+		backend string
+		queues  []QueueConfig
+		wantErr bool
+	}{
+		{QueueBackendRedis, named, false},
+		{QueueBackendMemory, named, false},
+		{QueueBackendPostgres, []QueueConfig{def}, false},
+		{QueueBackendNATS, []QueueConfig{def}, false},
+		{QueueBackendPostgres, named, true},
+		{QueueBackendNATS, named, true},
+	}
+	for _, tt := range tests {
+		err := checkQueuesSupported(tt.backend, tt.queues)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkQueuesSupported(%s, %d queues) error = %v, wantErr %v", tt.backend, len(tt.queues), err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "marketing") {
+			t.Errorf("checkQueuesSupported() error = %v, want it to name the queue", err)
+		}
+	}
+}
+
+// TestLoadConfigRejectsNamedQueuesOnPostgres runs LoadConfig in a child
+// process, since it exits instead of returning an error.
+func TestLoadConfigRejectsNamedQueuesOnPostgres(t *testing.T) {
+	if os.Getenv("CONFIG_TEST_LOAD") == "1" {
+		LoadConfig()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoadConfigRejectsNamedQueuesOnPostgres$")
+	cmd.Env = append(os.Environ(), "CONFIG_TEST_LOAD=1", "QUEUE_BACKEND=postgres", "QUEUES=marketing:workers=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("LoadConfig() with named queues on postgres did not exit")
+	}
+	if !strings.Contains(string(out), "marketing") {
+		t.Errorf("LoadConfig() output = %s, want an error naming the queue", out)
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// defaultRetryPolicyRules back off further from throttling providers and
+// wait out greylisting, which rarely clears within a few seconds.
+const defaultRetryPolicyRules = "rate_limited:base=30s;greylisted:base=5m,factor=1,jitter=none"
+
+// parseRetryPolicies builds the retry policies from a default policy spec
+// such as "base=5s,factor=2,cap=1h,jitter=full,max_age=24h", layered on
+// base, and rules such as "rate_limited:base=30s;marketing/*:max_age=2h".
+// A rule applies to a failure class, to every class of a job type
+// ("type/*") or to one class of a job type ("type/class"). Rules only set
+// the fields they list; the rest comes from the less specific rules, in the
+// order class, job type, job type and class.
+func parseRetryPolicies(defaultSpec, rules string, base domain.RetryPolicy) (*domain.RetryPolicies, error) {
+	def, err := applyRetryPolicy(base, defaultSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	classSpecs := make(map[domain.FailureClass]string)
+	typeSpecs := make(map[string]string)
+	typeClassSpecs := make(map[string]map[domain.FailureClass]string)
+	for _, rule := range strings.Split(rules, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		selector, spec, ok := strings.Cut(rule, ":")
+		if !ok {
+			return nil, fmt.Errorf("expected selector:policy, got %q", rule)
+		}
+		jobType, className, hasType := strings.Cut(strings.TrimSpace(selector), "/")
+		if !hasType {
+			jobType, className = "", jobType
+		}
+		if hasType && jobType == "" {
+			return nil, fmt.Errorf("empty job type in %q", selector)
+		}
+		if hasType && className == "*" {
+			typeSpecs[jobType] = spec
+			continue
+		}
+		class := domain.FailureClass(className)
+		if !isFailureClass(class) {
+			return nil, fmt.Errorf("unknown failure class %q", className)
+		}
+		if hasType {
+			if typeClassSpecs[jobType] == nil {
+				typeClassSpecs[jobType] = make(map[domain.FailureClass]string)
+			}
+			typeClassSpecs[jobType][class] = spec
+		} else {
+			classSpecs[class] = spec
+		}
+	}
+
+	policies := &domain.RetryPolicies{
+		Default: def,
+		Classes: make(map[domain.FailureClass]domain.RetryPolicy),
+		Types:   make(map[string]map[domain.FailureClass]domain.RetryPolicy),
+	}
+	for _, class := range domain.FailureClasses {
+		if policies.Classes[class], err = applyRetryPolicy(def, classSpecs[class]); err != nil {
+			return nil, err
+		}
+	}
+	for jobType := range typeSpecs {
+		if _, ok := typeClassSpecs[jobType]; !ok {
+			typeClassSpecs[jobType] = nil // Every class of the type gets the type's policy
+		}
+	}
+	for jobType, specs := range typeClassSpecs {
+		policies.Types[jobType] = make(map[domain.FailureClass]domain.RetryPolicy)
+		for _, class := range domain.FailureClasses {
+			policy, err := applyRetryPolicy(policies.Classes[class], typeSpecs[jobType])
+			if err != nil {
+				return nil, err
+			}
+			if policy, err = applyRetryPolicy(policy, specs[class]); err != nil {
+				return nil, err
+			}
+			policies.Types[jobType][class] = policy
+		}
+	}
+	return policies, nil
+}
+
+// applyRetryPolicy returns policy with the fields listed in spec replaced.
+func applyRetryPolicy(policy domain.RetryPolicy, spec string) (domain.RetryPolicy, error) {
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return policy, fmt.Errorf("expected field=value, got %q", pair)
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.TrimSpace(name) {
+		case "base":
+			policy.Base, err = parsePolicyDuration(value)
+		case "cap":
+			policy.Cap, err = parsePolicyDuration(value)
+		case "max_age":
+			policy.MaxAge, err = parsePolicyDuration(value)
+		case "factor":
+			policy.Factor, err = strconv.ParseFloat(value, 64)
+			if err == nil && policy.Factor < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "jitter":
+			policy.Jitter = domain.Jitter(value)
+			switch policy.Jitter {
+			case domain.JitterNone, domain.JitterFull, domain.JitterDecorrelated:
+			default:
+				err = fmt.Errorf("must be none, full or decorrelated")
+			}
+		default:
+			return policy, fmt.Errorf("unknown retry policy field %q", name)
+		}
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s %q: %w", strings.TrimSpace(name), value, err)
+		}
+	}
+	return policy, nil
+}
+
+func parsePolicyDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	return d, err
+}
+
+func isFailureClass(class domain.FailureClass) bool {
+	for _, c := range domain.FailureClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
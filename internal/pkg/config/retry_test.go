@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestParseRetryPoliciesLayersRules(t *testing.T) {
+	base := domain.RetryPolicy{Base: 5 * time.Second, Factor: 2, Jitter: domain.JitterFull}
+	p, err := parseRetryPolicies("cap=1h,max_age=24h", "rate_limited:base=30s; marketing/*:max_age=2h; marketing/greylisted:base=5m,jitter=none", base)
+	if err != nil {
+		t.Fatalf("parseRetryPolicies() error = %v", err)
+	}
+
+	def := domain.RetryPolicy{Base: 5 * time.Second, Factor: 2, Cap: time.Hour, Jitter: domain.JitterFull, MaxAge: 24 * time.Hour}
+	tests := []struct {
+		jobType string
+		class   domain.FailureClass
+		want    domain.RetryPolicy
+	}{
+		{"", domain.FailureTransient, def},
+		{"", domain.FailureRateLimited, withBase(def, 30*time.Second)},
+		{"marketing", domain.FailureTransient, withMaxAge(def, 2*time.Hour)},
+		{"marketing", domain.FailureRateLimited, withMaxAge(withBase(def, 30*time.Second), 2*time.Hour)},
+		{"marketing", domain.FailureGreylisted, domain.RetryPolicy{Base: 5 * time.Minute, Factor: 2, Cap: time.Hour, Jitter: domain.JitterNone, MaxAge: 2 * time.Hour}},
+	}
+	for _, tt := range tests {
+		if got := p.For(tt.jobType, tt.class); got != tt.want {
+			t.Errorf("For(%q, %s) = %+v, want %+v", tt.jobType, tt.class, got, tt.want)
+		}
+	}
+}
+
+func withBase(p domain.RetryPolicy, base time.Duration) domain.RetryPolicy {
+	p.Base = base
+	return p
+}
+
+func withMaxAge(p domain.RetryPolicy, maxAge time.Duration) domain.RetryPolicy {
+	p.MaxAge = maxAge
+	return p
+}
+
+func TestParseRetryPoliciesDefaultRules(t *testing.T) {
+	p, err := parseRetryPolicies("", defaultRetryPolicyRules, domain.RetryPolicy{Base: 5 * time.Second, Factor: 2, Jitter: domain.JitterFull})
+	if err != nil {
+		t.Fatalf("parseRetryPolicies() error = %v", err)
+	}
+	if got := p.For("", domain.FailureGreylisted); got.Base != 5*time.Minute || got.Factor != 1 || got.Jitter != domain.JitterNone {
+		t.Errorf("greylisted policy = %+v, want a flat 5m", got)
+	}
+}
+
+func TestParseRetryPoliciesRejectsInvalidSpecs(t *testing.T) {
+	tests := []struct{ spec, rules string }{
+		{spec: "base"},
+		{spec: "base=soon"},
+		{spec: "base=-1s"},
+		{spec: "factor=0.5"},
+		{spec: "jitter=some"},
+		{spec: "retries=3"},
+		{rules: "rate_limited"},
+		{rules: "bounced:base=1s"},
+		{rules: "/transient:base=1s"},
+		{rules: "marketing/bounced:base=1s"},
+		{rules: "marketing/*:base=x"},
+	}
+	for _, tt := range tests {
+		if _, err := parseRetryPolicies(tt.spec, tt.rules, domain.RetryPolicy{}); err == nil {
+			t.Errorf("parseRetryPolicies(%q, %q) succeeded, want an error", tt.spec, tt.rules)
+		}
+	}
+}
@@ -19,6 +19,7 @@ type InMemoryDLQ struct {
 type dlqEntry struct {
 	Job       domain.EmailJob
 	Reason    string
+	Error     *domain.DeliveryError // Failure that ended the delivery, if any
 	Timestamp time.Time
 }
 
@@ -31,13 +32,14 @@ func NewInMemoryDLQ(l *logger.Logger) ports.DeadLetterQueue {
 }
 
 // Store adds a failed job to the DLQ.
-func (d *InMemoryDLQ) Store(job domain.EmailJob, reason string) {
+func (d *InMemoryDLQ) Store(job domain.EmailJob, reason string, deliveryErr *domain.DeliveryError) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	entry := dlqEntry{
 		Job:       job,
 		Reason:    reason,
+		Error:     deliveryErr,
 		Timestamp: time.Now(),
 	}
 	d.failedJobs = append(d.failedJobs, entry)
@@ -55,6 +57,10 @@ func (d *InMemoryDLQ) logDLQContents() {
 		for i, entry := range d.failedJobs {
 			d.logger.Printf("  %d. To: %s, Subject: %s, Retries: %d, Reason: %s, Time: %s",
 				i+1, entry.Job.To, entry.Job.Subject, entry.Job.Retries, entry.Reason, entry.Timestamp.Format(time.RFC3339))
+			if entry.Error != nil {
+				d.logger.Printf("     Error: class=%s code=%d enhanced=%s provider=%s provider_code=%s message=%q",
+					entry.Error.Class, entry.Error.Code, entry.Error.EnhancedCode, entry.Error.Provider, entry.Error.ProviderCode, entry.Error.Message)
+			}
 		}
 		d.logger.Println("--------------------------")
 	}
@@ -0,0 +1,26 @@
+package dlq
+
+import (
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/pkg/logger"
+)
+
+func TestInMemoryDLQKeepsDeliveryError(t *testing.T) {
+	d := NewInMemoryDLQ(logger.NewLogger()).(*InMemoryDLQ)
+
+	bounce := domain.NewSMTPError(550, "5.1.1", "no such user")
+	d.Store(domain.EmailJob{ID: "1", To: "a@example.com"}, "Permanent failure", bounce)
+	d.Store(domain.EmailJob{ID: "2", To: "b@example.com"}, "Failed to render email", nil)
+
+	if len(d.failedJobs) != 2 {
+		t.Fatalf("DLQ holds %d jobs, want 2", len(d.failedJobs))
+	}
+	if got := d.failedJobs[0]; got.Job.ID != "1" || got.Error != bounce || got.Reason != "Permanent failure" {
+		t.Errorf("first entry = %+v, want job 1 with its delivery error", got)
+	}
+	if got := d.failedJobs[1]; got.Error != nil {
+		t.Errorf("second entry error = %v, want none", got.Error)
+	}
+}
@@ -7,47 +7,125 @@ import (
 
 var (
 	// EmailJobsEnqueuedTotal counts the total number of email jobs enqueued.
-	EmailJobsEnqueuedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	EmailJobsEnqueuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "email_jobs_enqueued_total",
 		Help: "Total number of email jobs enqueued.",
-	})
+	}, []string{"queue"})
 
 	// EmailJobsProcessedTotal counts the total number of email jobs successfully processed.
-	EmailJobsProcessedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	EmailJobsProcessedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "email_jobs_processed_total",
 		Help: "Total number of email jobs successfully processed.",
-	})
+	}, []string{"queue"})
 
 	// EmailJobsFailedTotal counts the total number of email jobs that failed (including retries).
-	EmailJobsFailedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	EmailJobsFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "email_jobs_failed_total",
 		Help: "Total number of email jobs that failed (including retries).",
-	})
+	}, []string{"queue"})
 
 	// EmailJobsRetriedTotal counts the total number of email jobs that were retried.
-	EmailJobsRetriedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	EmailJobsRetriedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "email_jobs_retried_total",
 		Help: "Total number of email jobs that were retried.",
-	})
+	}, []string{"queue"})
 
 	// EmailJobsDLQTotal counts the total number of email jobs moved to the Dead Letter Queue.
-	EmailJobsDLQTotal = promauto.NewCounter(prometheus.CounterOpts{
+	EmailJobsDLQTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "email_jobs_dlq_total",
 		Help: "Total number of email jobs moved to the Dead Letter Queue.",
-	})
+	}, []string{"queue"})
 
-	// EmailQueueLength gauges the current number of jobs in the queue.
-	EmailQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+	// EmailJobsCancelledTotal counts the total number of email jobs cancelled before they were sent.
+	EmailJobsCancelledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_jobs_cancelled_total",
+		Help: "Total number of email jobs cancelled before they were sent.",
+	}, []string{"queue"})
+
+	// EmailJobsExpiredTotal counts the total number of email jobs moved to the Dead Letter Queue because they expired.
+	EmailJobsExpiredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_jobs_expired_total",
+		Help: "Total number of email jobs moved to the Dead Letter Queue because they expired.",
+	}, []string{"queue"})
+
+	// EmailDigestJobsBufferedTotal counts the total number of email jobs buffered for a digest.
+	EmailDigestJobsBufferedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_digest_jobs_buffered_total",
+		Help: "Total number of email jobs buffered for a digest.",
+	}, []string{"queue"})
+
+	// EmailDigestsTotal counts the total number of digests the buffered jobs were merged into.
+	EmailDigestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_digests_total",
+		Help: "Total number of digests buffered email jobs were merged into.",
+	}, []string{"queue"})
+
+	// EmailQueueLength gauges the current number of jobs in each queue.
+	EmailQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "email_queue_length",
-		Help: "Current number of jobs in the email queue.",
+		Help: "Current number of jobs in each email queue.",
+	}, []string{"queue"})
+
+	// EmailQueueLaneLength gauges the current number of jobs in each priority lane of each queue.
+	EmailQueueLaneLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "email_queue_lane_length",
+		Help: "Current number of jobs in each priority lane of each email queue.",
+	}, []string{"queue", "lane"})
+
+	// EmailScheduledJobs gauges the current number of jobs waiting for their send_at time or retry delay.
+	EmailScheduledJobs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "email_scheduled_jobs",
+		Help: "Current number of jobs waiting for their send_at time or retry delay.",
+	}, []string{"queue"})
+
+	// EmailRetriesPending gauges the current number of failed jobs waiting for their retry delay.
+	EmailRetriesPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "email_retries_pending",
+		Help: "Current number of failed jobs waiting for their retry delay.",
+	}, []string{"queue"})
+
+	// EmailSequenceEnrollmentsTotal counts the total number of recipients enrolled in each sequence.
+	EmailSequenceEnrollmentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_sequence_enrollments_total",
+		Help: "Total number of recipients enrolled in each sequence.",
+	}, []string{"sequence"})
+
+	// EmailSequenceEnrollmentsFinishedTotal counts the total number of enrollments that completed, exited or failed.
+	EmailSequenceEnrollmentsFinishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_sequence_enrollments_finished_total",
+		Help: "Total number of sequence enrollments that completed, exited or failed.",
+	}, []string{"sequence", "state"})
+
+	// EmailScheduleRunsTotal counts the total number of runs each recurring schedule fired.
+	EmailScheduleRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_schedule_runs_total",
+		Help: "Total number of runs each recurring schedule fired.",
+	}, []string{"schedule"})
+
+	// EmailScheduleRecipientsFailedTotal counts the total number of recipients of runs of each recurring schedule whose job could not be enqueued.
+	EmailScheduleRecipientsFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_schedule_recipients_failed_total",
+		Help: "Total number of recipients of runs of each recurring schedule whose job could not be enqueued.",
+	}, []string{"schedule"})
+
+	// EmailScheduleRunsMissedTotal counts the total number of runs of each recurring schedule that were missed and not caught up.
+	EmailScheduleRunsMissedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "email_schedule_runs_missed_total",
+		Help: "Total number of runs of each recurring schedule that were missed and not caught up.",
+	}, []string{"schedule"})
+
+	// EmailScheduleLeader reports whether this instance fires the recurring schedules.
+	EmailScheduleLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "email_schedule_leader",
+		Help: "1 if this instance is the leader firing the recurring schedules, 0 otherwise.",
 	})
 
 	// EmailProcessingDuration measures the duration of email processing.
-	EmailProcessingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	EmailProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "email_processing_duration_seconds",
 		Help:    "Duration of email processing in seconds.",
 		Buckets: prometheus.DefBuckets, // Default buckets: .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10
-	})
+	}, []string{"queue"})
 )
 
 // InitMetrics registers the metrics. This function is called once at startup.
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// QueueDepth tracks the number of queued jobs per priority lane and keeps a
+// total gauge in line with the sum of the lanes.
+type QueueDepth struct {
+	mu    sync.Mutex
+	total prometheus.Gauge
+	lanes *prometheus.GaugeVec
+	depth map[domain.Priority]float64
+}
+
+// NewQueueDepth creates a QueueDepth reporting to the given gauges, with
+// every lane starting at zero.
+func NewQueueDepth(total prometheus.Gauge, lanes *prometheus.GaugeVec) *QueueDepth {
+	d := &QueueDepth{
+		total: total,
+		lanes: lanes,
+		depth: make(map[domain.Priority]float64),
+	}
+	for _, lane := range domain.Priorities {
+		d.Set(lane, 0)
+	}
+	return d
+}
+
+// Inc increments the depth of a lane by one.
+func (d *QueueDepth) Inc(lane domain.Priority) { d.Add(lane, 1) }
+
+// Dec decrements the depth of a lane by one.
+func (d *QueueDepth) Dec(lane domain.Priority) { d.Add(lane, -1) }
+
+// Add adds delta to the depth of a lane.
+func (d *QueueDepth) Add(lane domain.Priority, delta float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setLocked(lane, d.depth[lane]+delta)
+}
+
+// Set sets the depth of a lane, e.g. after counting it in the backend.
+func (d *QueueDepth) Set(lane domain.Priority, value float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setLocked(lane, value)
+}
+
+func (d *QueueDepth) setLocked(lane domain.Priority, value float64) {
+	d.depth[lane] = value
+	d.lanes.WithLabelValues(string(lane)).Set(value)
+
+	var total float64
+	for _, v := range d.depth {
+		total += v
+	}
+	d.total.Set(total)
+}
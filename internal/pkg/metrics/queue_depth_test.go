@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestQueueDepthKeepsTotalInLineWithLanes(t *testing.T) {
+	total := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue_depth"})
+	lanes := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_lane_depth"}, []string{"lane"})
+	d := NewQueueDepth(total, lanes)
+
+	for _, lane := range domain.Priorities {
+		if got := testutil.ToFloat64(lanes.WithLabelValues(string(lane))); got != 0 {
+			t.Errorf("lane %s starts at %v, want 0", lane, got)
+		}
+	}
+
+	d.Inc(domain.PriorityHigh)
+	d.Inc(domain.PriorityHigh)
+	d.Add(domain.PriorityBulk, 5)
+	d.Dec(domain.PriorityHigh)
+	d.Set(domain.PriorityNormal, 3)
+
+	want := map[domain.Priority]float64{domain.PriorityHigh: 1, domain.PriorityNormal: 3, domain.PriorityBulk: 5}
+	for lane, v := range want {
+		if got := testutil.ToFloat64(lanes.WithLabelValues(string(lane))); got != v {
+			t.Errorf("lane %s = %v, want %v", lane, got, v)
+		}
+	}
+	if got := testutil.ToFloat64(total); got != 9 {
+		t.Errorf("total = %v, want 9", got)
+	}
+
+	d.Set(domain.PriorityBulk, 0)
+	if got := testutil.ToFloat64(total); got != 4 {
+		t.Errorf("total after resetting a lane = %v, want 4", got)
+	}
+}
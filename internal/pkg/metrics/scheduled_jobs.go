@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"email-queue-service/internal/core/domain"
+)
+
+// ScheduledJobs tracks the jobs held by a scheduler. Jobs that already failed
+// at least once are waiting for a retry and are also counted on their own.
+type ScheduledJobs struct {
+	total   prometheus.Gauge
+	retries prometheus.Gauge
+}
+
+// NewScheduledJobs creates a ScheduledJobs reporting to the given gauges.
+func NewScheduledJobs(total, retries prometheus.Gauge) *ScheduledJobs {
+	return &ScheduledJobs{total: total, retries: retries}
+}
+
+// Inc counts a job that was scheduled.
+func (s *ScheduledJobs) Inc(job domain.EmailJob) {
+	s.total.Inc()
+	if job.Retries > 0 {
+		s.retries.Inc()
+	}
+}
+
+// Dec counts a job that left the scheduler.
+func (s *ScheduledJobs) Dec(job domain.EmailJob) {
+	s.total.Dec()
+	if job.Retries > 0 {
+		s.retries.Dec()
+	}
+}
+
+// Set sets both gauges, e.g. after counting the jobs in the backend.
+func (s *ScheduledJobs) Set(total, retries float64) {
+	s.total.Set(total)
+	s.retries.Set(retries)
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestScheduledJobsCountsRetriesSeparately(t *testing.T) {
+	total := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_jobs"})
+	retries := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_scheduled_retries"})
+	s := NewScheduledJobs(total, retries)
+
+	s.Inc(domain.EmailJob{})
+	s.Inc(domain.EmailJob{Retries: 1})
+	s.Inc(domain.EmailJob{Retries: 3})
+	s.Dec(domain.EmailJob{Retries: 1})
+	if got, gotRetries := testutil.ToFloat64(total), testutil.ToFloat64(retries); got != 2 || gotRetries != 1 {
+		t.Errorf("gauges = %v total, %v retries; want 2 and 1", got, gotRetries)
+	}
+
+	s.Set(10, 4)
+	if got, gotRetries := testutil.ToFloat64(total), testutil.ToFloat64(retries); got != 10 || gotRetries != 4 {
+		t.Errorf("gauges after Set = %v total, %v retries; want 10 and 4", got, gotRetries)
+	}
+}
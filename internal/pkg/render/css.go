@@ -0,0 +1,339 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// cssDecl is a single "property: value" pair.
+type cssDecl struct {
+	property  string
+	value     string
+	important bool
+}
+
+// cssRule is a style rule with a single selector.
+type cssRule struct {
+	selector string
+	decls    []cssDecl
+}
+
+// appliedDecl is a declaration matched against an element, with everything
+// needed to resolve the cascade.
+type appliedDecl struct {
+	cssDecl
+	inline      bool
+	specificity cascadia.Specificity
+	order       int
+}
+
+// InlineCSS moves the rules of every <style> block into the style attributes
+// of the elements they match. Rules that cannot be expressed inline (media
+// queries, @font-face, :hover and friends) are left in their <style> block;
+// blocks with nothing left are removed.
+func InlineCSS(document string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	var styleNodes []*html.Node
+	var rules []cssRule
+	walk(doc, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "style" {
+			styleNodes = append(styleNodes, n)
+		}
+	})
+
+	for _, node := range styleNodes {
+		if getAttr(node, "data-inline") == "false" {
+			continue
+		}
+		inlinable, leftover := parseStylesheet(textContent(node))
+		rules = append(rules, inlinable...)
+		if leftover == "" {
+			node.Parent.RemoveChild(node)
+			continue
+		}
+		for c := node.FirstChild; c != nil; c = node.FirstChild {
+			node.RemoveChild(c)
+		}
+		node.AppendChild(&html.Node{Type: html.TextNode, Data: leftover})
+	}
+
+	applied := make(map[*html.Node][]appliedDecl)
+	for order, rule := range rules {
+		sel, err := cascadia.Parse(rule.selector)
+		if err != nil {
+			continue
+		}
+		spec := sel.Specificity()
+		for _, n := range cascadia.QueryAll(doc, sel) {
+			for _, d := range rule.decls {
+				applied[n] = append(applied[n], appliedDecl{cssDecl: d, specificity: spec, order: order})
+			}
+		}
+	}
+
+	for n, decls := range applied {
+		for _, d := range parseDeclarations(getAttr(n, "style")) {
+			decls = append(decls, appliedDecl{cssDecl: d, inline: true, order: len(rules)})
+		}
+		setAttr(n, "style", resolveCascade(decls))
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", fmt.Errorf("failed to render HTML: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// resolveCascade picks the winning value for every property and serializes
+// the result as a style attribute, keeping properties in first-seen order.
+func resolveCascade(decls []appliedDecl) string {
+	sort.SliceStable(decls, func(i, j int) bool {
+		a, b := decls[i], decls[j]
+		if a.important != b.important {
+			return !a.important
+		}
+		if a.inline != b.inline {
+			return !a.inline
+		}
+		if a.specificity != b.specificity {
+			return a.specificity.Less(b.specificity)
+		}
+		return a.order < b.order
+	})
+
+	var properties []string
+	winners := make(map[string]cssDecl)
+	for _, d := range decls {
+		if _, seen := winners[d.property]; !seen {
+			properties = append(properties, d.property)
+		}
+		winners[d.property] = d.cssDecl
+	}
+
+	parts := make([]string, 0, len(properties))
+	for _, p := range properties {
+		d := winners[p]
+		value := d.value
+		if d.important {
+			value += " !important"
+		}
+		parts = append(parts, p+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// parseStylesheet splits a stylesheet into rules that can be inlined and the
+// CSS text that has to stay in a <style> block.
+func parseStylesheet(css string) ([]cssRule, string) {
+	css = stripComments(css)
+	var rules []cssRule
+	var leftover strings.Builder
+
+	for i := 0; i < len(css); {
+		for i < len(css) && isSpace(css[i]) {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+
+		if css[i] == '@' {
+			end := atRuleEnd(css, i)
+			leftover.WriteString(strings.TrimSpace(css[i:end]))
+			leftover.WriteString("\n")
+			i = end
+			continue
+		}
+
+		open := strings.IndexByte(css[i:], '{')
+		if open < 0 {
+			break
+		}
+		open += i
+		closing := strings.IndexByte(css[open:], '}')
+		if closing < 0 {
+			closing = len(css)
+		} else {
+			closing += open
+		}
+		selectors := css[i:open]
+		body := css[open+1 : min(closing, len(css))]
+		i = closing + 1
+
+		decls := parseDeclarations(body)
+		if len(decls) == 0 {
+			continue
+		}
+		var kept []string
+		for _, sel := range strings.Split(selectors, ",") {
+			sel = strings.TrimSpace(sel)
+			if sel == "" {
+				continue
+			}
+			if !isInlinable(sel) {
+				kept = append(kept, sel)
+				continue
+			}
+			rules = append(rules, cssRule{selector: sel, decls: decls})
+		}
+		if len(kept) > 0 {
+			leftover.WriteString(strings.Join(kept, ", "))
+			leftover.WriteString(" {")
+			leftover.WriteString(strings.TrimSpace(body))
+			leftover.WriteString("}\n")
+		}
+	}
+	return rules, strings.TrimSpace(leftover.String())
+}
+
+// isInlinable reports whether a selector only depends on document structure,
+// so that its declarations mean the same thing once moved into an attribute.
+func isInlinable(selector string) bool {
+	for _, dynamic := range []string{":hover", ":active", ":focus", ":visited", ":target", "::", ":before", ":after"} {
+		if strings.Contains(selector, dynamic) {
+			return false
+		}
+	}
+	_, err := cascadia.Parse(selector)
+	return err == nil
+}
+
+// parseDeclarations parses the body of a rule or a style attribute.
+func parseDeclarations(body string) []cssDecl {
+	var decls []cssDecl
+	for _, part := range splitOutside(body, ';') {
+		colon := strings.IndexByte(part, ':')
+		if colon < 0 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(part[:colon]))
+		value := strings.TrimSpace(part[colon+1:])
+		if property == "" || value == "" {
+			continue
+		}
+		important := false
+		if idx := strings.LastIndex(strings.ToLower(value), "!important"); idx >= 0 {
+			important = true
+			value = strings.TrimSpace(value[:idx])
+		}
+		decls = append(decls, cssDecl{property: property, value: value, important: important})
+	}
+	return decls
+}
+
+// splitOutside splits s on sep, ignoring separators inside quotes or parentheses
+// (e.g. data URIs in url(...)).
+func splitOutside(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// atRuleEnd returns the index just past the at-rule starting at i, which is
+// either terminated by ';' or by its balanced block.
+func atRuleEnd(css string, i int) int {
+	depth := 0
+	for j := i; j < len(css); j++ {
+		switch css[j] {
+		case ';':
+			if depth == 0 {
+				return j + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(css)
+}
+
+func stripComments(css string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			b.WriteString(css)
+			return b.String()
+		}
+		b.WriteString(css[:start])
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return b.String()
+		}
+		css = css[start+2+end+2:]
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// walk calls fn for n and all of its descendants in document order.
+func walk(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+	})
+	return b.String()
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,91 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     []string // Substrings of the result
+		notWant  []string
+	}{
+		{
+			name:     "type selector",
+			document: `<style>p { color: red }</style><p>Hi</p>`,
+			want:     []string{`<p style="color: red">Hi</p>`},
+			notWant:  []string{"<style>"},
+		},
+		{
+			name:     "class beats type regardless of order",
+			document: `<style>.note { color: blue } p { color: red }</style><p class="note">Hi</p>`,
+			want:     []string{`style="color: blue"`},
+		},
+		{
+			name:     "id beats class",
+			document: `<style>#main { color: green } .note { color: blue }</style><p id="main" class="note">Hi</p>`,
+			want:     []string{`style="color: green"`},
+		},
+		{
+			name:     "later rule wins at equal specificity",
+			document: `<style>p { color: red } p { color: blue }</style><p>Hi</p>`,
+			want:     []string{`style="color: blue"`},
+		},
+		{
+			name:     "inline style beats rules",
+			document: `<style>#main { color: green }</style><p id="main" style="color: black">Hi</p>`,
+			want:     []string{`style="color: black"`},
+		},
+		{
+			name:     "important beats inline style",
+			document: `<style>p { color: red !important }</style><p style="color: black">Hi</p>`,
+			want:     []string{`style="color: red !important"`},
+		},
+		{
+			name:     "properties are merged in first-seen order",
+			document: `<style>p { margin: 0 } .note { color: blue }</style><p class="note" style="font-weight: bold">Hi</p>`,
+			want:     []string{`style="margin: 0; color: blue; font-weight: bold"`},
+		},
+		{
+			name:     "descendant selector",
+			document: `<style>td a { color: red }</style><table><tr><td><a href="#">x</a></td></tr></table><a href="#">y</a>`,
+			want:     []string{`<a href="#" style="color: red">x</a>`, `<a href="#">y</a>`},
+		},
+		{
+			name:     "media queries and pseudo classes stay in the style block",
+			document: `<style>a { color: red } a:hover { color: blue } @media (max-width: 600px) { p { margin: 0 } }</style><a href="#">x</a>`,
+			want:     []string{`<a href="#" style="color: red">x</a>`, `a:hover {color: blue}`, `@media (max-width: 600px) { p { margin: 0 } }`},
+		},
+		{
+			name:     "data-inline=false blocks are left alone",
+			document: `<style data-inline="false">p { color: red }</style><p>Hi</p>`,
+			want:     []string{`<style data-inline="false">p { color: red }</style>`, `<p>Hi</p>`},
+		},
+		{
+			name:     "comments are ignored and url() keeps its semicolons",
+			document: `<style>/* p { color: red } */ p { background: url("data:image/png;base64,AAA") }</style><p>Hi</p>`,
+			want:     []string{`style="background: url(&#34;data:image/png;base64,AAA&#34;)"`},
+			notWant:  []string{"color: red"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InlineCSS(tt.document)
+			if err != nil {
+				t.Fatalf("InlineCSS() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("InlineCSS() = %s\nwant it to contain %s", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("InlineCSS() = %s\nwant it not to contain %s", got, notWant)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+// DefaultDigestSubject is the subject template of digests unless configured.
+const DefaultDigestSubject = `{{len .Items}} new notifications`
+
+// DefaultDigestTemplate is the body template of digests unless configured:
+// every item under its subject, with its HTML body, or its text body if it
+// has none.
+const DefaultDigestTemplate = `<html>
+<body>
+{{range .Items}}<h2>{{.Subject}}</h2>
+{{if .HTML}}{{.HTML}}{{else}}<p style="white-space: pre-wrap">{{.Text}}</p>{{end}}
+{{end}}</body>
+</html>
+`
+
+// DigestRenderer merges jobs into a digest email with a subject template
+// (text/template) and a body template (html/template). Both are executed
+// with the digest key as .Key, the recipient as .To and the jobs as .Items,
+// each with its .ID, .Subject, rendered .HTML body and .Text body.
+type DigestRenderer struct {
+	subject *texttemplate.Template
+	body    *htmltemplate.Template
+}
+
+// digestData is what the digest templates are executed with.
+type digestData struct {
+	Key   string
+	To    string
+	Items []digestItem
+}
+
+type digestItem struct {
+	ID      string
+	Subject string
+	HTML    htmltemplate.HTML // Already rendered, so not escaped again
+	Text    string
+}
+
+// NewDigestRenderer parses the digest templates.
+func NewDigestRenderer(subject, body string) (ports.DigestRenderer, error) {
+	subjectTmpl, err := texttemplate.New("digest_subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest subject template: %w", err)
+	}
+	bodyTmpl, err := htmltemplate.New("digest").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest template: %w", err)
+	}
+	return &DigestRenderer{subject: subjectTmpl, body: bodyTmpl}, nil
+}
+
+// RenderDigest executes the digest templates for items.
+func (r *DigestRenderer) RenderDigest(key, to string, items []domain.DigestItem) (string, string, error) {
+	data := digestData{Key: key, To: to, Items: make([]digestItem, len(items))}
+	for i, item := range items {
+		data.Items[i] = digestItem{
+			ID:      item.ID,
+			Subject: item.Subject,
+			HTML:    htmltemplate.HTML(item.Message.HTMLBody),
+			Text:    item.Message.TextBody,
+		}
+	}
+
+	var subject, body bytes.Buffer
+	if err := r.subject.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("failed to render digest subject: %w", err)
+	}
+	if err := r.body.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("failed to render digest: %w", err)
+	}
+	return subject.String(), body.String(), nil
+}
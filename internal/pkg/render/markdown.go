@@ -0,0 +1,30 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+var (
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		// Raw HTML is let through here and cleaned up by the sanitizer below,
+		// so harmless markup such as <br> or <sup> survives.
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+	sanitizer = bluemonday.UGCPolicy()
+)
+
+// MarkdownToHTML renders Markdown (GitHub flavoured) into sanitized HTML.
+func MarkdownToHTML(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", fmt.Errorf("failed to render markdown: %w", err)
+	}
+	return sanitizer.Sanitize(buf.String()), nil
+}
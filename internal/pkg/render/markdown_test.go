@@ -0,0 +1,72 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []string // Substrings of the result
+		notWant []string
+	}{
+		{
+			name:   "headings, emphasis and links",
+			source: "# Hello\n\nSome *emphasis* and a [link](https://example.com).",
+			want:   []string{"<h1>Hello</h1>", "<em>emphasis</em>", `<a href="https://example.com" rel="nofollow">link</a>`},
+		},
+		{
+			name:   "gfm tables and strikethrough",
+			source: "| a | b |\n|---|---|\n| 1 | 2 |\n\n~~old~~",
+			want:   []string{"<table>", "<th>a</th>", "<td>2</td>", "<del>old</del>"},
+		},
+		{
+			name:   "harmless raw html is kept",
+			source: "Line one<br>x<sup>2</sup>",
+			want:   []string{"<br>", "<sup>2</sup>"},
+		},
+		{
+			name:    "script tags are stripped",
+			source:  "Hi <script>alert(1)</script> there",
+			want:    []string{"Hi", "there"},
+			notWant: []string{"<script", "alert(1)"},
+		},
+		{
+			name:    "event handlers are stripped",
+			source:  `<p onclick="steal()">Click</p>`,
+			want:    []string{"<p>Click</p>"},
+			notWant: []string{"onclick", "steal()"},
+		},
+		{
+			name:    "javascript links are stripped",
+			source:  "[click](javascript:alert(1)) <a href=\"javascript:alert(2)\">raw</a>",
+			want:    []string{"click", "raw"},
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "iframes and style attributes are stripped",
+			source:  `<iframe src="https://evil.example"></iframe><span style="position:fixed">x</span>`,
+			notWant: []string{"<iframe", "position:fixed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarkdownToHTML(tt.source)
+			if err != nil {
+				t.Fatalf("MarkdownToHTML() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("MarkdownToHTML() = %s\nwant it to contain %s", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("MarkdownToHTML() = %s\nwant it not to contain %s", got, notWant)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package render
+
+import (
+	"fmt"
+
+	"email-queue-service/internal/core/domain"
+	"email-queue-service/internal/core/ports"
+)
+
+// Renderer post-processes email bodies before they are sent.
+type Renderer struct {
+	inlineCSS    bool
+	generateText bool
+}
+
+// NewRenderer creates a new Renderer with the given service-wide defaults.
+// Jobs can override both steps through their RenderOptions.
+func NewRenderer(inlineCSS, generateText bool) ports.Renderer {
+	return &Renderer{
+		inlineCSS:    inlineCSS,
+		generateText: generateText,
+	}
+}
+
+// Render builds the final message for a job. Markdown bodies are converted
+// to sanitized HTML, HTML bodies get their <style> rules inlined, and a
+// plain-text alternative is generated when none was given.
+func (r *Renderer) Render(job domain.EmailJob) (domain.Message, error) {
+	msg := domain.Message{
+		To:       job.To,
+		Subject:  job.Subject,
+		HTMLBody: job.HTMLBody,
+		TextBody: job.TextBody,
+	}
+
+	// fallbackText is used when no text body was given and none is generated.
+	fallbackText := ""
+	switch job.BodyFormat {
+	case domain.BodyFormatHTML:
+		msg.HTMLBody = job.Body
+	case domain.BodyFormatMarkdown:
+		rendered, err := MarkdownToHTML(job.Body)
+		if err != nil {
+			return domain.Message{}, err
+		}
+		msg.HTMLBody = rendered
+		// The Markdown source is itself readable as plain text.
+		fallbackText = job.Body
+	default:
+		fallbackText = job.Body
+	}
+
+	if msg.HTMLBody == "" {
+		if msg.TextBody == "" {
+			msg.TextBody = fallbackText
+		}
+		return msg, nil
+	}
+
+	inlineCSS, generateText := r.inlineCSS, r.generateText
+	if job.Render != nil {
+		if job.Render.InlineCSS != nil {
+			inlineCSS = *job.Render.InlineCSS
+		}
+		if job.Render.GenerateText != nil {
+			generateText = *job.Render.GenerateText
+		}
+	}
+
+	if inlineCSS {
+		inlined, err := InlineCSS(msg.HTMLBody)
+		if err != nil {
+			return domain.Message{}, fmt.Errorf("failed to inline CSS: %w", err)
+		}
+		msg.HTMLBody = inlined
+	}
+
+	if msg.TextBody == "" {
+		// A plain-text body sent alongside html_body is used as is.
+		if job.BodyFormat != domain.BodyFormatMarkdown && fallbackText != "" {
+			msg.TextBody = fallbackText
+		} else if generateText {
+			text, err := HTMLToText(msg.HTMLBody)
+			if err != nil {
+				return domain.Message{}, fmt.Errorf("failed to generate text body: %w", err)
+			}
+			msg.TextBody = text
+		} else {
+			msg.TextBody = fallbackText
+		}
+	}
+	return msg, nil
+}
+
+// Ensure Renderer implements the ports.Renderer interface
+var _ ports.Renderer = (*Renderer)(nil)
@@ -0,0 +1,101 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"email-queue-service/internal/core/domain"
+)
+
+func TestRendererRender(t *testing.T) {
+	on, off := true, false
+	const page = `<style>p { color: red }</style><p>Hi <a href="https://example.com">there</a></p>`
+
+	tests := []struct {
+		name      string
+		defaults  [2]bool // inlineCSS, generateText
+		job       domain.EmailJob
+		wantHTML  string // Substring of the HTML body; empty for none
+		wantStyle bool   // Whether the HTML keeps its <style> block
+		wantText  string
+	}{
+		{
+			name:     "plain text",
+			defaults: [2]bool{true, true},
+			job:      domain.EmailJob{Body: "Hello"},
+			wantText: "Hello",
+		},
+		{
+			name:     "html body is inlined and gets a text alternative",
+			defaults: [2]bool{true, true},
+			job:      domain.EmailJob{Body: page, BodyFormat: domain.BodyFormatHTML},
+			wantHTML: `<p style="color: red">`,
+			wantText: "Hi there [1]\n\n[1] https://example.com\n",
+		},
+		{
+			name:      "service defaults switch both steps off",
+			defaults:  [2]bool{false, false},
+			job:       domain.EmailJob{Body: page, BodyFormat: domain.BodyFormatHTML},
+			wantHTML:  `<p>Hi`,
+			wantStyle: true,
+			wantText:  "",
+		},
+		{
+			name:      "job overrides the service defaults",
+			defaults:  [2]bool{true, false},
+			job:       domain.EmailJob{Body: page, BodyFormat: domain.BodyFormatHTML, Render: &domain.RenderOptions{InlineCSS: &off, GenerateText: &on}},
+			wantHTML:  `<p>Hi`,
+			wantStyle: true,
+			wantText:  "Hi there [1]\n\n[1] https://example.com\n",
+		},
+		{
+			name:     "markdown is rendered and gets a text alternative",
+			defaults: [2]bool{true, true},
+			job:      domain.EmailJob{Body: "# Hi\n\n*there*", BodyFormat: domain.BodyFormatMarkdown},
+			wantHTML: "<h1>Hi</h1>",
+			wantText: "Hi\n==\n\nthere\n",
+		},
+		{
+			name:     "markdown source is the text alternative when generation is off",
+			defaults: [2]bool{true, false},
+			job:      domain.EmailJob{Body: "# Hi\n\n*there*", BodyFormat: domain.BodyFormatMarkdown},
+			wantHTML: "<em>there</em>",
+			wantText: "# Hi\n\n*there*",
+		},
+		{
+			name:     "markdown is sanitized",
+			defaults: [2]bool{true, true},
+			job:      domain.EmailJob{Body: "Hi <script>alert(1)</script>", BodyFormat: domain.BodyFormatMarkdown},
+			wantHTML: "<p>Hi",
+			wantText: "Hi\n",
+		},
+		{
+			name:     "a given text body is kept",
+			defaults: [2]bool{true, true},
+			job:      domain.EmailJob{HTMLBody: page, TextBody: "Custom"},
+			wantHTML: `<p style="color: red">`,
+			wantText: "Custom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRenderer(tt.defaults[0], tt.defaults[1])
+			msg, err := r.Render(tt.job)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if tt.wantHTML == "" && msg.HTMLBody != "" {
+				t.Errorf("HTMLBody = %q, want none", msg.HTMLBody)
+			}
+			if !strings.Contains(msg.HTMLBody, tt.wantHTML) {
+				t.Errorf("HTMLBody = %q, want it to contain %q", msg.HTMLBody, tt.wantHTML)
+			}
+			if got := strings.Contains(msg.HTMLBody, "<style>"); got != tt.wantStyle {
+				t.Errorf("HTMLBody = %q, <style> kept = %v, want %v", msg.HTMLBody, got, tt.wantStyle)
+			}
+			if msg.TextBody != tt.wantText {
+				t.Errorf("TextBody = %q, want %q", msg.TextBody, tt.wantText)
+			}
+		})
+	}
+}
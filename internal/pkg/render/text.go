@@ -0,0 +1,382 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLToText converts an HTML document into a readable plain-text alternative.
+// Links are turned into numbered footnotes, lists keep their bullets and
+// numbering, and data tables are laid out as aligned columns.
+func HTMLToText(document string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	c := &textConverter{}
+	text := c.render(doc)
+
+	if len(c.links) > 0 {
+		var b strings.Builder
+		b.WriteString(text)
+		b.WriteString("\n\n")
+		for i, link := range c.links {
+			fmt.Fprintf(&b, "[%d] %s\n", i+1, link)
+		}
+		text = b.String()
+	}
+	return tidyLines(text), nil
+}
+
+// textConverter holds state shared across a whole document conversion.
+type textConverter struct {
+	links     []string
+	listDepth int
+}
+
+// render converts the children of n into text.
+func (c *textConverter) render(n *html.Node) string {
+	b := &textBuilder{}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(b, child)
+	}
+	return b.String()
+}
+
+func (c *textConverter) node(b *textBuilder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			c.node(b, child)
+		}
+		return
+	}
+
+	switch n.Data {
+	case "head", "style", "script", "title", "noscript":
+	case "br":
+		b.lineBreak()
+	case "hr":
+		b.blockBreak(2)
+		b.raw(strings.Repeat("-", 40))
+		b.blockBreak(2)
+	case "h1", "h2":
+		underline := "="
+		if n.Data == "h2" {
+			underline = "-"
+		}
+		heading := collapseSpace(c.render(n))
+		b.blockBreak(2)
+		b.raw(heading + "\n" + strings.Repeat(underline, utf8.RuneCountInString(heading)))
+		b.blockBreak(2)
+	case "p", "h3", "h4", "h5", "h6", "address", "figure":
+		b.blockBreak(2)
+		b.raw(strings.TrimSpace(c.render(n)))
+		b.blockBreak(2)
+	case "div", "section", "article", "header", "footer", "main", "nav", "center", "tr":
+		b.blockBreak(1)
+		b.raw(strings.TrimSpace(c.render(n)))
+		b.blockBreak(1)
+	case "a":
+		c.link(b, n)
+	case "img":
+		if alt := strings.TrimSpace(getAttr(n, "alt")); alt != "" {
+			b.text(alt)
+		}
+	case "ul", "ol":
+		c.list(b, n)
+	case "table":
+		c.table(b, n)
+	case "blockquote":
+		b.blockBreak(2)
+		b.raw(prefixLines(strings.TrimSpace(c.render(n)), "> ", "> "))
+		b.blockBreak(2)
+	case "pre":
+		b.blockBreak(2)
+		b.raw(strings.Trim(textContent(n), "\n"))
+		b.blockBreak(2)
+	default:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			c.node(b, child)
+		}
+	}
+}
+
+// link renders an anchor's text followed by a footnote reference.
+func (c *textConverter) link(b *textBuilder, n *html.Node) {
+	label := collapseSpace(c.render(n))
+	href := strings.TrimSpace(getAttr(n, "href"))
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		b.text(label)
+		return
+	}
+	if label == "" || label == href || "mailto:"+label == href {
+		b.text(strings.TrimPrefix(href, "mailto:"))
+		return
+	}
+
+	index := -1
+	for i, existing := range c.links {
+		if existing == href {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		c.links = append(c.links, href)
+		index = len(c.links) - 1
+	}
+	b.text(fmt.Sprintf("%s [%d]", label, index+1))
+}
+
+// list renders ul/ol items with bullets or numbers, indenting nested content.
+func (c *textConverter) list(b *textBuilder, n *html.Node) {
+	gap := 2
+	if c.listDepth > 0 {
+		gap = 1
+	}
+	c.listDepth++
+	defer func() { c.listDepth-- }()
+
+	b.blockBreak(gap)
+	number := 1
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.Data != "li" {
+			continue
+		}
+		marker := "* "
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		item := strings.TrimSpace(c.render(child))
+		b.blockBreak(1)
+		b.raw(prefixLines(item, marker, strings.Repeat(" ", len(marker))))
+	}
+	b.blockBreak(gap)
+}
+
+// table renders a data table as aligned columns. Layout tables, whose cells
+// contain block content, are flattened cell by cell instead.
+func (c *textConverter) table(b *textBuilder, n *html.Node) {
+	rows := tableRows(n)
+	if isLayoutTable(n, rows) {
+		for _, row := range rows {
+			for _, cell := range row {
+				b.blockBreak(1)
+				b.raw(strings.TrimSpace(c.render(cell)))
+				b.blockBreak(1)
+			}
+		}
+		return
+	}
+
+	var cells [][]string
+	var widths []int
+	headerRow := len(rows) > 0
+	for r, row := range rows {
+		var line []string
+		for i, cell := range row {
+			if r == 0 && cell.Data != "th" {
+				headerRow = false
+			}
+			text := collapseSpace(c.render(cell))
+			line = append(line, text)
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(text))
+		}
+		cells = append(cells, line)
+	}
+
+	var out strings.Builder
+	for r, line := range cells {
+		for i, text := range line {
+			if i > 0 {
+				out.WriteString(" | ")
+			}
+			out.WriteString(text)
+			if i < len(line)-1 {
+				out.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(text)))
+			}
+		}
+		out.WriteString("\n")
+		if r == 0 && headerRow && len(cells) > 1 {
+			for i, w := range widths {
+				if i > 0 {
+					out.WriteString("-+-")
+				}
+				out.WriteString(strings.Repeat("-", w))
+			}
+			out.WriteString("\n")
+		}
+	}
+
+	b.blockBreak(2)
+	b.raw(strings.TrimRight(out.String(), "\n"))
+	b.blockBreak(2)
+}
+
+// tableRows collects the cells of every row of a table, looking through
+// thead/tbody/tfoot but not into nested tables.
+func tableRows(table *html.Node) [][]*html.Node {
+	var rows [][]*html.Node
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "thead", "tbody", "tfoot":
+				visit(child)
+			case "tr":
+				var row []*html.Node
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						row = append(row, cell)
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	visit(table)
+	return rows
+}
+
+// isLayoutTable reports whether a table is used for page layout rather than
+// for tabular data, as most HTML email templates do.
+func isLayoutTable(table *html.Node, rows [][]*html.Node) bool {
+	if role := getAttr(table, "role"); role == "presentation" || role == "none" {
+		return true
+	}
+	for _, row := range rows {
+		for _, cell := range row {
+			layout := false
+			walk(cell, func(n *html.Node) {
+				if n != cell && n.Type == html.ElementNode {
+					switch n.Data {
+					case "table", "p", "div", "ul", "ol", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote":
+						layout = true
+					}
+				}
+			})
+			if layout {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textBuilder accumulates inline text, collapsing whitespace the way a
+// browser would, and inserts line breaks between blocks.
+type textBuilder struct {
+	sb           strings.Builder
+	pendingSpace bool
+	pendingBreak int
+}
+
+func (b *textBuilder) text(s string) {
+	if s == "" {
+		return
+	}
+	if isSpace(s[0]) {
+		b.pendingSpace = true
+	}
+	for _, word := range strings.Fields(s) {
+		b.flush()
+		b.sb.WriteString(word)
+		b.pendingSpace = true
+	}
+	b.pendingSpace = isSpace(s[len(s)-1])
+}
+
+func (b *textBuilder) raw(s string) {
+	if s == "" {
+		return
+	}
+	b.flush()
+	b.sb.WriteString(s)
+}
+
+// blockBreak requests at least n newlines before the next content.
+func (b *textBuilder) blockBreak(n int) {
+	b.pendingBreak = max(b.pendingBreak, n)
+	b.pendingSpace = false
+}
+
+func (b *textBuilder) lineBreak() {
+	b.sb.WriteString("\n")
+	b.pendingSpace = false
+}
+
+func (b *textBuilder) flush() {
+	if b.sb.Len() == 0 {
+		b.pendingBreak, b.pendingSpace = 0, false
+		return
+	}
+	if b.pendingBreak > 0 {
+		s := b.sb.String()
+		trailing := len(s) - len(strings.TrimRight(s, "\n"))
+		if trailing < b.pendingBreak {
+			b.sb.WriteString(strings.Repeat("\n", b.pendingBreak-trailing))
+		}
+	} else if b.pendingSpace {
+		b.sb.WriteString(" ")
+	}
+	b.pendingBreak, b.pendingSpace = 0, false
+}
+
+func (b *textBuilder) String() string {
+	return b.sb.String()
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// prefixLines prefixes the first line of s with first and every other line with rest.
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if i == 0 {
+			lines[i] = first + line
+		} else if line != "" {
+			lines[i] = rest + line
+		} else {
+			lines[i] = strings.TrimRight(rest, " ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tidyLines trims trailing spaces and collapses runs of blank lines.
+func tidyLines(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n")) + "\n"
+}
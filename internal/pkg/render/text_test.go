@@ -0,0 +1,78 @@
+package render
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{
+			name:     "paragraphs and whitespace",
+			document: "<p>Hello\n   world</p><p>Second  paragraph</p>",
+			want:     "Hello world\n\nSecond paragraph\n",
+		},
+		{
+			name:     "links become footnotes",
+			document: `<p>See <a href="https://example.com/a">the docs</a> and <a href="https://example.com/b">the FAQ</a>, or <a href="https://example.com/a">the docs</a> again.</p>`,
+			want:     "See the docs [1] and the FAQ [2], or the docs [1] again.\n\n[1] https://example.com/a\n[2] https://example.com/b\n",
+		},
+		{
+			name:     "links without a label or to anchors stay inline",
+			document: `<p><a href="https://example.com">https://example.com</a> <a href="mailto:hi@example.com">hi@example.com</a> <a href="#top">Top</a></p>`,
+			want:     "https://example.com hi@example.com Top\n",
+		},
+		{
+			name:     "unordered and nested lists",
+			document: "<ul><li>One</li><li>Two<ul><li>Two A</li></ul></li></ul>",
+			want:     "* One\n* Two\n  * Two A\n",
+		},
+		{
+			name:     "ordered lists",
+			document: "<ol><li>First</li><li>Second</li></ol>",
+			want:     "1. First\n2. Second\n",
+		},
+		{
+			name:     "data tables are aligned",
+			document: "<table><tr><th>Item</th><th>Qty</th></tr><tr><td>Apples</td><td>3</td></tr><tr><td>Kiwi</td><td>12</td></tr></table>",
+			want:     "Item   | Qty\n-------+----\nApples | 3\nKiwi   | 12\n",
+		},
+		{
+			name:     "layout tables are flattened",
+			document: `<table role="presentation"><tr><td><p>Header</p></td></tr><tr><td><p>Body</p></td></tr></table>`,
+			want:     "Header\nBody\n",
+		},
+		{
+			name:     "entities are decoded",
+			document: "<p>Fish &amp; chips &lt;3 &euro;5&nbsp;only &quot;today&quot;</p>",
+			want:     "Fish & chips <3 €5 only \"today\"\n",
+		},
+		{
+			name:     "headings are underlined",
+			document: "<h1>Title</h1><h2>Sub</h2><p>Text</p>",
+			want:     "Title\n=====\n\nSub\n---\n\nText\n",
+		},
+		{
+			name:     "head, style and script are dropped",
+			document: "<html><head><title>T</title><style>p{}</style></head><body><script>x()</script><p>Body</p></body></html>",
+			want:     "Body\n",
+		},
+		{
+			name:     "line breaks and blockquotes",
+			document: "<p>a<br>b</p><blockquote><p>quoted</p></blockquote>",
+			want:     "a\nb\n\n> quoted\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTMLToText(tt.document)
+			if err != nil {
+				t.Fatalf("HTMLToText() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("HTMLToText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}